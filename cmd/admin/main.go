@@ -39,6 +39,10 @@ func run() error {
 			Name       string `conf:"default:postgres"`
 			DisableTLS bool   `conf:"default:false"`
 		}
+		Auth struct {
+			KeysDir      string        `conf:"default:keys"`
+			KeyRetention time.Duration `conf:"default:24h"`
+		}
 		Args conf.Args
 	}
 
@@ -73,6 +77,8 @@ func run() error {
 		err = useradd(dbConfig, cfg.Args.Num(1), cfg.Args.Num(2))
 	case "keygen":
 		err = keygen(cfg.Args.Num(1))
+	case "keys":
+		err = keys(cfg.Args.Num(1), cfg.Auth.KeysDir, cfg.Auth.KeyRetention)
 	default:
 		err = errors.New("Must specify a command")
 	}
@@ -188,3 +194,31 @@ func keygen(path string) error {
 
 	return nil
 }
+
+// keys manages the key ring signing auth tokens. Rotating generates a new key
+// and promotes it, the previous key keeps verifying the tokens it signed for
+// the retention period. The service picks the new key up when restarted.
+func keys(cmd, dir string, retain time.Duration) error {
+	switch cmd {
+	case "rotate":
+		kid, err := auth.RotateKeys(dir, time.Now(), retain)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Key %q is now active in %s\n", kid, dir)
+
+	case "list":
+		ring, err := auth.LoadKeyRing(dir)
+		if err != nil {
+			return err
+		}
+		for _, k := range ring.Keys() {
+			fmt.Printf("%s\t%s\t%s\n", k.ID, k.State, k.DateCreated.Format(time.RFC3339))
+		}
+
+	default:
+		return errors.New("keys command must be called with rotate or list")
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/web"
+	"github.com/book-library/internal/users"
+	errors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+//AccessTokens returns the personal access tokens of a user, without their secret
+func (u *User) AccessTokens(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.AccessTokens")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	tokens, err := users.AccessTokens(ctx, claims, u.Db, params["id"], v.Now)
+	if err != nil {
+		return accessTokenError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, tokens, http.StatusOK)
+}
+
+//CreateAccessToken creates a personal access token for scripts. The token is only part of this response
+func (u *User) CreateAccessToken(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.CreateAccessToken")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var nat users.NewAccessToken
+	if err := web.Decode(r, &nat); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	at, err := users.CreateAccessToken(ctx, claims, u.Db, params["id"], nat, v.Now)
+	if err != nil {
+		return accessTokenError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, at, http.StatusCreated)
+}
+
+//RevokeAccessToken revokes a personal access token
+func (u *User) RevokeAccessToken(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.RevokeAccessToken")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	if err := users.RevokeAccessToken(ctx, claims, u.Db, params["id"], params["token_id"], v.Now); err != nil {
+		return accessTokenError(err, "ID: "+params["token_id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//accessTokenError maps the errors of personal access tokens to their status
+func accessTokenError(err error, msg string) error {
+	switch err {
+	case users.ErrInvalidID, users.ErrInvalidScope, users.ErrInvalidExpiry:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case users.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case users.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
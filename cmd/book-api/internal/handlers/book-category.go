@@ -44,7 +44,7 @@ func (c *BookCategory) Create(ctx context.Context, w http.ResponseWriter, r *htt
 		return errors.New("claims missing from context")
 	}
 
-	if !claims.HasRole(auth.RoleAdmin) {
+	if !claims.HasPermission(auth.PermissionBooksManage) {
 		return errors.New("you don't have role to execute this action")
 	}
 
@@ -112,7 +112,7 @@ func (c *BookCategory) Delete(ctx context.Context, w http.ResponseWriter, r *htt
 		return errors.New("claims missing from context")
 	}
 
-	if claims.HasRole(auth.RoleAdmin) {
+	if claims.HasPermission(auth.PermissionBooksManage) {
 		return errors.New("you don't have role to execute this action")
 	}
 
@@ -86,7 +86,7 @@ func (b *Book) Create(ctx context.Context, w http.ResponseWriter, r *http.Reques
 		return errors.New("claims missing from context")
 	}
 
-	if !claims.HasRole(auth.RoleAdmin) {
+	if !claims.HasPermission(auth.PermissionBooksManage) {
 		return errors.New("you don't have role to execute this action")
 	}
 
@@ -124,7 +124,7 @@ func (b *Book) Update(ctx context.Context, w http.ResponseWriter, r *http.Reques
 	}
 
 	// If you are not an admin and looking to retrieve someone else then you are rejected.
-	if !claims.HasRole(auth.RoleAdmin) {
+	if !claims.HasPermission(auth.PermissionBooksManage) {
 		return errors.New("not authorized to execute this action")
 	}
 
@@ -159,7 +159,7 @@ func (b *Book) Delete(ctx context.Context, w http.ResponseWriter, r *http.Reques
 		return errors.New("claims missing from context")
 	}
 
-	if !claims.HasRole(auth.RoleAdmin) {
+	if !claims.HasPermission(auth.PermissionBooksManage) {
 		return errors.New("you don't have role to execute this action")
 	}
 
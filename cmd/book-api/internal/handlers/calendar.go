@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/book-library/internal/calendar"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/web"
+	"github.com/jmoiron/sqlx"
+	errors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+//Calendar represents the calendar feed API method handler set.
+type Calendar struct {
+	db *sqlx.DB
+}
+
+//Feed returns the due dates of a user as an iCalendar document
+func (c *Calendar) Feed(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.calendar.Feed")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	feed, err := calendar.Feed(ctx, c.db, params["id"], r.URL.Query().Get("token"), v.Now)
+	if err != nil {
+		return calendarError(err, "ID: "+params["id"])
+	}
+
+	w.Header().Set("Cache-Control", "private, max-age=900")
+	return web.RespondRaw(ctx, w, feed, "text/calendar; charset=utf-8", http.StatusOK)
+}
+
+//Regenerate creates a new calendar feed token, the previous one stops working
+func (c *Calendar) Regenerate(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.calendar.Regenerate")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	token, err := calendar.Regenerate(ctx, claims, c.db, params["id"], v.Now)
+	if err != nil {
+		return calendarError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, token, http.StatusCreated)
+}
+
+//calendarError maps the errors of the calendar package to request errors
+func calendarError(err error, msg string) error {
+	switch err {
+	case calendar.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case calendar.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case calendar.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
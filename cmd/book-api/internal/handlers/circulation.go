@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	loans "github.com/book-library/internal/loan"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/web"
+	"github.com/book-library/internal/standing"
+	"github.com/jmoiron/sqlx"
+	errors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+//dateLayout is the layout of the dates accepted in query strings.
+const dateLayout = "2006-01-02"
+
+//Circulation represents the circulation desk API method handler set.
+type Circulation struct {
+	db *sqlx.DB
+}
+
+//List returns the loans of every patron matching the query string filters
+func (c *Circulation) List(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.circulation.List")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	query := r.URL.Query()
+	f := loans.Filter{
+		BookID: query.Get("book_id"),
+		UserID: query.Get("user_id"),
+	}
+
+	var err error
+	if f.Overdue, err = queryBool(query.Get("overdue")); err != nil {
+		return web.NewRequestError(errors.Wrap(err, "overdue"), http.StatusBadRequest)
+	}
+	if f.IncludeReturned, err = queryBool(query.Get("include_returned")); err != nil {
+		return web.NewRequestError(errors.Wrap(err, "include_returned"), http.StatusBadRequest)
+	}
+	if f.From, err = queryDate(query.Get("from")); err != nil {
+		return web.NewRequestError(errors.Wrap(err, "from"), http.StatusBadRequest)
+	}
+	if f.To, err = queryDate(query.Get("to")); err != nil {
+		return web.NewRequestError(errors.Wrap(err, "to"), http.StatusBadRequest)
+	}
+
+	list, err := loans.Search(ctx, claims, c.db, f, v.Now)
+	if err != nil {
+		return circulationError(err, "searching loans")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+//Checkout checks a book out on behalf of a patron
+func (c *Circulation) Checkout(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.circulation.Checkout")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var co loans.Checkout
+	if err := web.Decode(r, &co); err != nil {
+		return errors.Wrap(err, "decoding checkout")
+	}
+
+	loan, err := loans.CheckoutFor(ctx, claims, c.db, co, v.Now)
+	if err != nil {
+		return circulationError(err, "checking out book "+co.BookID)
+	}
+
+	return web.Respond(ctx, w, loan, http.StatusCreated)
+}
+
+//Checkin ends a loan when the copy is given back at the desk
+func (c *Circulation) Checkin(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.circulation.Checkin")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	if err := loans.Checkin(ctx, claims, c.db, params["id"], v.Now); err != nil {
+		return circulationError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//ForceReturn ends a loan outside of the normal check in
+func (c *Circulation) ForceReturn(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.circulation.ForceReturn")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var fr loans.ForceReturn
+	if err := web.Decode(r, &fr); err != nil {
+		return errors.Wrap(err, "decoding force return")
+	}
+
+	if err := loans.Return(ctx, claims, c.db, params["id"], fr, v.Now); err != nil {
+		return circulationError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//DueDate changes the due date of a loan
+func (c *Circulation) DueDate(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.circulation.DueDate")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var dc loans.DueDateChange
+	if err := web.Decode(r, &dc); err != nil {
+		return errors.Wrap(err, "decoding due date change")
+	}
+
+	if err := loans.ChangeDueDate(ctx, claims, c.db, params["id"], dc, v.Now); err != nil {
+		return circulationError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//Audit returns the actions taken by staff on a loan
+func (c *Circulation) Audit(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.circulation.Audit")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	entries, err := loans.AuditTrail(ctx, claims, c.db, params["id"])
+	if err != nil {
+		return circulationError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, entries, http.StatusOK)
+}
+
+//Lost declares the copy of a loan lost and charges its replacement
+func (c *Circulation) Lost(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.circulation.Lost")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	fine, err := loans.DeclareLost(ctx, claims, c.db, params["id"], v.Now)
+	if err != nil {
+		return circulationError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, fine, http.StatusCreated)
+}
+
+//Damaged declares the copy of a loan returned damaged and charges its replacement
+func (c *Circulation) Damaged(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.circulation.Damaged")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	fine, err := loans.DeclareDamaged(ctx, claims, c.db, params["id"], v.Now)
+	if err != nil {
+		return circulationError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, fine, http.StatusCreated)
+}
+
+//Found reverses the loss of a copy which turned up
+func (c *Circulation) Found(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.circulation.Found")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	if err := loans.Found(ctx, claims, c.db, params["id"], v.Now); err != nil {
+		return circulationError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//Fines returns the fines of a patron
+func (c *Circulation) Fines(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.circulation.Fines")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	fines, err := loans.Fines(ctx, claims, c.db, params["id"])
+	if err != nil {
+		return circulationError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, fines, http.StatusOK)
+}
+
+//circulationError maps the errors of the loans and standing packages to request errors
+func circulationError(err error, msg string) error {
+	switch err {
+	case loans.ErrForbidden, standing.ErrBlocked:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case loans.ErrInvalidID, loans.ErrReasonRequired:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case loans.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case loans.ErrUnavailable, loans.ErrNotLost, loans.ErrLimitExceeded, loans.ErrAlreadyLoaned,
+		loans.ErrRenewalLimit, loans.ErrOnHold:
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
+
+//queryBool parses an optional boolean query string value
+func queryBool(s string) (bool, error) {
+	if s == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(s)
+}
+
+//queryDate parses an optional date query string value
+func queryDate(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/book-library/internal/events"
+	"github.com/book-library/internal/platform/web"
+	"github.com/jmoiron/sqlx"
+	errors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+//Settings of the event stream. A stream is closed once its window ends,
+//clients are expected to reconnect with the Last-Event-ID header to resume
+//where they left off.
+const (
+	streamWindow   = 4 * time.Second
+	streamPoll     = time.Second
+	streamRetry    = 1000
+	streamBatch    = 100
+	lastEventIDKey = "Last-Event-ID"
+)
+
+//Events represents the domain events API method handler set.
+type Events struct {
+	db *sqlx.DB
+}
+
+//Stream follows the events outbox as server-sent events
+func (e *Events) Stream(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.events.Stream")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("streaming is not supported by the response writer")
+	}
+
+	// Browsers resume with the header, the query parameter is for clients
+	// which can not set it.
+	last := r.Header.Get(lastEventIDKey)
+	if last == "" {
+		last = r.URL.Query().Get("last_event_id")
+	}
+	var lastID int64
+	if last != "" {
+		id, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return web.NewRequestError(errors.New("Last-Event-ID is not in its proper form"), http.StatusBadRequest)
+		}
+		lastID = id
+	}
+
+	// The write timeout of the server is meant for regular responses, the
+	// stream replaces it with its own window. A client which stops reading
+	// still can not hold the handler past it.
+	deadline := time.Now().Add(streamWindow)
+	err := http.NewResponseController(w).SetWriteDeadline(deadline.Add(streamPoll))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return errors.Wrap(err, "setting the write deadline of the stream")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set(web.AllowOriginKey, "*")
+	v.StatusCode = http.StatusOK
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry)
+	flusher.Flush()
+
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
+	ticker := time.NewTicker(streamPoll)
+	defer ticker.Stop()
+
+	for {
+		evts, err := events.After(ctx, e.db, lastID, streamBatch)
+		if err != nil {
+			// The headers are already sent, the client reconnects.
+			return nil
+		}
+
+		for _, evt := range evts {
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", evt.ID, evt.Type, evt.Payload)
+			lastID = evt.ID
+		}
+		flusher.Flush()
+
+		// Keep draining when a full batch was read, as long as the window
+		// lasts.
+		if len(evts) == streamBatch {
+			if time.Now().After(deadline) || ctx.Err() != nil {
+				return nil
+			}
+			continue
+		}
+
+		select {
+		case <-ticker.C:
+		case <-timeout.C:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/book-library/internal/holds"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/web"
+	"github.com/jmoiron/sqlx"
+	errors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+//Hold represents the holds API method handler set.
+type Hold struct {
+	db *sqlx.DB
+}
+
+//Place puts holds on books for a patron
+func (h *Hold) Place(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.holds.Place")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var nh holds.NewHolds
+	if err := web.Decode(r, &nh); err != nil {
+		return errors.Wrap(err, "decoding holds")
+	}
+
+	placed, err := holds.Place(ctx, claims, h.db, params["id"], nh, v.Now)
+	if err != nil {
+		return holdError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, placed, http.StatusCreated)
+}
+
+//ForUser returns the active holds of a patron
+func (h *Hold) ForUser(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.holds.ForUser")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	active, err := holds.ForUser(ctx, claims, h.db, params["id"])
+	if err != nil {
+		return holdError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, active, http.StatusOK)
+}
+
+//Cancel withdraws an active hold
+func (h *Hold) Cancel(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.holds.Cancel")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	if err := holds.Cancel(ctx, claims, h.db, params["id"], v.Now); err != nil {
+		return holdError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//holdError maps the errors of the holds package to request errors
+func holdError(err error, msg string) error {
+	switch err {
+	case holds.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case holds.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case holds.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case holds.ErrAvailable:
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/book-library/internal/ill"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/web"
+	"github.com/jmoiron/sqlx"
+	errors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+//ILL represents the inter-library loan API method handler set.
+type ILL struct {
+	db *sqlx.DB
+}
+
+//CreatePartner adds a partner library
+func (i *ILL) CreatePartner(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.ill.CreatePartner")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var np ill.NewPartner
+	if err := web.Decode(r, &np); err != nil {
+		return errors.Wrap(err, "decoding partner")
+	}
+
+	p, err := ill.AddPartner(ctx, claims, i.db, np, v.Now)
+	if err != nil {
+		return illError(err, "adding partner")
+	}
+
+	return web.Respond(ctx, w, p, http.StatusCreated)
+}
+
+//Partners returns every partner library
+func (i *ILL) Partners(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.ill.Partners")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	partners, err := ill.Partners(ctx, claims, i.db)
+	if err != nil {
+		return illError(err, "listing partners")
+	}
+
+	return web.Respond(ctx, w, partners, http.StatusOK)
+}
+
+//UpdatePartner changes the configuration of a partner library
+func (i *ILL) UpdatePartner(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.ill.UpdatePartner")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var up ill.UpdatePartner
+	if err := web.Decode(r, &up); err != nil {
+		return errors.Wrap(err, "decoding partner update")
+	}
+
+	if err := ill.ConfigurePartner(ctx, claims, i.db, params["id"], up, v.Now); err != nil {
+		return illError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//Submit records a patron request for a title we do not own
+func (i *ILL) Submit(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.ill.Submit")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var nr ill.NewRequest
+	if err := web.Decode(r, &nr); err != nil {
+		return errors.Wrap(err, "decoding request")
+	}
+
+	req, err := ill.Submit(ctx, claims, i.db, nr, v.Now)
+	if err != nil {
+		return illError(err, "submitting request")
+	}
+
+	return web.Respond(ctx, w, req, http.StatusCreated)
+}
+
+//List returns the requests, optionally filtered by their state
+func (i *ILL) List(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.ill.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	requests, err := ill.List(ctx, claims, i.db, r.URL.Query().Get("state"))
+	if err != nil {
+		return illError(err, "listing requests")
+	}
+
+	return web.Respond(ctx, w, requests, http.StatusOK)
+}
+
+//ForUser returns the requests of a patron
+func (i *ILL) ForUser(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.ill.ForUser")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	requests, err := ill.ForUser(ctx, claims, i.db, params["id"])
+	if err != nil {
+		return illError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, requests, http.StatusOK)
+}
+
+//Retrieve returns the specified request
+func (i *ILL) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.ill.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	req, err := ill.Retrieve(ctx, claims, i.db, params["id"])
+	if err != nil {
+		return illError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, req, http.StatusOK)
+}
+
+//Transition moves a request to another state of the workflow
+func (i *ILL) Transition(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.ill.Transition")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var t ill.Transition
+	if err := web.Decode(r, &t); err != nil {
+		return errors.Wrap(err, "decoding transition")
+	}
+
+	req, err := ill.Move(ctx, claims, i.db, params["id"], t, v.Now)
+	if err != nil {
+		return illError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, req, http.StatusOK)
+}
+
+//illError maps the errors of the ill package to request errors
+func illError(err error, msg string) error {
+	switch err {
+	case ill.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case ill.ErrInvalidID, ill.ErrCitationRequired, ill.ErrPartnerRequired:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case ill.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case ill.ErrInvalidTransition:
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
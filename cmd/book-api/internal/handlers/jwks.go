@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/web"
+	"go.opencensus.io/trace"
+)
+
+//JWKS serves the public keys verifying the tokens issued by this service.
+type JWKS struct {
+	authenticator *auth.Authenticator
+}
+
+//Keys returns the public keys as a JSON Web Key Set. Clients can cache them for a while, new keys are only
+//published when the signing keys rotate
+func (j *JWKS) Keys(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.jwks.Keys")
+	defer span.End()
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	return web.Respond(ctx, w, j.authenticator.JWKS(), http.StatusOK)
+}
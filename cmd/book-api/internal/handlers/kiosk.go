@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/book-library/internal/kiosk"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/web"
+	"github.com/jmoiron/sqlx"
+	errors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+//Kiosk represents the self-service kiosk API method handler set.
+type Kiosk struct {
+	db *sqlx.DB
+}
+
+//Register adds a new kiosk and returns its device secret
+func (k *Kiosk) Register(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.kiosk.Register")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var nk kiosk.NewKiosk
+	if err := web.Decode(r, &nk); err != nil {
+		return errors.Wrap(err, "decoding kiosk")
+	}
+
+	device, err := kiosk.Register(ctx, claims, k.db, nk, v.Now)
+	if err != nil {
+		return kioskError(err, "registering kiosk")
+	}
+
+	return web.Respond(ctx, w, device, http.StatusCreated)
+}
+
+//SetCard gives a patron a library card and its PIN
+func (k *Kiosk) SetCard(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.kiosk.SetCard")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var nc kiosk.NewCard
+	if err := web.Decode(r, &nc); err != nil {
+		return errors.Wrap(err, "decoding card")
+	}
+
+	if err := kiosk.SetCard(ctx, claims, k.db, params["id"], nc, v.Now); err != nil {
+		return kioskError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//Login opens a kiosk session with a library card barcode and PIN
+func (k *Kiosk) Login(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.kiosk.Login")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var l kiosk.Login
+	if err := web.Decode(r, &l); err != nil {
+		return errors.Wrap(err, "decoding login")
+	}
+
+	s, err := kiosk.Start(ctx, k.db, r.Header.Get(kiosk.DeviceHeader), l, v.Now)
+	if err != nil {
+		retryAfter(w, err)
+		return kioskError(err, "starting kiosk session")
+	}
+
+	return web.Respond(ctx, w, s, http.StatusCreated)
+}
+
+//Checkout loans the scanned book to the patron of the kiosk session
+func (k *Kiosk) Checkout(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.kiosk.Checkout")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	s, ok := ctx.Value(kiosk.Key).(kiosk.Session)
+	if !ok {
+		return errors.New("kiosk session missing from context")
+	}
+
+	var sc kiosk.Scan
+	if err := web.Decode(r, &sc); err != nil {
+		return errors.Wrap(err, "decoding scan")
+	}
+
+	loan, err := kiosk.Checkout(ctx, k.db, s, sc, v.Now)
+	if err != nil {
+		return kioskError(err, "checking out "+sc.Barcode)
+	}
+
+	return web.Respond(ctx, w, loan, http.StatusCreated)
+}
+
+//Checkin ends the loan of the scanned book
+func (k *Kiosk) Checkin(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.kiosk.Checkin")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	s, ok := ctx.Value(kiosk.Key).(kiosk.Session)
+	if !ok {
+		return errors.New("kiosk session missing from context")
+	}
+
+	var sc kiosk.Scan
+	if err := web.Decode(r, &sc); err != nil {
+		return errors.Wrap(err, "decoding scan")
+	}
+
+	loan, err := kiosk.Checkin(ctx, k.db, s, sc, v.Now)
+	if err != nil {
+		return kioskError(err, "checking in "+sc.Barcode)
+	}
+
+	return web.Respond(ctx, w, loan, http.StatusOK)
+}
+
+//Finish ends the kiosk session and returns the receipt to print
+func (k *Kiosk) Finish(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.kiosk.Finish")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	s, ok := ctx.Value(kiosk.Key).(kiosk.Session)
+	if !ok {
+		return errors.New("kiosk session missing from context")
+	}
+
+	receipt, err := kiosk.Finish(ctx, k.db, s, v.Now)
+	if err != nil {
+		return kioskError(err, "finishing kiosk session")
+	}
+
+	return web.RespondRaw(ctx, w, receipt, "text/plain; charset=utf-8", http.StatusOK)
+}
+
+//kioskError maps the errors of the kiosk and loans packages to request errors
+func kioskError(err error, msg string) error {
+	switch errors.Cause(err) {
+	case kiosk.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case kiosk.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case kiosk.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case kiosk.ErrUnknownDevice, kiosk.ErrAuthenticationFailure:
+		return web.NewRequestError(err, http.StatusUnauthorized)
+	case kiosk.ErrTooManyAttempts, kiosk.ErrCardLocked:
+		return web.NewRequestError(err, http.StatusTooManyRequests)
+	default:
+		return circulationError(err, msg)
+	}
+}
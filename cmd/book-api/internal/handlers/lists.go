@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/book-library/internal/lists"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/web"
+	"github.com/jmoiron/sqlx"
+	errors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+//List represents the reading lists API method handler set.
+type List struct {
+	db *sqlx.DB
+}
+
+//Create creates a new reading list
+func (l *List) Create(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.lists.Create")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var nl lists.NewList
+	if err := web.Decode(r, &nl); err != nil {
+		return errors.Wrap(err, "decoding list")
+	}
+
+	list, err := lists.Create(ctx, claims, l.db, nl, v.Now)
+	if err != nil {
+		return listError(err, "creating list")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusCreated)
+}
+
+//ForUser returns the lists of a patron visible to the caller
+func (l *List) ForUser(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.lists.ForUser")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	all, err := lists.ForUser(ctx, claims, l.db, params["id"])
+	if err != nil {
+		return listError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, all, http.StatusOK)
+}
+
+//Retrieve returns the specified list with the availability of its books
+func (l *List) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.lists.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	list, err := lists.Retrieve(ctx, claims, l.db, params["id"])
+	if err != nil {
+		return listError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+//Shared returns the public list of a share link
+func (l *List) Shared(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.lists.Shared")
+	defer span.End()
+
+	list, err := lists.Shared(ctx, l.db, params["token"])
+	if err != nil {
+		return listError(err, "retrieving shared list")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+//Update changes the name, description or visibility of a list
+func (l *List) Update(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.lists.Update")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var ul lists.UpdateList
+	if err := web.Decode(r, &ul); err != nil {
+		return errors.Wrap(err, "decoding list update")
+	}
+
+	if err := lists.Update(ctx, claims, l.db, params["id"], ul, v.Now); err != nil {
+		return listError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//Delete removes a list
+func (l *List) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.lists.Delete")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	if err := lists.Delete(ctx, claims, l.db, params["id"]); err != nil {
+		return listError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//AddEntry saves a book at the end of a list
+func (l *List) AddEntry(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.lists.AddEntry")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var ne lists.NewEntry
+	if err := web.Decode(r, &ne); err != nil {
+		return errors.Wrap(err, "decoding entry")
+	}
+
+	if err := lists.AddEntry(ctx, claims, l.db, params["id"], ne, v.Now); err != nil {
+		return listError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//UpdateEntry changes the note of a book saved in a list
+func (l *List) UpdateEntry(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.lists.UpdateEntry")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var en lists.EntryNote
+	if err := web.Decode(r, &en); err != nil {
+		return errors.Wrap(err, "decoding entry note")
+	}
+
+	if err := lists.UpdateEntry(ctx, claims, l.db, params["id"], params["book_id"], en, v.Now); err != nil {
+		return listError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//RemoveEntry takes a book out of a list
+func (l *List) RemoveEntry(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.lists.RemoveEntry")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	if err := lists.RemoveEntry(ctx, claims, l.db, params["id"], params["book_id"], v.Now); err != nil {
+		return listError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//Reorder sets the order of the books of a list
+func (l *List) Reorder(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.lists.Reorder")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var o lists.Order
+	if err := web.Decode(r, &o); err != nil {
+		return errors.Wrap(err, "decoding order")
+	}
+
+	if err := lists.Reorder(ctx, claims, l.db, params["id"], o, v.Now); err != nil {
+		return listError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//HoldUnavailable places holds on every book of a list with no copy on the shelf
+func (l *List) HoldUnavailable(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.lists.HoldUnavailable")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	placed, err := lists.HoldUnavailable(ctx, claims, l.db, params["id"], v.Now)
+	if err != nil {
+		return listError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, placed, http.StatusOK)
+}
+
+//listError maps the errors of the lists and holds packages to request errors
+func listError(err error, msg string) error {
+	switch err {
+	case lists.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case lists.ErrInvalidID, lists.ErrOrderMismatch:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case lists.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case lists.ErrAlreadyListed:
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return holdError(err, msg)
+	}
+}
@@ -25,15 +25,6 @@ func (l *Loan) List(ctx context.Context, w http.ResponseWriter, r *http.Request,
 	ctx, span := trace.StartSpan(ctx, "handlers.loans.List")
 	defer span.End()
 
-	//get user_id from the url
-	id := params["user_id"]
-
-	//check if token does already exist
-	ok, err := users.IsLoggedOut(ctx, l.db, id, r.Header.Get("bearer"))
-	if !ok {
-		return web.NewRequestError(err, http.StatusUnauthorized)
-	}
-
 	allLoans := []loans.Loan{};
 
 	claims, ok := ctx.Value(auth.Key).(auth.Claims)
@@ -63,15 +54,6 @@ func (l *Loan) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Requ
 	ctx, span := trace.StartSpan(ctx, "handlers.loans.Retrieve")
 	defer span.End()
 
-	//get user_id from the url
-	id := params["user_id"]
-
-	//check if token does already exist
-	ok, err := users.IsLoggedOut(ctx, l.db, id, r.Header.Get("bearer"))
-	if !ok {
-		return web.NewRequestError(err, http.StatusUnauthorized)
-	}
-
 	claims, ok := ctx.Value(auth.Key).(auth.Claims)
 	if !ok {
 		return errors.New("claims missing from context")
@@ -98,15 +80,6 @@ func (l *Loan) Create(ctx context.Context, w http.ResponseWriter, r *http.Reques
 	ctx, span := trace.StartSpan(ctx, "handlers.loans.Create")
 	defer span.End()
 
-	//get user_id from the url
-	id := params["user_id"]
-
-	//check if token does already exist
-	ok, err := users.IsLoggedOut(ctx, l.db, id, r.Header.Get("bearer"))
-	if !ok {
-		return web.NewRequestError(err, http.StatusUnauthorized)
-	}
-
 	//we retreive hier as claim the Value(state of each request) because we are in this case creating a new users
 	//so he doesn't have any claim and role yet and have to be created first thats why a keyValue from the web
 	//is used instead
@@ -133,34 +106,70 @@ func (l *Loan) Create(ctx context.Context, w http.ResponseWriter, r *http.Reques
 
 	loan, err := loans.InitNewLoan(ctx, claims, nl, v.Now, book.ID, l.db)
 	if err != nil {
-		switch err {
-		case users.ErrForbidden:
-			return web.NewRequestError(err, http.StatusForbidden)
-		case users.ErrInvalidID:
-			return web.NewRequestError(err, http.StatusBadRequest)
-		case users.ErrNotFound:
-			return web.NewRequestError(err, http.StatusNotFound)
-		default:
-			return errors.Wrapf(err, "ID: %s", params["id"])
-		}
+		return circulationError(err, "ID: "+params["id"])
 	}
 	return web.Respond(ctx, w, loan, http.StatusCreated)
 }
 
-//Update updates a specified Loan in the database
-func (l *Loan) Update(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
-	ctx, span := trace.StartSpan(ctx, "handlers.loans.Update")
+//Renew pushes the due date of a loan back
+func (l *Loan) Renew(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.loans.Renew")
 	defer span.End()
 
-	//get user_id from the url
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	loan, err := loans.Renew(ctx, claims, l.db, params["id"], v.Now)
+	if err != nil {
+		return circulationError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, loan, http.StatusOK)
+}
+
+//Batch checks out several books at once and returns the combined receipt
+func (l *Loan) Batch(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.loans.Batch")
+	defer span.End()
+
+	//get user_id from the url, staff check out on behalf of the patron
 	id := params["user_id"]
 
-	//check if token does already exist
-	ok, err := users.IsLoggedOut(ctx, l.db, id, r.Header.Get("bearer"))
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
 	if !ok {
-		return web.NewRequestError(err, http.StatusUnauthorized)
+		return errors.New("claims missing from context")
 	}
 
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var nb loans.NewBatch
+	if err := web.Decode(r, &nb); err != nil {
+		return errors.Wrap(err, "Error when decoding the request's body")
+	}
+
+	receipt, err := loans.CheckoutBatch(ctx, claims, l.db, id, nb, v.Now)
+	if err != nil {
+		return circulationError(err, "ID: "+id)
+	}
+
+	return web.Respond(ctx, w, receipt, http.StatusCreated)
+}
+
+//Update updates a specified Loan in the database
+func (l *Loan) Update(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.loans.Update")
+	defer span.End()
+
 	v, ok := ctx.Value(web.KeyValues).(*web.Values)
 	if !ok {
 		return errors.New("web value missing from context")
@@ -203,15 +212,6 @@ func (l *Loan) Delete(ctx context.Context, w http.ResponseWriter, r *http.Reques
 	ctx, span := trace.StartSpan(ctx, "handlers.loans.Delete")
 	defer span.End()
 
-	//get user_id from the url
-	id := params["user_id"]
-
-	//check if token does already exist
-	ok, err := users.IsLoggedOut(ctx, l.db, id, r.Header.Get("bearer"))
-	if !ok {
-		return web.NewRequestError(err, http.StatusUnauthorized)
-	}
-
 	v, ok := ctx.Value(web.KeyValues).(*web.Values)
 	if !ok {
 		return errors.New("web value missing from context")
@@ -222,7 +222,7 @@ func (l *Loan) Delete(ctx context.Context, w http.ResponseWriter, r *http.Reques
 		return errors.New("claims missing from context")
 	}
 
-	err = loans.EndUpALoan(ctx, claims, v.Now, params["id"], l.db)
+	err := loans.EndUpALoan(ctx, claims, v.Now, params["id"], l.db)
 	if err != nil {
 		switch err {
 		case users.ErrForbidden:
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/book-library/internal/notify"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/web"
+	"github.com/jmoiron/sqlx"
+	errors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+//Notification represents the notification preferences API method handler set.
+type Notification struct {
+	db *sqlx.DB
+}
+
+//Preferences returns the notification preferences of a user
+func (n *Notification) Preferences(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.notifications.Preferences")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	prefs, err := notify.Preferences(ctx, claims, n.db, params["id"])
+	if err != nil {
+		switch err {
+		case notify.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		case notify.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "ID: %s", params["id"])
+		}
+	}
+
+	return web.Respond(ctx, w, prefs, http.StatusOK)
+}
+
+//UpdatePreferences changes the notification preferences of a user
+func (n *Notification) UpdatePreferences(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.notifications.UpdatePreferences")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var upd notify.UpdatePreferences
+	if err := web.Decode(r, &upd); err != nil {
+		return errors.Wrap(err, "decoding notification preferences")
+	}
+
+	err := notify.SetPreferences(ctx, claims, n.db, params["id"], upd)
+	if err != nil {
+		switch err {
+		case notify.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		case notify.ErrInvalidID, notify.ErrUnknownEvent:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "ID: %s", params["id"])
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
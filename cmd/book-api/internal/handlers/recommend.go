@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/web"
+	"github.com/book-library/internal/recommend"
+	"github.com/jmoiron/sqlx"
+	errors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+//Recommend represents the recommendations API method handler set.
+type Recommend struct {
+	db *sqlx.DB
+}
+
+//Similar returns the books most similar to the specified one
+func (rc *Recommend) Similar(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.recommend.Similar")
+	defer span.End()
+
+	limit, err := queryLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		return web.NewRequestError(errors.Wrap(err, "limit"), http.StatusBadRequest)
+	}
+
+	recs, err := recommend.Similar(ctx, rc.db, params["id"], limit)
+	if err != nil {
+		return recommendError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, recs, http.StatusOK)
+}
+
+//ForUser returns the books suggested to a patron
+func (rc *Recommend) ForUser(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.recommend.ForUser")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	limit, err := queryLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		return web.NewRequestError(errors.Wrap(err, "limit"), http.StatusBadRequest)
+	}
+
+	recs, err := recommend.ForUser(ctx, claims, rc.db, params["id"], limit)
+	if err != nil {
+		return recommendError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, recs, http.StatusOK)
+}
+
+//recommendError maps the errors of the recommend package to request errors
+func recommendError(err error, msg string) error {
+	switch err {
+	case recommend.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case recommend.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
+
+//queryLimit parses an optional positive limit query string value
+func queryLimit(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 || n > 100 {
+		return 0, errors.New("must be between 1 and 100")
+	}
+	return n, nil
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/web"
+	"github.com/book-library/internal/reports"
+	"github.com/jmoiron/sqlx"
+	errors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+//Reports represents the circulation reports API method handler set.
+type Reports struct {
+	db *sqlx.DB
+}
+
+//Circulation returns the loans and returns per day, week or month
+func (rp *Reports) Circulation(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.reports.Circulation")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	rng, err := queryRange(r)
+	if err != nil {
+		return err
+	}
+
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = reports.IntervalDay
+	}
+
+	periods, err := reports.Circulation(ctx, claims, rp.db, interval, rng)
+	if err != nil {
+		return reportError(err, "circulation")
+	}
+
+	return respondReport(ctx, w, r, "circulation", periods)
+}
+
+//Titles returns the most borrowed books
+func (rp *Reports) Titles(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.reports.Titles")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	rng, err := queryRange(r)
+	if err != nil {
+		return err
+	}
+
+	limit, err := queryLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		return web.NewRequestError(errors.Wrap(err, "limit"), http.StatusBadRequest)
+	}
+
+	titles, err := reports.TopTitles(ctx, claims, rp.db, rng, limit)
+	if err != nil {
+		return reportError(err, "top titles")
+	}
+
+	return respondReport(ctx, w, r, "titles", titles)
+}
+
+//Categories returns the most borrowed categories
+func (rp *Reports) Categories(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.reports.Categories")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	rng, err := queryRange(r)
+	if err != nil {
+		return err
+	}
+
+	limit, err := queryLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		return web.NewRequestError(errors.Wrap(err, "limit"), http.StatusBadRequest)
+	}
+
+	categories, err := reports.TopCategories(ctx, claims, rp.db, rng, limit)
+	if err != nil {
+		return reportError(err, "top categories")
+	}
+
+	return respondReport(ctx, w, r, "categories", categories)
+}
+
+//Patrons returns the patrons who borrowed the most books
+func (rp *Reports) Patrons(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.reports.Patrons")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	rng, err := queryRange(r)
+	if err != nil {
+		return err
+	}
+
+	limit, err := queryLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		return web.NewRequestError(errors.Wrap(err, "limit"), http.StatusBadRequest)
+	}
+
+	patrons, err := reports.ActivePatrons(ctx, claims, rp.db, rng, limit)
+	if err != nil {
+		return reportError(err, "active patrons")
+	}
+
+	return respondReport(ctx, w, r, "patrons", patrons)
+}
+
+//Duration returns how long the books are kept
+func (rp *Reports) Duration(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.reports.Duration")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	rng, err := queryRange(r)
+	if err != nil {
+		return err
+	}
+
+	d, err := reports.LoanDuration(ctx, claims, rp.db, rng)
+	if err != nil {
+		return reportError(err, "loan duration")
+	}
+
+	return respondReport(ctx, w, r, "duration", d)
+}
+
+//Turnover returns how many times every copy of the books was borrowed
+func (rp *Reports) Turnover(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.reports.Turnover")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	rng, err := queryRange(r)
+	if err != nil {
+		return err
+	}
+
+	limit, err := queryLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		return web.NewRequestError(errors.Wrap(err, "limit"), http.StatusBadRequest)
+	}
+
+	turnovers, err := reports.TurnoverRate(ctx, claims, rp.db, rng, limit)
+	if err != nil {
+		return reportError(err, "turnover rate")
+	}
+
+	return respondReport(ctx, w, r, "turnover", turnovers)
+}
+
+//reportError maps the errors of the reports package to request errors
+func reportError(err error, msg string) error {
+	switch err {
+	case reports.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case reports.ErrInvalidInterval, reports.ErrInvalidRange:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
+
+//queryRange parses the optional from and to query string values
+func queryRange(r *http.Request) (reports.Range, error) {
+	var rng reports.Range
+	var err error
+
+	query := r.URL.Query()
+	if rng.From, err = queryDate(query.Get("from")); err != nil {
+		return rng, web.NewRequestError(errors.Wrap(err, "from"), http.StatusBadRequest)
+	}
+	if rng.To, err = queryDate(query.Get("to")); err != nil {
+		return rng, web.NewRequestError(errors.Wrap(err, "to"), http.StatusBadRequest)
+	}
+
+	return rng, nil
+}
+
+//respondReport sends a report as JSON, or as a CSV attachment when the
+//format query string value asks for it
+func respondReport(ctx context.Context, w http.ResponseWriter, r *http.Request, name string, t reports.Table) error {
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		return web.Respond(ctx, w, t, http.StatusOK)
+	case "csv":
+		data, err := reports.CSV(t)
+		if err != nil {
+			return errors.Wrap(err, "exporting "+name)
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+		return web.RespondRaw(ctx, w, data, "text/csv; charset=utf-8", http.StatusOK)
+	default:
+		return web.NewRequestError(errors.New("format must be json or csv"), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/web"
+	"github.com/book-library/internal/reviews"
+	"github.com/jmoiron/sqlx"
+	errors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+//Review represents the reviews API method handler set.
+type Review struct {
+	db *sqlx.DB
+}
+
+//Submit rates and reviews a book the patron borrowed
+func (rv *Review) Submit(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.reviews.Submit")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var nr reviews.NewReview
+	if err := web.Decode(r, &nr); err != nil {
+		return errors.Wrap(err, "decoding review")
+	}
+
+	review, err := reviews.Submit(ctx, claims, rv.db, params["id"], nr, v.Now)
+	if err != nil {
+		return reviewError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, review, http.StatusCreated)
+}
+
+//ForBook returns the published reviews of a book
+func (rv *Review) ForBook(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.reviews.ForBook")
+	defer span.End()
+
+	list, err := reviews.ForBook(ctx, rv.db, params["id"])
+	if err != nil {
+		return reviewError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+//Queue returns the reviews waiting for a moderator
+func (rv *Review) Queue(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.reviews.Queue")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	list, err := reviews.Queue(ctx, claims, rv.db)
+	if err != nil {
+		return reviewError(err, "listing moderation queue")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+//Moderate approves or hides a review
+func (rv *Review) Moderate(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.reviews.Moderate")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var m reviews.Moderation
+	if err := web.Decode(r, &m); err != nil {
+		return errors.Wrap(err, "decoding moderation")
+	}
+
+	if err := reviews.Moderate(ctx, claims, rv.db, params["id"], m, v.Now); err != nil {
+		return reviewError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//Delete removes a review
+func (rv *Review) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.reviews.Delete")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	if err := reviews.Delete(ctx, claims, rv.db, params["id"]); err != nil {
+		return reviewError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//Report flags a review as abusive
+func (rv *Review) Report(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.reviews.Report")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var nr reviews.NewReport
+	if err := web.Decode(r, &nr); err != nil {
+		return errors.Wrap(err, "decoding report")
+	}
+
+	if err := reviews.Flag(ctx, claims, rv.db, params["id"], nr, v.Now); err != nil {
+		return reviewError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//Reports returns the reports made on a review
+func (rv *Review) Reports(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.reviews.Reports")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	list, err := reviews.Reports(ctx, claims, rv.db, params["id"])
+	if err != nil {
+		return reviewError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+//reviewError maps the errors of the reviews package to request errors
+func reviewError(err error, msg string) error {
+	switch err {
+	case reviews.ErrForbidden, reviews.ErrNotBorrowed:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case reviews.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case reviews.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case reviews.ErrAlreadyReported:
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
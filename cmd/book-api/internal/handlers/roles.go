@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/web"
+	"github.com/book-library/internal/roles"
+	"github.com/jmoiron/sqlx"
+	errors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+//Role represents the roles and permissions API method handler set.
+type Role struct {
+	db *sqlx.DB
+}
+
+//Permissions returns every permission a role can grant
+func (rl *Role) Permissions(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.roles.Permissions")
+	defer span.End()
+
+	return web.Respond(ctx, w, auth.Permissions, http.StatusOK)
+}
+
+//List returns all the roles
+func (rl *Role) List(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.roles.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	list, err := roles.List(ctx, claims, rl.db)
+	if err != nil {
+		return roleError(err, "listing roles")
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+//Retrieve returns a specified role
+func (rl *Role) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.roles.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	role, err := roles.Retrieve(ctx, claims, rl.db, params["name"])
+	if err != nil {
+		return roleError(err, "name: "+params["name"])
+	}
+
+	return web.Respond(ctx, w, role, http.StatusOK)
+}
+
+//Create defines a new role
+func (rl *Role) Create(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.roles.Create")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var nr roles.NewRole
+	if err := web.Decode(r, &nr); err != nil {
+		return errors.Wrap(err, "decoding role")
+	}
+
+	role, err := roles.Create(ctx, claims, rl.db, nr, v.Now)
+	if err != nil {
+		return roleError(err, "creating role")
+	}
+
+	return web.Respond(ctx, w, role, http.StatusCreated)
+}
+
+//Update changes the description or the permissions of a role
+func (rl *Role) Update(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.roles.Update")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var upd roles.UpdateRole
+	if err := web.Decode(r, &upd); err != nil {
+		return errors.Wrap(err, "decoding role")
+	}
+
+	if err := roles.Update(ctx, claims, rl.db, params["name"], upd, v.Now); err != nil {
+		return roleError(err, "name: "+params["name"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//Delete removes a role nobody has
+func (rl *Role) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.roles.Delete")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	if err := roles.Delete(ctx, claims, rl.db, params["name"]); err != nil {
+		return roleError(err, "name: "+params["name"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//Assign replaces the roles of a user
+func (rl *Role) Assign(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.roles.Assign")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var a roles.Assignment
+	if err := web.Decode(r, &a); err != nil {
+		return errors.Wrap(err, "decoding roles")
+	}
+
+	if err := roles.Assign(ctx, claims, rl.db, params["id"], a, v.Now); err != nil {
+		return roleError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//roleError maps the errors of the roles package to their status
+func roleError(err error, msg string) error {
+	switch err {
+	case roles.ErrInvalidID, roles.ErrInvalidName, roles.ErrUnknownPermission:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case roles.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case roles.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case roles.ErrNameTaken, roles.ErrBuiltIn, roles.ErrInUse:
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
@@ -7,12 +7,23 @@ import (
 
 	"github.com/book-library/internal/mid"
 	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/oidc"
 	"github.com/book-library/internal/platform/web"
 	"github.com/jmoiron/sqlx"
 )
 
+// Links holds the public addresses used to build the links put in the emails
+// sent to the users.
+type Links struct {
+	API string // Where this service is reached.
+	App string // Where the web client is reached.
+}
+
 // API constructs an http.Handler with all application routes defined.
-func API(build string, shutdown chan os.Signal, log *log.Logger, db *sqlx.DB, authenticator *auth.Authenticator) http.Handler {
+//
+// Signing in with an identity provider is only offered when sso is not nil.
+// The cookies of browsers are only sent over https when secureCookies is set.
+func API(build string, shutdown chan os.Signal, log *log.Logger, db *sqlx.DB, authenticator *auth.Authenticator, links Links, sso *oidc.Provider, secureCookies bool) http.Handler {
 
 	// Construct the web.App which holds all routes as well as common Middleware.
 	app := web.NewApp(shutdown, mid.Logger(log), mid.Errors(log), mid.Metrics(), mid.Panics(log))
@@ -25,54 +36,264 @@ func API(build string, shutdown chan os.Signal, log *log.Logger, db *sqlx.DB, au
 
 	app.Handle("GET", "/v1/health", check.Health)
 
+	// Register the public keys of the tokens, other services verify the
+	// tokens with them. This route is not authenticated.
+	jwks := JWKS{
+		authenticator: authenticator,
+	}
+
+	app.Handle("GET", "/.well-known/jwks.json", jwks.Keys)
+
 	// Register users management and authentication endpoints.
 	u := User{
 		Db:            db,
 		authenticator: authenticator,
+		links:         links,
+		sso:           sso,
+		secureCookies: secureCookies,
+	}
+
+	// Browsers authenticate with the session cookie, so every state changing
+	// route checks the csrf token of the session with mid.CSRF. Every
+	// authenticated route lists the scopes a personal access token needs with
+	// mid.HasScope, the routes managing the account list none.
+	app.Handle("GET", "/v1/users/all", u.List, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionUsersRead), mid.HasScope(auth.ScopeUsersRead))
+	app.Handle("POST", "/v1/users/create", u.Create, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionUsersManage), mid.HasScope(auth.ScopeUsersWrite))
+
+	// Patrons sign up by themselves and confirm their email address before
+	// they can sign in, and reset their password when they forget it. These
+	// routes are not authenticated.
+	app.Handle("POST", "/v1/users/register", u.Register)
+	app.Handle("GET", "/v1/users/verify", u.Verify)
+	app.Handle("POST", "/v1/users/verify", u.Verify)
+	app.Handle("POST", "/v1/users/verify/resend", u.ResendVerification)
+	app.Handle("POST", "/v1/users/password/forgot", u.ForgotPassword)
+	app.Handle("POST", "/v1/users/password/reset", u.ResetPassword)
+
+	// Patrons sign in with their campus account through the identity
+	// provider, the provider sends them back to the callback.
+	if sso != nil {
+		app.Handle("GET", "/v1/users/sso/login", u.SSOLogin)
+		app.Handle("GET", "/v1/users/sso/callback", u.SSOCallback)
 	}
 
-	app.Handle("GET", "/v1/users/all", u.List, mid.Authentication(authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("POST", "/v1/users/create", u.Create, mid.Authentication(authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("GET", "/v1/users/:id", u.Retrieve, mid.Authentication(authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("PUT", "/v1/users/:id/update", u.Update, mid.Authentication(authenticator), mid.HasRole(auth.RoleUser))
-	app.Handle("DELETE", "/v1/users/:id/delete", u.Delete, mid.Authentication(authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("GET", "/v1/users/:user-id/me", u.RetrieveMe, mid.Authentication(authenticator), mid.HasRole(auth.RoleUser))
+	app.Handle("GET", "/v1/users/:id", u.Retrieve, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionUsersRead), mid.HasScope(auth.ScopeUsersRead))
+	app.Handle("PUT", "/v1/users/:id/update", u.Update, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionAccount, auth.PermissionUsersManage), mid.HasScope(auth.ScopeUsersWrite))
+	app.Handle("DELETE", "/v1/users/:id/delete", u.Delete, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionUsersManage), mid.HasScope(auth.ScopeUsersWrite))
+	app.Handle("GET", "/v1/users/:user-id/me", u.RetrieveMe, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionAccount), mid.HasScope(auth.ScopeUsersRead))
+	app.Handle("GET", "/v1/users/:id/sessions", u.Sessions, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionAccount, auth.PermissionUsersManage), mid.HasScope())
+	app.Handle("GET", "/v1/users/:id/identities", u.Identities, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionAccount, auth.PermissionUsersManage), mid.HasScope())
+	app.Handle("POST", "/v1/users/:id/revoke-tokens", u.RevokeTokens, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionUsersManage), mid.HasScope())
+	app.Handle("POST", "/v1/users/:id/unlock", u.Unlock, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionUsersManage), mid.HasScope())
+
+	// Register two-factor authentication endpoints. Admins turn it off for
+	// users who lost their authenticator app.
+	app.Handle("GET", "/v1/users/:id/2fa", u.TwoFactorStatus, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionAccount, auth.PermissionUsersManage), mid.HasScope())
+	app.Handle("POST", "/v1/users/:id/2fa", u.EnrollTwoFactor, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionAccount, auth.PermissionUsersManage), mid.HasScope())
+	app.Handle("POST", "/v1/users/:id/2fa/confirm", u.ConfirmTwoFactor, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionAccount, auth.PermissionUsersManage), mid.HasScope())
+	app.Handle("POST", "/v1/users/:id/2fa/disable", u.DisableTwoFactor, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionAccount, auth.PermissionUsersManage), mid.HasScope())
+	app.Handle("POST", "/v1/users/:id/2fa/recovery-codes", u.RegenerateRecoveryCodes, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionAccount, auth.PermissionUsersManage), mid.HasScope())
+
+	// Register personal access tokens endpoints. Scripts call the API with
+	// them, limited to their scopes.
+	app.Handle("GET", "/v1/users/:id/access-tokens", u.AccessTokens, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionAccount, auth.PermissionUsersManage), mid.HasScope())
+	app.Handle("POST", "/v1/users/:id/access-tokens", u.CreateAccessToken, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionAccount, auth.PermissionUsersManage), mid.HasScope())
+	app.Handle("DELETE", "/v1/users/:id/access-tokens/:token_id", u.RevokeAccessToken, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionAccount, auth.PermissionUsersManage), mid.HasScope())
+
+	// Register notification preferences endpoints.
+	n := Notification{
+		db: db,
+	}
+	app.Handle("GET", "/v1/users/:id/notifications", n.Preferences, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionAccount), mid.HasScope(auth.ScopeUsersRead))
+	app.Handle("PUT", "/v1/users/:id/notifications", n.UpdatePreferences, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionAccount), mid.HasScope(auth.ScopeUsersWrite))
+
+	// Register calendar feed endpoints. The feed is authenticated by the
+	// token in its query string so calendar apps can subscribe to it.
+	cal := Calendar{
+		db: db,
+	}
+	app.Handle("GET", "/v1/users/:id/loans.ics", cal.Feed)
+	app.Handle("POST", "/v1/users/:id/calendar-token", cal.Regenerate, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionAccount), mid.HasScope())
 
 	// This routes are not authenticated
 	app.Handle("POST", "/v1/users/token", u.TokenAuthenticator)
-	app.Handle("GET", "/v1/users/refresh-token", u.RefreshToken)
+	app.Handle("POST", "/v1/users/refresh-token", u.RefreshToken)
+	app.Handle("POST", "/v1/users/token/2fa", u.TwoFactorLogin)
+	app.Handle("POST", "/v1/users/token/2fa/enroll", u.TwoFactorLoginEnroll)
 	app.Handle("POST", "/v1/users/:user_id/logout", u.Logout)
+	app.Handle("POST", "/v1/users/token/revoke", u.RevokeToken, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionAccount), mid.HasScope())
 
 	// Register books endpoints.
 	bk := Book{
 		db: db,
 	}
-	app.Handle("GET", "/v1/books/all", bk.List, mid.Authentication(authenticator))
-	app.Handle("GET", "/v1/books/title", bk.RetrieveByTitle, mid.Authentication(authenticator))
-	app.Handle("POST", "/v1/books/create", bk.Create, mid.Authentication(authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("GET", "/v1/books/:id", bk.Retrieve, mid.Authentication(authenticator))
-	app.Handle("PUT", "/v1/books/:id/update", bk.Update, mid.Authentication(authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("DELETE", "/v1/books/:id/delete", bk.Delete, mid.Authentication(authenticator), mid.HasRole(auth.RoleAdmin))
+	app.Handle("GET", "/v1/books/all", bk.List, mid.Authentication(authenticator), mid.HasScope(auth.ScopeBooksRead))
+	app.Handle("GET", "/v1/books/title", bk.RetrieveByTitle, mid.Authentication(authenticator), mid.HasScope(auth.ScopeBooksRead))
+	app.Handle("POST", "/v1/books/create", bk.Create, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionBooksManage), mid.HasScope(auth.ScopeBooksWrite))
+	app.Handle("GET", "/v1/books/:id", bk.Retrieve, mid.Authentication(authenticator), mid.HasScope(auth.ScopeBooksRead))
+	app.Handle("PUT", "/v1/books/:id/update", bk.Update, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionBooksManage), mid.HasScope(auth.ScopeBooksWrite))
+	app.Handle("DELETE", "/v1/books/:id/delete", bk.Delete, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionBooksManage), mid.HasScope(auth.ScopeBooksWrite))
+
+	// Register recommendations endpoints.
+	rc := Recommend{
+		db: db,
+	}
+	app.Handle("GET", "/v1/books/:id/similar", rc.Similar, mid.Authentication(authenticator), mid.HasScope(auth.ScopeBooksRead))
+	app.Handle("GET", "/v1/users/:id/recommendations", rc.ForUser, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionLoansBorrow, auth.PermissionCirculation), mid.HasScope(auth.ScopeBooksRead))
+
+	// Register reviews endpoints.
+	rv := Review{
+		db: db,
+	}
+	app.Handle("GET", "/v1/books/:id/reviews", rv.ForBook, mid.Authentication(authenticator), mid.HasScope(auth.ScopeReviewsRead))
+	app.Handle("POST", "/v1/books/:id/reviews", rv.Submit, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionReviewsWrite), mid.HasScope(auth.ScopeReviewsWrite))
+	app.Handle("GET", "/v1/reviews/queue", rv.Queue, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionReviewsModerate), mid.HasScope(auth.ScopeReviewsRead))
+	app.Handle("POST", "/v1/reviews/:id/moderate", rv.Moderate, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionReviewsModerate), mid.HasScope(auth.ScopeReviewsWrite))
+	app.Handle("DELETE", "/v1/reviews/:id", rv.Delete, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionReviewsWrite, auth.PermissionReviewsModerate), mid.HasScope(auth.ScopeReviewsWrite))
+	app.Handle("POST", "/v1/reviews/:id/report", rv.Report, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionReviewsWrite), mid.HasScope(auth.ScopeReviewsWrite))
+	app.Handle("GET", "/v1/reviews/:id/reports", rv.Reports, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionReviewsModerate), mid.HasScope(auth.ScopeReviewsRead))
 
 	// Register book-category endpoints.
 	ct := BookCategory{
 		db: db,
 	}
-	app.Handle("GET", "/v1/categories/all", ct.List, mid.Authentication(authenticator))
-	app.Handle("POST", "/v1/categories/create", ct.Create, mid.Authentication(authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("PUT", "/v1/categories/:id/update", ct.Update, mid.Authentication(authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("DELETE", "/v1/categories/:id/delete", ct.Delete, mid.Authentication(authenticator), mid.HasRole(auth.RoleAdmin))
-	app.Handle("GET", "/v1/categories/:id", ct.Retreive, mid.Authentication(authenticator), mid.HasRole(auth.RoleUser))
+	app.Handle("GET", "/v1/categories/all", ct.List, mid.Authentication(authenticator), mid.HasScope(auth.ScopeBooksRead))
+	app.Handle("POST", "/v1/categories/create", ct.Create, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionBooksManage), mid.HasScope(auth.ScopeBooksWrite))
+	app.Handle("PUT", "/v1/categories/:id/update", ct.Update, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionBooksManage), mid.HasScope(auth.ScopeBooksWrite))
+	app.Handle("DELETE", "/v1/categories/:id/delete", ct.Delete, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionBooksManage), mid.HasScope(auth.ScopeBooksWrite))
+	app.Handle("GET", "/v1/categories/:id", ct.Retreive, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionLoansBorrow, auth.PermissionBooksManage), mid.HasScope(auth.ScopeBooksRead))
 
 	// Register loans endpoints.
 	l := Loan{
 		db: db,
 	}
-	app.Handle("GET", "/v1/loans/:user_id/all", l.List, mid.Authentication(authenticator), mid.HasRole(auth.RoleUser))
-	app.Handle("POST", "/v1/loans/:user_id/init", l.Create, mid.Authentication(authenticator), mid.HasRole(auth.RoleUser))
-	app.Handle("PUT", "/v1/loans/:user_id/update/:id", l.Update, mid.Authentication(authenticator), mid.HasRole(auth.RoleUser))
-	app.Handle("DELETE", "/v1/loans/:user_id/delete/:id", l.Delete, mid.Authentication(authenticator), mid.HasRole(auth.RoleUser))
-	app.Handle("GET", "/v1/loans/:user_id/retrieve/:id", l.Retrieve, mid.Authentication(authenticator), mid.HasRole(auth.RoleUser))
+	app.Handle("GET", "/v1/loans/:user_id/all", l.List, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionLoansBorrow), mid.HasScope(auth.ScopeLoansRead))
+	app.Handle("POST", "/v1/loans/:user_id/init", l.Create, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionLoansBorrow), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("POST", "/v1/loans/:user_id/batch", l.Batch, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionLoansBorrow, auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("POST", "/v1/loans/:user_id/renew/:id", l.Renew, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionLoansBorrow, auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("PUT", "/v1/loans/:user_id/update/:id", l.Update, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionLoansBorrow), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("DELETE", "/v1/loans/:user_id/delete/:id", l.Delete, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionLoansBorrow), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("GET", "/v1/loans/:user_id/retrieve/:id", l.Retrieve, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionLoansBorrow), mid.HasScope(auth.ScopeLoansRead))
+
+	// Register circulation desk endpoints.
+	cr := Circulation{
+		db: db,
+	}
+	app.Handle("GET", "/v1/circulation/loans", cr.List, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansRead))
+	app.Handle("POST", "/v1/circulation/checkout", cr.Checkout, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("POST", "/v1/circulation/loans/:id/checkin", cr.Checkin, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("POST", "/v1/circulation/loans/:id/force-return", cr.ForceReturn, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("PUT", "/v1/circulation/loans/:id/due-date", cr.DueDate, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("GET", "/v1/circulation/loans/:id/audit", cr.Audit, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansRead))
+	app.Handle("POST", "/v1/circulation/loans/:id/lost", cr.Lost, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("POST", "/v1/circulation/loans/:id/damaged", cr.Damaged, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("POST", "/v1/circulation/loans/:id/found", cr.Found, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("GET", "/v1/users/:id/fines", cr.Fines, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionLoansBorrow, auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansRead))
+
+	// Register patron standing endpoints.
+	st := Standing{
+		db: db,
+	}
+	app.Handle("GET", "/v1/users/:id/standing", st.Retrieve, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionLoansBorrow, auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansRead))
+	app.Handle("POST", "/v1/users/:id/blocks", st.Block, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("DELETE", "/v1/blocks/:id", st.Lift, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("PUT", "/v1/users/:id/membership", st.Membership, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("GET", "/v1/standing/policy", st.Policy, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansRead))
+	app.Handle("PUT", "/v1/standing/policy", st.UpdatePolicy, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionPolicyManage), mid.HasScope(auth.ScopeLoansWrite))
+
+	// Register self-service kiosk endpoints. Kiosks authenticate with their
+	// device credential and the session opened with a library card.
+	k := Kiosk{
+		db: db,
+	}
+	app.Handle("POST", "/v1/kiosks/create", k.Register, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionKiosksManage), mid.HasScope())
+	app.Handle("PUT", "/v1/users/:id/card", k.SetCard, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionCirculation), mid.HasScope(auth.ScopeUsersWrite))
+	app.Handle("POST", "/v1/kiosk/login", k.Login)
+	app.Handle("POST", "/v1/kiosk/checkout", k.Checkout, mid.KioskSession(db, authenticator))
+	app.Handle("POST", "/v1/kiosk/checkin", k.Checkin, mid.KioskSession(db, authenticator))
+	app.Handle("POST", "/v1/kiosk/finish", k.Finish, mid.KioskSession(db, authenticator))
+
+	// Register inter-library loan endpoints.
+	il := ILL{
+		db: db,
+	}
+	app.Handle("GET", "/v1/ill/partners", il.Partners, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansRead))
+	app.Handle("POST", "/v1/ill/partners", il.CreatePartner, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionILLManage), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("PUT", "/v1/ill/partners/:id", il.UpdatePartner, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionILLManage), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("GET", "/v1/ill/requests", il.List, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansRead))
+	app.Handle("POST", "/v1/ill/requests", il.Submit, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionLoansBorrow), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("GET", "/v1/ill/requests/:id", il.Retrieve, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionLoansBorrow, auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansRead))
+	app.Handle("POST", "/v1/ill/requests/:id/transition", il.Transition, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionLoansBorrow, auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("GET", "/v1/users/:id/ill-requests", il.ForUser, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionLoansBorrow, auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansRead))
+
+	// Register holds endpoints.
+	hd := Hold{
+		db: db,
+	}
+	app.Handle("GET", "/v1/users/:id/holds", hd.ForUser, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionLoansBorrow, auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansRead))
+	app.Handle("POST", "/v1/users/:id/holds", hd.Place, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionLoansBorrow, auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansWrite))
+	app.Handle("DELETE", "/v1/holds/:id", hd.Cancel, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionLoansBorrow, auth.PermissionCirculation), mid.HasScope(auth.ScopeLoansWrite))
+
+	// Register reading lists endpoints. Public lists can be seen by anybody
+	// with their share link.
+	ls := List{
+		db: db,
+	}
+	app.Handle("GET", "/v1/users/:id/lists", ls.ForUser, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionListsManage, auth.PermissionCirculation), mid.HasScope(auth.ScopeListsRead))
+	app.Handle("POST", "/v1/lists", ls.Create, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionListsManage), mid.HasScope(auth.ScopeListsWrite))
+	app.Handle("GET", "/v1/lists/shared/:token", ls.Shared)
+	app.Handle("GET", "/v1/lists/:id", ls.Retrieve, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionListsManage, auth.PermissionCirculation), mid.HasScope(auth.ScopeListsRead))
+	app.Handle("PUT", "/v1/lists/:id", ls.Update, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionListsManage), mid.HasScope(auth.ScopeListsWrite))
+	app.Handle("DELETE", "/v1/lists/:id", ls.Delete, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionListsManage), mid.HasScope(auth.ScopeListsWrite))
+	app.Handle("PUT", "/v1/lists/:id/order", ls.Reorder, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionListsManage), mid.HasScope(auth.ScopeListsWrite))
+	app.Handle("POST", "/v1/lists/:id/entries", ls.AddEntry, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionListsManage), mid.HasScope(auth.ScopeListsWrite))
+	app.Handle("PUT", "/v1/lists/:id/entries/:book_id", ls.UpdateEntry, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionListsManage), mid.HasScope(auth.ScopeListsWrite))
+	app.Handle("DELETE", "/v1/lists/:id/entries/:book_id", ls.RemoveEntry, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionListsManage), mid.HasScope(auth.ScopeListsWrite))
+	app.Handle("POST", "/v1/lists/:id/holds", ls.HoldUnavailable, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionListsManage), mid.HasScope(auth.ScopeListsWrite))
+
+	// Register circulation reports endpoints. Every report can be exported as
+	// CSV with the format query string value.
+	rp := Reports{
+		db: db,
+	}
+	app.Handle("GET", "/v1/reports/circulation", rp.Circulation, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionReportsRead), mid.HasScope(auth.ScopeReportsRead))
+	app.Handle("GET", "/v1/reports/titles", rp.Titles, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionReportsRead), mid.HasScope(auth.ScopeReportsRead))
+	app.Handle("GET", "/v1/reports/categories", rp.Categories, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionReportsRead), mid.HasScope(auth.ScopeReportsRead))
+	app.Handle("GET", "/v1/reports/patrons", rp.Patrons, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionReportsRead), mid.HasScope(auth.ScopeReportsRead))
+	app.Handle("GET", "/v1/reports/duration", rp.Duration, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionReportsRead), mid.HasScope(auth.ScopeReportsRead))
+	app.Handle("GET", "/v1/reports/turnover", rp.Turnover, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionReportsRead), mid.HasScope(auth.ScopeReportsRead))
+
+	// Register webhook subscriptions endpoints.
+	wh := Webhook{
+		db: db,
+	}
+	app.Handle("GET", "/v1/webhooks/all", wh.List, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionWebhooksManage), mid.HasScope(auth.ScopeWebhooksRead))
+	app.Handle("POST", "/v1/webhooks/create", wh.Create, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionWebhooksManage), mid.HasScope(auth.ScopeWebhooksWrite))
+	app.Handle("GET", "/v1/webhooks/:id", wh.Retrieve, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionWebhooksManage), mid.HasScope(auth.ScopeWebhooksRead))
+	app.Handle("PUT", "/v1/webhooks/:id/update", wh.Update, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionWebhooksManage), mid.HasScope(auth.ScopeWebhooksWrite))
+	app.Handle("DELETE", "/v1/webhooks/:id/delete", wh.Delete, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionWebhooksManage), mid.HasScope(auth.ScopeWebhooksWrite))
+	app.Handle("GET", "/v1/webhooks/:id/deliveries", wh.Deliveries, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionWebhooksManage), mid.HasScope(auth.ScopeWebhooksRead))
+	app.Handle("GET", "/v1/webhooks/deliveries/:delivery_id", wh.RetrieveDelivery, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionWebhooksManage), mid.HasScope(auth.ScopeWebhooksRead))
+	app.Handle("POST", "/v1/webhooks/deliveries/:delivery_id/replay", wh.Replay, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionWebhooksManage), mid.HasScope(auth.ScopeWebhooksWrite))
+
+	// Register domain events stream endpoint.
+	ev := Events{
+		db: db,
+	}
+	app.Handle("GET", "/v1/events/stream", ev.Stream, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionEventsRead), mid.HasScope(auth.ScopeEventsRead))
+
+	// Register roles endpoints. Only sessions manage roles, never personal
+	// access tokens.
+	rl := Role{
+		db: db,
+	}
+	app.Handle("GET", "/v1/permissions", rl.Permissions, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionRolesManage), mid.HasScope())
+	app.Handle("GET", "/v1/roles", rl.List, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionRolesManage), mid.HasScope())
+	app.Handle("POST", "/v1/roles", rl.Create, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionRolesManage), mid.HasScope())
+	app.Handle("GET", "/v1/roles/:name", rl.Retrieve, mid.Authentication(authenticator), mid.HasPermission(auth.PermissionRolesManage), mid.HasScope())
+	app.Handle("PUT", "/v1/roles/:name", rl.Update, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionRolesManage), mid.HasScope())
+	app.Handle("DELETE", "/v1/roles/:name", rl.Delete, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionRolesManage), mid.HasScope())
+	app.Handle("PUT", "/v1/users/:id/roles", rl.Assign, mid.Authentication(authenticator), mid.CSRF(), mid.HasPermission(auth.PermissionRolesManage), mid.HasScope())
 
 	return app
 }
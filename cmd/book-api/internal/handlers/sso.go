@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/oidc"
+	"github.com/book-library/internal/platform/web"
+	"github.com/book-library/internal/users"
+	errors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// defaultSSOCookieName holds the state of a sign in with the identity provider, so the answer of the provider is
+// only accepted from the browser which started it
+const defaultSSOCookieName = "sso-state"
+
+//SSOLogin sends the browser to the identity provider to sign in
+func (u *User) SSOLogin(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.SSOLogin")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		return err
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		return err
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		return err
+	}
+
+	if err := users.StartSSO(ctx, u.Db, state, nonce, verifier, v.Now); err != nil {
+		return errors.Wrap(err, "starting sign in")
+	}
+
+	to, err := u.sso.AuthCodeURL(ctx, state, nonce, challenge)
+	if err != nil {
+		return errors.Wrap(err, "building provider address")
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     defaultSSOCookieName,
+		Value:    state,
+		MaxAge:   int(users.SSOPeriod.Seconds()),
+		Path:     "/v1/users/sso/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return web.Redirect(ctx, w, r, to, http.StatusFound)
+}
+
+//SSOCallback finishes a sign in with the identity provider. The account of the user is linked or created on the
+//first sign in, then the browser is signed in with cookies and sent back to the web client, or sent to the two-factor
+//step of the web client when the account signs in with an authenticator app
+func (u *User) SSOCallback(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.SSOCallback")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		return web.NewRequestError(errors.Errorf("identity provider refused the sign in: %s", e), http.StatusUnauthorized)
+	}
+
+	state, code := q.Get("state"), q.Get("code")
+	c, err := r.Cookie(defaultSSOCookieName)
+	if err != nil || state == "" || code == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(state)) != 1 {
+		return web.NewRequestError(users.ErrInvalidToken, http.StatusBadRequest)
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: defaultSSOCookieName, Path: "/v1/users/sso/", MaxAge: -1, HttpOnly: true})
+
+	login, err := users.FinishSSO(ctx, u.Db, state, v.Now)
+	if err != nil {
+		switch err {
+		case users.ErrInvalidToken:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "finishing sign in")
+		}
+	}
+
+	idt, err := u.sso.Exchange(ctx, code, login.Verifier, login.Nonce, v.Now)
+	if err != nil {
+		switch err {
+		case oidc.ErrExchange, oidc.ErrInvalidIDToken:
+			return web.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return errors.Wrap(err, "exchanging code")
+		}
+	}
+
+	ei := users.ExternalIdentity{
+		Issuer:        idt.Issuer,
+		Subject:       idt.Subject,
+		Email:         idt.Email,
+		EmailVerified: bool(idt.EmailVerified),
+		Name:          idt.Name,
+		Roles:         u.sso.Roles(idt),
+	}
+
+	claims, err := users.SignInExternal(ctx, u.Db, ei, v.Now)
+	if err != nil {
+		switch err {
+		case users.ErrUnverifiedIdentity:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrap(err, "signing in")
+		}
+	}
+
+	// The identity provider stands for the password, the app of the account
+	// is still asked for. The web client answers the challenge like after a
+	// password, it is kept in the fragment so it never reaches a server.
+	ch, err := users.BeginLogin(ctx, u.Db, claims, v.Now)
+	if err != nil {
+		switch errors.Cause(err) {
+		case users.ErrAccountLocked:
+			retryAfter(w, err)
+			return web.NewRequestError(err, http.StatusTooManyRequests)
+		default:
+			return errors.Wrap(err, "beginning sign in")
+		}
+	}
+	if ch != nil {
+		f := url.Values{"challenge": {ch.Token}, "type": {ch.Type}}
+		to := strings.TrimSuffix(u.links.App, "/") + "/users/two-factor#" + f.Encode()
+		return web.Redirect(ctx, w, r, to, http.StatusFound)
+	}
+
+	refresh, s, err := users.StartSession(ctx, u.Db, claims.Subject, device(r), v.Now)
+	if err != nil {
+		return errors.Wrap(err, "starting session")
+	}
+	claims.SessionID = s.FamilyID
+
+	tk := users.Tokens{
+		RefreshToken: refresh,
+		ExpiresAt:    time.Unix(claims.ExpiresAt, 0).UTC(),
+	}
+	tk.Token, err = u.authenticator.GenerateToken(claims)
+	if err != nil {
+		return errors.Wrap(err, "generating token")
+	}
+
+	u.setTokenCookies(w, tk, claims.Csrf)
+
+	return web.Redirect(ctx, w, r, u.links.App+"/", http.StatusFound)
+}
+
+//Identities returns the identity provider accounts linked to an account
+func (u *User) Identities(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.Identities")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	identities, err := users.Identities(ctx, claims, u.Db, params["id"])
+	if err != nil {
+		switch err {
+		case users.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		case users.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "ID: %s", params["id"])
+		}
+	}
+
+	return web.Respond(ctx, w, identities, http.StatusOK)
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/web"
+	"github.com/book-library/internal/standing"
+	"github.com/jmoiron/sqlx"
+	errors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+//Standing represents the patron standing API method handler set.
+type Standing struct {
+	db *sqlx.DB
+}
+
+//Retrieve explains whether a patron is allowed to borrow and why not
+func (s *Standing) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.standing.Retrieve")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	st, err := standing.Retrieve(ctx, claims, s.db, params["id"], v.Now)
+	if err != nil {
+		return standingError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, st, http.StatusOK)
+}
+
+//Block prevents a patron from borrowing until the block is lifted
+func (s *Standing) Block(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.standing.Block")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var nb standing.NewBlock
+	if err := web.Decode(r, &nb); err != nil {
+		return errors.Wrap(err, "decoding block")
+	}
+
+	b, err := standing.Add(ctx, claims, s.db, params["id"], nb, v.Now)
+	if err != nil {
+		return standingError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, b, http.StatusCreated)
+}
+
+//Lift ends a block set by staff
+func (s *Standing) Lift(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.standing.Lift")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	if err := standing.Lift(ctx, claims, s.db, params["id"], v.Now); err != nil {
+		return standingError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//Membership sets until when a patron is a member of the library
+func (s *Standing) Membership(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.standing.Membership")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var m standing.Membership
+	if err := web.Decode(r, &m); err != nil {
+		return errors.Wrap(err, "decoding membership")
+	}
+
+	if err := standing.SetMembership(ctx, claims, s.db, params["id"], m, v.Now); err != nil {
+		return standingError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//Policy returns the thresholds blocking patrons automatically
+func (s *Standing) Policy(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.standing.Policy")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	p, err := standing.RetrievePolicy(ctx, claims, s.db)
+	if err != nil {
+		return standingError(err, "retrieving policy")
+	}
+
+	return web.Respond(ctx, w, p, http.StatusOK)
+}
+
+//UpdatePolicy changes the thresholds blocking patrons automatically
+func (s *Standing) UpdatePolicy(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.standing.UpdatePolicy")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var up standing.UpdatePolicy
+	if err := web.Decode(r, &up); err != nil {
+		return errors.Wrap(err, "decoding policy")
+	}
+
+	if err := standing.ChangePolicy(ctx, claims, s.db, up, v.Now); err != nil {
+		return standingError(err, "updating policy")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//standingError maps the errors of the standing package to request errors
+func standingError(err error, msg string) error {
+	switch err {
+	case standing.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case standing.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case standing.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/web"
+	"github.com/book-library/internal/users"
+	errors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+//TwoFactorLogin answers the challenge of a sign in with a code of the authenticator app or a recovery code. It
+//responds with the tokens of a new session like TokenAuthenticator
+func (u *User) TwoFactorLogin(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.TwoFactorLogin")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var ans users.ChallengeAnswer
+	if err := web.Decode(r, &ans); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	claims, codes, err := users.CompleteLogin(ctx, u.Db, ans, v.Now)
+	if err != nil {
+		retryAfter(w, err)
+		return twoFactorError(err, "completing sign in")
+	}
+
+	refresh, s, err := users.StartSession(ctx, u.Db, claims.Subject, device(r), v.Now)
+	if err != nil {
+		return errors.Wrap(err, "starting session")
+	}
+	claims.SessionID = s.FamilyID
+
+	return u.respondTokens(ctx, w, claims, refresh, codes)
+}
+
+//TwoFactorLoginEnroll returns the secret of the authenticator app to enroll to answer an enroll challenge
+func (u *User) TwoFactorLoginEnroll(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.TwoFactorLoginEnroll")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var ans users.ChallengeAnswer
+	if err := web.Decode(r, &ans); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	e, err := users.EnrollChallenge(ctx, u.Db, ans.Challenge, v.Now)
+	if err != nil {
+		return twoFactorError(err, "enrolling")
+	}
+
+	return web.Respond(ctx, w, e, http.StatusOK)
+}
+
+//TwoFactorStatus tells whether an account signs in with an authenticator app
+func (u *User) TwoFactorStatus(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.TwoFactorStatus")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	tf, err := users.TwoFactorStatus(ctx, claims, u.Db, params["id"])
+	if err != nil {
+		return twoFactorError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, tf, http.StatusOK)
+}
+
+//EnrollTwoFactor generates the secret of a new authenticator app for the account of the user
+func (u *User) EnrollTwoFactor(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.EnrollTwoFactor")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	e, err := users.Enroll(ctx, claims, u.Db, params["id"], v.Now)
+	if err != nil {
+		return twoFactorError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, e, http.StatusOK)
+}
+
+//ConfirmTwoFactor turns two-factor authentication on with a first code of the enrolled app and responds with the
+//recovery codes
+func (u *User) ConfirmTwoFactor(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.ConfirmTwoFactor")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var c users.TwoFactorCode
+	if err := web.Decode(r, &c); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	codes, err := users.ConfirmTwoFactor(ctx, claims, u.Db, params["id"], c.Code, v.Now)
+	if err != nil {
+		return twoFactorError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, users.RecoveryCodes{Codes: codes}, http.StatusOK)
+}
+
+//DisableTwoFactor turns two-factor authentication off. Users send a code, admins do not
+func (u *User) DisableTwoFactor(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.DisableTwoFactor")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	// The code is optional for admins, the body may be empty.
+	var c users.TwoFactorCode
+	if r.ContentLength != 0 {
+		if err := web.Decode(r, &c); err != nil {
+			return errors.Wrap(err, "")
+		}
+	}
+
+	if err := users.DisableTwoFactor(ctx, claims, u.Db, params["id"], c.Code, v.Now); err != nil {
+		retryAfter(w, err)
+		return twoFactorError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//RegenerateRecoveryCodes replaces the recovery codes of the account of the user
+func (u *User) RegenerateRecoveryCodes(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.RegenerateRecoveryCodes")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var c users.TwoFactorCode
+	if err := web.Decode(r, &c); err != nil {
+		return errors.Wrap(err, "")
+	}
+
+	codes, err := users.RegenerateRecoveryCodes(ctx, claims, u.Db, params["id"], c.Code, v.Now)
+	if err != nil {
+		retryAfter(w, err)
+		return twoFactorError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, users.RecoveryCodes{Codes: codes}, http.StatusOK)
+}
+
+//twoFactorError maps the errors of two-factor authentication to their status
+func twoFactorError(err error, msg string) error {
+	switch errors.Cause(err) {
+	case users.ErrInvalidID:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case users.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case users.ErrForbidden, users.ErrTwoFactorRequired:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case users.ErrInvalidCode, users.ErrInvalidChallenge:
+		return web.NewRequestError(err, http.StatusUnauthorized)
+	case users.ErrTwoFactorEnabled, users.ErrTwoFactorDisabled:
+		return web.NewRequestError(err, http.StatusConflict)
+	case users.ErrTooManyAttempts, users.ErrAccountLocked:
+		return web.NewRequestError(err, http.StatusTooManyRequests)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
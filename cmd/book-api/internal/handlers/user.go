@@ -2,14 +2,20 @@ package handlers
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
+	"github.com/book-library/internal/lockout"
+	"github.com/book-library/internal/mid"
 	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/oidc"
 	"github.com/book-library/internal/platform/web"
 	"github.com/book-library/internal/users"
 	"github.com/jmoiron/sqlx"
 	errors "github.com/pkg/errors"
 	"go.opencensus.io/trace"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -18,15 +24,19 @@ const (
 	AllowCredentialsKey        = "Access-Control-Allow-Credentials"
 	AllowHeadersKey            = "Access-Control-Allow-Headers"
 	// default names for cookies and headers
-	defaultJWTCookieName = "session-cookie"
-	defaultXsrfToken     = "x-xsrf-token"
-	OriginKey            = "Origin"
+	defaultJWTCookieName     = "session-cookie"
+	defaultRefreshCookieName = "refresh-token"
+	defaultXsrfToken         = "x-xsrf-token"
+	OriginKey                = "Origin"
 )
 
 //User represents the Users API method handler set.
 type User struct {
 	Db            *sqlx.DB
 	authenticator *auth.Authenticator
+	links         Links
+	sso           *oidc.Provider
+	secureCookies bool
 }
 
 //List returns all the existing users from the system to the world
@@ -36,15 +46,7 @@ func (u *User) List(ctx context.Context, w http.ResponseWriter, r *http.Request,
 
 	claims, ok := ctx.Value(auth.Key).(auth.Claims)
 	if !ok {
-		if !claims.HasRole(auth.RoleAdmin) {
-			return errors.New("claims missing from context")
-		}
-	}
-
-	//check if token does already exist
-	ok, err := users.IsLoggedOut(ctx, u.Db, claims.Subject, r.Header.Get("bearer"))
-	if !ok {
-		return web.NewRequestError(err, http.StatusUnauthorized)
+		return errors.New("claims missing from context")
 	}
 
 	usr, err := users.List(ctx, claims, u.Db)
@@ -62,15 +64,7 @@ func (u *User) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Requ
 
 	claims, ok := ctx.Value(auth.Key).(auth.Claims)
 	if !ok {
-		if !claims.HasRole(auth.RoleUser) {
-			return errors.New("claims missing from context")
-		}
-	}
-
-	//check if token does already exist
-	ok, err := users.IsLoggedOut(ctx, u.Db, claims.Subject, r.Header.Get("bearer"))
-	if !ok {
-		return web.NewRequestError(err, http.StatusUnauthorized)
+		return errors.New("claims missing from context")
 	}
 
 	user, err := users.Retrieve(ctx, claims, u.Db, params["id"])
@@ -94,15 +88,6 @@ func (u *User) RetrieveMe(ctx context.Context, w http.ResponseWriter, r *http.Re
 	ctx, span := trace.StartSpan(ctx, "handlers.users.Retrieve")
 	defer span.End()
 
-	//get user_id from the url
-	id := params["user_id"]
-
-	//check if token does already exist
-	ok, err := users.IsLoggedOut(ctx, u.Db, id, r.Header.Get("bearer"))
-	if !ok {
-		return web.NewRequestError(err, http.StatusUnauthorized)
-	}
-
 	claims, ok := ctx.Value(auth.Key).(auth.Claims)
 	if !ok {
 		return errors.New("claims missing from context")
@@ -118,7 +103,7 @@ func (u *User) RetrieveMe(ctx context.Context, w http.ResponseWriter, r *http.Re
 		case users.ErrNotFound:
 			return web.NewRequestError(err, http.StatusNotFound)
 		default:
-			return errors.Wrapf(err, "ID: %s", nil)
+			return errors.Wrap(err, "retrieving current users")
 		}
 	}
 	return web.Respond(ctx, w, user, http.StatusOK)
@@ -129,19 +114,6 @@ func (u *User) Create(ctx context.Context, w http.ResponseWriter, r *http.Reques
 	ctx, span := trace.StartSpan(ctx, "handlers.users.Create")
 	defer span.End()
 
-	claims, ok := ctx.Value(auth.Key).(auth.Claims)
-	if !ok {
-		if !claims.HasRole(auth.RoleAdmin) {
-			return errors.New("claims missing from context")
-		}
-	}
-
-	//check if token does already exist
-	ok, err := users.IsLoggedOut(ctx, u.Db, claims.Subject, r.Header.Get("bearer"))
-	if !ok {
-		return web.NewRequestError(err, http.StatusUnauthorized)
-	}
-
 	//we retreive hier as claim the Value(state of each request) because we are in this case creating a new users
 	//so he doesn't have any claim and role yet and have to be created first thats why a keyValue from the web
 	//is used instead
@@ -168,15 +140,6 @@ func (u *User) Update(ctx context.Context, w http.ResponseWriter, r *http.Reques
 	ctx, span := trace.StartSpan(ctx, "handlers.users.Update")
 	defer span.End()
 
-	//get user_id from the url
-	id := params["id"]
-
-	//check if token does already exist
-	ok, err := users.IsLoggedOut(ctx, u.Db, id, r.Header.Get("bearer"))
-	if !ok {
-		return web.NewRequestError(err, http.StatusUnauthorized)
-	}
-
 	v, ok := ctx.Value(web.KeyValues).(*web.Values)
 	if !ok {
 		return errors.New("web value missing from context")
@@ -192,7 +155,7 @@ func (u *User) Update(ctx context.Context, w http.ResponseWriter, r *http.Reques
 		return errors.Wrap(err, "")
 	}
 
-	err = users.Update(ctx, claims, u.Db, params["id"], udp, v.Now)
+	err := users.Update(ctx, claims, u.Db, params["id"], udp, u.verifyLink(), v.Now)
 	if err != nil {
 		switch err {
 		case users.ErrForbidden:
@@ -213,25 +176,16 @@ func (u *User) Delete(ctx context.Context, w http.ResponseWriter, r *http.Reques
 	ctx, span := trace.StartSpan(ctx, "handlers.users.Delete")
 	defer span.End()
 
-	//get user_id from the url
-	id := params["user_id"]
-
-	//check if token does already exist
-	ok, err := users.IsLoggedOut(ctx, u.Db, id, r.Header.Get("bearer"))
-	if !ok {
-		return web.NewRequestError(err, http.StatusUnauthorized)
-	}
-
 	claims, ok := ctx.Value(auth.Key).(auth.Claims)
 	if !ok {
 		return errors.New("claims missing from context")
 	}
 
-	if claims.HasRole(auth.RoleAdmin) {
-		return errors.New("you don't have role to execute this action")
+	if !claims.HasPermission(auth.PermissionUsersManage) {
+		return web.NewRequestError(users.ErrForbidden, http.StatusForbidden)
 	}
 
-	err = users.Delete(ctx, u.Db, params["id"])
+	err := users.Delete(ctx, u.Db, params["id"])
 	if err != nil {
 		switch err {
 		case users.ErrForbidden:
@@ -249,7 +203,8 @@ func (u *User) Delete(ctx context.Context, w http.ResponseWriter, r *http.Reques
 }
 
 //TokenAuthenticator handles request to authenticate the users and expects a request using Basic Auth with the User's email
-//and password. It responds with a jwt
+//and password. It responds with a short lived jwt and the refresh token of a new session, or with a challenge when the
+//account also signs in with an authenticator app
 func (u *User) TokenAuthenticator(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
 	ctx, span := trace.StartSpan(ctx, "handlers.users.TokenAuthenticator")
 	defer span.End()
@@ -267,125 +222,483 @@ func (u *User) TokenAuthenticator(ctx context.Context, w http.ResponseWriter, r
 		return web.NewRequestError(err, http.StatusUnauthorized)
 	}
 
-	claims, err := users.Authenticate(ctx, u.Db, v.Now, email, pass)
+	claims, err := users.Authenticate(ctx, u.Db, v.Now, email, pass, device(r).IP)
 	if err != nil {
-		switch err {
+		switch errors.Cause(err) {
 		case users.ErrAuthenticationFailure:
 			return web.NewRequestError(err, http.StatusUnauthorized)
+		case users.ErrNotVerified:
+			return web.NewRequestError(err, http.StatusForbidden)
+		case users.ErrTooManyAttempts, users.ErrAccountLocked:
+			retryAfter(w, err)
+			return web.NewRequestError(err, http.StatusTooManyRequests)
 		default:
 			return errors.Wrap(err, "authenticating")
 		}
 	}
 
-	var tk struct {
-		Token string `json:"token"`
+	ch, err := users.BeginLogin(ctx, u.Db, claims, v.Now)
+	if err != nil {
+		switch errors.Cause(err) {
+		case users.ErrAccountLocked:
+			retryAfter(w, err)
+			return web.NewRequestError(err, http.StatusTooManyRequests)
+		default:
+			return errors.Wrap(err, "beginning sign in")
+		}
+	}
+	if ch != nil {
+		enableCors(&w)
+		return web.Respond(ctx, w, ch, http.StatusAccepted)
+	}
+
+	refresh, s, err := users.StartSession(ctx, u.Db, claims.Subject, device(r), v.Now)
+	if err != nil {
+		return errors.Wrap(err, "starting session")
+	}
+	claims.SessionID = s.FamilyID
+
+	return u.respondTokens(ctx, w, claims, refresh, nil)
+}
+
+//RefreshToken rotates the session of a refresh token and issues a new jwt. The refresh token is read from its
+//cookie or from the body of the request
+func (u *User) RefreshToken(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.RefreshToken")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	token, err := refreshToken(r)
+	if err != nil {
+		return err
+	}
+
+	claims, refresh, err := users.RefreshSession(ctx, u.Db, token, device(r), v.Now)
+	if err != nil {
+		switch err {
+		case users.ErrInvalidRefresh, users.ErrRefreshReused:
+			return web.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return errors.Wrap(err, "refreshing session")
+		}
+	}
+
+	return u.respondTokens(ctx, w, claims, refresh, nil)
+}
+
+//Logout revokes the session of the refresh token and clears the cookies of the client
+func (u *User) Logout(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.Logout")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	token, err := refreshToken(r)
+	if err != nil {
+		return err
+	}
+
+	if err := users.Logout(ctx, u.Db, params["user_id"], token, v.Now); err != nil {
+		switch err {
+		case users.ErrInvalidRefresh:
+			return web.NewRequestError(err, http.StatusUnauthorized)
+		default:
+			return errors.Wrap(err, "logging out")
+		}
+	}
+
+	//invalidate the cookies now that the session is revoked
+	u.clearTokenCookies(w)
+
+	return web.Respond(ctx, w, "logout was successful", http.StatusOK)
+}
+
+//Sessions returns the devices signed in to an account
+func (u *User) Sessions(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.Sessions")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	sessions, err := users.Sessions(ctx, claims, u.Db, params["id"], v.Now)
+	if err != nil {
+		switch err {
+		case users.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		case users.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrapf(err, "ID: %s", params["id"])
+		}
+	}
+
+	return web.Respond(ctx, w, sessions, http.StatusOK)
+}
+
+//RevokeToken revokes the access token the request was authenticated with
+func (u *User) RevokeToken(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.RevokeToken")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	if err := users.RevokeToken(ctx, u.Db, claims, v.Now); err != nil {
+		switch err {
+		case users.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "revoking token")
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//RevokeTokens signs a user out of every device and revokes every access token issued to them
+func (u *User) RevokeTokens(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.RevokeTokens")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	if err := users.RevokeAll(ctx, claims, u.Db, params["id"], v.Now); err != nil {
+		switch err {
+		case users.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case users.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case users.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		default:
+			return errors.Wrapf(err, "ID: %s", params["id"])
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//Unlock lifts the lockout of an account after too many failed sign in attempts
+func (u *User) Unlock(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.Unlock")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
 	}
 
-//	csrf.CookieName(defaultXsrfToken)
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
 
+	if err := users.Unlock(ctx, claims, u.Db, params["id"], v.Now); err != nil {
+		switch err {
+		case users.ErrInvalidID:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		case users.ErrNotFound:
+			return web.NewRequestError(err, http.StatusNotFound)
+		case users.ErrForbidden:
+			return web.NewRequestError(err, http.StatusForbidden)
+		case users.ErrNotLocked:
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrapf(err, "ID: %s", params["id"])
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//respondTokens sends the jwt of the claims and the refresh token, both in the body and as cookies. Recovery codes
+//generated during the sign in are only sent in the body
+func (u *User) respondTokens(ctx context.Context, w http.ResponseWriter, claims auth.Claims, refresh string, codes []string) error {
+	tk := users.Tokens{
+		RefreshToken:  refresh,
+		ExpiresAt:     time.Unix(claims.ExpiresAt, 0).UTC(),
+		RecoveryCodes: codes,
+	}
+
+	var err error
 	tk.Token, err = u.authenticator.GenerateToken(claims)
 	if err != nil {
 		return errors.Wrap(err, "generating token")
 	}
 
+	u.setTokenCookies(w, tk, claims.Csrf)
+
+	enableCors(&w)
+
+	return web.Respond(ctx, w, tk, http.StatusOK)
+}
+
+//setTokenCookies sets the cookies of browsers signed in: the jwt, the refresh token and the csrf token
+func (u *User) setTokenCookies(w http.ResponseWriter, tk users.Tokens, csrf string) {
 	// Finally, we set the client cookie for "token" as the JWT we just generated
-	// we also set an expiry time which is the same as the token itself
+	// we also set an expiry time which is the same as the token itself. The
+	// refresh token is only sent back to the endpoints using it.
 	http.SetCookie(w, &http.Cookie{
 		Name:     defaultJWTCookieName,
 		Value:    tk.Token,
-		MaxAge:   int(claims.ExpiresAt),
+		MaxAge:   int(users.AccessPeriod.Seconds()),
 		Path:     "/v1/",
-		Raw:      claims.StandardClaims.Subject,
-		Secure:   false,
+		Secure:   u.secureCookies,
 		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     defaultRefreshCookieName,
+		Value:    tk.RefreshToken,
+		MaxAge:   int(users.RefreshPeriod.Seconds()),
+		Path:     "/v1/users/",
+		Secure:   u.secureCookies,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
 	})
 
-	// Set the content type and headers once we know marshaling has succeeded.
-	w.Header().Set("Content-Type", "application/json")
-	//set the hidden x-xsrf-token header
-	w.Header().Add(defaultXsrfToken, claims.Csrf)
+	//set the csrf token both as a cookie and as the hidden x-xsrf-token header. The cookie can be read by the
+	//web client, which echoes it in the X-XSRF-Token header of its requests. It lasts as long as the refresh token
+	//since refreshing the session is checked with it too
+	http.SetCookie(w, &http.Cookie{
+		Name:     defaultXsrfToken,
+		Value:    csrf,
+		MaxAge:   int(users.RefreshPeriod.Seconds()),
+		Path:     "/",
+		Secure:   u.secureCookies,
+		HttpOnly: false,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.Header().Add(defaultXsrfToken, csrf)
+}
 
-	enableCors(&w)
+//clearTokenCookies removes the cookies set by setTokenCookies
+func (u *User) clearTokenCookies(w http.ResponseWriter) {
+	for _, c := range []http.Cookie{
+		{Name: defaultJWTCookieName, Path: "/v1/", HttpOnly: true},
+		{Name: defaultRefreshCookieName, Path: "/v1/users/", HttpOnly: true},
+		{Name: defaultXsrfToken, Path: "/"},
+	} {
+		c.MaxAge = -1
+		c.Secure = u.secureCookies
+		c.SameSite = http.SameSiteStrictMode
+		http.SetCookie(w, &c)
+	}
+}
+
+//refreshToken reads the refresh token of a request from its cookie or from the body. Browsers send the cookie
+//along with cross-site requests too, so a token read from the cookie needs the csrf token echoed in the
+//X-XSRF-Token header
+func refreshToken(r *http.Request) (string, error) {
+	if c, err := r.Cookie(defaultRefreshCookieName); err == nil && c.Value != "" {
+		xsrf, err := r.Cookie(defaultXsrfToken)
+		header := r.Header.Get(defaultXsrfToken)
+		if err != nil || header == "" || subtle.ConstantTimeCompare([]byte(header), []byte(xsrf.Value)) != 1 {
+			return "", mid.ErrCSRF
+		}
+		return c.Value, nil
+	}
 
-	return web.Respond(ctx, w, tk.Token, http.StatusOK)
+	var rf users.Refresh
+	if err := web.Decode(r, &rf); err != nil || rf.RefreshToken == "" {
+		err := errors.New("expected refresh token")
+		return "", web.NewRequestError(err, http.StatusUnauthorized)
+	}
+
+	return rf.RefreshToken, nil
 }
 
-//RefreshToken refreshes a given claims by issuing a new token
-func (u *User) RefreshToken(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
-	ctx, span := trace.StartSpan(ctx, "handlers.users.RefreshToken")
+//retryAfter tells the client how long to wait before trying again after an attempt refused by the lockout
+func retryAfter(w http.ResponseWriter, err error) {
+	if d := lockout.RetryAfter(err); d > 0 {
+		w.Header().Set("Retry-After", fmt.Sprint(int((d+time.Second-1)/time.Second)))
+	}
+}
+
+//device describes the client making the request for its session
+func device(r *http.Request) users.Device {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return users.Device{UserAgent: r.UserAgent(), IP: ip}
+}
+
+//Register creates the account of a patron signing up by themselves, it can not be used until the email
+//address is verified
+func (u *User) Register(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.Register")
 	defer span.End()
 
-	_, ok := ctx.Value(web.KeyValues).(*web.Values)
-	if ok {
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
 		return web.NewShutdownError("web value missing from context")
 	}
 
-	cookie, _ := r.Cookie(defaultJWTCookieName)
-	if cookie.Value == "" {
-		err := errors.New("expected session-cookie")
-		return web.NewRequestError(err, http.StatusUnauthorized)
+	var nr users.NewRegistration
+	if err := web.Decode(r, &nr); err != nil {
+		return errors.Wrap(err, "decoding registration")
 	}
 
-	claims, err := users.RefreshesToken(ctx, u.Db, cookie.Raw)
+	user, err := users.Register(ctx, u.Db, nr, u.verifyLink(), v.Now)
 	if err != nil {
-		return web.NewRequestError(err, http.StatusConflict)
+		switch err {
+		case users.ErrEmailTaken:
+			return web.NewRequestError(err, http.StatusConflict)
+		default:
+			return errors.Wrap(err, "registering")
+		}
 	}
 
-	token, err := u.authenticator.GenerateToken(claims)
+	return web.Respond(ctx, w, user, http.StatusCreated)
+}
 
-	if err != nil {
-		return errors.Wrap(err, "generating token")
+//Verify confirms the email address of a registered patron with the token of the link sent to them
+func (u *User) Verify(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.Verify")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
 	}
 
-	cookie.Value = token
-	cookie.Expires = time.Unix(claims.ExpiresAt, 0)
+	// The link in the email is opened with a GET, clients may also post the
+	// token they extracted from it.
+	vf := users.Verification{Token: r.URL.Query().Get("token")}
+	if r.Method == http.MethodPost {
+		if err := web.Decode(r, &vf); err != nil {
+			return errors.Wrap(err, "decoding verification")
+		}
+	}
 
-	//add cookies back int the header
-	http.SetCookie(w, cookie)
-	return web.Respond(ctx, w, "Refreshed token", http.StatusOK)
+	if err := users.Verify(ctx, u.Db, vf.Token, v.Now); err != nil {
+		switch err {
+		case users.ErrInvalidToken:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "verifying")
+		}
+	}
+
+	return web.Respond(ctx, w, "email address verified", http.StatusOK)
 }
 
-func (u *User) Logout(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
-	ctx, span := trace.StartSpan(ctx, "handlers.users.Logout")
+//ResendVerification sends a new verification link to an account which is not verified yet
+func (u *User) ResendVerification(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.ResendVerification")
 	defer span.End()
 
-	_, ok := ctx.Value(web.KeyValues).(*web.Values)
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
 	if !ok {
 		return web.NewShutdownError("web value missing from context")
 	}
 
-	//get user_id from the url
-	id := params["user_id"]
-	err := users.Logout(ctx, u.Db, id)
-	if err != nil {
-		return errors.Wrap(err, "could not logout cookie already expired")
+	var rv users.ResendVerification
+	if err := web.Decode(r, &rv); err != nil {
+		return errors.Wrap(err, "decoding verification")
 	}
 
-	//get actual cookie from request
-	cookie, _ := r.Cookie(defaultJWTCookieName)
-	if cookie.Value == "" {
-		err := errors.New("expected session-cookie")
-		return web.NewRequestError(err, http.StatusUnauthorized)
+	if err := users.Resend(ctx, u.Db, rv.Email, u.verifyLink(), v.Now); err != nil {
+		switch err {
+		case users.ErrTooManyRequests:
+			w.Header().Set("Retry-After", fmt.Sprint(int(users.VerificationWindow.Seconds())))
+			return web.NewRequestError(err, http.StatusTooManyRequests)
+		default:
+			return errors.Wrap(err, "resending verification")
+		}
 	}
 
-	//invalidate cookies after session is deleted from the db
-	cookie.MaxAge = 0
-	cookie.Expires = time.Now()
+	return web.Respond(ctx, w, nil, http.StatusAccepted)
+}
 
-	parts := cookie.Value
-	claims, err := u.authenticator.ParseClaims(parts)
-	if err != nil {
-		return web.NewRequestError(err, http.StatusUnauthorized)
+//ForgotPassword emails a link to reset the password, it answers the same whether the account exists or not
+func (u *User) ForgotPassword(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.ForgotPassword")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
 	}
 
-	//invalidate the claims as well
-	claims.StandardClaims.ExpiresAt = int64(cookie.MaxAge)
-	cookie.Value = fmt.Sprint(claims)
+	var fp users.ForgotPassword
+	if err := web.Decode(r, &fp); err != nil {
+		return errors.Wrap(err, "decoding forgotten password")
+	}
 
-	//send the invalidated cookie back to the client
-	http.SetCookie(w, cookie)
+	if err := users.Forgot(ctx, u.Db, fp.Email, u.resetLink(), v.Now); err != nil {
+		return errors.Wrap(err, "forgetting password")
+	}
 
-	return web.Respond(ctx, w, "logout was successful", http.StatusOK)
+	return web.Respond(ctx, w, nil, http.StatusAccepted)
+}
+
+//ResetPassword replaces the password of a user with the token of the link sent to them
+func (u *User) ResetPassword(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.users.ResetPassword")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	var pr users.PasswordReset
+	if err := web.Decode(r, &pr); err != nil {
+		return errors.Wrap(err, "decoding password reset")
+	}
+
+	if err := users.Reset(ctx, u.Db, pr, v.Now); err != nil {
+		switch err {
+		case users.ErrInvalidToken:
+			return web.NewRequestError(err, http.StatusBadRequest)
+		default:
+			return errors.Wrap(err, "resetting password")
+		}
+	}
+
+	return web.Respond(ctx, w, "password was reset", http.StatusOK)
+}
+
+//verifyLink returns the address of the verification endpoint put in the emails
+func (u *User) verifyLink() string {
+	return strings.TrimSuffix(u.links.API, "/") + "/v1/users/verify"
+}
+
+//resetLink returns the address of the page of the web client where a new password is chosen
+func (u *User) resetLink() string {
+	return strings.TrimSuffix(u.links.App, "/") + "/users/reset-password"
 }
 
 //enableCors enables cross origin control
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/web"
+	"github.com/book-library/internal/webhook"
+	"github.com/jmoiron/sqlx"
+	errors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+//Webhook represents the webhook subscriptions API method handler set.
+type Webhook struct {
+	db *sqlx.DB
+}
+
+//List returns all the existing webhooks
+func (wh *Webhook) List(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.webhooks.List")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	hooks, err := webhook.List(ctx, claims, wh.db)
+	if err != nil {
+		return webhookError(err, "listing webhooks")
+	}
+
+	return web.Respond(ctx, w, hooks, http.StatusOK)
+}
+
+//Retrieve returns a specified webhook
+func (wh *Webhook) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.webhooks.Retrieve")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	hook, err := webhook.Retrieve(ctx, claims, wh.db, params["id"])
+	if err != nil {
+		return webhookError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, hook, http.StatusOK)
+}
+
+//Create subscribes a new endpoint to library events
+func (wh *Webhook) Create(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.webhooks.Create")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var nw webhook.NewWebhook
+	if err := web.Decode(r, &nw); err != nil {
+		return errors.Wrap(err, "decoding webhook")
+	}
+
+	hook, err := webhook.Create(ctx, claims, wh.db, nw, v.Now)
+	if err != nil {
+		return webhookError(err, "creating webhook")
+	}
+
+	return web.Respond(ctx, w, hook, http.StatusCreated)
+}
+
+//Update updates a specified webhook
+func (wh *Webhook) Update(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.webhooks.Update")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	var upd webhook.UpdateWebhook
+	if err := web.Decode(r, &upd); err != nil {
+		return errors.Wrap(err, "decoding webhook")
+	}
+
+	if err := webhook.Update(ctx, claims, wh.db, params["id"], upd, v.Now); err != nil {
+		return webhookError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//Delete removes a specified webhook along with its deliveries
+func (wh *Webhook) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.webhooks.Delete")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	if err := webhook.Delete(ctx, claims, wh.db, params["id"]); err != nil {
+		return webhookError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+//Deliveries returns the delivery log of a specified webhook
+func (wh *Webhook) Deliveries(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.webhooks.Deliveries")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	deliveries, err := webhook.Deliveries(ctx, claims, wh.db, params["id"])
+	if err != nil {
+		return webhookError(err, "ID: "+params["id"])
+	}
+
+	return web.Respond(ctx, w, deliveries, http.StatusOK)
+}
+
+//RetrieveDelivery returns a delivery along with all of its attempts
+func (wh *Webhook) RetrieveDelivery(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.webhooks.RetrieveDelivery")
+	defer span.End()
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	delivery, err := webhook.RetrieveDelivery(ctx, claims, wh.db, params["delivery_id"])
+	if err != nil {
+		return webhookError(err, "delivery: "+params["delivery_id"])
+	}
+
+	return web.Respond(ctx, w, delivery, http.StatusOK)
+}
+
+//Replay schedules a delivery to be posted again
+func (wh *Webhook) Replay(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+	ctx, span := trace.StartSpan(ctx, "handlers.webhooks.Replay")
+	defer span.End()
+
+	v, ok := ctx.Value(web.KeyValues).(*web.Values)
+	if !ok {
+		return web.NewShutdownError("web value missing from context")
+	}
+
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("claims missing from context")
+	}
+
+	if err := webhook.Replay(ctx, claims, wh.db, params["delivery_id"], v.Now); err != nil {
+		return webhookError(err, "delivery: "+params["delivery_id"])
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusAccepted)
+}
+
+//webhookError maps the errors of the webhook package to request errors
+func webhookError(err error, msg string) error {
+	switch err {
+	case webhook.ErrForbidden:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case webhook.ErrInvalidID, webhook.ErrUnknownEvent:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case webhook.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	default:
+		return errors.Wrap(err, msg)
+	}
+}
@@ -26,7 +26,7 @@ func TestBooks(t *testing.T) {
 
 	shutdown := make(chan os.Signal, 1)
 	tests := BookTests{
-		app:       handlers.API("develop", shutdown, test.Log, test.DB, test.Authenticator),
+		app:       handlers.API("develop", shutdown, test.Log, test.DB, test.Authenticator, handlers.Links{API: "http://localhost:3000", App: "http://localhost:4200"}, nil, false),
 		userToken: test.Token("admin@example.com", "gophers"),
 	}
 
@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/book-library/cmd/book-api/internal/handlers"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/oidc"
+	"github.com/book-library/internal/platform/oidc/oidctest"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+)
+
+// TestSSO signs a patron in with a local identity provider, from the first
+// redirection to the session cookies of the browser.
+func TestSSO(t *testing.T) {
+	test := tests.NewIntegration(t)
+	defer test.Teardown()
+
+	idp := oidctest.New("library")
+	defer idp.Close()
+
+	sso := oidc.NewProvider(oidc.Config{
+		Issuer:      idp.Issuer(),
+		ClientID:    "library",
+		RedirectURL: "http://localhost:3000/v1/users/sso/callback",
+		RoleClaim:   "groups",
+		RoleMap:     map[string]string{"library-staff": auth.RoleLibrarian, "library-admins": auth.RoleAdmin},
+	}, nil)
+
+	shutdown := make(chan os.Signal, 1)
+	app := handlers.API("develop", shutdown, test.Log, test.DB, test.Authenticator, handlers.Links{API: "http://localhost:3000", App: "http://localhost:4200"}, sso, false)
+
+	noRedirect := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	idp.SignIn(map[string]interface{}{
+		"sub":            "jane",
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"name":           "Jane Doe",
+		"groups":         []string{"library-staff"},
+	})
+
+	t.Log("Given the need to sign patrons in with their campus account.")
+	{
+		t.Log("\tTest 0:\tWhen the patron signs in with the identity provider.")
+		{
+			r := httptest.NewRequest("GET", "/v1/users/sso/login", nil)
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if w.Code != http.StatusFound || !strings.HasPrefix(w.Header().Get("Location"), idp.Issuer()) {
+				t.Fatalf("\t%s\tShould be sent to the identity provider : %v %s", tests.Failed, w.Code, w.Header().Get("Location"))
+			}
+			t.Logf("\t%s\tShould be sent to the identity provider.", tests.Success)
+			state := w.Result().Cookies()
+
+			resp, err := noRedirect.Get(w.Header().Get("Location"))
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to reach the identity provider : %s", tests.Failed, err)
+			}
+			resp.Body.Close()
+
+			back, err := url.Parse(resp.Header.Get("Location"))
+			if err != nil || back.Path != "/v1/users/sso/callback" {
+				t.Fatalf("\t%s\tShould be sent back to the callback : %s", tests.Failed, resp.Header.Get("Location"))
+			}
+
+			// Without the cookie of the browser which started it, the sign in
+			// is refused.
+			r = httptest.NewRequest("GET", back.RequestURI(), nil)
+			w = httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("\t%s\tShould refuse another browser : %v", tests.Failed, w.Code)
+			}
+			t.Logf("\t%s\tShould refuse another browser.", tests.Success)
+
+			r = httptest.NewRequest("GET", back.RequestURI(), nil)
+			for _, c := range state {
+				r.AddCookie(c)
+			}
+			w = httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			if w.Code != http.StatusFound || w.Header().Get("Location") != "http://localhost:4200/" {
+				t.Fatalf("\t%s\tShould be sent back to the web client : %v %s", tests.Failed, w.Code, w.Body)
+			}
+			t.Logf("\t%s\tShould be sent back to the web client.", tests.Success)
+
+			var session *http.Cookie
+			for _, c := range w.Result().Cookies() {
+				if c.Name == "session-cookie" {
+					session = c
+				}
+			}
+			if session == nil {
+				t.Fatalf("\t%s\tShould be signed in with the session cookie.", tests.Failed)
+			}
+
+			claims, err := test.Authenticator.ParseClaims(session.Value)
+			if err != nil || !claims.HasRole(auth.RoleLibrarian) {
+				t.Fatalf("\t%s\tShould receive the roles mapped from the provider : %v %v", tests.Failed, claims.Roles, err)
+			}
+			t.Logf("\t%s\tShould be signed in with the roles mapped from the provider.", tests.Success)
+
+			r = httptest.NewRequest("GET", "/v1/users/"+claims.Subject+"/identities", nil)
+			r.AddCookie(session)
+			w = httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			var identities []users.Identity
+			if err := json.NewDecoder(w.Body).Decode(&identities); err != nil || len(identities) != 1 || identities[0].Subject != "jane" {
+				t.Fatalf("\t%s\tShould list the identity of the provider : %v %+v", tests.Failed, err, identities)
+			}
+			t.Logf("\t%s\tShould list the identity of the provider.", tests.Success)
+		}
+
+		t.Log("\tTest 1:\tWhen the account of the patron requires an authenticator app.")
+		{
+			idp.SignIn(map[string]interface{}{
+				"sub":            "john",
+				"email":          "john@example.com",
+				"email_verified": true,
+				"name":           "John Doe",
+				"groups":         []string{"library-admins"},
+			})
+
+			r := httptest.NewRequest("GET", "/v1/users/sso/login", nil)
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+			state := w.Result().Cookies()
+
+			resp, err := noRedirect.Get(w.Header().Get("Location"))
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to reach the identity provider : %s", tests.Failed, err)
+			}
+			resp.Body.Close()
+
+			back, err := url.Parse(resp.Header.Get("Location"))
+			if err != nil {
+				t.Fatalf("\t%s\tShould be sent back to the callback : %s", tests.Failed, resp.Header.Get("Location"))
+			}
+
+			r = httptest.NewRequest("GET", back.RequestURI(), nil)
+			for _, c := range state {
+				r.AddCookie(c)
+			}
+			w = httptest.NewRecorder()
+			app.ServeHTTP(w, r)
+
+			to, err := url.Parse(w.Header().Get("Location"))
+			if w.Code != http.StatusFound || err != nil || to.Path != "/users/two-factor" {
+				t.Fatalf("\t%s\tShould be sent to the two-factor step : %v %s", tests.Failed, w.Code, w.Header().Get("Location"))
+			}
+			f, err := url.ParseQuery(to.Fragment)
+			if err != nil || f.Get("challenge") == "" || f.Get("type") != users.ChallengeEnroll {
+				t.Fatalf("\t%s\tShould receive the challenge to answer : %s", tests.Failed, to.Fragment)
+			}
+			t.Logf("\t%s\tShould be sent to the two-factor step.", tests.Success)
+
+			for _, c := range w.Result().Cookies() {
+				if c.Name == "session-cookie" && c.MaxAge >= 0 {
+					t.Fatalf("\t%s\tShould not be signed in before answering the challenge.", tests.Failed)
+				}
+			}
+			t.Logf("\t%s\tShould not be signed in before answering the challenge.", tests.Success)
+		}
+	}
+}
@@ -24,7 +24,7 @@ func TestUsers(t *testing.T) {
 
 	shutdown := make(chan os.Signal, 1)
 	tests := UserTests{
-		app:        handlers.API("develop", shutdown, test.Log, test.DB, test.Authenticator),
+		app:        handlers.API("develop", shutdown, test.Log, test.DB, test.Authenticator, handlers.Links{API: "http://localhost:3000", App: "http://localhost:4200"}, nil, true),
 		userToken:  test.Token("user@example.com", "gophers"),
 		adminToken: test.Token("admin@example.com", "gophers"),
 	}
@@ -40,6 +40,7 @@ func TestUsers(t *testing.T) {
 	t.Run("deleteUserNotFound", tests.deleteUserNotFound)
 	t.Run("putUser404", tests.putUser404)
 	t.Run("crudUsers", tests.crudUser)
+	t.Run("postCSRF", tests.postCSRF)
 }
 
 // UserTests holds methods for each user subtest. This type allows passing
@@ -583,4 +584,161 @@ func (ut *UserTests) putUser403(t *testing.T, id string) {
 			t.Logf("\t%s\tShould receive a status code of 403 for the response.", tests.Success)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// postCSRF validates state changing requests authenticated with the session
+// cookie must echo the csrf token of the session.
+func (ut *UserTests) postCSRF(t *testing.T) {
+	r := httptest.NewRequest("POST", "/v1/users/token", nil)
+	w := httptest.NewRecorder()
+
+	r.SetBasicAuth("users@example.com", "gophers")
+
+	// Admins sign in with a second factor, a user receives its tokens
+	// right away.
+	ut.app.ServeHTTP(w, r)
+
+	t.Log("Given the need to protect browsers against cross-site request forgery.")
+	{
+		if w.Code != http.StatusOK {
+			t.Fatalf("\t%s\tShould be able to sign in : %v", tests.Failed, w.Code)
+		}
+		cookies := w.Result().Cookies()
+
+		var csrf string
+		for _, c := range cookies {
+			if c.Name == "x-xsrf-token" {
+				csrf = c.Value
+			}
+		}
+		if csrf == "" {
+			t.Fatalf("\t%s\tShould receive a csrf cookie.", tests.Failed)
+		}
+		for _, c := range cookies {
+			if !c.Secure || c.SameSite != http.SameSiteStrictMode {
+				t.Fatalf("\t%s\tShould only send the cookie %s over https to the same site : %v", tests.Failed, c.Name, c)
+			}
+		}
+		t.Logf("\t%s\tShould only send the cookies over https to the same site.", tests.Success)
+
+		// An invalid id makes the request fail with 400 once it goes
+		// through the csrf check.
+		for i, tt := range []struct {
+			name   string
+			header string
+			code   int
+		}{
+			{"without the csrf header", "", http.StatusForbidden},
+			{"with a wrong csrf header", csrf + "x", http.StatusForbidden},
+			{"with the csrf header", csrf, http.StatusBadRequest},
+		} {
+			t.Logf("\tTest %d:\tWhen posting with the session cookie %s.", i, tt.name)
+			{
+				r := httptest.NewRequest("POST", "/v1/users/abc/2fa", nil)
+				w := httptest.NewRecorder()
+
+				for _, c := range cookies {
+					r.AddCookie(c)
+				}
+				if tt.header != "" {
+					r.Header.Set("X-XSRF-Token", tt.header)
+				}
+
+				ut.app.ServeHTTP(w, r)
+
+				if w.Code != tt.code {
+					t.Fatalf("\t%s\tShould receive a status code of %d for the response : %v", tests.Failed, tt.code, w.Code)
+				}
+				t.Logf("\t%s\tShould receive a status code of %d for the response.", tests.Success, tt.code)
+			}
+		}
+
+		t.Logf("\tTest 3:\tWhen posting with a bearer token.")
+		{
+			r := httptest.NewRequest("POST", "/v1/users/abc/revoke-tokens", nil)
+			w := httptest.NewRecorder()
+
+			r.Header.Set("Authorization", "Bearer "+ut.adminToken)
+
+			ut.app.ServeHTTP(w, r)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("\t%s\tShould not need a csrf token : %v", tests.Failed, w.Code)
+			}
+			t.Logf("\t%s\tShould not need a csrf token.", tests.Success)
+		}
+
+		for i, tt := range []struct {
+			name   string
+			target string
+			header string
+			code   int
+		}{
+			{"logging out without the csrf header", "/v1/users/" + tests.UserID + "/logout", "", http.StatusForbidden},
+			{"refreshing without the csrf header", "/v1/users/refresh-token", "", http.StatusForbidden},
+			{"refreshing with a wrong csrf header", "/v1/users/refresh-token", csrf + "x", http.StatusForbidden},
+			{"refreshing with the csrf header", "/v1/users/refresh-token", csrf, http.StatusOK},
+		} {
+			t.Logf("\tTest %d:\tWhen %s with the refresh cookie.", i+4, tt.name)
+			{
+				r := httptest.NewRequest("POST", tt.target, nil)
+				w := httptest.NewRecorder()
+
+				for _, c := range cookies {
+					r.AddCookie(c)
+				}
+				if tt.header != "" {
+					r.Header.Set("X-XSRF-Token", tt.header)
+				}
+
+				ut.app.ServeHTTP(w, r)
+
+				if w.Code != tt.code {
+					t.Fatalf("\t%s\tShould receive a status code of %d for the response : %v", tests.Failed, tt.code, w.Code)
+				}
+				t.Logf("\t%s\tShould receive a status code of %d for the response.", tests.Success, tt.code)
+			}
+		}
+	}
+}
+
+// TestUpdateOwnRoles validates a user can not grant themselves roles by
+// updating their own account.
+func TestUpdateOwnRoles(t *testing.T) {
+	test := tests.NewIntegration(t)
+	defer test.Teardown()
+
+	shutdown := make(chan os.Signal, 1)
+	app := handlers.API("develop", shutdown, test.Log, test.DB, test.Authenticator, handlers.Links{API: "http://localhost:3000", App: "http://localhost:4200"}, nil, false)
+	token := test.Token("users@example.com", "gophers")
+
+	t.Log("Given the need to keep role changes to the role managers.")
+	{
+		t.Log("\tTest 0:\tWhen a user sends roles along with their own update.")
+		{
+			body := `{"name": "User Gopher", "roles": ["ADMIN"]}`
+			r := httptest.NewRequest("PUT", "/v1/users/"+tests.UserID+"/update", strings.NewReader(body))
+			w := httptest.NewRecorder()
+
+			r.Header.Set("Authorization", "Bearer "+token)
+
+			app.ServeHTTP(w, r)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("\t%s\tShould receive a status code of 400 for the response : %v", tests.Failed, w.Code)
+			}
+			t.Logf("\t%s\tShould receive a status code of 400 for the response.", tests.Success)
+
+			var roles []string
+			const q = `SELECT unnest(roles) FROM users WHERE user_id = $1`
+			if err := test.DB.Select(&roles, q, tests.UserID); err != nil {
+				t.Fatalf("\t%s\tShould be able to read the roles of the user : %v", tests.Failed, err)
+			}
+
+			if diff := cmp.Diff([]string{auth.RoleUser}, roles); diff != "" {
+				t.Fatalf("\t%s\tShould keep the roles of the user. Diff:\n%s", tests.Failed, diff)
+			}
+			t.Logf("\t%s\tShould keep the roles of the user.", tests.Success)
+		}
+	}
+}
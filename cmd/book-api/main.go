@@ -16,8 +16,16 @@ import (
 	"contrib.go.opencensus.io/exporter/zipkin"
 	"github.com/ardanlabs/conf"
 	"github.com/book-library/cmd/book-api/internal/handlers"
+	"github.com/book-library/internal/holds"
+	"github.com/book-library/internal/notify"
 	"github.com/book-library/internal/platform/auth"
 	"github.com/book-library/internal/platform/database"
+	"github.com/book-library/internal/platform/oidc"
+	"github.com/book-library/internal/recommend"
+	"github.com/book-library/internal/session"
+	"github.com/book-library/internal/roles"
+	"github.com/book-library/internal/users"
+	"github.com/book-library/internal/webhook"
 	"github.com/dgrijalva/jwt-go"
 	openzipkin "github.com/openzipkin/zipkin-go"
 	zipkinHTTP "github.com/openzipkin/zipkin-go/reporter/http"
@@ -53,6 +61,17 @@ func run() error {
 			ReadTimeout     time.Duration `conf:"default:5s"`
 			WriteTimeout    time.Duration `conf:"default:5s"`
 			ShutdownTimeout time.Duration `conf:"default:5s"`
+			PublicURL       string        `conf:"default:http://localhost:3000"`
+			AppURL          string        `conf:"default:http://localhost:4200"`
+			SecureCookies   bool          `conf:"default:true"`
+		}
+		SSO struct {
+			Issuer       string
+			ClientID     string
+			ClientSecret string   `conf:"noprint"`
+			Scopes       []string `conf:"default:email;profile"`
+			RoleClaim    string   `conf:"default:groups"`
+			RoleMap      map[string]string
 		}
 		DB struct {
 			User       string `conf:"default:postgres"`
@@ -66,6 +85,38 @@ func run() error {
 //			PrivateKeyFile string `conf:"default:/app-library/private.pem"`
  			PrivateKeyFile string `conf:"default:private.pem"`
 			Algorithm string `conf:"default:RS256"`
+			RevocationCache time.Duration `conf:"default:10s"`
+			RoleCache time.Duration `conf:"default:10s"`
+			KeysDir   string `conf:"default:keys"`
+			JWKSURL   string
+			JWKSCache time.Duration `conf:"default:5m"`
+		}
+		Mail struct {
+			Driver   string `conf:"default:smtp"`
+			Host     string `conf:"default:localhost"`
+			Port     int    `conf:"default:25"`
+			Username string
+			Password string `conf:"noprint"`
+			From     string `conf:"default:library@example.com"`
+		}
+		Notify struct {
+			Interval       time.Duration `conf:"default:30s"`
+			MaxAttempts    int           `conf:"default:8"`
+			ReminderWindow time.Duration `conf:"default:72h"`
+		}
+		Sessions struct {
+			CleanupInterval time.Duration `conf:"default:5m"`
+		}
+		Recommend struct {
+			Interval time.Duration `conf:"default:1h"`
+		}
+		Holds struct {
+			Interval time.Duration `conf:"default:15m"`
+		}
+		Webhooks struct {
+			Interval    time.Duration `conf:"default:10s"`
+			Timeout     time.Duration `conf:"default:10s"`
+			MaxAttempts int           `conf:"default:10"`
 		}
 		Zipkin struct {
 			LocalEndpoint string  `conf:"default:0.0.0.0:3000"`
@@ -106,20 +157,47 @@ func run() error {
 
 	log.Println("main : Started : Initializing authentication support")
 
-	privateKeyPEM, err := ioutil.ReadFile(cfg.Auth.PrivateKeyFile)
-	if err != nil {
-		return errors.Wrap(err, "reading auth private key")
+	// Tokens issued by another instance are verified with the keys it
+	// publishes, it may have rotated its keys before this one.
+	var remote auth.KeyLookupFunc
+	if cfg.Auth.JWKSURL != "" {
+		remote = auth.NewRemoteKeyLookupFunc(cfg.Auth.JWKSURL, cfg.Auth.JWKSCache, &http.Client{Timeout: 5 * time.Second})
 	}
 
-	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privateKeyPEM)
-	if err != nil {
-		return errors.Wrap(err, "parsing auth private key")
-	}
+	// The keys are read from the key ring maintained by "admin keys rotate",
+	// the single private key file is used until the first rotation.
+	var authenticator *auth.Authenticator
+	ring, err := auth.LoadKeyRing(cfg.Auth.KeysDir)
+	switch {
+	case err == nil:
+		log.Printf("main : Using key ring %s : active key %q", cfg.Auth.KeysDir, ring.ActiveID())
+		authenticator, err = auth.NewKeyRingAuthenticator(ring, cfg.Auth.Algorithm, remote)
+		if err != nil {
+			return errors.Wrap(err, "constructing authenticator")
+		}
 
-	f := auth.NewSimpleKeyLookupFunc(cfg.Auth.KeyID, privateKey.Public().(*rsa.PublicKey))
-	authenticator, err := auth.NewAuthenticator(privateKey, cfg.Auth.KeyID, cfg.Auth.Algorithm, f)
-	if err != nil {
-		return errors.Wrap(err, "constructing authenticator")
+	case os.IsNotExist(errors.Cause(err)):
+		privateKeyPEM, err := ioutil.ReadFile(cfg.Auth.PrivateKeyFile)
+		if err != nil {
+			return errors.Wrap(err, "reading auth private key")
+		}
+
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privateKeyPEM)
+		if err != nil {
+			return errors.Wrap(err, "parsing auth private key")
+		}
+
+		f := auth.NewSimpleKeyLookupFunc(cfg.Auth.KeyID, privateKey.Public().(*rsa.PublicKey))
+		if remote != nil {
+			f = auth.ChainKeyLookupFuncs(f, remote)
+		}
+		authenticator, err = auth.NewAuthenticator(privateKey, cfg.Auth.KeyID, cfg.Auth.Algorithm, f)
+		if err != nil {
+			return errors.Wrap(err, "constructing authenticator")
+		}
+
+	default:
+		return errors.Wrap(err, "loading key ring")
 	}
 
 	// =========================================================================
@@ -166,6 +244,117 @@ func run() error {
 		reporter.Close()
 	}()
 
+	// =========================================================================
+	// Start Session Support
+
+	// Expired sessions are deleted in the background. Revoked and rotated
+	// sessions are kept until they expire so a reused refresh token is still
+	// recognized.
+	log.Println("main : Started : Initializing session cleanup")
+
+	sessions := session.NewPostgresManager(db.DB, cfg.Sessions.CleanupInterval)
+
+	defer func() {
+		log.Println("main : Session cleanup Stopping")
+		sessions.CleanUp()
+	}()
+
+	// Tokens are checked against the revocations on every request, the
+	// answers are cached for a short while to spare the database.
+	authenticator.UseRevocation(users.NewRevocations(db, cfg.Auth.RevocationCache).Revoked)
+
+	// The permissions of roles are read from the database, edits apply once
+	// the cache expires.
+	authenticator.UsePermissions(roles.NewCache(db, cfg.Auth.RoleCache).Permissions)
+
+	// Scripts authenticate with personal access tokens, looked up by their
+	// hash.
+	authenticator.UseAccessTokens(func(ctx context.Context, token string, now time.Time) (auth.Claims, error) {
+		return users.AccessTokenClaims(ctx, db, token, now)
+	})
+
+	// =========================================================================
+	// Start Notification Support
+
+	log.Println("main : Started : Initializing notification support")
+
+	// Emails are only written to the logs when no mail server is available,
+	// for instance while developing.
+	var sender notify.Sender
+	switch cfg.Mail.Driver {
+	case "smtp":
+		sender = notify.NewSMTPSender(notify.SMTPConfig{
+			Host:     cfg.Mail.Host,
+			Port:     cfg.Mail.Port,
+			Username: cfg.Mail.Username,
+			Password: cfg.Mail.Password,
+			From:     cfg.Mail.From,
+		})
+	case "log":
+		sender = notify.NewLogSender(log)
+	default:
+		return errors.Errorf("unknown mail driver %q", cfg.Mail.Driver)
+	}
+
+	notifier := notify.NewWorker(log, db, sender, notify.WorkerConfig{
+		Interval:       cfg.Notify.Interval,
+		MaxAttempts:    cfg.Notify.MaxAttempts,
+		ReminderWindow: cfg.Notify.ReminderWindow,
+	})
+	notifier.Start()
+
+	defer func() {
+		log.Println("main : Notification worker Stopping")
+		notifier.Stop()
+	}()
+
+	// =========================================================================
+	// Start Webhook Support
+
+	log.Println("main : Started : Initializing webhook support")
+
+	dispatcher := webhook.NewDispatcher(log, db, webhook.DispatcherConfig{
+		Interval:    cfg.Webhooks.Interval,
+		Timeout:     cfg.Webhooks.Timeout,
+		MaxAttempts: cfg.Webhooks.MaxAttempts,
+	})
+	dispatcher.Start()
+
+	defer func() {
+		log.Println("main : Webhook dispatcher Stopping")
+		dispatcher.Stop()
+	}()
+
+	// =========================================================================
+	// Start Recommendation Support
+
+	log.Println("main : Started : Initializing recommendation support")
+
+	recommender := recommend.NewJob(log, db, recommend.JobConfig{
+		Interval: cfg.Recommend.Interval,
+	})
+	recommender.Start()
+
+	defer func() {
+		log.Println("main : Recommendation job Stopping")
+		recommender.Stop()
+	}()
+
+	// =========================================================================
+	// Start Holds Support
+
+	log.Println("main : Started : Initializing holds support")
+
+	expirer := holds.NewJob(log, db, holds.JobConfig{
+		Interval: cfg.Holds.Interval,
+	})
+	expirer.Start()
+
+	defer func() {
+		log.Println("main : Holds job Stopping")
+		expirer.Stop()
+	}()
+
 	// =========================================================================
 	// Start Debug Service
 	//
@@ -181,6 +370,25 @@ func run() error {
 		log.Printf("main : Debug Listener closed : %v", http.ListenAndServe(cfg.Web.DebugHost, http.DefaultServeMux))
 	}()
 
+	// =========================================================================
+	// Start Single Sign-On Support
+
+	// Patrons can sign in with the identity provider once its issuer is set.
+	var sso *oidc.Provider
+	if cfg.SSO.Issuer != "" {
+		log.Printf("main : Started : Initializing single sign-on with %s", cfg.SSO.Issuer)
+
+		sso = oidc.NewProvider(oidc.Config{
+			Issuer:       cfg.SSO.Issuer,
+			ClientID:     cfg.SSO.ClientID,
+			ClientSecret: cfg.SSO.ClientSecret,
+			RedirectURL:  cfg.Web.PublicURL + "/v1/users/sso/callback",
+			Scopes:       cfg.SSO.Scopes,
+			RoleClaim:    cfg.SSO.RoleClaim,
+			RoleMap:      cfg.SSO.RoleMap,
+		}, &http.Client{Timeout: 10 * time.Second})
+	}
+
 	// =========================================================================
 	// Start API Service
 
@@ -193,7 +401,7 @@ func run() error {
 
 	api := http.Server{
 		Addr:         cfg.Web.APIHost,
-		Handler:      handlers.API(build, shutdown, log, db, authenticator),
+		Handler:      handlers.API(build, shutdown, log, db, authenticator, handlers.Links{API: cfg.Web.PublicURL, App: cfg.Web.AppURL}, sso, cfg.Web.SecureCookies),
 		ReadTimeout:  cfg.Web.ReadTimeout,
 		WriteTimeout: cfg.Web.WriteTimeout,
 	}
@@ -59,7 +59,7 @@ func Retrieve(ctx context.Context, user auth.Claims, db *sqlx.DB, id string) (*B
 
 	// If you do not have the admin role ...
 	// then get outta here!
-	if !user.HasRole(auth.RoleUser) {
+	if !user.HasPermission(auth.PermissionLoansBorrow, auth.PermissionBooksManage) {
 		return nil, ErrForbidden
 	}
 
@@ -83,7 +83,7 @@ func RetrieveByCategory(ctx context.Context, user auth.Claims, db *sqlx.DB, cate
 
 	// If you do not have the admin role ...
 	// then get outta here!
-	if !user.HasRole(auth.RoleUser) {
+	if !user.HasPermission(auth.PermissionLoansBorrow, auth.PermissionBooksManage) {
 		return nil, ErrForbidden
 	}
 
@@ -107,7 +107,7 @@ func Create(ctx context.Context, now time.Time, n NewBookCategory, user auth.Cla
 
 	// If you do not have the admin role ...
 	// then get outta here!
-	if !user.HasRole(auth.RoleAdmin) {
+	if !user.HasPermission(auth.PermissionBooksManage) {
 		return nil, ErrForbidden
 	}
 
@@ -142,7 +142,7 @@ func Update(ctx context.Context, id string, upd UpdateBookCategory, now time.Tim
 
 	// // If you do not have the admin role ...
 	// // then get outta here!
-	if !user.HasRole(auth.RoleAdmin) {
+	if !user.HasPermission(auth.PermissionBooksManage) {
 		return ErrForbidden
 	}
 
@@ -192,7 +192,7 @@ func Delete(ctx context.Context, id string, user auth.Claims, db *sqlx.DB) error
 	defer span.End()
 
 	// If you are not an admin and looking to retrieve someone else then you are rejected.
-	if !user.HasRole(auth.RoleAdmin) && user.Subject != id {
+	if !user.HasPermission(auth.PermissionBooksManage) && user.Subject != id {
 		return ErrForbidden
 	}
 
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	auth "github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/events"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	errors "github.com/pkg/errors"
@@ -97,34 +98,49 @@ func Create(ctx context.Context, now time.Time, n NewBook, user auth.Claims, db
 
 	// If you do not have the admin role ...
 	// then get outta here!
-	if !user.HasRole(auth.RoleAdmin) {
+	if !user.HasPermission(auth.PermissionBooksManage) {
 		return nil, ErrForbidden
 	}
 
 	book := Book{
-		ID:          uuid.New().String(),
-		Title:       n.Title,
-		ISBN:        n.ISBN,
-		Category:    n.Category,
-		Description: n.Description,
-		Quantity:    n.Quantity,
-		Authors:     n.Authors,
-		DateCreated: now.UTC(),
-		DateUpdated: now.UTC(),
+		ID:               uuid.New().String(),
+		Title:            n.Title,
+		ISBN:             n.ISBN,
+		Category:         n.Category,
+		Description:      n.Description,
+		Quantity:         n.Quantity,
+		ReplacementPrice: n.ReplacementPrice,
+		Authors:          n.Authors,
+		DateCreated:      now.UTC(),
+		DateUpdated:      now.UTC(),
 	}
 
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
 	const q = `INSERT INTO books
-		(book_id, title, isbn, category, authors, description, quantity, date_created, date_updated)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
-	_, err := db.ExecContext(
+		(book_id, title, isbn, category, authors, description, quantity, replacement_price, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	_, err = tx.ExecContext(
 		ctx, q,
 		book.ID, book.Title, book.ISBN, book.Category,book.Authors, book.Description, book.Quantity,
-		book.DateCreated, book.DateUpdated,
+		book.ReplacementPrice, book.DateCreated, book.DateUpdated,
 	)
 	if err != nil {
 		return nil, errors.Wrap(err, "inserting book")
 	}
 
+	if err := events.Record(ctx, tx, events.BookCreated, book.ID, book, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing book")
+	}
+
 	//catgory, errr  := category.RetrieveByCategory(ctx, db, book.Category)
 	//if (errr != nil) {
 	//	return nil, errors.Wrap(errr, "category might not exist ")
@@ -154,7 +170,7 @@ func Update(ctx context.Context, id string, upd UpdateBook, now time.Time, user
 
 	// If you do not have the admin role ...
 	// then get outta here!
-	if !user.HasRole(auth.RoleAdmin) {
+	if !user.HasPermission(auth.PermissionBooksManage) {
 		return ErrForbidden
 	}
 
@@ -172,6 +188,10 @@ func Update(ctx context.Context, id string, upd UpdateBook, now time.Time, user
 		book.Description = *upd.Description
 	}
 
+	if upd.ReplacementPrice != nil {
+		book.ReplacementPrice = *upd.ReplacementPrice
+	}
+
 	if upd.Authors != nil {
 		book.Authors = *upd.Authors
 	}
@@ -180,24 +200,30 @@ func Update(ctx context.Context, id string, upd UpdateBook, now time.Time, user
 		book.DateUpdated = now
 	}
 
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
 	const q = `UPDATE books SET
 	"authors" = $2,
 	"description" = $3,
-	"quantity" = $4
+	"quantity" = $4,
+	"replacement_price" = $5
 	WHERE book_id = $1`
-	_, err = db.ExecContext(ctx, q, id,
-		book.Authors, book.Description, book.Quantity,
+	_, err = tx.ExecContext(ctx, q, id,
+		book.Authors, book.Description, book.Quantity, book.ReplacementPrice,
 	)
-
-	bks2, errB2 := List(ctx, db)
-	if errB2 != nil {
-		fmt.Printf("%v", bks2)
-	}
 	if err != nil {
 		return errors.Wrap(err, "updating book")
 	}
 
-	return nil
+	if err := events.Record(ctx, tx, events.BookUpdated, book.ID, book, now); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // Delete removes a book from the database.
@@ -206,7 +232,7 @@ func Delete(ctx context.Context, id string, user auth.Claims, db *sqlx.DB) error
 	defer span.End()
 
 	// If you are not an admin and looking to retrieve someone else then you are rejected.
-	if !user.HasRole(auth.RoleAdmin) && user.Subject != id {
+	if !user.HasPermission(auth.PermissionBooksManage) && user.Subject != id {
 		return ErrForbidden
 	}
 
@@ -214,11 +240,22 @@ func Delete(ctx context.Context, id string, user auth.Claims, db *sqlx.DB) error
 		return ErrInvalidID
 	}
 
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
 	const q = `DELETE FROM books WHERE book_id = $1`
 
-	if _, err := db.ExecContext(ctx, q, id); err != nil {
+	if _, err := tx.ExecContext(ctx, q, id); err != nil {
 		return errors.Wrapf(err, "deleting book %s", id)
 	}
 
-	return nil
+	deleted := map[string]string{"id": id}
+	if err := events.Record(ctx, tx, events.BookDeleted, id, deleted, time.Now()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
@@ -0,0 +1,168 @@
+package calendar
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/utils"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Predefined errors identify expected failure conditions.
+var (
+	// ErrNotFound is used when the feed does not exist or the token does not
+	// match. Both cases look the same so tokens can not be guessed.
+	ErrNotFound = errors.New("Calendar not found")
+
+	// ErrInvalidID is used when an invalid UUID is provided.
+	ErrInvalidID = errors.New("ID is not in its proper form")
+
+	// ErrForbidden occurs when a users tries to do something that is forbidden to
+	// them according to our access control policies.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+)
+
+// Regenerate creates a new feed token for the user, invalidating the
+// previous one. The returned value is the only one holding the token.
+func Regenerate(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string, now time.Time) (*Token, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.calendar.Regenerate")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionUsersManage) && claims.Subject != userID {
+		return nil, ErrForbidden
+	}
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	b, err := utils.GenerateRandomBytes(32)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating calendar token")
+	}
+
+	t := Token{
+		UserID:      userID,
+		Token:       hex.EncodeToString(b),
+		DateCreated: now.UTC(),
+	}
+
+	const q = `INSERT INTO calendar_tokens (user_id, token_hash, date_created)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET
+		"token_hash" = EXCLUDED.token_hash,
+		"date_created" = EXCLUDED.date_created`
+	if _, err := db.ExecContext(ctx, q, t.UserID, hash(t.Token), t.DateCreated); err != nil {
+		return nil, errors.Wrap(err, "storing calendar token")
+	}
+
+	return &t, nil
+}
+
+// Feed renders the calendar of the user: the due date of every active loan
+// and the pick up deadline of every ready hold.
+func Feed(ctx context.Context, db *sqlx.DB, userID, token string, now time.Time) ([]byte, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.calendar.Feed")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var stored string
+	const qt = `SELECT token_hash FROM calendar_tokens WHERE user_id = $1`
+	if err := db.GetContext(ctx, &stored, qt, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting user %q calendar token", userID)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(hash(token))) != 1 {
+		return nil, ErrNotFound
+	}
+
+	entries, err := entries(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return Render("Library due dates", entries, now), nil
+}
+
+// entries collects the dates of the user rendered in the feed.
+func entries(ctx context.Context, db *sqlx.DB, userID string) ([]Entry, error) {
+	var loans []struct {
+		ID          string    `db:"loan_id"`
+		Title       string    `db:"title"`
+		ISBN        string    `db:"isbn"`
+		ReturnDate  time.Time `db:"date_return"`
+		DateUpdated time.Time `db:"date_updated"`
+		Changes     int       `db:"changes"`
+	}
+
+	// Every renewal and every due date set by the desk moves the date, the
+	// sequence counts both.
+	const q = `SELECT loan_id, title, isbn, date_return,
+		COALESCE(date_updated, loan_date) AS date_updated,
+		renewals + (SELECT COUNT(*) FROM loan_audit AS a
+			WHERE a.loan_id = loans.loan_id AND a.action = 'due_date_change') AS changes
+		FROM loans
+		WHERE user_id = $1 AND status = 'active'
+		ORDER BY date_return`
+	if err := db.SelectContext(ctx, &loans, q, userID); err != nil {
+		return nil, errors.Wrap(err, "selecting loans")
+	}
+
+	entries := make([]Entry, 0, len(loans))
+	for _, l := range loans {
+		entries = append(entries, Entry{
+			UID:         "loan-" + l.ID + "@book-library",
+			Summary:     fmt.Sprintf("Return %q", l.Title),
+			Description: fmt.Sprintf("%s (ISBN %s) is due back at the library.", l.Title, l.ISBN),
+			Date:        l.ReturnDate,
+			Updated:     l.DateUpdated,
+			Sequence:    l.Changes,
+		})
+	}
+
+	var holds []struct {
+		ID          string    `db:"hold_id"`
+		Title       string    `db:"title"`
+		DateExpires time.Time `db:"date_expires"`
+		DateUpdated time.Time `db:"date_updated"`
+	}
+	const qh = `SELECT hold_id, title, date_expires, date_updated FROM holds
+		WHERE user_id = $1 AND status = 'ready'
+		ORDER BY date_expires`
+	if err := db.SelectContext(ctx, &holds, qh, userID); err != nil {
+		return nil, errors.Wrap(err, "selecting holds")
+	}
+
+	for _, h := range holds {
+		entries = append(entries, Entry{
+			UID:         "hold-" + h.ID + "@book-library",
+			Summary:     fmt.Sprintf("Pick up %q", h.Title),
+			Description: fmt.Sprintf("%s is waiting for you at the desk.", h.Title),
+			Date:        h.DateExpires,
+			Updated:     h.DateUpdated,
+		})
+	}
+
+	return entries, nil
+}
+
+// hash returns the value of a token stored in the database.
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
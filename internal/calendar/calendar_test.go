@@ -0,0 +1,111 @@
+package calendar_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/books"
+	"github.com/book-library/internal/calendar"
+	loans "github.com/book-library/internal/loan"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/tests"
+)
+
+// TestRender validates the calendar is encoded following RFC 5545.
+func TestRender(t *testing.T) {
+	t.Log("Given the need to render due dates as a calendar.")
+	{
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+		entries := []calendar.Entry{
+			{
+				UID:         "loan-1@book-library",
+				Summary:     "Return \"Go, the language; volume 1\"",
+				Description: strings.Repeat("A very long description. ", 10),
+				Date:        now.Add(30 * 24 * time.Hour),
+				Updated:     now.Add(-time.Hour),
+				Sequence:    1,
+			},
+		}
+
+		ics := string(calendar.Render("Library due dates", entries, now))
+
+		lines := strings.Split(strings.TrimSuffix(ics, "\r\n"), "\r\n")
+		for _, l := range lines {
+			if len(l) > 75 {
+				t.Fatalf("\t%s\tShould fold the lines longer than 75 octets : %q.", tests.Failed, l)
+			}
+		}
+		t.Logf("\t%s\tShould fold the lines longer than 75 octets.", tests.Success)
+
+		for _, want := range []string{
+			"BEGIN:VCALENDAR",
+			"DTSTART;VALUE=DATE:20181031",
+			"DTEND;VALUE=DATE:20181101",
+			"LAST-MODIFIED:20180930T230000Z",
+			"SEQUENCE:1",
+			`SUMMARY:Return "Go\, the language\; volume 1"`,
+			"TRIGGER:-P3D",
+			"END:VCALENDAR",
+		} {
+			if !strings.Contains(ics, want+"\r\n") {
+				t.Fatalf("\t%s\tShould contain %q : %s.", tests.Failed, want, ics)
+			}
+		}
+		t.Logf("\t%s\tShould render the events with their alarm.", tests.Success)
+	}
+}
+
+// TestFeed validates the feed is only served with the current token.
+func TestFeed(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to subscribe to the due dates of a patron.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		claims := auth.NewClaims(
+			"5cf37266-3473-4006-984f-9325122678b7",
+			[]string{auth.RoleAdmin, auth.RoleUser},
+			now, time.Hour,
+			"718ffbea-f4a1-4667-8ae3-b349da52675e", // This is just some random UUID.
+		)
+
+		bk, err := books.Create(ctx, now, books.NewBook{
+			Title:    "Go programming",
+			ISBN:     "bcn22",
+			Category: "computer-science",
+			Authors:  "Bill Kenedy",
+			Quantity: 2,
+		}, claims, db)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create book : %s.", tests.Failed, err)
+		}
+
+		nl := loans.NewLoan{BookTitle: bk.Title, BookISBN: bk.ISBN, BookID: bk.ID, BookQuantity: 1}
+		if _, err := loans.InitNewLoan(ctx, claims, nl, now, bk.ID, db); err != nil {
+			t.Fatalf("\t%s\tShould be able to create loan : %s.", tests.Failed, err)
+		}
+
+		first, err := calendar.Regenerate(ctx, claims, db, claims.Subject, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to generate a token : %s.", tests.Failed, err)
+		}
+
+		feed, err := calendar.Feed(ctx, db, claims.Subject, first.Token, now)
+		if err != nil || !strings.Contains(string(feed), "Go programming") {
+			t.Fatalf("\t%s\tShould render the active loans : %s, %v.", tests.Failed, feed, err)
+		}
+		t.Logf("\t%s\tShould render the active loans.", tests.Success)
+
+		if _, err := calendar.Regenerate(ctx, claims, db, claims.Subject, now); err != nil {
+			t.Fatalf("\t%s\tShould be able to regenerate the token : %s.", tests.Failed, err)
+		}
+		if _, err := calendar.Feed(ctx, db, claims.Subject, first.Token, now); err != calendar.ErrNotFound {
+			t.Fatalf("\t%s\tShould reject the previous token : %v.", tests.Failed, err)
+		}
+		t.Logf("\t%s\tShould reject the previous token.", tests.Success)
+	}
+}
@@ -0,0 +1,100 @@
+package calendar
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// These are the settings of the rendered calendar.
+const (
+	prodID = "-//book-library//loans//EN"
+
+	// AlarmBefore is how long before the date an entry reminds the patron.
+	AlarmBefore = 3 * 24 * time.Hour
+
+	// lineLimit is the maximum length of a content line in octets, not
+	// counting the line break.
+	lineLimit = 75
+)
+
+// Render encodes the entries as an RFC 5545 calendar. Every entry is an all
+// day event with a display alarm AlarmBefore its date.
+func Render(name string, entries []Entry, now time.Time) []byte {
+	var b bytes.Buffer
+
+	line(&b, "BEGIN:VCALENDAR")
+	line(&b, "VERSION:2.0")
+	line(&b, "PRODID:"+prodID)
+	line(&b, "CALSCALE:GREGORIAN")
+	line(&b, "METHOD:PUBLISH")
+	line(&b, "X-WR-CALNAME:"+escape(name))
+
+	for _, e := range entries {
+		day := e.Date.UTC()
+
+		line(&b, "BEGIN:VEVENT")
+		line(&b, "UID:"+e.UID)
+		line(&b, "DTSTAMP:"+stamp(now))
+		if !e.Updated.IsZero() {
+			line(&b, "LAST-MODIFIED:"+stamp(e.Updated))
+		}
+		line(&b, fmt.Sprintf("SEQUENCE:%d", e.Sequence))
+		line(&b, "DTSTART;VALUE=DATE:"+day.Format("20060102"))
+		line(&b, "DTEND;VALUE=DATE:"+day.AddDate(0, 0, 1).Format("20060102"))
+		line(&b, "SUMMARY:"+escape(e.Summary))
+		if e.Description != "" {
+			line(&b, "DESCRIPTION:"+escape(e.Description))
+		}
+		line(&b, "TRANSP:TRANSPARENT")
+		line(&b, "BEGIN:VALARM")
+		line(&b, "ACTION:DISPLAY")
+		line(&b, fmt.Sprintf("TRIGGER:-P%dD", int(AlarmBefore.Hours()/24)))
+		line(&b, "DESCRIPTION:"+escape(e.Summary))
+		line(&b, "END:VALARM")
+		line(&b, "END:VEVENT")
+	}
+
+	line(&b, "END:VCALENDAR")
+
+	return b.Bytes()
+}
+
+// stamp formats a time as a UTC date-time value.
+func stamp(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// escape escapes the characters which have a meaning in TEXT values.
+func escape(s string) string {
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	)
+	return r.Replace(s)
+}
+
+// line writes a content line terminated by CRLF, folding it when it is longer
+// than lineLimit octets. Lines are never folded in the middle of a UTF-8
+// sequence.
+func line(b *bytes.Buffer, s string) {
+	limit := lineLimit
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+
+		// The leading space of the continuation counts in its length.
+		limit = lineLimit - 1
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+}
@@ -0,0 +1,25 @@
+package calendar
+
+import (
+	"time"
+)
+
+// Entry is a date a patron has to remember, rendered as one event of the
+// calendar feed. Sequence grows each time the date moves so subscribed
+// calendars replace the event they already have.
+type Entry struct {
+	UID         string
+	Summary     string
+	Description string
+	Date        time.Time
+	Updated     time.Time
+	Sequence    int
+}
+
+// Token is the secret giving access to the calendar feed of a patron. It is
+// only handed out when it is generated, the database keeps its hash.
+type Token struct {
+	UserID      string    `json:"user_id"`
+	Token       string    `json:"token"`
+	DateCreated time.Time `json:"date_created"`
+}
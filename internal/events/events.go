@@ -0,0 +1,89 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/book-library/internal/webhook"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// These are the domain events recorded by the library.
+const (
+	BookCreated    = "BookCreated"
+	BookUpdated    = "BookUpdated"
+	BookDeleted    = "BookDeleted"
+	LoanStarted    = "LoanStarted"
+	LoanReturned   = "LoanReturned"
+	LoanLost       = "LoanLost"
+	LoanDamaged    = "LoanDamaged"
+	LoanFound      = "LoanFound"
+	UserRegistered = "UserRegistered"
+	LoginLocked    = "LoginLocked"
+	LoginUnlocked  = "LoginUnlocked"
+)
+
+// outboxLock is the key of the advisory lock taken while recording an event.
+const outboxLock = 7283
+
+// webhookEvents maps the domain events to the events webhooks subscribe to.
+var webhookEvents = map[string]string{
+	BookCreated:    webhook.EventBookCreated,
+	BookUpdated:    webhook.EventBookUpdated,
+	BookDeleted:    webhook.EventBookDeleted,
+	LoanStarted:    webhook.EventLoanStarted,
+	LoanReturned:   webhook.EventLoanEnded,
+	UserRegistered: webhook.EventUserCreated,
+}
+
+// Record appends an event to the outbox and queues the matching webhook
+// deliveries. It is meant to be called with the transaction performing the
+// change of state so the event exists if and only if the change is committed.
+func Record(ctx context.Context, tx sqlx.ExtContext, typ, aggregateID string, data interface{}, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.events.Record")
+	defer span.End()
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrapf(err, "encoding %s payload", typ)
+	}
+
+	// Serialize the writers until the transaction ends. Without it a
+	// transaction could commit an event with a lower ID than one already read
+	// by a stream, and the stream would never see it.
+	const ql = `SELECT pg_advisory_xact_lock($1)`
+	if _, err := tx.ExecContext(ctx, ql, outboxLock); err != nil {
+		return errors.Wrap(err, "locking events outbox")
+	}
+
+	const q = `INSERT INTO events (type, aggregate_id, payload, occurred_at) VALUES ($1, $2, $3, $4)`
+	if _, err := tx.ExecContext(ctx, q, typ, aggregateID, string(payload), now.UTC()); err != nil {
+		return errors.Wrapf(err, "inserting %s event", typ)
+	}
+
+	if e, ok := webhookEvents[typ]; ok {
+		if err := webhook.Emit(ctx, tx, e, data, now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// After retrieves, in order, at most limit events recorded after the event
+// identified by lastID.
+func After(ctx context.Context, db *sqlx.DB, lastID int64, limit int) ([]Event, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.events.After")
+	defer span.End()
+
+	evts := []Event{}
+	const q = `SELECT * FROM events WHERE event_id > $1 ORDER BY event_id LIMIT $2`
+	if err := db.SelectContext(ctx, &evts, q, lastID, limit); err != nil {
+		return nil, errors.Wrap(err, "selecting events")
+	}
+
+	return evts, nil
+}
@@ -0,0 +1,65 @@
+package events_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/books"
+	"github.com/book-library/internal/events"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/tests"
+)
+
+// TestEvents validates events are recorded along with the changes of state
+// and can be followed from any point of the stream.
+func TestEvents(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to follow the changes of state of the library.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		// claims is information about the person making the request.
+		claims := auth.NewClaims(
+			auth.RoleAdmin,
+			[]string{auth.RoleAdmin, auth.RoleUser},
+			now, time.Hour,
+			"718ffbea-f4a1-4667-8ae3-b349da52675e", // This is just some random UUID.
+		)
+
+		nb := books.NewBook{
+			Title:    "Go programming",
+			ISBN:     "bcn22",
+			Category: "computer-science",
+			Authors:  "Bill Kenedy",
+			Quantity: 2,
+		}
+		b, err := books.Create(ctx, now, nb, claims, db)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create book : %s.", tests.Failed, err)
+		}
+		if err := books.Delete(ctx, b.ID, claims, db); err != nil {
+			t.Fatalf("\t%s\tShould be able to delete book : %s.", tests.Failed, err)
+		}
+
+		evts, err := events.After(ctx, db, 0, 10)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to read the events : %s.", tests.Failed, err)
+		}
+		if len(evts) != 2 || evts[0].Type != events.BookCreated || evts[1].Type != events.BookDeleted {
+			t.Fatalf("\t%s\tShould record the events in order : %+v.", tests.Failed, evts)
+		}
+		if evts[0].AggregateID != b.ID {
+			t.Fatalf("\t%s\tShould record the aggregate : got %q want %q.", tests.Failed, evts[0].AggregateID, b.ID)
+		}
+		t.Logf("\t%s\tShould record the events in order.", tests.Success)
+
+		evts, err = events.After(ctx, db, evts[0].ID, 10)
+		if err != nil || len(evts) != 1 || evts[0].Type != events.BookDeleted {
+			t.Fatalf("\t%s\tShould resume after the last event : %+v, %v.", tests.Failed, evts, err)
+		}
+		t.Logf("\t%s\tShould resume after the last event.", tests.Success)
+	}
+}
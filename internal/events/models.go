@@ -0,0 +1,16 @@
+package events
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event is a fact about a change of state, stored in the outbox in the same
+// transaction as the change itself.
+type Event struct {
+	ID          int64           `db:"event_id" json:"id"`
+	Type        string          `db:"type" json:"type"`
+	AggregateID string          `db:"aggregate_id" json:"aggregate_id"`
+	Payload     json.RawMessage `db:"payload" json:"payload"`
+	OccurredAt  time.Time       `db:"occurred_at" json:"occurred_at"`
+}
@@ -0,0 +1,292 @@
+package holds
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/book-library/internal/notify"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// PickupPeriod is how long a patron has to pick up a copy once the hold is
+// ready.
+const PickupPeriod = 7 * 24 * time.Hour
+
+// Hold status values. Only waiting and ready holds are active. A ready hold
+// not picked up within PickupPeriod expires.
+const (
+	StatusWaiting   = "waiting"
+	StatusReady     = "ready"
+	StatusFulfilled = "fulfilled"
+	StatusCancelled = "cancelled"
+	StatusExpired   = "expired"
+)
+
+// Predefined errors identify expected failure conditions.
+var (
+	// ErrNotFound is used when a specific Hold or book is requested but does
+	// not exist.
+	ErrNotFound = errors.New("Not found")
+
+	// ErrInvalidID is used when an invalid UUID is provided.
+	ErrInvalidID = errors.New("ID is not in its proper form")
+
+	// ErrForbidden occurs when a users tries to do something that is forbidden to
+	// them according to our access control policies.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrAvailable is used when a hold is placed on a book which has a copy
+	// on the shelf.
+	ErrAvailable = errors.New("A copy of the book is available")
+)
+
+// isStaff reports whether the claims belong to someone working at the desk.
+func isStaff(claims auth.Claims) bool {
+	return claims.HasPermission(auth.PermissionCirculation)
+}
+
+// Place puts holds on books for a patron, in the order of the request.
+// Placing a hold on a book the patron already waits for returns the
+// existing hold. Either every hold is placed or none is.
+func Place(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string, nh NewHolds, now time.Time) ([]Hold, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.holds.Place")
+	defer span.End()
+
+	if !isStaff(claims) && claims.Subject != userID {
+		return nil, ErrForbidden
+	}
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	holds := make([]Hold, 0, len(nh.BookIDs))
+	for _, id := range nh.BookIDs {
+		h, err := place(ctx, tx, userID, id, now)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, *h)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing holds")
+	}
+
+	return holds, nil
+}
+
+// ForUser retrieves the active holds of a patron. Patrons can only see their
+// own.
+func ForUser(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string) ([]Hold, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.holds.ForUser")
+	defer span.End()
+
+	if !isStaff(claims) && claims.Subject != userID {
+		return nil, ErrForbidden
+	}
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	holds := []Hold{}
+	const q = `SELECT * FROM holds WHERE user_id = $1 AND status IN ('waiting', 'ready') ORDER BY date_created`
+	if err := db.SelectContext(ctx, &holds, q, userID); err != nil {
+		return nil, errors.Wrapf(err, "selecting user %q holds", userID)
+	}
+
+	return holds, nil
+}
+
+// Cancel withdraws an active hold.
+func Cancel(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.holds.Cancel")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	var h Hold
+	const qh = `SELECT * FROM holds WHERE hold_id = $1 AND status IN ('waiting', 'ready')`
+	if err := db.GetContext(ctx, &h, qh, id); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return errors.Wrapf(err, "selecting hold %q", id)
+	}
+
+	if !isStaff(claims) && claims.Subject != h.UserID {
+		return ErrForbidden
+	}
+
+	const q = `UPDATE holds SET "status" = $2, "date_updated" = $3 WHERE hold_id = $1`
+	if _, err := db.ExecContext(ctx, q, id, StatusCancelled, now.UTC()); err != nil {
+		return errors.Wrapf(err, "cancelling hold %s", id)
+	}
+
+	return nil
+}
+
+// Promote tells the patron who waited the longest for a book that a copy came
+// back. Copies are not set aside, the patron is only asked to come and pick
+// it up within PickupPeriod. It is meant to be called within the transaction
+// putting the copy back on the shelf.
+func Promote(ctx context.Context, tx sqlx.ExtContext, bookID string, now time.Time) error {
+	var h Hold
+	const qh = `SELECT * FROM holds WHERE book_id = $1 AND status = 'waiting'
+		ORDER BY date_created LIMIT 1 FOR UPDATE`
+	if err := sqlx.GetContext(ctx, tx, &h, qh, bookID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return errors.Wrapf(err, "selecting book %q holds", bookID)
+	}
+
+	ready := now.UTC()
+	expires := now.Add(PickupPeriod).UTC()
+	const q = `UPDATE holds SET "status" = $2, "date_ready" = $3, "date_expires" = $4, "date_updated" = $3 WHERE hold_id = $1`
+	if _, err := tx.ExecContext(ctx, q, h.ID, StatusReady, ready, expires); err != nil {
+		return errors.Wrapf(err, "promoting hold %s", h.ID)
+	}
+
+	n := notify.NewNotification{
+		UserID:    h.UserID,
+		Event:     notify.EventHoldReady,
+		DedupeKey: notify.EventHoldReady + ":" + h.ID,
+		Data: map[string]interface{}{
+			"Title": h.Title,
+		},
+	}
+	if _, err := notify.Enqueue(ctx, tx, n, now); err != nil && err != notify.ErrNoRecipient {
+		return errors.Wrap(err, "queueing hold notice")
+	}
+
+	return nil
+}
+
+// Expire closes the ready holds which were not picked up within PickupPeriod
+// and promotes the next patron waiting for the book when a copy is on the
+// shelf. It returns how many holds expired.
+func Expire(ctx context.Context, db *sqlx.DB, now time.Time) (int, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.holds.Expire")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var expired []Hold
+	const q = `UPDATE holds SET "status" = $2, "date_updated" = $3
+		WHERE hold_id IN (
+			SELECT hold_id FROM holds
+			WHERE status = $1 AND date_expires <= $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`
+	if err := tx.SelectContext(ctx, &expired, q, StatusReady, StatusExpired, now.UTC()); err != nil {
+		return 0, errors.Wrap(err, "expiring holds")
+	}
+
+	for _, h := range expired {
+		var quantity int
+		const qb = `SELECT quantity FROM books WHERE book_id = $1 FOR UPDATE`
+		if err := tx.GetContext(ctx, &quantity, qb, h.BookID); err != nil {
+			if err == sql.ErrNoRows {
+				continue
+			}
+			return 0, errors.Wrapf(err, "selecting book %q", h.BookID)
+		}
+		if quantity < 1 {
+			continue
+		}
+
+		if err := Promote(ctx, tx, h.BookID, now); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "committing expired holds")
+	}
+
+	return len(expired), nil
+}
+
+// Fulfil closes the active hold of a patron on a book once they borrowed it.
+// It is meant to be called within the transaction of the checkout.
+func Fulfil(ctx context.Context, tx sqlx.ExecerContext, userID, bookID string, now time.Time) error {
+	const q = `UPDATE holds SET "status" = $3, "date_updated" = $4
+		WHERE user_id = $1 AND book_id = $2 AND status IN ('waiting', 'ready')`
+	if _, err := tx.ExecContext(ctx, q, userID, bookID, StatusFulfilled, now.UTC()); err != nil {
+		return errors.Wrapf(err, "fulfilling book %s hold", bookID)
+	}
+	return nil
+}
+
+// place puts a hold on a book for a patron, it returns the active hold of the
+// patron when there is one.
+func place(ctx context.Context, tx sqlx.ExtContext, userID, bookID string, now time.Time) (*Hold, error) {
+	if _, err := uuid.Parse(bookID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var book struct {
+		Title    string `db:"title"`
+		Quantity int    `db:"quantity"`
+	}
+	const qb = `SELECT title, quantity FROM books WHERE book_id = $1 FOR SHARE`
+	if err := sqlx.GetContext(ctx, tx, &book, qb, bookID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting book %q", bookID)
+	}
+
+	var h Hold
+	const qh = `SELECT * FROM holds WHERE user_id = $1 AND book_id = $2 AND status IN ('waiting', 'ready')`
+	err := sqlx.GetContext(ctx, tx, &h, qh, userID, bookID)
+	switch {
+	case err == nil:
+		return &h, nil
+	case err != sql.ErrNoRows:
+		return nil, errors.Wrapf(err, "selecting book %q hold", bookID)
+	}
+
+	if book.Quantity > 0 {
+		return nil, ErrAvailable
+	}
+
+	h = Hold{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		BookID:      bookID,
+		Title:       book.Title,
+		Status:      StatusWaiting,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `INSERT INTO holds
+		(hold_id, user_id, book_id, title, status, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	if _, err := tx.ExecContext(ctx, q, h.ID, h.UserID, h.BookID, h.Title, h.Status, h.DateCreated, h.DateUpdated); err != nil {
+		return nil, errors.Wrap(err, "inserting hold")
+	}
+
+	return &h, nil
+}
@@ -0,0 +1,122 @@
+package holds_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/books"
+	"github.com/book-library/internal/holds"
+	loans "github.com/book-library/internal/loan"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+)
+
+// TestHolds validates patrons are told when a book they wait for is back.
+func TestHolds(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to wait for a book every copy of which is loaned.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		admin := auth.NewClaims(
+			auth.RoleAdmin,
+			[]string{auth.RoleAdmin, auth.RoleUser},
+			now, time.Hour,
+			"718ffbea-f4a1-4667-8ae3-b349da52675e", // This is just some random UUID.
+		)
+
+		bk, err := books.Create(ctx, now, books.NewBook{Title: "Go programming", ISBN: "bcn22", Quantity: 1}, admin, db)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create book : %s.", tests.Failed, err)
+		}
+
+		var patrons []auth.Claims
+		for _, email := range []string{"jane@example.com", "john@example.com", "alex@example.com"} {
+			u, err := users.Create(ctx, db, users.NewUser{Name: "Patron", Email: email, Roles: []string{auth.RoleUser}, Password: "gophers"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create patron : %s.", tests.Failed, err)
+			}
+			patrons = append(patrons, auth.NewClaims(u.ID, []string{auth.RoleUser}, now, time.Hour, ""))
+		}
+		jane, john, alex := patrons[0], patrons[1], patrons[2]
+
+		if _, err := holds.Place(ctx, john, db, john.Subject, holds.NewHolds{BookIDs: []string{bk.ID}}, now); err != holds.ErrAvailable {
+			t.Fatalf("\t%s\tShould not hold a book on the shelf : %v.", tests.Failed, err)
+		}
+		t.Logf("\t%s\tShould not hold a book on the shelf.", tests.Success)
+
+		ln, err := loans.CheckoutBatch(ctx, jane, db, jane.Subject, loans.NewBatch{BookIDs: []string{bk.ID}}, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to borrow the book : %s.", tests.Failed, err)
+		}
+
+		t.Log("\tWhen a patron waits for the copy.")
+		{
+			placed, err := holds.Place(ctx, john, db, john.Subject, holds.NewHolds{BookIDs: []string{bk.ID}}, now)
+			if err != nil || len(placed) != 1 || placed[0].Status != holds.StatusWaiting {
+				t.Fatalf("\t%s\tShould be able to place a hold : %+v, %v.", tests.Failed, placed, err)
+			}
+			again, err := holds.Place(ctx, john, db, john.Subject, holds.NewHolds{BookIDs: []string{bk.ID}}, now)
+			if err != nil || again[0].ID != placed[0].ID {
+				t.Fatalf("\t%s\tShould keep a single hold : %+v, %v.", tests.Failed, again, err)
+			}
+			t.Logf("\t%s\tShould be able to place a single hold.", tests.Success)
+
+			if err := loans.EndUpALoan(ctx, jane, now, ln.Loans[0].ID, db); err != nil {
+				t.Fatalf("\t%s\tShould be able to return the book : %s.", tests.Failed, err)
+			}
+
+			active, err := holds.ForUser(ctx, john, db, john.Subject)
+			if err != nil || len(active) != 1 || active[0].Status != holds.StatusReady {
+				t.Fatalf("\t%s\tShould mark the hold ready : %+v, %v.", tests.Failed, active, err)
+			}
+			t.Logf("\t%s\tShould mark the hold ready when the copy is back.", tests.Success)
+
+			ln, err = loans.CheckoutBatch(ctx, john, db, john.Subject, loans.NewBatch{BookIDs: []string{bk.ID}}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to borrow the book : %s.", tests.Failed, err)
+			}
+			active, err = holds.ForUser(ctx, john, db, john.Subject)
+			if err != nil || len(active) != 0 {
+				t.Fatalf("\t%s\tShould fulfil the hold : %+v, %v.", tests.Failed, active, err)
+			}
+			t.Logf("\t%s\tShould fulfil the hold when the patron borrows the book.", tests.Success)
+		}
+
+		t.Log("\tWhen a patron does not pick up the copy.")
+		{
+			for _, p := range []auth.Claims{jane, alex} {
+				if _, err := holds.Place(ctx, p, db, p.Subject, holds.NewHolds{BookIDs: []string{bk.ID}}, now); err != nil {
+					t.Fatalf("\t%s\tShould be able to place a hold : %s.", tests.Failed, err)
+				}
+			}
+			if err := loans.EndUpALoan(ctx, john, now, ln.Loans[0].ID, db); err != nil {
+				t.Fatalf("\t%s\tShould be able to return the book : %s.", tests.Failed, err)
+			}
+
+			if n, err := holds.Expire(ctx, db, now.Add(holds.PickupPeriod-time.Second)); err != nil || n != 0 {
+				t.Fatalf("\t%s\tShould wait for the pickup period : %d, %v.", tests.Failed, n, err)
+			}
+			t.Logf("\t%s\tShould wait for the pickup period.", tests.Success)
+
+			if n, err := holds.Expire(ctx, db, now.Add(holds.PickupPeriod)); err != nil || n != 1 {
+				t.Fatalf("\t%s\tShould expire the hold : %d, %v.", tests.Failed, n, err)
+			}
+			active, err := holds.ForUser(ctx, jane, db, jane.Subject)
+			if err != nil || len(active) != 0 {
+				t.Fatalf("\t%s\tShould expire the hold : %+v, %v.", tests.Failed, active, err)
+			}
+			t.Logf("\t%s\tShould expire the hold.", tests.Success)
+
+			active, err = holds.ForUser(ctx, alex, db, alex.Subject)
+			if err != nil || len(active) != 1 || active[0].Status != holds.StatusReady {
+				t.Fatalf("\t%s\tShould promote the next patron : %+v, %v.", tests.Failed, active, err)
+			}
+			t.Logf("\t%s\tShould promote the next patron.", tests.Success)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package holds
+
+import (
+	"time"
+)
+
+// Hold is a patron waiting for a copy of a book to come back.
+type Hold struct {
+	ID          string     `db:"hold_id" json:"id"`
+	UserID      string     `db:"user_id" json:"user_id"`
+	BookID      string     `db:"book_id" json:"book_id"`
+	Title       string     `db:"title" json:"title"`
+	Status      string     `db:"status" json:"status"`
+	DateReady   *time.Time `db:"date_ready" json:"date_ready,omitempty"`     // When a copy came back for the patron.
+	DateExpires *time.Time `db:"date_expires" json:"date_expires,omitempty"` // When the patron has to pick the copy up by.
+	DateCreated time.Time  `db:"date_created" json:"date_created"`
+	DateUpdated time.Time  `db:"date_updated" json:"date_updated"`
+}
+
+// NewHolds contains the books a patron wants to be held.
+type NewHolds struct {
+	BookIDs []string `json:"book_ids" validate:"required,min=1"`
+}
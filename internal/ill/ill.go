@@ -0,0 +1,378 @@
+package ill
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/book-library/internal/notify"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// These are the states of a Request.
+const (
+	StateRequested = "requested"
+	StateOrdered   = "ordered"
+	StateReceived  = "received"
+	StateLoaned    = "loaned"
+	StateReturned  = "returned"
+	StateCancelled = "cancelled"
+)
+
+// transitions lists the states a Request can move to from each state.
+var transitions = map[string][]string{
+	StateRequested: {StateOrdered, StateCancelled},
+	StateOrdered:   {StateReceived, StateCancelled},
+	StateReceived:  {StateLoaned, StateReturned},
+	StateLoaned:    {StateReturned},
+}
+
+// Predefined errors identify expected failure conditions.
+var (
+	// ErrNotFound is used when a specific Request or Partner is requested but
+	// does not exist.
+	ErrNotFound = errors.New("Not found")
+
+	// ErrInvalidID is used when an invalid UUID is provided.
+	ErrInvalidID = errors.New("ID is not in its proper form")
+
+	// ErrForbidden occurs when a users tries to do something that is forbidden to
+	// them according to our access control policies.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrCitationRequired is used when a Request has neither an ISBN nor a
+	// citation.
+	ErrCitationRequired = errors.New("An ISBN or a citation is required")
+
+	// ErrInvalidTransition is used when a Request can not move to the asked
+	// state from its current one.
+	ErrInvalidTransition = errors.New("Invalid state transition")
+
+	// ErrPartnerRequired is used when a Request is ordered without an active
+	// partner.
+	ErrPartnerRequired = errors.New("An active partner is required")
+)
+
+// CanTransition reports whether a Request can move from one state to another.
+func CanTransition(from, to string) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// isStaff reports whether the claims belong to someone working at the desk.
+func isStaff(claims auth.Claims) bool {
+	return claims.HasPermission(auth.PermissionCirculation)
+}
+
+// AddPartner adds a library we can borrow from.
+func AddPartner(ctx context.Context, claims auth.Claims, db *sqlx.DB, np NewPartner, now time.Time) (*Partner, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.ill.AddPartner")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionILLManage) {
+		return nil, ErrForbidden
+	}
+
+	p := Partner{
+		ID:          uuid.New().String(),
+		Name:        np.Name,
+		Email:       np.Email,
+		LoanDays:    np.LoanDays,
+		Active:      true,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `INSERT INTO ill_partners
+		(partner_id, name, email, loan_days, active, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := db.ExecContext(ctx, q, p.ID, p.Name, p.Email, p.LoanDays, p.Active, p.DateCreated, p.DateUpdated)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting partner")
+	}
+
+	return &p, nil
+}
+
+// Partners retrieves every partner library.
+func Partners(ctx context.Context, claims auth.Claims, db *sqlx.DB) ([]Partner, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.ill.Partners")
+	defer span.End()
+
+	if !isStaff(claims) {
+		return nil, ErrForbidden
+	}
+
+	partners := []Partner{}
+	const q = `SELECT * FROM ill_partners ORDER BY name`
+	if err := db.SelectContext(ctx, &partners, q); err != nil {
+		return nil, errors.Wrap(err, "selecting partners")
+	}
+
+	return partners, nil
+}
+
+// ConfigurePartner modifies the configuration of a partner library. Disabled
+// partners can not be ordered from anymore.
+func ConfigurePartner(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, up UpdatePartner, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.ill.ConfigurePartner")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionILLManage) {
+		return ErrForbidden
+	}
+
+	p, err := partner(ctx, db, id)
+	if err != nil {
+		return err
+	}
+
+	if up.Name != nil {
+		p.Name = *up.Name
+	}
+	if up.Email != nil {
+		p.Email = *up.Email
+	}
+	if up.LoanDays != nil {
+		p.LoanDays = *up.LoanDays
+	}
+	if up.Active != nil {
+		p.Active = *up.Active
+	}
+	p.DateUpdated = now.UTC()
+
+	const q = `UPDATE ill_partners SET
+		"name" = $2, "email" = $3, "loan_days" = $4, "active" = $5, "date_updated" = $6
+		WHERE partner_id = $1`
+	if _, err := db.ExecContext(ctx, q, id, p.Name, p.Email, p.LoanDays, p.Active, p.DateUpdated); err != nil {
+		return errors.Wrapf(err, "updating partner %s", id)
+	}
+
+	return nil
+}
+
+// Submit records a patron asking for a title we do not own.
+func Submit(ctx context.Context, claims auth.Claims, db *sqlx.DB, nr NewRequest, now time.Time) (*Request, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.ill.Submit")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionLoansBorrow) {
+		return nil, ErrForbidden
+	}
+
+	if strings.TrimSpace(nr.ISBN) == "" && strings.TrimSpace(nr.Citation) == "" {
+		return nil, ErrCitationRequired
+	}
+
+	r := Request{
+		ID:          uuid.New().String(),
+		UserID:      claims.Subject,
+		ISBN:        strings.TrimSpace(nr.ISBN),
+		Citation:    strings.TrimSpace(nr.Citation),
+		Title:       nr.Title,
+		State:       StateRequested,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `INSERT INTO ill_requests
+		(request_id, user_id, isbn, citation, title, state, note, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, '', $7, $8)`
+	_, err := db.ExecContext(ctx, q, r.ID, r.UserID, r.ISBN, r.Citation, r.Title, r.State, r.DateCreated, r.DateUpdated)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting request")
+	}
+
+	return &r, nil
+}
+
+// List retrieves the requests in the provided state, or every request when
+// the state is empty. It is meant for staff.
+func List(ctx context.Context, claims auth.Claims, db *sqlx.DB, state string) ([]Request, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.ill.List")
+	defer span.End()
+
+	if !isStaff(claims) {
+		return nil, ErrForbidden
+	}
+
+	requests := []Request{}
+	const q = `SELECT * FROM ill_requests WHERE $1 = '' OR state = $1 ORDER BY date_created`
+	if err := db.SelectContext(ctx, &requests, q, state); err != nil {
+		return nil, errors.Wrap(err, "selecting requests")
+	}
+
+	return requests, nil
+}
+
+// ForUser retrieves the requests of a patron. Patrons can only see their own.
+func ForUser(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string) ([]Request, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.ill.ForUser")
+	defer span.End()
+
+	if !isStaff(claims) && claims.Subject != userID {
+		return nil, ErrForbidden
+	}
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	requests := []Request{}
+	const q = `SELECT * FROM ill_requests WHERE user_id = $1 ORDER BY date_created DESC`
+	if err := db.SelectContext(ctx, &requests, q, userID); err != nil {
+		return nil, errors.Wrapf(err, "selecting user %q requests", userID)
+	}
+
+	return requests, nil
+}
+
+// Retrieve finds a request by its ID. Patrons can only see their own.
+func Retrieve(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string) (*Request, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.ill.Retrieve")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var r Request
+	const q = `SELECT * FROM ill_requests WHERE request_id = $1`
+	if err := db.GetContext(ctx, &r, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting request %q", id)
+	}
+
+	if !isStaff(claims) && claims.Subject != r.UserID {
+		return nil, ErrForbidden
+	}
+
+	return &r, nil
+}
+
+// Move changes the state of a request. Staff drive the whole workflow,
+// patrons can only cancel their own requests before they are received.
+func Move(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, t Transition, now time.Time) (*Request, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.ill.Move")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var r Request
+	const qr = `SELECT * FROM ill_requests WHERE request_id = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &r, qr, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting request %q", id)
+	}
+
+	owner := claims.Subject == r.UserID && t.State == StateCancelled
+	if !isStaff(claims) && !owner {
+		return nil, ErrForbidden
+	}
+
+	if !CanTransition(r.State, t.State) {
+		return nil, ErrInvalidTransition
+	}
+
+	switch t.State {
+	case StateOrdered:
+		if _, err := uuid.Parse(t.PartnerID); err != nil {
+			return nil, ErrPartnerRequired
+		}
+		p, err := partner(ctx, tx, t.PartnerID)
+		if err != nil || !p.Active {
+			return nil, ErrPartnerRequired
+		}
+		r.PartnerID = &p.ID
+
+	case StateReceived:
+		due := t.DueDate
+		if due == nil {
+			p, err := partner(ctx, tx, *r.PartnerID)
+			if err != nil {
+				return nil, err
+			}
+			d := now.AddDate(0, 0, p.LoanDays)
+			due = &d
+		}
+		d := due.UTC()
+		r.DueDate = &d
+
+	case StateLoaned:
+		d := now.UTC()
+		r.LoanDate = &d
+	}
+
+	r.State = t.State
+	r.DateUpdated = now.UTC()
+	if t.Note != "" {
+		r.Note = t.Note
+	}
+
+	const q = `UPDATE ill_requests SET
+		"state" = $2, "partner_id" = $3, "date_loaned" = $4, "date_due" = $5, "note" = $6, "date_updated" = $7
+		WHERE request_id = $1`
+	if _, err := tx.ExecContext(ctx, q, id, r.State, r.PartnerID, r.LoanDate, r.DueDate, r.Note, r.DateUpdated); err != nil {
+		return nil, errors.Wrapf(err, "updating request %s", id)
+	}
+
+	if r.State == StateReceived {
+		n := notify.NewNotification{
+			UserID:    r.UserID,
+			Event:     notify.EventILLReceived,
+			DedupeKey: notify.EventILLReceived + ":" + r.ID,
+			Data: map[string]interface{}{
+				"Title":      r.Title,
+				"ReturnDate": *r.DueDate,
+			},
+		}
+		if _, err := notify.Enqueue(ctx, tx, n, now); err != nil && err != notify.ErrNoRecipient {
+			return nil, errors.Wrap(err, "queueing arrival notice")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing request")
+	}
+
+	return &r, nil
+}
+
+// partner finds a partner library by its ID.
+func partner(ctx context.Context, db sqlx.QueryerContext, id string) (*Partner, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var p Partner
+	const q = `SELECT * FROM ill_partners WHERE partner_id = $1`
+	if err := sqlx.GetContext(ctx, db, &p, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting partner %q", id)
+	}
+
+	return &p, nil
+}
@@ -0,0 +1,135 @@
+package ill_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/ill"
+	loans "github.com/book-library/internal/loan"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+)
+
+// TestCanTransition validates the workflow of the requests.
+func TestCanTransition(t *testing.T) {
+	t.Log("Given the need to move requests through the inter-library loan workflow.")
+	{
+		allowed := [][2]string{
+			{ill.StateRequested, ill.StateOrdered},
+			{ill.StateOrdered, ill.StateReceived},
+			{ill.StateReceived, ill.StateLoaned},
+			{ill.StateLoaned, ill.StateReturned},
+			{ill.StateRequested, ill.StateCancelled},
+		}
+		for _, tr := range allowed {
+			if !ill.CanTransition(tr[0], tr[1]) {
+				t.Fatalf("\t%s\tShould allow %s to %s.", tests.Failed, tr[0], tr[1])
+			}
+		}
+		t.Logf("\t%s\tShould allow the workflow transitions.", tests.Success)
+
+		denied := [][2]string{
+			{ill.StateRequested, ill.StateLoaned},
+			{ill.StateLoaned, ill.StateCancelled},
+			{ill.StateReturned, ill.StateLoaned},
+			{ill.StateCancelled, ill.StateOrdered},
+		}
+		for _, tr := range denied {
+			if ill.CanTransition(tr[0], tr[1]) {
+				t.Fatalf("\t%s\tShould not allow %s to %s.", tests.Failed, tr[0], tr[1])
+			}
+		}
+		t.Logf("\t%s\tShould not allow skipping or reopening requests.", tests.Success)
+	}
+}
+
+// TestRequests validates a request can go through the whole workflow.
+func TestRequests(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to borrow titles from partner libraries.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		admin := auth.NewClaims(
+			auth.RoleAdmin,
+			[]string{auth.RoleAdmin, auth.RoleUser},
+			now, time.Hour,
+			"718ffbea-f4a1-4667-8ae3-b349da52675e", // This is just some random UUID.
+		)
+
+		patron, err := users.Create(ctx, db, users.NewUser{
+			Name:     "Jane Doe",
+			Email:    "jane@example.com",
+			Roles:    []string{auth.RoleUser},
+			Password: "gophers",
+		}, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create patron : %s.", tests.Failed, err)
+		}
+		claims := auth.NewClaims(patron.ID, []string{auth.RoleUser}, now, time.Hour, "")
+
+		p, err := ill.AddPartner(ctx, admin, db, ill.NewPartner{Name: "County Library", Email: "ill@county.example.com", LoanDays: 21}, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to add a partner : %s.", tests.Failed, err)
+		}
+
+		t.Log("\tWhen a patron asks for a title we do not own.")
+		{
+			if _, err := ill.Submit(ctx, claims, db, ill.NewRequest{Title: "Unknown"}, now); err != ill.ErrCitationRequired {
+				t.Fatalf("\t%s\tShould require an ISBN or a citation : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould require an ISBN or a citation.", tests.Success)
+
+			req, err := ill.Submit(ctx, claims, db, ill.NewRequest{Title: "The Mythical Man-Month", ISBN: "9780201835953"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to submit a request : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to submit a request.", tests.Success)
+
+			if _, err := ill.Move(ctx, claims, db, req.ID, ill.Transition{State: ill.StateOrdered, PartnerID: p.ID}, now); err != ill.ErrForbidden {
+				t.Fatalf("\t%s\tShould not let the patron order the title : %v.", tests.Failed, err)
+			}
+			if _, err := ill.Move(ctx, admin, db, req.ID, ill.Transition{State: ill.StateOrdered}, now); err != ill.ErrPartnerRequired {
+				t.Fatalf("\t%s\tShould require a partner : %v.", tests.Failed, err)
+			}
+			if _, err := ill.Move(ctx, admin, db, req.ID, ill.Transition{State: ill.StateOrdered, PartnerID: p.ID}, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to order the title : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to order the title.", tests.Success)
+
+			received, err := ill.Move(ctx, admin, db, req.ID, ill.Transition{State: ill.StateReceived}, now)
+			if err != nil || !received.DueDate.Equal(now.AddDate(0, 0, 21)) {
+				t.Fatalf("\t%s\tShould use the loan period of the partner : %+v, %v.", tests.Failed, received, err)
+			}
+			t.Logf("\t%s\tShould use the loan period of the partner.", tests.Success)
+
+			if _, err := ill.Move(ctx, admin, db, req.ID, ill.Transition{State: ill.StateLoaned}, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to loan the title : %s.", tests.Failed, err)
+			}
+
+			list, err := loans.List(ctx, claims, db)
+			if err != nil || len(list) != 1 || list[0].Partner != p.Name || !list[0].ReturnDate.Equal(*received.DueDate) {
+				t.Fatalf("\t%s\tShould list the item with the patron loans : %+v, %v.", tests.Failed, list, err)
+			}
+			t.Logf("\t%s\tShould list the item with the patron loans.", tests.Success)
+
+			if _, err := ill.Move(ctx, admin, db, req.ID, ill.Transition{State: ill.StateCancelled}, now); err != ill.ErrInvalidTransition {
+				t.Fatalf("\t%s\tShould not cancel a loaned title : %v.", tests.Failed, err)
+			}
+			if _, err := ill.Move(ctx, admin, db, req.ID, ill.Transition{State: ill.StateReturned}, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to return the title : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to return the title to the partner.", tests.Success)
+
+			list, err = loans.List(ctx, claims, db)
+			if err != nil || len(list) != 0 {
+				t.Fatalf("\t%s\tShould remove the item from the patron loans : %+v, %v.", tests.Failed, list, err)
+			}
+			t.Logf("\t%s\tShould remove the item from the patron loans.", tests.Success)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package ill
+
+import (
+	"time"
+)
+
+// Partner is a library we borrow titles from.
+type Partner struct {
+	ID          string    `db:"partner_id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	Email       string    `db:"email" json:"email"`
+	LoanDays    int       `db:"loan_days" json:"loan_days"` // How long the partner lends its items for.
+	Active      bool      `db:"active" json:"active"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// NewPartner contains information needed to add a new Partner.
+type NewPartner struct {
+	Name     string `json:"name" validate:"required"`
+	Email    string `json:"email" validate:"required,email"`
+	LoanDays int    `json:"loan_days" validate:"gte=1"`
+}
+
+// UpdatePartner defines what information may be provided to modify an
+// existing Partner. All fields are optional so clients can send just the
+// fields they want changed.
+type UpdatePartner struct {
+	Name     *string `json:"name"`
+	Email    *string `json:"email" validate:"omitempty,email"`
+	LoanDays *int    `json:"loan_days" validate:"omitempty,gte=1"`
+	Active   *bool   `json:"active"`
+}
+
+// Request is a title a patron asked us to borrow from a partner library.
+type Request struct {
+	ID          string     `db:"request_id" json:"id"`
+	UserID      string     `db:"user_id" json:"user_id"`
+	PartnerID   *string    `db:"partner_id" json:"partner_id,omitempty"`
+	ISBN        string     `db:"isbn" json:"isbn,omitempty"`
+	Citation    string     `db:"citation" json:"citation,omitempty"`
+	Title       string     `db:"title" json:"title"`
+	State       string     `db:"state" json:"state"`
+	Note        string     `db:"note" json:"note,omitempty"`
+	LoanDate    *time.Time `db:"date_loaned" json:"date_loaned,omitempty"`
+	DueDate     *time.Time `db:"date_due" json:"date_due,omitempty"` // When the item has to be back at the partner.
+	DateCreated time.Time  `db:"date_created" json:"date_created"`
+	DateUpdated time.Time  `db:"date_updated" json:"date_updated"`
+}
+
+// NewRequest contains what a patron knows about the title they want. Either
+// the ISBN or a free form citation is required.
+type NewRequest struct {
+	ISBN     string `json:"isbn"`
+	Citation string `json:"citation"`
+	Title    string `json:"title" validate:"required"`
+}
+
+// Transition moves a Request to another state. The partner is required when
+// the item is ordered, the due date defaults to the loan period of the
+// partner when the item is received.
+type Transition struct {
+	State     string     `json:"state" validate:"required"`
+	PartnerID string     `json:"partner_id"`
+	DueDate   *time.Time `json:"date_due"`
+	Note      string     `json:"note"`
+}
@@ -0,0 +1,383 @@
+package kiosk
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	loans "github.com/book-library/internal/loan"
+	"github.com/book-library/internal/lockout"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/utils"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// IdleTimeout is how long a session stays open without any activity, so the
+// next person at the kiosk can not use the account of the previous one.
+const IdleTimeout = 2 * time.Minute
+
+// Headers kiosks identify themselves and their session with.
+const (
+	DeviceHeader  = "X-Kiosk-Key"
+	SessionHeader = "X-Kiosk-Session"
+)
+
+// ctxKey represents the type of value for the context key.
+type ctxKey int
+
+// Key is used to store/retrieve the current Session from a context.Context.
+const Key ctxKey = 1
+
+// These are the actions recorded during a session.
+const (
+	ActionCheckout = "checkout"
+	ActionCheckin  = "checkin"
+)
+
+// Predefined errors identify expected failure conditions.
+var (
+	// ErrNotFound is used when a specific Kiosk, patron or book is requested
+	// but does not exist.
+	ErrNotFound = errors.New("Not found")
+
+	// ErrInvalidID is used when an invalid UUID is provided.
+	ErrInvalidID = errors.New("ID is not in its proper form")
+
+	// ErrForbidden occurs when a users tries to do something that is forbidden to
+	// them according to our access control policies.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrUnknownDevice is used when the device credential is missing, wrong
+	// or belongs to a disabled kiosk.
+	ErrUnknownDevice = errors.New("Unknown kiosk")
+
+	// ErrAuthenticationFailure occurs when the card barcode or the PIN is wrong.
+	ErrAuthenticationFailure = errors.New("Authentication failed")
+
+	// ErrTooManyAttempts occurs when a PIN is tried again too soon after
+	// failing several times.
+	ErrTooManyAttempts = errors.New("Too many failed attempts, slow down")
+
+	// ErrCardLocked occurs when a PIN is tried while the card is locked after
+	// too many failures.
+	ErrCardLocked = errors.New("Too many failed attempts, ask at the desk")
+
+	// ErrSessionExpired is used when the session ended or was idle for more
+	// than IdleTimeout.
+	ErrSessionExpired = errors.New("Session expired")
+)
+
+// Register adds a new kiosk. The returned value is the only one holding the
+// device secret.
+func Register(ctx context.Context, claims auth.Claims, db *sqlx.DB, nk NewKiosk, now time.Time) (*Kiosk, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.kiosk.Register")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionKiosksManage) {
+		return nil, ErrForbidden
+	}
+
+	b, err := utils.GenerateRandomBytes(32)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating kiosk secret")
+	}
+
+	k := Kiosk{
+		ID:          uuid.New().String(),
+		Name:        nk.Name,
+		Secret:      hex.EncodeToString(b),
+		Active:      true,
+		DateCreated: now.UTC(),
+	}
+
+	const q = `INSERT INTO kiosks (kiosk_id, name, secret_hash, active, date_created)
+		VALUES ($1, $2, $3, $4, $5)`
+	if _, err := db.ExecContext(ctx, q, k.ID, k.Name, hash(k.Secret), k.Active, k.DateCreated); err != nil {
+		return nil, errors.Wrap(err, "inserting kiosk")
+	}
+
+	return &k, nil
+}
+
+// Credential returns the value kiosks send to identify themselves.
+func Credential(k Kiosk) string {
+	return k.ID + ":" + k.Secret
+}
+
+// SetCard gives a patron a library card, replacing the previous one.
+func SetCard(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string, nc NewCard, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.kiosk.SetCard")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionCirculation) {
+		return ErrForbidden
+	}
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrInvalidID
+	}
+
+	pin, err := bcrypt.GenerateFromPassword([]byte(nc.PIN), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.Wrap(err, "generating pin hash")
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	const qd = `DELETE FROM library_cards WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, qd, userID); err != nil {
+		return errors.Wrapf(err, "deleting user %s card", userID)
+	}
+
+	const q = `INSERT INTO library_cards (barcode, user_id, pin_hash, date_created)
+		VALUES ($1, $2, $3, $4)`
+	if _, err := tx.ExecContext(ctx, q, nc.Barcode, userID, pin, now.UTC()); err != nil {
+		return errors.Wrapf(err, "inserting user %s card", userID)
+	}
+
+	return tx.Commit()
+}
+
+// Start opens a session for the patron holding the card, on the kiosk
+// identified by the credential. The returned value is the only one holding
+// the session token.
+func Start(ctx context.Context, db *sqlx.DB, credential string, l Login, now time.Time) (*Session, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.kiosk.Start")
+	defer span.End()
+
+	kioskID, err := device(ctx, db, credential)
+	if err != nil {
+		return nil, err
+	}
+
+	var card struct {
+		UserID  string         `db:"user_id"`
+		PINHash []byte         `db:"pin_hash"`
+		Roles   pq.StringArray `db:"roles"`
+	}
+	// PINs are short, the failures are counted by barcode so a card can not
+	// be tried with every PIN.
+	key := lockout.Card.Key(l.Barcode)
+	switch wait, err := lockout.Check(ctx, db, now, key); err {
+	case nil:
+	case lockout.ErrLocked:
+		return nil, lockout.Retry(ErrCardLocked, wait)
+	case lockout.ErrThrottled:
+		return nil, lockout.Retry(ErrTooManyAttempts, wait)
+	default:
+		return nil, err
+	}
+
+	const qc = `SELECT c.user_id, c.pin_hash, u.roles
+		FROM library_cards AS c
+		JOIN users AS u ON u.user_id = c.user_id
+		WHERE c.barcode = $1`
+	if err := db.GetContext(ctx, &card, qc, l.Barcode); err != nil {
+		if err == sql.ErrNoRows {
+			if err := lockout.Fail(ctx, db, now, key); err != nil {
+				return nil, err
+			}
+			return nil, ErrAuthenticationFailure
+		}
+		return nil, errors.Wrap(err, "selecting card")
+	}
+
+	if err := bcrypt.CompareHashAndPassword(card.PINHash, []byte(l.PIN)); err != nil {
+		if err := lockout.Fail(ctx, db, now, key); err != nil {
+			return nil, err
+		}
+		return nil, ErrAuthenticationFailure
+	}
+	if err := lockout.Reset(ctx, db, key); err != nil {
+		return nil, err
+	}
+
+	b, err := utils.GenerateRandomBytes(32)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating session token")
+	}
+
+	s := Session{
+		ID:          uuid.New().String(),
+		KioskID:     kioskID,
+		UserID:      card.UserID,
+		Roles:       card.Roles,
+		Barcode:     l.Barcode,
+		Token:       hex.EncodeToString(b),
+		LastSeen:    now.UTC(),
+		DateCreated: now.UTC(),
+	}
+
+	const q = `INSERT INTO kiosk_sessions
+		(session_id, kiosk_id, user_id, barcode, token_hash, last_seen, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	if _, err := db.ExecContext(ctx, q, s.ID, s.KioskID, s.UserID, s.Barcode, hash(s.Token), s.LastSeen, s.DateCreated); err != nil {
+		return nil, errors.Wrap(err, "inserting kiosk session")
+	}
+
+	return &s, nil
+}
+
+// Resume validates the session token sent by the kiosk and records the
+// activity so the session does not time out.
+func Resume(ctx context.Context, db *sqlx.DB, credential, token string, now time.Time) (*Session, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.kiosk.Resume")
+	defer span.End()
+
+	kioskID, err := device(ctx, db, credential)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Session
+	const q = `UPDATE kiosk_sessions SET "last_seen" = $4
+		WHERE token_hash = $1 AND kiosk_id = $2 AND date_ended IS NULL AND last_seen > $3
+		RETURNING session_id, kiosk_id, user_id, barcode, last_seen, date_created,
+			(SELECT roles FROM users WHERE users.user_id = kiosk_sessions.user_id) AS roles`
+	if err := db.GetContext(ctx, &s, q, hash(token), kioskID, now.Add(-IdleTimeout).UTC(), now.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSessionExpired
+		}
+		return nil, errors.Wrap(err, "resuming kiosk session")
+	}
+
+	return &s, nil
+}
+
+// Checkout loans the scanned book to the patron of the session.
+func Checkout(ctx context.Context, db *sqlx.DB, s Session, sc Scan, now time.Time) (*loans.Loan, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.kiosk.Checkout")
+	defer span.End()
+
+	var bookID string
+	const qb = `SELECT book_id FROM books WHERE isbn = $1 ORDER BY quantity DESC LIMIT 1`
+	if err := db.GetContext(ctx, &bookID, qb, sc.Barcode); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting book %q", sc.Barcode)
+	}
+
+	r, err := loans.CheckoutBatch(ctx, patron(s, now), db, s.UserID, loans.NewBatch{BookIDs: []string{bookID}}, now)
+	if err != nil {
+		return nil, err
+	}
+	loan := r.Loans[0]
+
+	if err := record(ctx, db, s, ActionCheckout, loan, now); err != nil {
+		return nil, err
+	}
+
+	return &loan, nil
+}
+
+// Checkin ends the loan of the scanned book held by the patron of the session.
+func Checkin(ctx context.Context, db *sqlx.DB, s Session, sc Scan, now time.Time) (*loans.Loan, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.kiosk.Checkin")
+	defer span.End()
+
+	var loan loans.Loan
+	const ql = `SELECT * FROM loans WHERE user_id = $1 AND isbn = $2 AND status = $3 ORDER BY loan_date LIMIT 1`
+	if err := db.GetContext(ctx, &loan, ql, s.UserID, sc.Barcode, loans.StatusActive); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting loan of %q", sc.Barcode)
+	}
+
+	if err := loans.EndUpALoan(ctx, patron(s, now), now, loan.ID, db); err != nil {
+		return nil, err
+	}
+
+	if err := record(ctx, db, s, ActionCheckin, loan, now); err != nil {
+		return nil, err
+	}
+
+	return &loan, nil
+}
+
+// Finish ends the session and returns its receipt.
+func Finish(ctx context.Context, db *sqlx.DB, s Session, now time.Time) ([]byte, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.kiosk.Finish")
+	defer span.End()
+
+	const q = `UPDATE kiosk_sessions SET "date_ended" = $2 WHERE session_id = $1`
+	if _, err := db.ExecContext(ctx, q, s.ID, now.UTC()); err != nil {
+		return nil, errors.Wrapf(err, "ending kiosk session %s", s.ID)
+	}
+
+	activity := []Activity{}
+	const qa = `SELECT * FROM kiosk_activity WHERE session_id = $1 ORDER BY date_created`
+	if err := db.SelectContext(ctx, &activity, qa, s.ID); err != nil {
+		return nil, errors.Wrapf(err, "selecting kiosk session %s activity", s.ID)
+	}
+
+	return Receipt(s, activity, now), nil
+}
+
+// device authenticates the kiosk sending the credential and returns its ID.
+func device(ctx context.Context, db *sqlx.DB, credential string) (string, error) {
+	parts := strings.SplitN(credential, ":", 2)
+	if len(parts) != 2 {
+		return "", ErrUnknownDevice
+	}
+	if _, err := uuid.Parse(parts[0]); err != nil {
+		return "", ErrUnknownDevice
+	}
+
+	var k struct {
+		SecretHash string `db:"secret_hash"`
+		Active     bool   `db:"active"`
+	}
+	const q = `SELECT secret_hash, active FROM kiosks WHERE kiosk_id = $1`
+	if err := db.GetContext(ctx, &k, q, parts[0]); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrUnknownDevice
+		}
+		return "", errors.Wrap(err, "selecting kiosk")
+	}
+
+	if !k.Active || subtle.ConstantTimeCompare([]byte(k.SecretHash), []byte(hash(parts[1]))) != 1 {
+		return "", ErrUnknownDevice
+	}
+
+	return parts[0], nil
+}
+
+// patron returns the claims the session acts with on behalf of the patron.
+// Without resolved permissions the roles grant their DefaultPermissions.
+func patron(s Session, now time.Time) auth.Claims {
+	c := auth.NewClaims(s.UserID, s.Roles, now, IdleTimeout, "")
+	c.Permissions = s.Permissions
+	return c
+}
+
+// record appends a check out or a check in to the activity of the session.
+func record(ctx context.Context, db *sqlx.DB, s Session, action string, l loans.Loan, now time.Time) error {
+	const q = `INSERT INTO kiosk_activity
+		(session_id, loan_id, action, title, isbn, date_return, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	if _, err := db.ExecContext(ctx, q, s.ID, l.ID, action, l.BookTitle, l.BookISBN, l.ReturnDate, now.UTC()); err != nil {
+		return errors.Wrapf(err, "recording kiosk %s", action)
+	}
+	return nil
+}
+
+// hash returns the value of a secret stored in the database.
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
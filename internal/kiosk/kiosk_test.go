@@ -0,0 +1,168 @@
+package kiosk_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/books"
+	"github.com/book-library/internal/kiosk"
+	loans "github.com/book-library/internal/loan"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/roles"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+)
+
+// TestReceipt validates the receipt fits the kiosk printer.
+func TestReceipt(t *testing.T) {
+	t.Log("Given the need to print what a patron did at the kiosk.")
+	{
+		now := time.Date(2018, time.October, 1, 10, 0, 0, 0, time.UTC)
+		s := kiosk.Session{Barcode: "29000012345678"}
+		activity := []kiosk.Activity{
+			{Action: kiosk.ActionCheckout, Title: "Go programming", ReturnDate: now.AddDate(0, 0, 30)},
+			{Action: kiosk.ActionCheckin, Title: "The Go Programming Language, a rather long title indeed"},
+		}
+
+		receipt := string(kiosk.Receipt(s, activity, now))
+
+		for _, l := range strings.Split(receipt, "\n") {
+			if len([]rune(l)) > 40 {
+				t.Fatalf("\t%s\tShould fit the printer width : %q.", tests.Failed, l)
+			}
+		}
+		t.Logf("\t%s\tShould fit the printer width.", tests.Success)
+
+		for _, want := range []string{"**********5678", "Checked out (1):", "Due: 31 Oct 2018", "Returned (1):"} {
+			if !strings.Contains(receipt, want) {
+				t.Fatalf("\t%s\tShould contain %q : %s.", tests.Failed, want, receipt)
+			}
+		}
+		t.Logf("\t%s\tShould list the books with their due date.", tests.Success)
+	}
+}
+
+// TestKiosk validates a patron can check books out and in at a kiosk.
+func TestKiosk(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to serve patrons at a self-service kiosk.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		admin := auth.NewClaims(
+			auth.RoleAdmin,
+			[]string{auth.RoleAdmin, auth.RoleUser},
+			now, time.Hour,
+			"718ffbea-f4a1-4667-8ae3-b349da52675e", // This is just some random UUID.
+		)
+
+		if _, err := books.Create(ctx, now, books.NewBook{
+			Title:    "Go programming",
+			ISBN:     "9780134190440",
+			Category: "computer-science",
+			Authors:  "Bill Kenedy",
+			Quantity: 1,
+		}, admin, db); err != nil {
+			t.Fatalf("\t%s\tShould be able to create book : %s.", tests.Failed, err)
+		}
+
+		patron, err := users.Create(ctx, db, users.NewUser{
+			Name:     "Jane Doe",
+			Email:    "jane@example.com",
+			Roles:    []string{auth.RoleUser},
+			Password: "gophers",
+		}, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create patron : %s.", tests.Failed, err)
+		}
+
+		if err := kiosk.SetCard(ctx, admin, db, patron.ID, kiosk.NewCard{Barcode: "29000012345678", PIN: "1234"}, now); err != nil {
+			t.Fatalf("\t%s\tShould be able to give a card : %s.", tests.Failed, err)
+		}
+
+		device, err := kiosk.Register(ctx, admin, db, kiosk.NewKiosk{Name: "Entrance"}, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to register a kiosk : %s.", tests.Failed, err)
+		}
+		cred := kiosk.Credential(*device)
+
+		t.Log("\tWhen the patron enters a wrong PIN.")
+		{
+			if _, err := kiosk.Start(ctx, db, cred, kiosk.Login{Barcode: "29000012345678", PIN: "0000"}, now); err != kiosk.ErrAuthenticationFailure {
+				t.Fatalf("\t%s\tShould not open a session : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould not open a session.", tests.Success)
+		}
+
+		t.Log("\tWhen the patron borrows and returns a book.")
+		{
+			s, err := kiosk.Start(ctx, db, cred, kiosk.Login{Barcode: "29000012345678", PIN: "1234"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to open a session : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to open a session.", tests.Success)
+
+			if _, err := kiosk.Checkout(ctx, db, *s, kiosk.Scan{Barcode: "9780134190440"}, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to check the book out : %s.", tests.Failed, err)
+			}
+			if _, err := kiosk.Checkin(ctx, db, *s, kiosk.Scan{Barcode: "9780134190440"}, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to check the book in : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to check the book out and in.", tests.Success)
+
+			receipt, err := kiosk.Finish(ctx, db, *s, now)
+			if err != nil || !strings.Contains(string(receipt), "Returned (1):") {
+				t.Fatalf("\t%s\tShould print the receipt : %s, %v.", tests.Failed, receipt, err)
+			}
+			t.Logf("\t%s\tShould print the receipt.", tests.Success)
+
+			if _, err := kiosk.Resume(ctx, db, cred, s.Token, now); err != kiosk.ErrSessionExpired {
+				t.Fatalf("\t%s\tShould end the session : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould end the session.", tests.Success)
+		}
+
+		t.Log("\tWhen the role of the patron no longer grants borrowing.")
+		{
+			upd := roles.UpdateRole{Permissions: []string{auth.PermissionAccount}}
+			if err := roles.Update(ctx, admin, db, auth.RoleUser, upd, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to update the role : %s.", tests.Failed, err)
+			}
+
+			started, err := kiosk.Start(ctx, db, cred, kiosk.Login{Barcode: "29000012345678", PIN: "1234"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to open a session : %s.", tests.Failed, err)
+			}
+			s, err := kiosk.Resume(ctx, db, cred, started.Token, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to resume the session : %s.", tests.Failed, err)
+			}
+			s.Permissions, err = roles.NewCache(db, time.Minute).Permissions(ctx, s.Roles)
+			if err != nil {
+				t.Fatalf("\t%s\tShould resolve the permissions : %s.", tests.Failed, err)
+			}
+
+			if _, err := kiosk.Checkout(ctx, db, *s, kiosk.Scan{Barcode: "9780134190440"}, now); err != loans.ErrForbidden {
+				t.Fatalf("\t%s\tShould act with the permissions of the role : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould act with the permissions of the role.", tests.Success)
+		}
+
+		t.Log("\tWhen the patron walks away.")
+		{
+			s, err := kiosk.Start(ctx, db, cred, kiosk.Login{Barcode: "29000012345678", PIN: "1234"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to open a session : %s.", tests.Failed, err)
+			}
+
+			if _, err := kiosk.Resume(ctx, db, cred, s.Token, now.Add(kiosk.IdleTimeout+time.Second)); err != kiosk.ErrSessionExpired {
+				t.Fatalf("\t%s\tShould time the session out : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould time the session out.", tests.Success)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package kiosk
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Kiosk is a self-service device allowed to open patron sessions.
+type Kiosk struct {
+	ID          string    `db:"kiosk_id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	Secret      string    `db:"-" json:"secret,omitempty"`
+	Active      bool      `db:"active" json:"active"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
+
+// NewKiosk contains information needed to register a new Kiosk.
+type NewKiosk struct {
+	Name string `json:"name" validate:"required"`
+}
+
+// NewCard contains the library card barcode and PIN of a patron.
+type NewCard struct {
+	Barcode string `json:"barcode" validate:"required"`
+	PIN     string `json:"pin" validate:"required,numeric,min=4,max=8"`
+}
+
+// Login contains what a patron enters at the kiosk to open a session.
+type Login struct {
+	Barcode string `json:"barcode" validate:"required"`
+	PIN     string `json:"pin" validate:"required"`
+}
+
+// Scan contains the barcode of a book scanned at the kiosk. Books are
+// identified by the ISBN printed on their cover.
+type Scan struct {
+	Barcode string `json:"barcode" validate:"required"`
+}
+
+// Session is a patron using a kiosk. It ends when the patron finishes or
+// after IdleTimeout without activity.
+type Session struct {
+	ID          string    `db:"session_id" json:"id"`
+	KioskID     string    `db:"kiosk_id" json:"kiosk_id"`
+	UserID      string    `db:"user_id" json:"user_id"`
+	Barcode     string    `db:"barcode" json:"-"`
+	Token       string    `db:"-" json:"token,omitempty"`
+	LastSeen    time.Time `db:"last_seen" json:"last_seen"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+
+	// Roles are the roles of the card holder, the session acts with the
+	// Permissions they grant. They are resolved like those of a token.
+	Roles       pq.StringArray `db:"roles" json:"-"`
+	Permissions []string       `db:"-" json:"-"`
+}
+
+// Activity is a check out or a check in made during a Session.
+type Activity struct {
+	SessionID   string    `db:"session_id" json:"session_id"`
+	LoanID      string    `db:"loan_id" json:"loan_id"`
+	Action      string    `db:"action" json:"action"`
+	Title       string    `db:"title" json:"title"`
+	ISBN        string    `db:"isbn" json:"isbn"`
+	ReturnDate  time.Time `db:"date_return" json:"date_return"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
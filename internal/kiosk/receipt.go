@@ -0,0 +1,84 @@
+package kiosk
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// receiptWidth is the number of characters a receipt printer fits on a line.
+const receiptWidth = 40
+
+// Receipt renders the activity of a session as plain text for the receipt
+// printer of the kiosk.
+func Receipt(s Session, activity []Activity, now time.Time) []byte {
+	var b bytes.Buffer
+
+	center(&b, "BOOK LIBRARY")
+	center(&b, "Self-service receipt")
+	b.WriteString(strings.Repeat("-", receiptWidth) + "\n")
+	fmt.Fprintf(&b, "Date: %s\n", now.UTC().Format("02 Jan 2006 15:04 MST"))
+	fmt.Fprintf(&b, "Card: %s\n", mask(s.Barcode))
+
+	sections := []struct {
+		action string
+		title  string
+	}{
+		{ActionCheckout, "Checked out"},
+		{ActionCheckin, "Returned"},
+	}
+	for _, sec := range sections {
+		var lines []Activity
+		for _, a := range activity {
+			if a.Action == sec.action {
+				lines = append(lines, a)
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&b, "\n%s (%d):\n", sec.title, len(lines))
+		for _, a := range lines {
+			b.WriteString("  " + truncate(a.Title, receiptWidth-2) + "\n")
+			if a.Action == ActionCheckout {
+				fmt.Fprintf(&b, "    Due: %s\n", a.ReturnDate.UTC().Format("02 Jan 2006"))
+			}
+		}
+	}
+
+	if len(activity) == 0 {
+		b.WriteString("\nNo books checked out or returned.\n")
+	}
+
+	b.WriteString(strings.Repeat("-", receiptWidth) + "\n")
+	center(&b, "Thank you!")
+
+	return b.Bytes()
+}
+
+// center writes a line centered on the receipt.
+func center(b *bytes.Buffer, s string) {
+	if pad := (receiptWidth - len(s)) / 2; pad > 0 {
+		b.WriteString(strings.Repeat(" ", pad))
+	}
+	b.WriteString(s + "\n")
+}
+
+// truncate shortens s to at most n runes.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-3]) + "..."
+}
+
+// mask hides all but the last four characters of a card barcode.
+func mask(barcode string) string {
+	if len(barcode) <= 4 {
+		return barcode
+	}
+	return strings.Repeat("*", len(barcode)-4) + barcode[len(barcode)-4:]
+}
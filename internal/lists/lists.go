@@ -0,0 +1,409 @@
+package lists
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/book-library/internal/holds"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/utils"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Predefined errors identify expected failure conditions.
+var (
+	// ErrNotFound is used when a specific List, entry or book is requested but
+	// does not exist. Private lists of other patrons are reported as not found.
+	ErrNotFound = errors.New("Not found")
+
+	// ErrInvalidID is used when an invalid UUID is provided.
+	ErrInvalidID = errors.New("ID is not in its proper form")
+
+	// ErrForbidden occurs when a users tries to do something that is forbidden to
+	// them according to our access control policies.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrAlreadyListed is used when a book is saved twice in the same List.
+	ErrAlreadyListed = errors.New("Book is already in the list")
+
+	// ErrOrderMismatch is used when a new order does not list every book of
+	// the List exactly once.
+	ErrOrderMismatch = errors.New("Order must list every book of the list once")
+)
+
+// Create adds a new List for the patron.
+func Create(ctx context.Context, claims auth.Claims, db *sqlx.DB, nl NewList, now time.Time) (*List, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.lists.Create")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionListsManage) {
+		return nil, ErrForbidden
+	}
+
+	b, err := utils.GenerateRandomBytes(16)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating share token")
+	}
+
+	l := List{
+		ID:          uuid.New().String(),
+		UserID:      claims.Subject,
+		Name:        nl.Name,
+		Description: nl.Description,
+		Public:      nl.Public,
+		ShareToken:  hex.EncodeToString(b),
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `INSERT INTO lists
+		(list_id, user_id, name, description, public, share_token, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err = db.ExecContext(ctx, q, l.ID, l.UserID, l.Name, l.Description, l.Public, l.ShareToken, l.DateCreated, l.DateUpdated)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting list")
+	}
+
+	return &l, nil
+}
+
+// ForUser retrieves the lists of a patron, without their entries. Other
+// patrons only see the public ones.
+func ForUser(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string) ([]List, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.lists.ForUser")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	lists := []List{}
+	const q = `SELECT * FROM lists WHERE user_id = $1 AND (public OR $2) ORDER BY name`
+	if err := db.SelectContext(ctx, &lists, q, userID, claims.Subject == userID); err != nil {
+		return nil, errors.Wrapf(err, "selecting user %q lists", userID)
+	}
+
+	return lists, nil
+}
+
+// Retrieve finds a List by its ID along with its entries. Private lists are
+// only visible to their owner.
+func Retrieve(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string) (*List, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.lists.Retrieve")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	const q = `SELECT * FROM lists WHERE list_id = $1`
+	l, err := lookup(ctx, db, q, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !l.Public && l.UserID != claims.Subject {
+		return nil, ErrNotFound
+	}
+
+	if l.Entries, err = entries(ctx, db, l.ID); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Shared finds a public List by the token of its share link. It does not
+// require to be signed in.
+func Shared(ctx context.Context, db *sqlx.DB, token string) (*List, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.lists.Shared")
+	defer span.End()
+
+	const q = `SELECT * FROM lists WHERE share_token = $1 AND public`
+	l, err := lookup(ctx, db, q, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.Entries, err = entries(ctx, db, l.ID); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// Update modifies the name, description or visibility of a List.
+func Update(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, ul UpdateList, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.lists.Update")
+	defer span.End()
+
+	l, err := owned(ctx, claims, db, id)
+	if err != nil {
+		return err
+	}
+
+	if ul.Name != nil {
+		l.Name = *ul.Name
+	}
+	if ul.Description != nil {
+		l.Description = *ul.Description
+	}
+	if ul.Public != nil {
+		l.Public = *ul.Public
+	}
+
+	const q = `UPDATE lists SET "name" = $2, "description" = $3, "public" = $4, "date_updated" = $5 WHERE list_id = $1`
+	if _, err := db.ExecContext(ctx, q, id, l.Name, l.Description, l.Public, now.UTC()); err != nil {
+		return errors.Wrapf(err, "updating list %s", id)
+	}
+
+	return nil
+}
+
+// Delete removes a List and its entries.
+func Delete(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string) error {
+	ctx, span := trace.StartSpan(ctx, "internal.lists.Delete")
+	defer span.End()
+
+	if _, err := owned(ctx, claims, db, id); err != nil {
+		return err
+	}
+
+	const q = `DELETE FROM lists WHERE list_id = $1`
+	if _, err := db.ExecContext(ctx, q, id); err != nil {
+		return errors.Wrapf(err, "deleting list %s", id)
+	}
+
+	return nil
+}
+
+// AddEntry saves a book at the end of a List.
+func AddEntry(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, ne NewEntry, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.lists.AddEntry")
+	defer span.End()
+
+	if _, err := owned(ctx, claims, db, id); err != nil {
+		return err
+	}
+
+	if _, err := uuid.Parse(ne.BookID); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `INSERT INTO list_entries (list_id, book_id, position, note, date_added)
+		SELECT $1, b.book_id, COALESCE((SELECT MAX(position) FROM list_entries WHERE list_id = $1), 0) + 1, $3, $4
+		FROM books AS b WHERE b.book_id = $2
+		ON CONFLICT (list_id, book_id) DO NOTHING`
+	res, err := db.ExecContext(ctx, q, id, ne.BookID, ne.Note, now.UTC())
+	if err != nil {
+		return errors.Wrapf(err, "adding book %s to list %s", ne.BookID, id)
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		var exists bool
+		const qb = `SELECT EXISTS (SELECT 1 FROM books WHERE book_id = $1)`
+		if err := db.GetContext(ctx, &exists, qb, ne.BookID); err != nil {
+			return errors.Wrapf(err, "selecting book %q", ne.BookID)
+		}
+		if !exists {
+			return ErrNotFound
+		}
+		return ErrAlreadyListed
+	}
+
+	return touch(ctx, db, id, now)
+}
+
+// UpdateEntry changes the note of a book saved in a List.
+func UpdateEntry(ctx context.Context, claims auth.Claims, db *sqlx.DB, id, bookID string, en EntryNote, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.lists.UpdateEntry")
+	defer span.End()
+
+	if _, err := owned(ctx, claims, db, id); err != nil {
+		return err
+	}
+
+	if _, err := uuid.Parse(bookID); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `UPDATE list_entries SET "note" = $3 WHERE list_id = $1 AND book_id = $2`
+	res, err := db.ExecContext(ctx, q, id, bookID, en.Note)
+	if err != nil {
+		return errors.Wrapf(err, "updating book %s in list %s", bookID, id)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrNotFound
+	}
+
+	return touch(ctx, db, id, now)
+}
+
+// RemoveEntry takes a book out of a List.
+func RemoveEntry(ctx context.Context, claims auth.Claims, db *sqlx.DB, id, bookID string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.lists.RemoveEntry")
+	defer span.End()
+
+	if _, err := owned(ctx, claims, db, id); err != nil {
+		return err
+	}
+
+	if _, err := uuid.Parse(bookID); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `DELETE FROM list_entries WHERE list_id = $1 AND book_id = $2`
+	res, err := db.ExecContext(ctx, q, id, bookID)
+	if err != nil {
+		return errors.Wrapf(err, "removing book %s from list %s", bookID, id)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrNotFound
+	}
+
+	return touch(ctx, db, id, now)
+}
+
+// Reorder sets the position of every book of a List.
+func Reorder(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, o Order, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.lists.Reorder")
+	defer span.End()
+
+	if _, err := owned(ctx, claims, db, id); err != nil {
+		return err
+	}
+
+	ids := make([]string, 0, len(o.BookIDs))
+	seen := make(map[string]bool, len(o.BookIDs))
+	for _, bookID := range o.BookIDs {
+		u, err := uuid.Parse(bookID)
+		if err != nil {
+			return ErrInvalidID
+		}
+		if seen[u.String()] {
+			return ErrOrderMismatch
+		}
+		seen[u.String()] = true
+		ids = append(ids, u.String())
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	// The positions are given by the index of the books in the order.
+	const q = `UPDATE list_entries AS e SET "position" = o.position
+		FROM unnest($2::uuid[]) WITH ORDINALITY AS o(book_id, position)
+		WHERE e.list_id = $1 AND e.book_id = o.book_id`
+	res, err := tx.ExecContext(ctx, q, id, pq.Array(ids))
+	if err != nil {
+		return errors.Wrapf(err, "reordering list %s", id)
+	}
+
+	var total int
+	const qc = `SELECT COUNT(*) FROM list_entries WHERE list_id = $1`
+	if err := tx.GetContext(ctx, &total, qc, id); err != nil {
+		return errors.Wrapf(err, "counting list %s entries", id)
+	}
+	if n, err := res.RowsAffected(); err != nil || int(n) != len(ids) || total != len(ids) {
+		return ErrOrderMismatch
+	}
+
+	if err := touch(ctx, tx, id, now); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// HoldUnavailable places holds for the owner of a List on every book of the
+// List with no copy on the shelf.
+func HoldUnavailable(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, now time.Time) ([]holds.Hold, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.lists.HoldUnavailable")
+	defer span.End()
+
+	if _, err := owned(ctx, claims, db, id); err != nil {
+		return nil, err
+	}
+
+	es, err := entries(ctx, db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var nh holds.NewHolds
+	for _, e := range es {
+		if !e.Available {
+			nh.BookIDs = append(nh.BookIDs, e.BookID)
+		}
+	}
+	if len(nh.BookIDs) == 0 {
+		return []holds.Hold{}, nil
+	}
+
+	return holds.Place(ctx, claims, db, claims.Subject, nh, now)
+}
+
+// lookup finds a single List with the provided query.
+func lookup(ctx context.Context, db *sqlx.DB, q string, arg string) (*List, error) {
+	var l List
+	if err := db.GetContext(ctx, &l, q, arg); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting list %q", arg)
+	}
+	return &l, nil
+}
+
+// owned finds a List which belongs to the user of the claims.
+func owned(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string) (*List, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	const q = `SELECT * FROM lists WHERE list_id = $1`
+	l, err := lookup(ctx, db, q, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.UserID != claims.Subject {
+		if l.Public {
+			return nil, ErrForbidden
+		}
+		return nil, ErrNotFound
+	}
+
+	return l, nil
+}
+
+// entries retrieves the books of a List in their order.
+func entries(ctx context.Context, db *sqlx.DB, id string) ([]Entry, error) {
+	entries := []Entry{}
+	const q = `SELECT e.book_id, b.title, b.authors, b.isbn, e.position, e.note, b.quantity > 0 AS available, e.date_added
+		FROM list_entries AS e JOIN books AS b ON b.book_id = e.book_id
+		WHERE e.list_id = $1
+		ORDER BY e.position`
+	if err := db.SelectContext(ctx, &entries, q, id); err != nil {
+		return nil, errors.Wrapf(err, "selecting list %q entries", id)
+	}
+	return entries, nil
+}
+
+// touch records a List was modified.
+func touch(ctx context.Context, db sqlx.ExecerContext, id string, now time.Time) error {
+	const q = `UPDATE lists SET "date_updated" = $2 WHERE list_id = $1`
+	if _, err := db.ExecContext(ctx, q, id, now.UTC()); err != nil {
+		return errors.Wrapf(err, "updating list %s", id)
+	}
+	return nil
+}
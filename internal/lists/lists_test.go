@@ -0,0 +1,117 @@
+package lists_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/books"
+	"github.com/book-library/internal/lists"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+)
+
+// TestLists validates patrons can keep and share reading lists.
+func TestLists(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to save books to borrow later.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		admin := auth.NewClaims(
+			auth.RoleAdmin,
+			[]string{auth.RoleAdmin, auth.RoleUser},
+			now, time.Hour,
+			"718ffbea-f4a1-4667-8ae3-b349da52675e", // This is just some random UUID.
+		)
+
+		shelved, err := books.Create(ctx, now, books.NewBook{Title: "Go programming", ISBN: "bcn22", Authors: "Bill Kenedy", Quantity: 1}, admin, db)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create book : %s.", tests.Failed, err)
+		}
+		loaned, err := books.Create(ctx, now, books.NewBook{Title: "Concurrency in Go", ISBN: "bcn23", Authors: "Katherine Cox-Buday", Quantity: 1}, admin, db)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create book : %s.", tests.Failed, err)
+		}
+		if _, err := db.ExecContext(ctx, `UPDATE books SET quantity = 0 WHERE book_id = $1`, loaned.ID); err != nil {
+			t.Fatalf("\t%s\tShould be able to loan every copy : %s.", tests.Failed, err)
+		}
+
+		patron, err := users.Create(ctx, db, users.NewUser{
+			Name:     "Jane Doe",
+			Email:    "jane@example.com",
+			Roles:    []string{auth.RoleUser},
+			Password: "gophers",
+		}, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create patron : %s.", tests.Failed, err)
+		}
+		claims := auth.NewClaims(patron.ID, []string{auth.RoleUser}, now, time.Hour, "")
+		other := auth.NewClaims("45b5fbd3-755f-4379-8f07-a58d4a30fa2f", []string{auth.RoleUser}, now, time.Hour, "")
+
+		t.Log("\tWhen a patron keeps a private wishlist.")
+		{
+			l, err := lists.Create(ctx, claims, db, lists.NewList{Name: "Wishlist"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create a list : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to create a list.", tests.Success)
+
+			for _, id := range []string{shelved.ID, loaned.ID} {
+				if err := lists.AddEntry(ctx, claims, db, l.ID, lists.NewEntry{BookID: id}, now); err != nil {
+					t.Fatalf("\t%s\tShould be able to save a book : %s.", tests.Failed, err)
+				}
+			}
+			if err := lists.AddEntry(ctx, claims, db, l.ID, lists.NewEntry{BookID: loaned.ID}, now); err != lists.ErrAlreadyListed {
+				t.Fatalf("\t%s\tShould not save a book twice : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to save books once.", tests.Success)
+
+			if err := lists.Reorder(ctx, claims, db, l.ID, lists.Order{BookIDs: []string{loaned.ID}}, now); err != lists.ErrOrderMismatch {
+				t.Fatalf("\t%s\tShould require every book in the order : %v.", tests.Failed, err)
+			}
+			if err := lists.Reorder(ctx, claims, db, l.ID, lists.Order{BookIDs: []string{loaned.ID, shelved.ID}}, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to reorder the list : %s.", tests.Failed, err)
+			}
+
+			saved, err := lists.Retrieve(ctx, claims, db, l.ID)
+			if err != nil || len(saved.Entries) != 2 || saved.Entries[0].BookID != loaned.ID {
+				t.Fatalf("\t%s\tShould keep the order of the books : %+v, %v.", tests.Failed, saved, err)
+			}
+			if saved.Entries[0].Available || !saved.Entries[1].Available {
+				t.Fatalf("\t%s\tShould show the availability of the books : %+v.", tests.Failed, saved.Entries)
+			}
+			t.Logf("\t%s\tShould show the books in order with their availability.", tests.Success)
+
+			if _, err := lists.Retrieve(ctx, other, db, l.ID); err != lists.ErrNotFound {
+				t.Fatalf("\t%s\tShould hide the list from other patrons : %v.", tests.Failed, err)
+			}
+			if _, err := lists.Shared(ctx, db, l.ShareToken); err != lists.ErrNotFound {
+				t.Fatalf("\t%s\tShould not share a private list : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould keep the list private.", tests.Success)
+
+			placed, err := lists.HoldUnavailable(ctx, claims, db, l.ID, now)
+			if err != nil || len(placed) != 1 || placed[0].BookID != loaned.ID {
+				t.Fatalf("\t%s\tShould hold the unavailable books : %+v, %v.", tests.Failed, placed, err)
+			}
+			t.Logf("\t%s\tShould hold the unavailable books.", tests.Success)
+
+			public := true
+			if err := lists.Update(ctx, claims, db, l.ID, lists.UpdateList{Public: &public}, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to make the list public : %s.", tests.Failed, err)
+			}
+			shared, err := lists.Shared(ctx, db, l.ShareToken)
+			if err != nil || len(shared.Entries) != 2 {
+				t.Fatalf("\t%s\tShould share the public list : %+v, %v.", tests.Failed, shared, err)
+			}
+			if err := lists.RemoveEntry(ctx, other, db, l.ID, shelved.ID, now); err != lists.ErrForbidden {
+				t.Fatalf("\t%s\tShould not let other patrons change the list : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould share the public list read only.", tests.Success)
+		}
+	}
+}
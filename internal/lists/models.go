@@ -0,0 +1,63 @@
+package lists
+
+import (
+	"time"
+)
+
+// List is a named selection of books kept by a patron, such as a wishlist
+// or a reading list shared with others.
+type List struct {
+	ID          string    `db:"list_id" json:"id"`
+	UserID      string    `db:"user_id" json:"user_id"`
+	Name        string    `db:"name" json:"name"`
+	Description string    `db:"description" json:"description"`
+	Public      bool      `db:"public" json:"public"`
+	ShareToken  string    `db:"share_token" json:"share_token"` // Identifies the list in its share link.
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+	Entries     []Entry   `db:"-" json:"entries,omitempty"`
+}
+
+// NewList contains information needed to create a new List.
+type NewList struct {
+	Name        string `json:"name" validate:"required"`
+	Description string `json:"description"`
+	Public      bool   `json:"public"`
+}
+
+// UpdateList defines what information may be provided to modify an existing
+// List. All fields are optional so clients can send just the fields they want
+// changed.
+type UpdateList struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Public      *bool   `json:"public"`
+}
+
+// Entry is a book saved in a List along with its current availability.
+type Entry struct {
+	BookID    string    `db:"book_id" json:"book_id"`
+	Title     string    `db:"title" json:"title"`
+	Authors   string    `db:"authors" json:"authors"`
+	ISBN      string    `db:"isbn" json:"isbn"`
+	Position  int       `db:"position" json:"position"`
+	Note      string    `db:"note" json:"note"`
+	Available bool      `db:"available" json:"available"` // Whether a copy is on the shelf right now.
+	DateAdded time.Time `db:"date_added" json:"date_added"`
+}
+
+// NewEntry contains information needed to save a book in a List.
+type NewEntry struct {
+	BookID string `json:"book_id" validate:"required"`
+	Note   string `json:"note"`
+}
+
+// EntryNote contains the new note of an Entry.
+type EntryNote struct {
+	Note string `json:"note"`
+}
+
+// Order contains every book of a List in the order they should be shown.
+type Order struct {
+	BookIDs []string `json:"book_ids" validate:"required"`
+}
@@ -0,0 +1,198 @@
+package loans
+
+import (
+	"context"
+	"time"
+
+	"github.com/book-library/internal/notify"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/standing"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// MaxActiveLoans is the number of books a patron can hold at once.
+const MaxActiveLoans = 10
+
+// Policy errors returned when a checkout breaks the circulation rules.
+var (
+	// ErrLimitExceeded is used when the checkout would leave the patron with
+	// more than MaxActiveLoans books.
+	ErrLimitExceeded = errors.New("Loan limit exceeded")
+
+	// ErrAlreadyLoaned is used when the patron already holds a copy of a book
+	// or asks for the same book twice.
+	ErrAlreadyLoaned = errors.New("Book is already loaned to the patron")
+)
+
+// shelved is a book as it is checked against the policy.
+type shelved struct {
+	ID       string `db:"book_id"`
+	Title    string `db:"title"`
+	ISBN     string `db:"isbn"`
+	Quantity int    `db:"quantity"`
+}
+
+// CheckoutBatch checks out several books for a patron at once. The whole
+// batch is validated against the circulation policy and every loan is
+// created in a single transaction: either all the books are loaned or none
+// is. One combined receipt is queued for the patron.
+func CheckoutBatch(ctx context.Context, user auth.Claims, db *sqlx.DB, userID string, nb NewBatch, now time.Time) (*Receipt, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.loan.CheckoutBatch")
+	defer span.End()
+
+	// Patrons check out for themselves, staff for anybody.
+	if !isStaff(user) && (!user.HasPermission(auth.PermissionLoansBorrow) || user.Subject != userID) {
+		return nil, ErrForbidden
+	}
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	books, err := checkPolicy(ctx, tx, userID, nb.BookIDs, now)
+	if err != nil {
+		return nil, err
+	}
+
+	r := Receipt{
+		ID:       uuid.New().String(),
+		UserID:   userID,
+		LoanDate: now.UTC(),
+		Loans:    make([]Loan, 0, len(books)),
+	}
+	lines := make([]map[string]interface{}, 0, len(books))
+
+	for _, b := range books {
+		loan := Loan{
+			ID:           uuid.New().String(),
+			BookID:       b.ID,
+			BookISBN:     b.ISBN,
+			BookTitle:    b.Title,
+			BookQuantity: 1,
+			LoanDate:     now.UTC(),
+			ReturnDate:   now.Add(LoanPeriod).UTC(),
+			UserID:       userID,
+			Status:       StatusActive,
+		}
+
+		if err := checkout(ctx, tx, loan, now); err != nil {
+			return nil, err
+		}
+
+		if user.Subject != userID {
+			if err := audit(ctx, tx, loan.ID, user.Subject, ActionCheckout, "", nil, nil, now); err != nil {
+				return nil, err
+			}
+		}
+
+		r.Loans = append(r.Loans, loan)
+		lines = append(lines, map[string]interface{}{
+			"Title":      loan.BookTitle,
+			"ISBN":       loan.BookISBN,
+			"ReturnDate": loan.ReturnDate,
+		})
+	}
+
+	n := notify.NewNotification{
+		UserID:    userID,
+		Event:     notify.EventBatchReceipt,
+		DedupeKey: notify.EventBatchReceipt + ":" + r.ID,
+		Data: map[string]interface{}{
+			"LoanDate": r.LoanDate,
+			"Loans":    lines,
+		},
+	}
+	if _, err := notify.Enqueue(ctx, tx, n, now); err != nil && err != notify.ErrNoRecipient {
+		return nil, errors.Wrap(err, "queueing checkout receipt")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing loans")
+	}
+
+	return &r, nil
+}
+
+// checkPolicy validates the patron is in good standing and can borrow all
+// the books at once, and returns them in the requested order. The books are locked until the end
+// of the transaction so their copies can not be loaned twice.
+func checkPolicy(ctx context.Context, tx sqlx.ExtContext, userID string, bookIDs []string, now time.Time) ([]shelved, error) {
+	ids := make([]string, 0, len(bookIDs))
+	seen := make(map[string]bool, len(bookIDs))
+	for _, id := range bookIDs {
+		u, err := uuid.Parse(id)
+		if err != nil {
+			return nil, ErrInvalidID
+		}
+		id = u.String()
+		if seen[id] {
+			return nil, ErrAlreadyLoaned
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	var patron int
+	const qu = `SELECT COUNT(*) FROM users WHERE user_id = $1`
+	if err := sqlx.GetContext(ctx, tx, &patron, qu, userID); err != nil {
+		return nil, errors.Wrapf(err, "selecting user %q", userID)
+	}
+	if patron == 0 {
+		return nil, ErrNotFound
+	}
+
+	if err := standing.Verify(ctx, tx, userID, now); err != nil {
+		return nil, err
+	}
+
+	var active struct {
+		Total int `db:"total"`
+		Same  int `db:"same"`
+	}
+	const qa = `SELECT COUNT(*) AS total, COUNT(*) FILTER (WHERE book_id = ANY($2::uuid[])) AS same
+		FROM loans WHERE user_id = $1 AND status = 'active'`
+	if err := sqlx.GetContext(ctx, tx, &active, qa, userID, pq.Array(ids)); err != nil {
+		return nil, errors.Wrapf(err, "counting user %q loans", userID)
+	}
+	if active.Same > 0 {
+		return nil, ErrAlreadyLoaned
+	}
+	if active.Total+len(ids) > MaxActiveLoans {
+		return nil, ErrLimitExceeded
+	}
+
+	var found []shelved
+	const qb = `SELECT book_id, title, isbn, quantity FROM books WHERE book_id = ANY($1::uuid[]) FOR UPDATE`
+	if err := sqlx.SelectContext(ctx, tx, &found, qb, pq.Array(ids)); err != nil {
+		return nil, errors.Wrap(err, "selecting books")
+	}
+
+	byID := make(map[string]shelved, len(found))
+	for _, b := range found {
+		byID[b.ID] = b
+	}
+
+	books := make([]shelved, 0, len(ids))
+	for _, id := range ids {
+		b, ok := byID[id]
+		if !ok {
+			return nil, ErrNotFound
+		}
+		if b.Quantity < 1 {
+			return nil, ErrUnavailable
+		}
+		books = append(books, b)
+	}
+
+	return books, nil
+}
@@ -0,0 +1,111 @@
+package loans_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/books"
+	loans "github.com/book-library/internal/loan"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+)
+
+// TestCheckoutBatch validates a batch of books is loaned all at once or not
+// at all.
+func TestCheckoutBatch(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to check out several books at once.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		admin := auth.NewClaims(
+			auth.RoleAdmin,
+			[]string{auth.RoleAdmin, auth.RoleUser},
+			now, time.Hour,
+			"718ffbea-f4a1-4667-8ae3-b349da52675e", // This is just some random UUID.
+		)
+
+		var ids []string
+		for i, q := range []int{2, 2, 1} {
+			bk, err := books.Create(ctx, now, books.NewBook{
+				Title:    "Go programming",
+				ISBN:     fmt.Sprintf("bcn2%d", i),
+				Category: "computer-science",
+				Authors:  "Bill Kenedy",
+				Quantity: q,
+			}, admin, db)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create book : %s.", tests.Failed, err)
+			}
+			ids = append(ids, bk.ID)
+		}
+
+		patron, err := users.Create(ctx, db, users.NewUser{
+			Name:     "Jane Doe",
+			Email:    "jane@example.com",
+			Roles:    []string{auth.RoleUser},
+			Password: "gophers",
+		}, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create patron : %s.", tests.Failed, err)
+		}
+		claims := auth.NewClaims(patron.ID, []string{auth.RoleUser}, now, time.Hour, "")
+
+		t.Log("\tWhen every book can be loaned.")
+		{
+			r, err := loans.CheckoutBatch(ctx, claims, db, patron.ID, loans.NewBatch{BookIDs: ids[:2]}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to check the books out : %s.", tests.Failed, err)
+			}
+			if len(r.Loans) != 2 || !r.Loans[0].ReturnDate.Equal(now.Add(loans.LoanPeriod)) {
+				t.Fatalf("\t%s\tShould list every loan on the receipt : %+v.", tests.Failed, r)
+			}
+			t.Logf("\t%s\tShould list every loan on the receipt.", tests.Success)
+		}
+
+		t.Log("\tWhen one book of the batch can not be loaned.")
+		{
+			if _, err := loans.CheckoutBatch(ctx, claims, db, patron.ID, loans.NewBatch{BookIDs: ids[1:]}, now); err != loans.ErrAlreadyLoaned {
+				t.Fatalf("\t%s\tShould reject the batch : %v.", tests.Failed, err)
+			}
+
+			bk, err := books.Retrieve(ctx, ids[2], db)
+			if err != nil || bk.Quantity != 1 {
+				t.Fatalf("\t%s\tShould not loan any book of the batch : %+v, %v.", tests.Failed, bk, err)
+			}
+			t.Logf("\t%s\tShould not loan any book of the batch.", tests.Success)
+		}
+
+		t.Log("\tWhen the batch asks for the same book twice.")
+		{
+			batch := loans.NewBatch{BookIDs: []string{ids[2], ids[2]}}
+			if _, err := loans.CheckoutBatch(ctx, claims, db, patron.ID, batch, now); err != loans.ErrAlreadyLoaned {
+				t.Fatalf("\t%s\tShould reject the same book twice : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould reject the same book twice.", tests.Success)
+		}
+
+		t.Log("\tWhen a single book is loaned.")
+		{
+			nl := loans.NewLoan{BookTitle: "Anything", BookISBN: "none", BookQuantity: 5}
+			ln, err := loans.InitNewLoan(ctx, claims, nl, now, ids[2], db)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to loan the book : %s.", tests.Failed, err)
+			}
+			if ln.BookTitle != "Go programming" || ln.BookISBN != "bcn22" || ln.BookQuantity != 1 {
+				t.Fatalf("\t%s\tShould loan the book from the shelf : %+v.", tests.Failed, ln)
+			}
+			t.Logf("\t%s\tShould loan the book from the shelf.", tests.Success)
+
+			if _, err := loans.InitNewLoan(ctx, claims, nl, now, ids[2], db); err != loans.ErrAlreadyLoaned {
+				t.Fatalf("\t%s\tShould follow the policy of the batches : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould follow the policy of the batches.", tests.Success)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package loans
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// These are the actions recorded in the loan audit trail.
+const (
+	ActionCheckout      = "checkout"
+	ActionCheckin       = "checkin"
+	ActionForceReturn   = "force_return"
+	ActionDueDateChange = "due_date_change"
+)
+
+// isStaff reports whether the claims belong to someone working at the desk.
+func isStaff(user auth.Claims) bool {
+	return user.HasPermission(auth.PermissionCirculation)
+}
+
+// Search retrieves the loans of every patron matching the filter. It is
+// meant for staff, patrons only ever see their own loans.
+func Search(ctx context.Context, user auth.Claims, db *sqlx.DB, f Filter, now time.Time) ([]Loan, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.loan.Search")
+	defer span.End()
+
+	if !isStaff(user) {
+		return nil, ErrForbidden
+	}
+
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if !f.IncludeReturned {
+		where = append(where, "date_returned IS NULL")
+	}
+	if f.Overdue {
+		where = append(where, "date_returned IS NULL AND date_return < "+arg(now.UTC()))
+	}
+	if f.BookID != "" {
+		if _, err := uuid.Parse(f.BookID); err != nil {
+			return nil, ErrInvalidID
+		}
+		where = append(where, "book_id = "+arg(f.BookID))
+	}
+	if f.UserID != "" {
+		if _, err := uuid.Parse(f.UserID); err != nil {
+			return nil, ErrInvalidID
+		}
+		where = append(where, "user_id = "+arg(f.UserID))
+	}
+	if f.From != nil {
+		where = append(where, "loan_date >= "+arg(f.From.UTC()))
+	}
+	if f.To != nil {
+		where = append(where, "loan_date < "+arg(f.To.UTC()))
+	}
+
+	q := `SELECT * FROM loans`
+	if len(where) > 0 {
+		q += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	q += ` ORDER BY loan_date DESC`
+
+	loans := []Loan{}
+	if err := db.SelectContext(ctx, &loans, q, args...); err != nil {
+		return nil, errors.Wrap(err, "selecting loans")
+	}
+
+	return loans, nil
+}
+
+// CheckoutFor checks a book out on behalf of a patron.
+func CheckoutFor(ctx context.Context, user auth.Claims, db *sqlx.DB, c Checkout, now time.Time) (*Loan, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.loan.CheckoutFor")
+	defer span.End()
+
+	if !isStaff(user) {
+		return nil, ErrForbidden
+	}
+
+	if _, err := uuid.Parse(c.UserID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	books, err := checkPolicy(ctx, tx, c.UserID, []string{c.BookID}, now)
+	if err != nil {
+		return nil, err
+	}
+	book := books[0]
+
+	loan := Loan{
+		ID:           uuid.New().String(),
+		BookID:       book.ID,
+		BookISBN:     book.ISBN,
+		BookTitle:    book.Title,
+		BookQuantity: 1,
+		LoanDate:     now.UTC(),
+		ReturnDate:   now.Add(LoanPeriod).UTC(),
+		UserID:       c.UserID,
+		Status:       StatusActive,
+	}
+
+	if err := checkout(ctx, tx, loan, now); err != nil {
+		return nil, err
+	}
+
+	if err := receipt(ctx, tx, loan, now); err != nil {
+		return nil, err
+	}
+
+	if err := audit(ctx, tx, loan.ID, user.Subject, ActionCheckout, "", nil, nil, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing loan")
+	}
+
+	return &loan, nil
+}
+
+// Checkin ends the loan of a patron when the copy is given back at the desk.
+func Checkin(ctx context.Context, user auth.Claims, db *sqlx.DB, id string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.loan.Checkin")
+	defer span.End()
+
+	if !isStaff(user) {
+		return ErrForbidden
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	loan, err := open(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := checkin(ctx, tx, *loan, now); err != nil {
+		return err
+	}
+
+	if err := audit(ctx, tx, id, user.Subject, ActionCheckin, "", nil, nil, now); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Return ends a loan without the usual check in, for instance when the copy
+// was dropped in the wrong branch. The reason is recorded in the audit trail.
+func Return(ctx context.Context, user auth.Claims, db *sqlx.DB, id string, fr ForceReturn, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.loan.Return")
+	defer span.End()
+
+	if !isStaff(user) {
+		return ErrForbidden
+	}
+
+	if strings.TrimSpace(fr.Reason) == "" {
+		return ErrReasonRequired
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	loan, err := open(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := checkin(ctx, tx, *loan, now); err != nil {
+		return err
+	}
+
+	if err := audit(ctx, tx, id, user.Subject, ActionForceReturn, fr.Reason, nil, nil, now); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ChangeDueDate moves the due date of a loan. The previous and the new due
+// dates are recorded in the audit trail along with the reason.
+func ChangeDueDate(ctx context.Context, user auth.Claims, db *sqlx.DB, id string, c DueDateChange, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.loan.ChangeDueDate")
+	defer span.End()
+
+	if !isStaff(user) {
+		return ErrForbidden
+	}
+
+	if strings.TrimSpace(c.Reason) == "" {
+		return ErrReasonRequired
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	loan, err := open(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	due := c.ReturnDate.UTC()
+	const q = `UPDATE loans SET "date_return" = $2, "date_updated" = $3 WHERE loan_id = $1`
+	if _, err := tx.ExecContext(ctx, q, id, due, now.UTC()); err != nil {
+		return errors.Wrapf(err, "updating loan %s due date", id)
+	}
+
+	if err := audit(ctx, tx, id, user.Subject, ActionDueDateChange, c.Reason, &loan.ReturnDate, &due, now); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AuditTrail retrieves every action taken by staff on a loan.
+func AuditTrail(ctx context.Context, user auth.Claims, db *sqlx.DB, id string) ([]AuditEntry, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.loan.AuditTrail")
+	defer span.End()
+
+	if !isStaff(user) {
+		return nil, ErrForbidden
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	entries := []AuditEntry{}
+	const q = `SELECT * FROM loan_audit WHERE loan_id = $1 ORDER BY date_created`
+	if err := db.SelectContext(ctx, &entries, q, id); err != nil {
+		return nil, errors.Wrapf(err, "selecting loan %q audit", id)
+	}
+
+	return entries, nil
+}
+
+// audit appends an entry to the audit trail of a loan.
+func audit(ctx context.Context, db sqlx.ExecerContext, loanID, staffID, action, reason string, oldDue, newDue *time.Time, now time.Time) error {
+	const q = `INSERT INTO loan_audit
+		(audit_id, loan_id, staff_id, action, reason, old_due, new_due, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	if _, err := db.ExecContext(ctx, q, uuid.New().String(), loanID, staffID, action, reason, oldDue, newDue, now.UTC()); err != nil {
+		return errors.Wrapf(err, "recording %s of loan %s", action, loanID)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package loans_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/books"
+	loans "github.com/book-library/internal/loan"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+)
+
+// TestCirculation validates staff can manage the loans of the patrons.
+func TestCirculation(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to manage loans at the circulation desk.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		admin := auth.NewClaims(
+			auth.RoleAdmin,
+			[]string{auth.RoleAdmin, auth.RoleUser},
+			now, time.Hour,
+			"718ffbea-f4a1-4667-8ae3-b349da52675e", // This is just some random UUID.
+		)
+
+		bk, err := books.Create(ctx, now, books.NewBook{
+			Title:            "Go programming",
+			ISBN:             "bcn22",
+			Category:         "computer-science",
+			Authors:          "Bill Kenedy",
+			Quantity:         1,
+			ReplacementPrice: 2500,
+		}, admin, db)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create book : %s.", tests.Failed, err)
+		}
+
+		patron, err := users.Create(ctx, db, users.NewUser{
+			Name:     "Jane Doe",
+			Email:    "jane@example.com",
+			Roles:    []string{auth.RoleUser},
+			Password: "gophers",
+		}, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create patron : %s.", tests.Failed, err)
+		}
+
+		librarian := auth.NewClaims(
+			"45b5fbd3-755f-4379-8f07-a58d4a30fa2f",
+			[]string{auth.RoleLibrarian},
+			now, time.Hour,
+			"718ffbea-f4a1-4667-8ae3-b349da52675e",
+		)
+
+		t.Log("\tWhen checking a book out on behalf of a patron.")
+		{
+			ln, err := loans.CheckoutFor(ctx, librarian, db, loans.Checkout{UserID: patron.ID, BookID: bk.ID}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to check the book out : %s.", tests.Failed, err)
+			}
+			if ln.UserID != patron.ID {
+				t.Fatalf("\t%s\tShould loan the book to the patron : got %s.", tests.Failed, ln.UserID)
+			}
+			t.Logf("\t%s\tShould be able to check the book out.", tests.Success)
+
+			if _, err := loans.CheckoutFor(ctx, librarian, db, loans.Checkout{UserID: patron.ID, BookID: bk.ID}, now); err != loans.ErrAlreadyLoaned {
+				t.Fatalf("\t%s\tShould not loan the same book twice : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould not loan the same book twice.", tests.Success)
+
+			overdue, err := loans.Search(ctx, librarian, db, loans.Filter{Overdue: true}, now.Add(loans.LoanPeriod+time.Hour))
+			if err != nil || len(overdue) != 1 {
+				t.Fatalf("\t%s\tShould list the overdue loan : %+v, %v.", tests.Failed, overdue, err)
+			}
+			t.Logf("\t%s\tShould list the overdue loan.", tests.Success)
+
+			if err := loans.ChangeDueDate(ctx, librarian, db, ln.ID, loans.DueDateChange{ReturnDate: now.Add(2 * loans.LoanPeriod)}, now); err != loans.ErrReasonRequired {
+				t.Fatalf("\t%s\tShould require a reason to change the due date : %v.", tests.Failed, err)
+			}
+			dc := loans.DueDateChange{ReturnDate: now.Add(2 * loans.LoanPeriod), Reason: "patron in hospital"}
+			if err := loans.ChangeDueDate(ctx, librarian, db, ln.ID, dc, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to change the due date : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to change the due date.", tests.Success)
+
+			if err := loans.Checkin(ctx, librarian, db, ln.ID, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to check the book in : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to check the book in.", tests.Success)
+
+			trail, err := loans.AuditTrail(ctx, librarian, db, ln.ID)
+			if err != nil || len(trail) != 3 || trail[1].Reason != dc.Reason {
+				t.Fatalf("\t%s\tShould record every action : %+v, %v.", tests.Failed, trail, err)
+			}
+			t.Logf("\t%s\tShould record every action.", tests.Success)
+		}
+
+		t.Log("\tWhen a patron loses a book.")
+		{
+			ln, err := loans.CheckoutFor(ctx, librarian, db, loans.Checkout{UserID: patron.ID, BookID: bk.ID}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to check the book out : %s.", tests.Failed, err)
+			}
+
+			fine, err := loans.DeclareLost(ctx, librarian, db, ln.ID, now)
+			if err != nil || fine.Amount != 2500 {
+				t.Fatalf("\t%s\tShould charge the replacement price : %+v, %v.", tests.Failed, fine, err)
+			}
+			t.Logf("\t%s\tShould charge the replacement price.", tests.Success)
+
+			if err := loans.Found(ctx, librarian, db, ln.ID, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to reverse the loss : %s.", tests.Failed, err)
+			}
+			fines, err := loans.Fines(ctx, librarian, db, patron.ID)
+			if err != nil || len(fines) != 1 || fines[0].Status != loans.FineReversed {
+				t.Fatalf("\t%s\tShould reverse the fine : %+v, %v.", tests.Failed, fines, err)
+			}
+			t.Logf("\t%s\tShould reverse the fine.", tests.Success)
+
+			saved, err := books.Retrieve(ctx, bk.ID, db)
+			if err != nil || saved.Quantity != 1 {
+				t.Fatalf("\t%s\tShould put the copy back on the shelf : %+v, %v.", tests.Failed, saved, err)
+			}
+			t.Logf("\t%s\tShould put the copy back on the shelf.", tests.Success)
+		}
+
+		t.Log("\tWhen a patron uses the desk endpoints.")
+		{
+			claims := auth.NewClaims(patron.ID, []string{auth.RoleUser}, now, time.Hour, "")
+			if _, err := loans.Search(ctx, claims, db, loans.Filter{}, now); err != loans.ErrForbidden {
+				t.Fatalf("\t%s\tShould not be able to search the loans : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould not be able to search the loans.", tests.Success)
+		}
+	}
+}
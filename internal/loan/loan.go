@@ -3,20 +3,33 @@ package loans
 import (
 	"context"
 	"database/sql"
-	"fmt"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	errors "github.com/pkg/errors"
 
-	books "github.com/book-library/internal/books"
+	"github.com/book-library/internal/events"
+	"github.com/book-library/internal/holds"
+	"github.com/book-library/internal/notify"
 	auth "github.com/book-library/internal/platform/auth"
 	"go.opencensus.io/trace"
 )
 
 const loansCollection = "loans"
 
+// LoanPeriod is how long a book can be kept before it has to be returned.
+const LoanPeriod = 30 * 24 * time.Hour
+
+// Loan status values. Only active loans hold a copy, the others are kept as
+// the circulation history.
+const (
+	StatusActive   = "active"
+	StatusReturned = "returned"
+	StatusLost     = "lost"
+	StatusDamaged  = "damaged"
+)
+
 // Predefined errors identify expected failure conditions.
 var (
 	// ErrNotFound is used when a specific Product is requested but does not exist.
@@ -28,6 +41,12 @@ var (
 	// ErrForbidden occurs when a users tries to do something that is forbidden to
 	// them according to our access control policies.
 	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrUnavailable is used when every copy of a book is already loaned.
+	ErrUnavailable = errors.New("No copy of the book is available")
+
+	// ErrReasonRequired is used when staff change a loan without saying why.
+	ErrReasonRequired = errors.New("A reason is required")
 )
 
 //List retrieves a list of existing loans from the databse
@@ -36,74 +55,145 @@ func List(ctx context.Context, user auth.Claims, db *sqlx.DB) ([]Loan, error) {
 	defer span.End()
 
 	loans := []Loan{}
-	const q = `SELECT * FROM loans`
+	const q = `SELECT * FROM loans WHERE date_returned IS NULL`
 
 	if err := db.SelectContext(ctx, &loans, q); err != nil {
 		return nil, errors.Wrap(err, "selecting loans")
 	}
 
+	// Items borrowed from partner libraries are loaned like our own books
+	// but have to be back by the date the partner set.
+	var items []struct {
+		ID         string    `db:"request_id"`
+		Title      string    `db:"title"`
+		ISBN       string    `db:"isbn"`
+		UserID     string    `db:"user_id"`
+		LoanDate   time.Time `db:"date_loaned"`
+		ReturnDate time.Time `db:"date_due"`
+		Partner    string    `db:"name"`
+	}
+	const qi = `SELECT r.request_id, r.title, r.isbn, r.user_id, r.date_loaned, r.date_due, p.name
+		FROM ill_requests AS r JOIN ill_partners AS p ON p.partner_id = r.partner_id
+		WHERE r.state = 'loaned'`
+	if err := db.SelectContext(ctx, &items, qi); err != nil {
+		return nil, errors.Wrap(err, "selecting inter-library loans")
+	}
+
+	for _, it := range items {
+		loans = append(loans, Loan{
+			ID:           it.ID,
+			BookTitle:    it.Title,
+			BookISBN:     it.ISBN,
+			BookQuantity: 1,
+			LoanDate:     it.LoanDate,
+			ReturnDate:   it.ReturnDate,
+			UserID:       it.UserID,
+			Status:       StatusActive,
+			Partner:      it.Partner,
+		})
+	}
+
 	return loans, nil
 }
 
-//InitNewLoan initiates a new loan when users want to loan a book
+//InitNewLoan initiates a new loan when users want to loan a book. It follows
+//the same circulation policy as CheckoutBatch, the loan is built from the book
+//as it is on the shelf.
 func InitNewLoan(ctx context.Context, user auth.Claims, n NewLoan, now time.Time, id string, db *sqlx.DB) (*Loan, error) {
 	ctx, span := trace.StartSpan(ctx, "internal.loan.InitNewLoan")
 	defer span.End()
 
 	// If you do not have the required role or your not authorized ...
 	// then get outta here!
-	if !user.HasRole(auth.RoleUser) {
+	if !user.HasPermission(auth.PermissionLoansBorrow) {
 		return nil, ErrForbidden
 	}
 
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	books, err := checkPolicy(ctx, tx, user.Subject, []string{id}, now)
+	if err != nil {
+		return nil, err
+	}
+	b := books[0]
+
 	loan := Loan{
 		ID:           uuid.New().String(),
-		BookID:       id,
-		BookISBN:     n.BookISBN,
-		BookTitle:    n.BookTitle,
-		BookQuantity: n.BookQuantity,
+		BookID:       b.ID,
+		BookISBN:     b.ISBN,
+		BookTitle:    b.Title,
+		BookQuantity: 1,
 		LoanDate:     now.UTC(),
-		ReturnDate:   now.Add(30).UTC(),
+		ReturnDate:   now.Add(LoanPeriod).UTC(),
 		UserID:       user.Subject,
+		Status:       StatusActive,
 	}
 
+	if err := checkout(ctx, tx, loan, now); err != nil {
+		return nil, err
+	}
+
+	if err := receipt(ctx, tx, loan, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing loan")
+	}
+
+	return &loan, nil
+}
+
+// checkout records a new loan and takes the copy off the shelf. It is meant
+// to be called within a transaction.
+func checkout(ctx context.Context, tx sqlx.ExtContext, loan Loan, now time.Time) error {
 	const q = `INSERT INTO loans
-	(loan_id, book_id, isbn, title, quantity, loan_date, date_return, user_id)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
-	_, err := db.ExecContext(
+	(loan_id, book_id, isbn, title, quantity, loan_date, date_return, user_id, status)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	_, err := tx.ExecContext(
 		ctx, q,
 		loan.ID, loan.BookID, loan.BookISBN, loan.BookTitle, loan.BookQuantity,
-		loan.LoanDate, loan.ReturnDate, user.Subject,
+		loan.LoanDate, loan.ReturnDate, loan.UserID, loan.Status,
 	)
 	if err != nil {
-		return nil, err
+		return errors.Wrap(err, "inserting loan")
 	}
 
-	list, err := List(ctx, user, db)
-	if err != nil {
-		fmt.Printf("%v", list)
-	}
-	//get the book which is been lent
-	book, err := books.Retrieve(ctx, loan.BookID, db)
-	if err != nil {
-		return nil, err
+	//reduce book quantity along with the loan
+	const qb = `UPDATE books SET "quantity" = "quantity" - 1 WHERE book_id = $1`
+	if _, err := tx.ExecContext(ctx, qb, loan.BookID); err != nil {
+		return errors.Wrapf(err, "updating book %s quantity", loan.BookID)
 	}
 
-	//reduce book quantity if loan succeeded
-	book.Quantity = book.Quantity - 1
-	nbk := books.UpdateBook{
-		Quantity:    &book.Quantity,
-		DateUpdated: &now,
+	if err := holds.Fulfil(ctx, tx, loan.UserID, loan.BookID, now); err != nil {
+		return err
 	}
 
-	//update book quantity
-	books.Update(ctx, book.ID, nbk, now, user, db)
+	return events.Record(ctx, tx, events.LoanStarted, loan.ID, loan, now)
+}
 
-	bks2, errB2 := books.List(ctx, db)
-	if errB2 != nil {
-		fmt.Printf("%v", bks2)
+// receipt queues the checkout receipt of a loan, it is sent by the
+// notification worker.
+func receipt(ctx context.Context, tx sqlx.ExtContext, loan Loan, now time.Time) error {
+	n := notify.NewNotification{
+		UserID:    loan.UserID,
+		Event:     notify.EventCheckoutReceipt,
+		DedupeKey: notify.EventCheckoutReceipt + ":" + loan.ID,
+		Data: map[string]interface{}{
+			"Title":      loan.BookTitle,
+			"ISBN":       loan.BookISBN,
+			"LoanDate":   loan.LoanDate,
+			"ReturnDate": loan.ReturnDate,
+		},
 	}
-	return &loan, nil
+	if _, err := notify.Enqueue(ctx, tx, n, now); err != nil && err != notify.ErrNoRecipient {
+		return errors.Wrap(err, "queueing checkout receipt")
+	}
+	return nil
 }
 
 //Retrieve retrieves a loan by id
@@ -113,7 +203,7 @@ func Retrieve(ctx context.Context, user auth.Claims, book_id string, db *sqlx.DB
 
 	// If you do not have the required role or your not authorized ...
 	// then get outta here!
-	if !user.HasRole(auth.RoleUser) {
+	if !user.HasPermission(auth.PermissionLoansBorrow) {
 		return nil, ErrForbidden
 	}
 
@@ -123,7 +213,7 @@ func Retrieve(ctx context.Context, user auth.Claims, book_id string, db *sqlx.DB
 
 	//actual retrieven loan
 	var loan Loan
-	const q = `SELECT * FROM loans  WHERE book_id = $1 AND user_id = $2`
+	const q = `SELECT * FROM loans  WHERE book_id = $1 AND user_id = $2 AND date_returned IS NULL`
 
 	if err := db.GetContext(ctx, &loan, q, book_id); err != nil {
 		if err == sql.ErrNoRows {
@@ -147,43 +237,75 @@ func EndUpALoan(ctx context.Context, user auth.Claims, now time.Time, id string,
 
 	// If you do not have the required role ...
 	// then get outta here!
-	if !user.HasRole(auth.RoleUser) {
+	if !user.HasPermission(auth.PermissionLoansBorrow) {
 		return ErrForbidden
 	}
 
-	loan, er := Retrieve(ctx, user, id, db, user.Subject)
-	if er != nil {
-		if id != user.Id {
-			return ErrInvalidID
-		}
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
 	}
 
-	//get the book which is been lent
-	book, errr := books.Retrieve(ctx, loan.BookID, db)
-	if errr != nil {
-		return ErrInvalidID
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
 	}
+	defer tx.Rollback()
 
-	//update book quantity if laon succeeded
-	book.Quantity++
-	nbk := books.UpdateBook{
-		Quantity:    &book.Quantity,
-		DateUpdated: &now,
+	loan, err := open(ctx, tx, id)
+	if err != nil {
+		return err
 	}
 
-	//update book quantity
-	books.Update(ctx, id, nbk, now, user, db)
-	if _, err := uuid.Parse(id); err != nil {
-		return ErrInvalidID
+	// Only admins may end the loans of someone else.
+	if !user.HasPermission(auth.PermissionCirculation) && loan.UserID != user.Subject {
+		return ErrForbidden
 	}
 
-	const q = `DELETE FROM loans WHERE loan_id = $1`
+	if err := checkin(ctx, tx, *loan, now); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
 
-	if _, err := db.ExecContext(ctx, q, id); err != nil {
-		return errors.Wrapf(err, "deleting loan %s", id)
+// open gets a loan which has not been returned yet. The row is locked until
+// the end of the transaction so two desks can not close it at once.
+func open(ctx context.Context, tx sqlx.QueryerContext, id string) (*Loan, error) {
+	var loan Loan
+	const q = `SELECT * FROM loans WHERE loan_id = $1 AND date_returned IS NULL FOR UPDATE`
+	if err := sqlx.GetContext(ctx, tx, &loan, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting loan %q", id)
 	}
 
-	return nil
+	return &loan, nil
+}
+
+// checkin closes a loan and puts the copy back on the shelf. Returned loans
+// are kept as the circulation history. It is meant to be called within a
+// transaction, with the loan retrieved by open.
+func checkin(ctx context.Context, tx sqlx.ExtContext, loan Loan, now time.Time) error {
+	//put the book back on the shelf along with the end of the loan
+	const qb = `UPDATE books SET "quantity" = "quantity" + 1 WHERE book_id = $1`
+	if _, err := tx.ExecContext(ctx, qb, loan.BookID); err != nil {
+		return errors.Wrapf(err, "updating book %s quantity", loan.BookID)
+	}
+
+	returned := now.UTC()
+	const q = `UPDATE loans SET "status" = $2, "date_returned" = $3 WHERE loan_id = $1`
+	if _, err := tx.ExecContext(ctx, q, loan.ID, StatusReturned, returned); err != nil {
+		return errors.Wrapf(err, "returning loan %s", loan.ID)
+	}
+	loan.Status = StatusReturned
+	loan.ReturnedDate = &returned
+
+	if err := holds.Promote(ctx, tx, loan.BookID, now); err != nil {
+		return err
+	}
+
+	return events.Record(ctx, tx, events.LoanReturned, loan.ID, loan, now)
 }
 
 // Update replaces a Loan document in the database.
@@ -193,19 +315,21 @@ func Update(ctx context.Context, id string, upd UpdateLoan, now time.Time, user
 
 	// If you do not have the required role or your not authorized ...
 	// then get outta here!
-	if !user.HasRole(auth.RoleUser) {
+	if !user.HasPermission(auth.PermissionLoansBorrow) {
 		return ErrForbidden
 	}
 
-	if !user.HasRole(auth.RoleAdmin) {
+	if !user.HasPermission(auth.PermissionCirculation) {
 		return ErrForbidden
 	}
 
 	loan, err := Retrieve(ctx, user, id, db, user.Subject)
 	if err != nil {
-		if id != user.Id {
-			return err
-		}
+		return err
+	}
+
+	if upd.BookISBN != nil {
+		loan.BookISBN = *upd.BookISBN
 	}
 
 	if upd.BookQuantity != nil {
@@ -219,10 +343,11 @@ func Update(ctx context.Context, id string, upd UpdateLoan, now time.Time, user
 	const q = `UPDATE loans SET
 		"isbn" = $2,
 		"quantity" = $3,
-		"date_return" = $4
+		"date_return" = $4,
+		"date_updated" = $5
 		WHERE loan_id = $1`
 	_, err = db.ExecContext(ctx, q, id,
-		upd.BookISBN, upd.BookQuantity, upd.ReturnDate,
+		loan.BookISBN, loan.BookQuantity, loan.ReturnDate, now.UTC(),
 	)
 	if err != nil {
 		return errors.Wrap(err, "updating users")
@@ -238,7 +363,7 @@ func GetLoansByUuid(ctx context.Context, user auth.Claims, db *sqlx.DB, id strin
 	defer span.End()
 
 	var loan Loan;
-	if user.HasRole(auth.RoleUser) {
+	if user.HasPermission(auth.PermissionLoansBorrow) {
 		if id == user.Subject {
 			const q = `SELECT * FROM loans WHERE loan_id = $1 AND user_id = $2`
 			if err := db.GetContext(ctx, &loan, q, id); err != nil {
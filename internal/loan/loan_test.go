@@ -131,6 +131,20 @@ func TestLoan(t *testing.T) {
 				t.Logf("\t%s\tShould be able to see updates to isbn.", tests.Success)
 			}
 
+			//test a partial update keeps the other fields
+			due := now.Add(48 * time.Hour).UTC()
+			if err := loans.Update(ctx, uln.ID, loans.UpdateLoan{ReturnDate: &due}, now, claims, db); err != nil {
+				t.Fatalf("\t%s\tShould be able to update the due date only : %s.", tests.Failed, err)
+			}
+			uln, err = loans.Retrieve(ctx, claims, uln.ID, db, uln.ID)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to retreive loan : %s.", tests.Failed, err)
+			}
+			if uln.BookISBN != *ul.BookISBN || uln.BookQuantity == 0 || !uln.ReturnDate.Equal(due) {
+				t.Fatalf("\t%s\tShould keep the fields left out of the update : %+v.", tests.Failed, uln)
+			}
+			t.Logf("\t%s\tShould keep the fields left out of the update.", tests.Success)
+
 			//test delete loan
 			if err := loans.EndUpALoan(ctx, claims, now, uln.ID, db); err != nil {
 				t.Fatalf("\t%s\tShould be able to delete loan : %s.", tests.Failed, err)
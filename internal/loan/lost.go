@@ -0,0 +1,220 @@
+package loans
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/book-library/internal/events"
+	"github.com/book-library/internal/holds"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// These are the actions of the lost and damaged workflow recorded in the
+// loan audit trail.
+const (
+	ActionLost    = "lost"
+	ActionDamaged = "damaged"
+	ActionFound   = "found"
+)
+
+// Fine kinds and status values.
+const (
+	FineLost    = "lost"
+	FineDamaged = "damaged"
+
+	FineOpen     = "open"
+	FineReversed = "reversed"
+)
+
+// ErrNotLost is used when a copy turns up for a loan which was not declared
+// lost.
+var ErrNotLost = errors.New("Loan is not declared lost")
+
+// DeclareLost closes a loan whose copy will not come back. The copy stays out
+// of the available stock and the patron is charged the replacement price of
+// the book.
+func DeclareLost(ctx context.Context, user auth.Claims, db *sqlx.DB, id string, now time.Time) (*Fine, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.loan.DeclareLost")
+	defer span.End()
+
+	return writeOff(ctx, user, db, id, StatusLost, now)
+}
+
+// DeclareDamaged closes a loan whose copy came back unusable. The copy is
+// withdrawn from the available stock and the patron is charged the
+// replacement price of the book.
+func DeclareDamaged(ctx context.Context, user auth.Claims, db *sqlx.DB, id string, now time.Time) (*Fine, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.loan.DeclareDamaged")
+	defer span.End()
+
+	return writeOff(ctx, user, db, id, StatusDamaged, now)
+}
+
+// Found reverses a loss when the copy turns up: the copy is put back on the
+// shelf, the loan is returned and the replacement fee is cancelled.
+func Found(ctx context.Context, user auth.Claims, db *sqlx.DB, id string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.loan.Found")
+	defer span.End()
+
+	if !isStaff(user) {
+		return ErrForbidden
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var loan Loan
+	const ql = `SELECT * FROM loans WHERE loan_id = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &loan, ql, id); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return errors.Wrapf(err, "selecting loan %q", id)
+	}
+	if loan.Status != StatusLost {
+		return ErrNotLost
+	}
+
+	const qb = `UPDATE books SET "quantity" = "quantity" + 1 WHERE book_id = $1`
+	if _, err := tx.ExecContext(ctx, qb, loan.BookID); err != nil {
+		return errors.Wrapf(err, "updating book %s quantity", loan.BookID)
+	}
+
+	if err := holds.Promote(ctx, tx, loan.BookID, now); err != nil {
+		return err
+	}
+
+	returned := now.UTC()
+	const q = `UPDATE loans SET "status" = $2, "date_returned" = $3 WHERE loan_id = $1`
+	if _, err := tx.ExecContext(ctx, q, id, StatusReturned, returned); err != nil {
+		return errors.Wrapf(err, "returning loan %s", id)
+	}
+	loan.Status = StatusReturned
+	loan.ReturnedDate = &returned
+
+	const qf = `UPDATE fines SET "status" = $3, "date_updated" = $4 WHERE loan_id = $1 AND kind = $2 AND status = $5`
+	if _, err := tx.ExecContext(ctx, qf, id, FineLost, FineReversed, now.UTC(), FineOpen); err != nil {
+		return errors.Wrapf(err, "reversing loan %s fine", id)
+	}
+
+	if err := audit(ctx, tx, id, user.Subject, ActionFound, "", nil, nil, now); err != nil {
+		return err
+	}
+
+	if err := events.Record(ctx, tx, events.LoanFound, id, loan, now); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Fines retrieves the fines of a patron. Patrons can only see their own.
+func Fines(ctx context.Context, user auth.Claims, db *sqlx.DB, userID string) ([]Fine, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.loan.Fines")
+	defer span.End()
+
+	if !isStaff(user) && user.Subject != userID {
+		return nil, ErrForbidden
+	}
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	fines := []Fine{}
+	const q = `SELECT * FROM fines WHERE user_id = $1 ORDER BY date_created DESC`
+	if err := db.SelectContext(ctx, &fines, q, userID); err != nil {
+		return nil, errors.Wrapf(err, "selecting user %q fines", userID)
+	}
+
+	return fines, nil
+}
+
+// writeOff closes an active loan with the provided status and charges the
+// replacement fee. The copy is not put back on the shelf.
+func writeOff(ctx context.Context, user auth.Claims, db *sqlx.DB, id, status string, now time.Time) (*Fine, error) {
+	if !isStaff(user) {
+		return nil, ErrForbidden
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	loan, err := open(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var price int
+	const qp = `SELECT replacement_price FROM books WHERE book_id = $1`
+	if err := tx.GetContext(ctx, &price, qp, loan.BookID); err != nil {
+		return nil, errors.Wrapf(err, "selecting book %q replacement price", loan.BookID)
+	}
+
+	closed := now.UTC()
+	const q = `UPDATE loans SET "status" = $2, "date_returned" = $3 WHERE loan_id = $1`
+	if _, err := tx.ExecContext(ctx, q, id, status, closed); err != nil {
+		return nil, errors.Wrapf(err, "closing loan %s", id)
+	}
+	loan.Status = status
+	loan.ReturnedDate = &closed
+
+	kind, action, typ := FineLost, ActionLost, events.LoanLost
+	if status == StatusDamaged {
+		kind, action, typ = FineDamaged, ActionDamaged, events.LoanDamaged
+	}
+
+	fine := Fine{
+		ID:          uuid.New().String(),
+		UserID:      loan.UserID,
+		LoanID:      loan.ID,
+		Kind:        kind,
+		Amount:      price,
+		Status:      FineOpen,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const qf = `INSERT INTO fines
+		(fine_id, user_id, loan_id, kind, amount, status, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err = tx.ExecContext(ctx, qf,
+		fine.ID, fine.UserID, fine.LoanID, fine.Kind, fine.Amount, fine.Status, fine.DateCreated, fine.DateUpdated,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting fine")
+	}
+
+	if err := audit(ctx, tx, id, user.Subject, action, "", nil, nil, now); err != nil {
+		return nil, err
+	}
+
+	if err := events.Record(ctx, tx, typ, id, loan, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing loan")
+	}
+
+	return &fine, nil
+}
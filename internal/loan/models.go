@@ -6,14 +6,19 @@ import (
 
 //Loan represents a book in our system.
 type Loan struct {
-	ID           string    `db:"loan_id,omitempty" json:"id"`
-	BookTitle    string    `db:"title" json:"title"`
-	BookISBN     string    `db:"isbn" json:"isbn"`
-	BookQuantity int       `db:"quantity"  json:"category"`
-	BookID       string    `db:"book_id,omitempty" json:"book_id"`
-	LoanDate     time.Time `db:"loan_date" json:"loan_date"` // When the Loan was added.
-	ReturnDate  time.Time  `db:"date_return" json:"date_return"` // When the Loan record was last modified.
-	UserID       string    `db:"user_id" json:"user_id"`
+	ID           string     `db:"loan_id,omitempty" json:"id"`
+	BookTitle    string     `db:"title" json:"title"`
+	BookISBN     string     `db:"isbn" json:"isbn"`
+	BookQuantity int        `db:"quantity"  json:"category"`
+	BookID       string     `db:"book_id,omitempty" json:"book_id"`
+	LoanDate     time.Time  `db:"loan_date" json:"loan_date"`     // When the Loan was added.
+	ReturnDate   time.Time  `db:"date_return" json:"date_return"` // When the Loan record was last modified.
+	UserID       string     `db:"user_id" json:"user_id"`
+	Status       string     `db:"status" json:"status"`
+	ReturnedDate *time.Time `db:"date_returned" json:"date_returned,omitempty"` // When the book was given back.
+	Renewals     int        `db:"renewals" json:"renewals"`                     // How many times the due date was pushed back.
+	DateUpdated  *time.Time `db:"date_updated" json:"date_updated,omitempty"`   // When the due date last moved.
+	Partner      string     `db:"-" json:"partner,omitempty"`                   // The library lending the item, for inter-library loans.
 }
 
 //NewLoan contains information needed to create a new Book.
@@ -33,5 +38,73 @@ type NewLoan struct {
 type UpdateLoan struct {
 	BookISBN     *string    `json:"isbn" json:"isbn"`
 	BookQuantity *int       `json:"quantity"  validate:"gte=1"`
-	ReturnDate  *time.Time `json:"date_return" json:"date_return"` // When the Loan record was last modified.
+	ReturnDate   *time.Time `json:"date_return" json:"date_return"` // When the Loan record was last modified.
+}
+
+// Filter defines the criteria staff can use to search the loans. All fields
+// are optional, the zero value matches every open loan.
+type Filter struct {
+	Overdue         bool       `json:"overdue"`
+	BookID          string     `json:"book_id"`
+	UserID          string     `json:"user_id"`
+	From            *time.Time `json:"from"`
+	To              *time.Time `json:"to"`
+	IncludeReturned bool       `json:"include_returned"`
+}
+
+// Checkout contains information needed by staff to check a book out on
+// behalf of a patron.
+type Checkout struct {
+	UserID string `json:"user_id" validate:"required"`
+	BookID string `json:"book_id" validate:"required"`
+}
+
+// NewBatch contains the books a patron checks out at once.
+type NewBatch struct {
+	BookIDs []string `json:"book_ids" validate:"required,min=1"`
+}
+
+// Receipt is the combined record of the loans created by a batch checkout.
+type Receipt struct {
+	ID       string    `json:"id"`
+	UserID   string    `json:"user_id"`
+	LoanDate time.Time `json:"loan_date"`
+	Loans    []Loan    `json:"loans"`
+}
+
+// ForceReturn contains the reason why a loan is closed by staff outside of
+// the normal check in.
+type ForceReturn struct {
+	Reason string `json:"reason" validate:"required"`
+}
+
+// DueDateChange contains the new due date of a loan and the reason why it
+// is changed.
+type DueDateChange struct {
+	ReturnDate time.Time `json:"date_return" validate:"required"`
+	Reason     string    `json:"reason" validate:"required"`
+}
+
+// AuditEntry records an action taken by staff on a loan.
+type AuditEntry struct {
+	ID          string     `db:"audit_id" json:"id"`
+	LoanID      string     `db:"loan_id" json:"loan_id"`
+	StaffID     string     `db:"staff_id" json:"staff_id"`
+	Action      string     `db:"action" json:"action"`
+	Reason      string     `db:"reason" json:"reason"`
+	OldDueDate  *time.Time `db:"old_due" json:"old_due,omitempty"`
+	NewDueDate  *time.Time `db:"new_due" json:"new_due,omitempty"`
+	DateCreated time.Time  `db:"date_created" json:"date_created"`
+}
+
+// Fine is an amount a patron owes the library.
+type Fine struct {
+	ID          string    `db:"fine_id" json:"id"`
+	UserID      string    `db:"user_id" json:"user_id"`
+	LoanID      string    `db:"loan_id" json:"loan_id"`
+	Kind        string    `db:"kind" json:"kind"`
+	Amount      int       `db:"amount" json:"amount"` // In cents.
+	Status      string    `db:"status" json:"status"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
 }
@@ -0,0 +1,94 @@
+package loans
+
+import (
+	"context"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/standing"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// MaxRenewals is how many times the due date of a loan can be pushed back.
+const MaxRenewals = 2
+
+// ActionRenew is recorded in the audit trail when staff renew a loan on
+// behalf of a patron.
+const ActionRenew = "renew"
+
+// Renewal errors returned when a loan can not be extended.
+var (
+	// ErrRenewalLimit is used when the loan was already renewed MaxRenewals
+	// times.
+	ErrRenewalLimit = errors.New("Loan can not be renewed anymore")
+
+	// ErrOnHold is used when another patron waits for the book.
+	ErrOnHold = errors.New("Another patron is waiting for the book")
+)
+
+// Renew pushes the due date of a loan back by LoanPeriod. Patrons renew their
+// own loans, staff anybody's. Blocked patrons can not renew.
+func Renew(ctx context.Context, user auth.Claims, db *sqlx.DB, id string, now time.Time) (*Loan, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.loan.Renew")
+	defer span.End()
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	loan, err := open(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isStaff(user) && loan.UserID != user.Subject {
+		return nil, ErrForbidden
+	}
+
+	if loan.Renewals >= MaxRenewals {
+		return nil, ErrRenewalLimit
+	}
+
+	var waiting bool
+	const qh = `SELECT EXISTS (SELECT 1 FROM holds WHERE book_id = $1 AND status = 'waiting')`
+	if err := tx.GetContext(ctx, &waiting, qh, loan.BookID); err != nil {
+		return nil, errors.Wrapf(err, "selecting book %q holds", loan.BookID)
+	}
+	if waiting {
+		return nil, ErrOnHold
+	}
+
+	if err := standing.Verify(ctx, tx, loan.UserID, now); err != nil {
+		return nil, err
+	}
+
+	old := loan.ReturnDate
+	loan.ReturnDate = loan.ReturnDate.Add(LoanPeriod).UTC()
+	loan.Renewals++
+
+	const q = `UPDATE loans SET "date_return" = $2, "renewals" = $3, "date_updated" = $4 WHERE loan_id = $1`
+	if _, err := tx.ExecContext(ctx, q, id, loan.ReturnDate, loan.Renewals, now.UTC()); err != nil {
+		return nil, errors.Wrapf(err, "renewing loan %s", id)
+	}
+
+	if user.Subject != loan.UserID {
+		if err := audit(ctx, tx, id, user.Subject, ActionRenew, "", &old, &loan.ReturnDate, now); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing loan")
+	}
+
+	return loan, nil
+}
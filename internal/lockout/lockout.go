@@ -0,0 +1,296 @@
+// Package lockout slows down and locks out repeated failed attempts at a
+// secret, a password or a PIN, so they can not be guessed by trying them all.
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/book-library/internal/events"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+var (
+	// ErrThrottled occurs when an attempt comes before the delay following
+	// the last failures is over.
+	ErrThrottled = errors.New("Too many failed attempts, slow down")
+
+	// ErrLocked occurs when an attempt is made while the failures reached the
+	// threshold of the policy.
+	ErrLocked = errors.New("Too many failed attempts, try again later")
+)
+
+// Policy tells how many failures are tolerated for one kind of key.
+type Policy struct {
+	// Prefix tells apart the keys of the policy, an email and an address do
+	// not share their failures.
+	Prefix string
+
+	// FreeAttempts is how many failures come without any delay.
+	FreeAttempts int
+
+	// BaseDelay is the delay after the first failure past the free attempts,
+	// it doubles with each failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// Threshold is how many failures within Window lock the key for Period.
+	Threshold int
+	Window    time.Duration
+	Period    time.Duration
+}
+
+// These are the policies of the secrets checked by the library. Addresses are
+// shared by many patrons behind the same network, they tolerate more failures
+// than a single account. The codes of authenticator apps are counted apart
+// from the password, signing in with the password does not forget them.
+var (
+	Account = Policy{Prefix: "account", FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 30 * time.Second, Threshold: 10, Window: time.Hour, Period: 15 * time.Minute}
+	Code    = Policy{Prefix: "code", FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 30 * time.Second, Threshold: 10, Window: time.Hour, Period: 15 * time.Minute}
+	Address = Policy{Prefix: "ip", FreeAttempts: 10, BaseDelay: time.Second, MaxDelay: 30 * time.Second, Threshold: 50, Window: time.Hour, Period: 15 * time.Minute}
+	Card    = Policy{Prefix: "card", FreeAttempts: 2, BaseDelay: 2 * time.Second, MaxDelay: time.Minute, Threshold: 5, Window: time.Hour, Period: 30 * time.Minute}
+)
+
+// Key identifies what the failures are counted against.
+type Key struct {
+	Policy  Policy
+	Subject string
+}
+
+// Key returns the key of subject under the policy.
+func (p Policy) Key(subject string) Key {
+	return Key{Policy: p, Subject: subject}
+}
+
+// String is the key stored in the database and recorded in the events.
+func (k Key) String() string {
+	return k.Policy.Prefix + ":" + k.Subject
+}
+
+// Lock is the payload of the events recorded when a key is locked or unlocked.
+type Lock struct {
+	Key        string     `json:"key"`
+	Failures   int        `json:"failures,omitempty"`
+	Until      *time.Time `json:"until,omitempty"`
+	LiftedBy   string     `json:"lifted_by,omitempty"`
+	OccurredAt time.Time  `json:"occurred_at"`
+}
+
+// RetryError is an attempt refused by Check along with how long to wait
+// before trying again. Err is the error the caller reports, errors.Cause
+// returns it.
+type RetryError struct {
+	Err   error
+	After time.Duration
+}
+
+// Retry returns err along with how long to wait before trying again.
+func Retry(err error, after time.Duration) error {
+	return &RetryError{Err: err, After: after}
+}
+
+// Error implements the error interface.
+func (e *RetryError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the error reported for the refused attempt.
+func (e *RetryError) Cause() error {
+	return e.Err
+}
+
+// RetryAfter returns how long to wait before trying again after err, zero
+// when err is not a RetryError.
+func RetryAfter(err error) time.Duration {
+	if e, ok := err.(*RetryError); ok {
+		return e.After
+	}
+	return 0
+}
+
+// throttle is a row of the login_throttles table.
+type throttle struct {
+	Key             string     `db:"throttle_key"`
+	Failures        int        `db:"failures"`
+	DateLastFailure *time.Time `db:"date_last_failure"`
+	DateLockedUntil *time.Time `db:"date_locked_until"`
+}
+
+// Check refuses an attempt while any of the keys is locked or within the
+// delay following its last failure, it returns how long to wait before the
+// attempt is accepted. It is called before the secret is compared. Keys
+// without a subject are ignored.
+func Check(ctx context.Context, db sqlx.QueryerContext, now time.Time, keys ...Key) (time.Duration, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.lockout.Check")
+	defer span.End()
+
+	policies := map[string]Policy{}
+	var names []string
+	for _, k := range keys {
+		if k.Subject == "" {
+			continue
+		}
+		policies[k.String()] = k.Policy
+		names = append(names, k.String())
+	}
+	if len(names) == 0 {
+		return 0, nil
+	}
+
+	var ts []throttle
+	const q = `SELECT * FROM login_throttles WHERE throttle_key = ANY($1)`
+	if err := sqlx.SelectContext(ctx, db, &ts, q, pq.StringArray(names)); err != nil {
+		return 0, errors.Wrap(err, "selecting login throttles")
+	}
+
+	// The attempt waits for the last of the keys, a lockout comes before any
+	// delay.
+	now = now.UTC()
+	var locked, throttled time.Duration
+	for _, t := range ts {
+		if t.DateLockedUntil != nil && now.Before(*t.DateLockedUntil) {
+			if d := t.DateLockedUntil.Sub(now); d > locked {
+				locked = d
+			}
+		}
+		if t.DateLastFailure != nil {
+			if d := t.DateLastFailure.Add(policies[t.Key].delay(t.Failures)).Sub(now); d > throttled {
+				throttled = d
+			}
+		}
+	}
+	switch {
+	case locked > 0:
+		return locked, ErrLocked
+	case throttled > 0:
+		return throttled, ErrThrottled
+	}
+
+	return 0, nil
+}
+
+// Fail counts a failed attempt against every key. A key reaching the threshold
+// of its policy is locked, and the lockout is recorded for audit.
+func Fail(ctx context.Context, db *sqlx.DB, now time.Time, keys ...Key) error {
+	ctx, span := trace.StartSpan(ctx, "internal.lockout.Fail")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	now = now.UTC()
+	for _, k := range keys {
+		if k.Subject == "" {
+			continue
+		}
+
+		// Failures older than the window of the policy are forgotten, the
+		// count starts over.
+		var failures int
+		const q = `INSERT INTO login_throttles (throttle_key, failures, date_last_failure)
+			VALUES ($1, 1, $2)
+			ON CONFLICT (throttle_key) DO UPDATE SET
+				failures = CASE WHEN login_throttles.date_last_failure < $3 THEN 1 ELSE login_throttles.failures + 1 END,
+				date_last_failure = $2
+			RETURNING failures`
+		if err := tx.GetContext(ctx, &failures, q, k.String(), now, now.Add(-k.Policy.Window)); err != nil {
+			return errors.Wrap(err, "counting failure")
+		}
+		if failures < k.Policy.Threshold {
+			continue
+		}
+
+		// Once locked the count starts over, the attempts after the lockout
+		// are delayed again before locking it once more.
+		until := now.Add(k.Policy.Period)
+		const ql = `UPDATE login_throttles SET failures = 0, date_locked_until = $2 WHERE throttle_key = $1`
+		if _, err := tx.ExecContext(ctx, ql, k.String(), until); err != nil {
+			return errors.Wrap(err, "locking out")
+		}
+
+		l := Lock{Key: k.String(), Failures: failures, Until: &until, OccurredAt: now}
+		if err := events.Record(ctx, tx, events.LoginLocked, k.String(), l, now); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing failure")
+	}
+
+	return nil
+}
+
+// Reset forgets the failures of a key after a successful attempt. Attempts on
+// a locked key are refused by Check before the secret is compared, a lockout
+// is never lifted this way.
+func Reset(ctx context.Context, db sqlx.ExecerContext, key Key) error {
+	ctx, span := trace.StartSpan(ctx, "internal.lockout.Reset")
+	defer span.End()
+
+	const q = `DELETE FROM login_throttles WHERE throttle_key = $1`
+	if _, err := db.ExecContext(ctx, q, key.String()); err != nil {
+		return errors.Wrap(err, "resetting login throttle")
+	}
+
+	return nil
+}
+
+// Unlock lifts the lockout of a key and forgets its failures. It returns
+// whether the key was locked, lifting a lockout is recorded for audit.
+func Unlock(ctx context.Context, db *sqlx.DB, key Key, by string, now time.Time) (bool, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.lockout.Unlock")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	const q = `DELETE FROM login_throttles WHERE throttle_key = $1
+		RETURNING COALESCE(date_locked_until > $2, FALSE)`
+	if err := tx.GetContext(ctx, &locked, q, key.String(), now.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "unlocking")
+	}
+
+	if locked {
+		l := Lock{Key: key.String(), LiftedBy: by, OccurredAt: now.UTC()}
+		if err := events.Record(ctx, tx, events.LoginUnlocked, key.String(), l, now); err != nil {
+			return false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, errors.Wrap(err, "committing unlock")
+	}
+
+	return locked, nil
+}
+
+// delay is how long to wait after the last of failures before trying again.
+func (p Policy) delay(failures int) time.Duration {
+	n := failures - p.FreeAttempts
+	if n <= 0 {
+		return 0
+	}
+	d := p.BaseDelay
+	for i := 1; i < n && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
@@ -2,27 +2,50 @@ package mid
 
 import (
 	"context"
+	"crypto/subtle"
 	"github.com/book-library/internal/platform/auth"
 	"github.com/book-library/internal/platform/web"
 	errors "github.com/pkg/errors"
 	"go.opencensus.io/trace"
 	"net/http"
 	"strings"
+	"time"
 )
 
 const (
 	// default names for cookies and headers
 	defaultJWTCookieName  = "session-cookie"
 	defaultXSRFCookieName = "x-xsrf-token"
+	defaultXSRFHeader     = "X-XSRF-Token"
 )
 
+// ctxKey represents the type of value for the context key.
+type ctxKey int
+
+// cookieKey is set in the context when a request was authenticated with the
+// session cookie rather than the authorization header.
+const cookieKey ctxKey = 1
+
 //ErrForbidden is returned when a users doesn't have the required roles for doing an action
 var ErrForbidden = web.NewRequestError(
 	errors.New("you don't have the authorization for that action"),
 	http.StatusForbidden,
 )
 
-//Authentication validates a jwt and the csrf cookie from the Authorization header
+//ErrRevoked is returned when a token was revoked before it expired
+var ErrRevoked = web.NewRequestError(
+	errors.New("token was revoked"),
+	http.StatusUnauthorized,
+)
+
+//ErrCSRF is returned when a request authenticated with the session cookie does not echo its csrf token
+var ErrCSRF = web.NewRequestError(
+	errors.New("missing or invalid csrf token"),
+	http.StatusForbidden,
+)
+
+//Authentication validates a jwt or a personal access token from the Authorization header or, for browsers, a jwt
+//from the session cookie
 func Authentication(authenticator *auth.Authenticator) web.Middleware {
 
 	//actual middleware to be execute
@@ -33,19 +56,60 @@ func Authentication(authenticator *auth.Authenticator) web.Middleware {
 			ctx, span := trace.StartSpan(ctx, "internal.mid.Authentication")
 			defer span.End()
 
-			// Expecting: bearer <token>
+			// Expecting: bearer <token>, browsers send the session cookie instead.
 			authStr := r.Header.Get("authorization")
+			var token string
+			if c, err := r.Cookie(defaultJWTCookieName); authStr == "" && err == nil && c.Value != "" {
+				token = c.Value
+				ctx = context.WithValue(ctx, cookieKey, true)
+			} else {
+				// Parse the authorization header.
+				parts := strings.Split(authStr, " ")
+				if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+					return errors.New("expected authorization header format: bearer <token>")
+				}
+				token = parts[1]
+			}
+
+			now := time.Now()
+			if v, ok := ctx.Value(web.KeyValues).(*web.Values); ok {
+				now = v.Now
+			}
+
+			// Scripts send a personal access token, everyone else a token
+			// signed by us.
+			var claims auth.Claims
+			var err error
+			if auth.IsAccessToken(token) {
+				claims, err = authenticator.ParseAccessToken(ctx, token, now)
+				switch err {
+				case nil:
+				case auth.ErrUnknownAccessToken:
+					return web.NewRequestError(err, http.StatusUnauthorized)
+				default:
+					return errors.Wrap(err, "checking access token")
+				}
+			} else {
+				claims, err = authenticator.ParseClaims(token)
+				if err != nil {
+					return errors.New("Token does not exist")
+				}
+			}
 
-			// Parse the authorization header.
-			parts := strings.Split(authStr, " ")
-			if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-				return errors.New("expected authorization header format: bearer <token>")
+			// Reject tokens revoked before they expired.
+			revoked, err := authenticator.Revoked(ctx, claims, now)
+			if err != nil {
+				return errors.Wrap(err, "checking token revocation")
+			}
+			if revoked {
+				return ErrRevoked
 			}
 
-			// Validate the token is signed by us.
-			claims, err := authenticator.ParseClaims(parts[1])
+			// Resolve what the roles of the claims grant, admins edit roles
+			// while tokens are valid.
+			claims.Permissions, err = authenticator.Permissions(ctx, claims.Roles)
 			if err != nil {
-				return errors.New("Token does not exist")
+				return errors.Wrap(err, "resolving permissions")
 			}
 
 			//Add claims to context so that they can be checked later on
@@ -85,3 +149,106 @@ func HasRole(roles ...string) web.Middleware {
 
 	return f
 }
+
+//HasPermission checks the roles of an authenticated user grant at least one of the required permissions
+func HasPermission(perms ...string) web.Middleware {
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+			ctx, span := trace.StartSpan(ctx, "internal.mid.HasPermission")
+			defer span.End()
+
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return errors.New("claims missing from context: HasPermission called without/before Authenticate")
+			}
+
+			if !claims.HasPermission(perms...) {
+				return ErrForbidden
+			}
+
+			return after(ctx, w, r, params)
+		}
+
+		return h
+	}
+
+	return f
+}
+
+//HasScope checks the personal access token of a request has at least one of the scopes of the route. Requests of
+//a session are not scoped. A route listing no scope is not available to personal access tokens at all
+func HasScope(scopes ...string) web.Middleware {
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+			ctx, span := trace.StartSpan(ctx, "internal.mid.HasScope")
+			defer span.End()
+
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return errors.New("claims missing from context: HasScope called without/before Authenticate")
+			}
+
+			if !claims.HasScope(scopes...) {
+				return ErrForbidden
+			}
+
+			return after(ctx, w, r, params)
+		}
+
+		return h
+	}
+
+	return f
+}
+
+//CSRF protects the requests authenticated with the session cookie against cross-site request forgery. State
+//changing requests have to send the csrf token of their session both in the x-xsrf-token cookie and in the
+//X-XSRF-Token header, which a foreign site can not do. Clients sending a bearer token are not concerned
+func CSRF() web.Middleware {
+	// This is the actual middleware function to be executed.
+	f := func(after web.Handler) web.Handler {
+
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+			ctx, span := trace.StartSpan(ctx, "internal.mid.CSRF")
+			defer span.End()
+
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return after(ctx, w, r, params)
+			}
+
+			if fromCookie, _ := ctx.Value(cookieKey).(bool); !fromCookie {
+				return after(ctx, w, r, params)
+			}
+
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return errors.New("claims missing from context: CSRF called without/before Authenticate")
+			}
+
+			c, err := r.Cookie(defaultXSRFCookieName)
+			if err != nil {
+				return ErrCSRF
+			}
+			header := r.Header.Get(defaultXSRFHeader)
+
+			// The token has to be the one of the session, not any value the
+			// cookie and the header agree on.
+			if claims.Csrf == "" || header == "" ||
+				subtle.ConstantTimeCompare([]byte(header), []byte(c.Value)) != 1 ||
+				subtle.ConstantTimeCompare([]byte(header), []byte(claims.Csrf)) != 1 {
+				return ErrCSRF
+			}
+
+			return after(ctx, w, r, params)
+		}
+
+		return h
+	}
+
+	return f
+}
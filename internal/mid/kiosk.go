@@ -0,0 +1,55 @@
+package mid
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/book-library/internal/kiosk"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/web"
+	"github.com/jmoiron/sqlx"
+	errors "github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+//KioskSession validates the device credential and the session token sent by a self-service kiosk. The session
+//acts with the permissions the roles of the card holder grant, resolved by the authenticator
+func KioskSession(db *sqlx.DB, authenticator *auth.Authenticator) web.Middleware {
+
+	//actual middleware to be execute
+	f := func(after web.Handler) web.Handler {
+
+		//wrapped handler around the next one
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request, params map[string]string) error {
+			ctx, span := trace.StartSpan(ctx, "internal.mid.KioskSession")
+			defer span.End()
+
+			v, ok := ctx.Value(web.KeyValues).(*web.Values)
+			if !ok {
+				return web.NewShutdownError("web value missing from context")
+			}
+
+			s, err := kiosk.Resume(ctx, db, r.Header.Get(kiosk.DeviceHeader), r.Header.Get(kiosk.SessionHeader), v.Now)
+			if err != nil {
+				switch err {
+				case kiosk.ErrUnknownDevice, kiosk.ErrSessionExpired:
+					return web.NewRequestError(err, http.StatusUnauthorized)
+				default:
+					return errors.Wrap(err, "resuming kiosk session")
+				}
+			}
+
+			s.Permissions, err = authenticator.Permissions(ctx, s.Roles)
+			if err != nil {
+				return errors.Wrap(err, "resolving permissions")
+			}
+
+			//Add the session to context so that handlers act for its patron
+			ctx = context.WithValue(ctx, kiosk.Key, *s)
+
+			return after(ctx, w, r, params)
+		}
+		return h
+	}
+	return f
+}
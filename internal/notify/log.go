@@ -0,0 +1,24 @@
+package notify
+
+import (
+	"context"
+	"log"
+)
+
+// LogSender is a Sender writing the messages to a logger instead of
+// delivering them. It is meant for development, where following the links
+// of the emails from the logs is enough.
+type LogSender struct {
+	log *log.Logger
+}
+
+// NewLogSender creates a *LogSender for use.
+func NewLogSender(log *log.Logger) *LogSender {
+	return &LogSender{log: log}
+}
+
+// Send implements the Sender interface.
+func (s *LogSender) Send(ctx context.Context, m Message) error {
+	s.log.Printf("notify : mail to %s : %s\n%s", m.To, m.Subject, m.BodyText)
+	return nil
+}
@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"time"
+)
+
+// Notification is a rendered message waiting in, or already sent from, the
+// outbox.
+type Notification struct {
+	ID          string     `db:"notification_id" json:"id"`
+	UserID      string     `db:"user_id" json:"user_id"`
+	Event       string     `db:"event" json:"event"`
+	Recipient   string     `db:"recipient" json:"recipient"`
+	Subject     string     `db:"subject" json:"subject"`
+	BodyText    string     `db:"body_text" json:"-"`
+	BodyHTML    string     `db:"body_html" json:"-"`
+	DedupeKey   *string    `db:"dedupe_key" json:"-"`
+	Status      string     `db:"status" json:"status"`
+	Attempts    int        `db:"attempts" json:"attempts"`
+	NextAttempt time.Time  `db:"next_attempt" json:"next_attempt"`
+	LastError   *string    `db:"last_error" json:"last_error,omitempty"`
+	DateCreated time.Time  `db:"date_created" json:"date_created"`
+	DateSent    *time.Time `db:"date_sent" json:"date_sent,omitempty"`
+}
+
+// NewNotification contains information needed to queue a Notification. Data
+// is handed to the templates registered for Event.
+type NewNotification struct {
+	UserID    string
+	Event     string
+	DedupeKey string
+	Data      map[string]interface{}
+}
+
+// Message is a fully rendered email ready to be handed to a Sender.
+type Message struct {
+	To       string
+	Subject  string
+	BodyText string
+	BodyHTML string
+}
+
+// Preference tells whether a user wants to receive emails for an event.
+type Preference struct {
+	Event string `db:"event" json:"event"`
+	Email bool   `db:"email" json:"email"`
+}
+
+// UpdatePreferences defines which event preferences a user wants to change.
+// Events not present in the map keep their current value.
+type UpdatePreferences struct {
+	Email map[string]bool `json:"email" validate:"required"`
+}
@@ -0,0 +1,250 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Outbox status values.
+const (
+	StatusPending = "pending"
+	StatusSending = "sending"
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+)
+
+// Predefined errors identify expected failure conditions.
+var (
+	// ErrUnknownEvent is used when no template is registered for an event.
+	ErrUnknownEvent = errors.New("unknown notification event")
+
+	// ErrNoRecipient is used when the user to notify does not exist.
+	ErrNoRecipient = errors.New("notification recipient not found")
+
+	// ErrInvalidID is used when an invalid UUID is provided.
+	ErrInvalidID = errors.New("ID is not in its proper form")
+
+	// ErrForbidden occurs when a users tries to do something that is forbidden to
+	// them according to our access control policies.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+)
+
+// Enqueue renders the templates of the event and stores the resulting message
+// in the outbox, where a Worker picks it up. It returns a nil Notification
+// when the user opted out of the event or when a notification with the same
+// dedupe key was already queued.
+//
+// db can be a *sqlx.DB or a *sqlx.Tx so the notification is only queued when
+// the surrounding transaction commits.
+func Enqueue(ctx context.Context, db sqlx.ExtContext, n NewNotification, now time.Time) (*Notification, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.notify.Enqueue")
+	defer span.End()
+
+	if _, err := uuid.Parse(n.UserID); err != nil {
+		return nil, ErrNoRecipient
+	}
+
+	var u struct {
+		Name  string `db:"name"`
+		Email string `db:"email"`
+	}
+	const qu = `SELECT name, email FROM users WHERE user_id = $1`
+	if err := sqlx.GetContext(ctx, db, &u, qu, n.UserID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNoRecipient
+		}
+		return nil, errors.Wrap(err, "selecting recipient")
+	}
+
+	if !mandatory[n.Event] {
+		var enabled bool
+		const qp = `SELECT email FROM notification_preferences WHERE user_id = $1 AND event = $2`
+		err := sqlx.GetContext(ctx, db, &enabled, qp, n.UserID, n.Event)
+		switch {
+		case err == sql.ErrNoRows:
+			// Users receive everything until they say otherwise.
+		case err != nil:
+			return nil, errors.Wrap(err, "selecting notification preference")
+		case !enabled:
+			return nil, nil
+		}
+	}
+
+	data := map[string]interface{}{"Name": u.Name}
+	for k, v := range n.Data {
+		data[k] = v
+	}
+
+	m, err := Render(n.Event, data)
+	if err != nil {
+		return nil, err
+	}
+
+	nt := Notification{
+		ID:          uuid.New().String(),
+		UserID:      n.UserID,
+		Event:       n.Event,
+		Recipient:   u.Email,
+		Subject:     m.Subject,
+		BodyText:    m.BodyText,
+		BodyHTML:    m.BodyHTML,
+		Status:      StatusPending,
+		NextAttempt: now.UTC(),
+		DateCreated: now.UTC(),
+	}
+	if n.DedupeKey != "" {
+		nt.DedupeKey = &n.DedupeKey
+	}
+
+	const q = `INSERT INTO notifications
+		(notification_id, user_id, event, recipient, subject, body_text, body_html,
+		dedupe_key, status, attempts, next_attempt, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, 0, $10, $11)
+		ON CONFLICT (dedupe_key) DO NOTHING`
+	res, err := db.ExecContext(
+		ctx, q,
+		nt.ID, nt.UserID, nt.Event, nt.Recipient, nt.Subject, nt.BodyText, nt.BodyHTML,
+		nt.DedupeKey, nt.Status, nt.NextAttempt, nt.DateCreated,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting notification")
+	}
+
+	if rows, err := res.RowsAffected(); err == nil && rows == 0 {
+		return nil, nil
+	}
+
+	return &nt, nil
+}
+
+// ScheduleReminders queues a due-soon notification for every open loan due
+// within the provided window and an overdue notification for every open loan
+// past its return date. Each loan gets at most one reminder of each kind.
+func ScheduleReminders(ctx context.Context, db *sqlx.DB, now time.Time, window time.Duration) (int, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.notify.ScheduleReminders")
+	defer span.End()
+
+	var loans []struct {
+		ID         string    `db:"loan_id"`
+		UserID     string    `db:"user_id"`
+		Title      string    `db:"title"`
+		ReturnDate time.Time `db:"date_return"`
+	}
+	const q = `SELECT loan_id, user_id, title, date_return FROM loans WHERE date_returned IS NULL AND date_return < $1`
+	if err := db.SelectContext(ctx, &loans, q, now.Add(window)); err != nil {
+		return 0, errors.Wrap(err, "selecting loans due soon")
+	}
+
+	var queued int
+	for _, l := range loans {
+		event := EventDueSoon
+		if l.ReturnDate.Before(now) {
+			event = EventOverdue
+		}
+
+		n := NewNotification{
+			UserID:    l.UserID,
+			Event:     event,
+			DedupeKey: event + ":" + l.ID + ":" + l.ReturnDate.Format("2006-01-02"),
+			Data: map[string]interface{}{
+				"Title":      l.Title,
+				"ReturnDate": l.ReturnDate,
+			},
+		}
+
+		nt, err := Enqueue(ctx, db, n, now)
+		if err != nil {
+			if err == ErrNoRecipient {
+				continue
+			}
+			return queued, err
+		}
+		if nt != nil {
+			queued++
+		}
+	}
+
+	return queued, nil
+}
+
+// Preferences returns the email preference of the user for every event.
+func Preferences(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string) ([]Preference, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.notify.Preferences")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	// If you are not an admin and looking to retrieve someone else then you are rejected.
+	if !claims.HasPermission(auth.PermissionUsersManage) && claims.Subject != userID {
+		return nil, ErrForbidden
+	}
+
+	stored := []Preference{}
+	const q = `SELECT event, email FROM notification_preferences WHERE user_id = $1`
+	if err := db.SelectContext(ctx, &stored, q, userID); err != nil {
+		return nil, errors.Wrap(err, "selecting notification preferences")
+	}
+
+	set := make(map[string]bool, len(stored))
+	for _, p := range stored {
+		set[p.Event] = p.Email
+	}
+
+	prefs := make([]Preference, 0, len(Events))
+	for _, e := range Events {
+		p := Preference{Event: e, Email: true}
+		if v, ok := set[e]; ok && !mandatory[e] {
+			p.Email = v
+		}
+		prefs = append(prefs, p)
+	}
+
+	return prefs, nil
+}
+
+// SetPreferences stores the preferences provided by the user.
+func SetPreferences(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string, upd UpdatePreferences) error {
+	ctx, span := trace.StartSpan(ctx, "internal.notify.SetPreferences")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrInvalidID
+	}
+
+	// If you are not an admin and looking to change someone else then you are rejected.
+	if !claims.HasPermission(auth.PermissionUsersManage) && claims.Subject != userID {
+		return ErrForbidden
+	}
+
+	for event := range upd.Email {
+		if _, ok := templates[event]; !ok {
+			return ErrUnknownEvent
+		}
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	const q = `INSERT INTO notification_preferences (user_id, event, email)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, event) DO UPDATE SET email = EXCLUDED.email`
+	for event, enabled := range upd.Email {
+		if _, err := tx.ExecContext(ctx, q, userID, event, enabled); err != nil {
+			return errors.Wrapf(err, "storing preference %s", event)
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,304 @@
+package notify_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/notify"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+)
+
+// smtpStandIn is a minimal in-process SMTP server recording the messages it
+// receives. It speaks just enough of the protocol for net/smtp.
+type smtpStandIn struct {
+	ln       net.Listener
+	messages chan string
+}
+
+func startSMTPStandIn(t *testing.T) *smtpStandIn {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+
+	s := smtpStandIn{
+		ln:       ln,
+		messages: make(chan string, 10),
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return &s
+}
+
+func (s *smtpStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 localhost ESMTP stand-in")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM"), strings.HasPrefix(cmd, "RCPT TO"), strings.HasPrefix(cmd, "RSET"):
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.messages <- data.String()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func (s *smtpStandIn) config() notify.SMTPConfig {
+	host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return notify.SMTPConfig{Host: host, Port: p, From: "library@example.com"}
+}
+
+// TestSMTPSender validates rendered messages are delivered over SMTP.
+func TestSMTPSender(t *testing.T) {
+	srv := startSMTPStandIn(t)
+	defer srv.ln.Close()
+
+	t.Log("Given the need to email patrons.")
+	{
+		t.Log("\tWhen sending a checkout receipt.")
+		{
+			now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+			m, err := notify.Render(notify.EventCheckoutReceipt, map[string]interface{}{
+				"Name":       "Bill Kennedy",
+				"Title":      "Go programming",
+				"ISBN":       "bcn22",
+				"LoanDate":   now,
+				"ReturnDate": now.AddDate(0, 0, 30),
+			})
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to render the receipt : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to render the receipt.", tests.Success)
+
+			if !strings.Contains(m.BodyText, "31 Oct 2018") {
+				t.Fatalf("\t%s\tShould mention the return date : %s.", tests.Failed, m.BodyText)
+			}
+			t.Logf("\t%s\tShould mention the return date.", tests.Success)
+
+			m.To = "bill@ardanlabs.com"
+			if err := notify.NewSMTPSender(srv.config()).Send(context.Background(), m); err != nil {
+				t.Fatalf("\t%s\tShould be able to send the receipt : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to send the receipt.", tests.Success)
+
+			select {
+			case got := <-srv.messages:
+				if !strings.Contains(got, "To: bill@ardanlabs.com") || !strings.Contains(got, "text/html") {
+					t.Fatalf("\t%s\tShould receive the multipart message : %s.", tests.Failed, got)
+				}
+				t.Logf("\t%s\tShould receive the multipart message.", tests.Success)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("\t%s\tShould receive the message.", tests.Failed)
+			}
+		}
+
+		t.Log("\tWhen a template is missing data.")
+		{
+			if _, err := notify.Render(notify.EventHoldReady, nil); err == nil {
+				t.Fatalf("\t%s\tShould fail to render the message.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould fail to render the message.", tests.Success)
+		}
+	}
+}
+
+// failingSender is a Sender which always fails.
+type failingSender struct{}
+
+func (failingSender) Send(ctx context.Context, m notify.Message) error {
+	return net.ErrClosed
+}
+
+// TestOutbox validates notifications are queued, honour preferences and are
+// retried when the delivery fails.
+func TestOutbox(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	srv := startSMTPStandIn(t)
+	defer srv.ln.Close()
+
+	t.Log("Given the need to queue notifications.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		nu := users.NewUser{
+			Name:            "Bill Kennedy",
+			Email:           "bill@ardanlabs.com",
+			Roles:           []string{auth.RoleUser},
+			Password:        "gophers",
+			PasswordConfirm: "gophers",
+		}
+		u, err := users.Create(ctx, db, nu, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+		}
+
+		claims := auth.NewClaims(u.ID, u.Roles, now, time.Hour, "")
+
+		t.Log("\tWhen the delivery fails.")
+		{
+			n := notify.NewNotification{
+				UserID:    u.ID,
+				Event:     notify.EventHoldReady,
+				DedupeKey: "hold:1",
+				Data:      map[string]interface{}{"Title": "Go programming"},
+			}
+			if _, err := notify.Enqueue(ctx, db, n, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to queue a notification : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to queue a notification.", tests.Success)
+
+			nt, err := notify.Enqueue(ctx, db, n, now)
+			if err != nil || nt != nil {
+				t.Fatalf("\t%s\tShould not queue the same notification twice : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould not queue the same notification twice.", tests.Success)
+
+			sent, failed, err := notify.Dispatch(ctx, db, failingSender{}, now, 3)
+			if err != nil || sent != 0 || failed != 1 {
+				t.Fatalf("\t%s\tShould record the failure : %d sent, %d failed, %v.", tests.Failed, sent, failed, err)
+			}
+			t.Logf("\t%s\tShould record the failure.", tests.Success)
+
+			sent, _, err = notify.Dispatch(ctx, db, notify.NewSMTPSender(srv.config()), now, 3)
+			if err != nil || sent != 0 {
+				t.Fatalf("\t%s\tShould wait before retrying : %d sent, %v.", tests.Failed, sent, err)
+			}
+			t.Logf("\t%s\tShould wait before retrying.", tests.Success)
+
+			sent, _, err = notify.Dispatch(ctx, db, notify.NewSMTPSender(srv.config()), now.Add(notify.Backoff(1)), 3)
+			if err != nil || sent != 1 {
+				t.Fatalf("\t%s\tShould retry after the backoff : %d sent, %v.", tests.Failed, sent, err)
+			}
+			t.Logf("\t%s\tShould retry after the backoff.", tests.Success)
+		}
+
+		t.Log("\tWhen an instance stopped while sending.")
+		{
+			n := notify.NewNotification{
+				UserID:    u.ID,
+				Event:     notify.EventHoldReady,
+				DedupeKey: "hold:2",
+				Data:      map[string]interface{}{"Title": "Go programming"},
+			}
+			nt, err := notify.Enqueue(ctx, db, n, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to queue a notification : %s.", tests.Failed, err)
+			}
+
+			// The claim of the stopped instance, its lease runs for a while.
+			const q = `UPDATE notifications SET status = $2, next_attempt = $3 WHERE notification_id = $1`
+			if _, err := db.Exec(q, nt.ID, notify.StatusSending, now.Add(time.Minute)); err != nil {
+				t.Fatalf("\t%s\tShould be able to claim the notification : %s.", tests.Failed, err)
+			}
+
+			sent, _, err := notify.Dispatch(ctx, db, notify.NewSMTPSender(srv.config()), now, 3)
+			if err != nil || sent != 0 {
+				t.Fatalf("\t%s\tShould not send a claimed notification : %d sent, %v.", tests.Failed, sent, err)
+			}
+			t.Logf("\t%s\tShould not send a claimed notification.", tests.Success)
+
+			sent, _, err = notify.Dispatch(ctx, db, notify.NewSMTPSender(srv.config()), now.Add(time.Minute), 3)
+			if err != nil || sent != 1 {
+				t.Fatalf("\t%s\tShould send it once the lease expired : %d sent, %v.", tests.Failed, sent, err)
+			}
+			t.Logf("\t%s\tShould send it once the lease expired.", tests.Success)
+
+			n.DedupeKey = "hold:3"
+			nt, err = notify.Enqueue(ctx, db, n, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to queue a notification : %s.", tests.Failed, err)
+			}
+
+			// Every instance claiming it stopped before recording a result.
+			const qa = `UPDATE notifications SET status = $2, attempts = $3, next_attempt = $4 WHERE notification_id = $1`
+			if _, err := db.Exec(qa, nt.ID, notify.StatusSending, 3, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to claim the notification : %s.", tests.Failed, err)
+			}
+
+			sent, failed, err := notify.Dispatch(ctx, db, notify.NewSMTPSender(srv.config()), now, 3)
+			if err != nil || sent != 0 || failed != 1 {
+				t.Fatalf("\t%s\tShould fail it once the leases used every attempt : %d sent, %d failed, %v.", tests.Failed, sent, failed, err)
+			}
+			var status string
+			if err := db.Get(&status, `SELECT status FROM notifications WHERE notification_id = $1`, nt.ID); err != nil || status != notify.StatusFailed {
+				t.Fatalf("\t%s\tShould fail it once the leases used every attempt : %s %v.", tests.Failed, status, err)
+			}
+			t.Logf("\t%s\tShould fail it once the leases used every attempt.", tests.Success)
+		}
+
+		t.Log("\tWhen the user opted out.")
+		{
+			upd := notify.UpdatePreferences{Email: map[string]bool{notify.EventHoldReady: false}}
+			if err := notify.SetPreferences(ctx, claims, db, u.ID, upd); err != nil {
+				t.Fatalf("\t%s\tShould be able to store preferences : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to store preferences.", tests.Success)
+
+			n := notify.NewNotification{
+				UserID: u.ID,
+				Event:  notify.EventHoldReady,
+				Data:   map[string]interface{}{"Title": "Go programming"},
+			}
+			nt, err := notify.Enqueue(ctx, db, n, now)
+			if err != nil || nt != nil {
+				t.Fatalf("\t%s\tShould not queue the notification : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould not queue the notification.", tests.Success)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Sender delivers a rendered Message to its recipient. It is the extension
+// point used to plug another mail provider in.
+type Sender interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// SMTPConfig is the required properties to talk to a SMTP server.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPSender is a Sender delivering messages through a SMTP server.
+type SMTPSender struct {
+	cfg SMTPConfig
+}
+
+// NewSMTPSender creates a *SMTPSender for use.
+func NewSMTPSender(cfg SMTPConfig) *SMTPSender {
+	return &SMTPSender{cfg: cfg}
+}
+
+// Send implements the Sender interface. The message is sent as a
+// multipart/alternative email holding both the text and the html bodies.
+func (s *SMTPSender) Send(ctx context.Context, m Message) error {
+	body, err := s.compose(m)
+	if err != nil {
+		return err
+	}
+
+	// Only authenticate when credentials are configured. net/smtp refuses to
+	// send them over an unencrypted connection to anything but localhost.
+	var a smtp.Auth
+	if s.cfg.Username != "" {
+		a = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+	}
+
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	if err := smtp.SendMail(addr, a, s.cfg.From, []string{m.To}, body); err != nil {
+		return errors.Wrapf(err, "sending mail to %s", m.To)
+	}
+
+	return nil
+}
+
+// compose builds the raw RFC 5322 message.
+func (s *SMTPSender) compose(m Message) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	fmt.Fprintf(&buf, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", uuid.New().String(), s.cfg.Host)
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", mw.Boundary())
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", m.BodyText},
+		{"text/html; charset=utf-8", m.BodyHTML},
+	}
+	for _, p := range parts {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", p.contentType)
+		h.Set("Content-Transfer-Encoding", "8bit")
+
+		pw, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating mime part")
+		}
+		if _, err := pw.Write([]byte(p.body)); err != nil {
+			return nil, errors.Wrap(err, "writing mime part")
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, errors.Wrap(err, "closing mime message")
+	}
+
+	return buf.Bytes(), nil
+}
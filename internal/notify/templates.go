@@ -0,0 +1,200 @@
+package notify
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// These are the events a notification can be sent for.
+const (
+	EventCheckoutReceipt = "checkout_receipt"
+	EventBatchReceipt    = "batch_receipt"
+	EventDueSoon         = "due_soon"
+	EventOverdue         = "overdue"
+	EventHoldReady       = "hold_ready"
+	EventILLReceived     = "ill_received"
+	EventPasswordReset   = "password_reset"
+	EventVerifyEmail     = "verify_email"
+)
+
+// Events lists every event users can receive notifications for.
+var Events = []string{
+	EventCheckoutReceipt,
+	EventBatchReceipt,
+	EventDueSoon,
+	EventOverdue,
+	EventHoldReady,
+	EventILLReceived,
+	EventPasswordReset,
+	EventVerifyEmail,
+}
+
+// mandatory holds events which are always delivered whatever the user's
+// preferences are, because the user explicitly asked for them.
+var mandatory = map[string]bool{
+	EventPasswordReset: true,
+	EventVerifyEmail:   true,
+}
+
+// messageTemplate groups the templates used to render one event.
+type messageTemplate struct {
+	subject *template.Template
+	text    *template.Template
+	html    *htmltemplate.Template
+}
+
+// templates holds the parsed templates for every event. Like the schema
+// migrations, the templates are kept as constants so they are part of the
+// compiled executable.
+var templates = map[string]messageTemplate{
+	EventCheckoutReceipt: mustParse(EventCheckoutReceipt,
+		`Your loan of "{{.Title}}"`,
+		`Hello {{.Name}},
+
+you borrowed "{{.Title}}" (ISBN {{.ISBN}}) on {{.LoanDate.Format "02 Jan 2006"}}.
+Please bring it back before {{.ReturnDate.Format "02 Jan 2006"}}.
+
+Your library`,
+		`<p>Hello {{.Name}},</p>
+<p>you borrowed <strong>{{.Title}}</strong> (ISBN {{.ISBN}}) on {{.LoanDate.Format "02 Jan 2006"}}.<br>
+Please bring it back before <strong>{{.ReturnDate.Format "02 Jan 2006"}}</strong>.</p>
+<p>Your library</p>`,
+	),
+	EventBatchReceipt: mustParse(EventBatchReceipt,
+		`Your loan of {{len .Loans}} books`,
+		`Hello {{.Name}},
+
+you borrowed the following books on {{.LoanDate.Format "02 Jan 2006"}}:
+{{range .Loans}}
+  - "{{.Title}}" (ISBN {{.ISBN}}), due before {{.ReturnDate.Format "02 Jan 2006"}}{{end}}
+
+Your library`,
+		`<p>Hello {{.Name}},</p>
+<p>you borrowed the following books on {{.LoanDate.Format "02 Jan 2006"}}:</p>
+<ul>{{range .Loans}}
+<li><strong>{{.Title}}</strong> (ISBN {{.ISBN}}), due before <strong>{{.ReturnDate.Format "02 Jan 2006"}}</strong></li>{{end}}
+</ul>
+<p>Your library</p>`,
+	),
+	EventDueSoon: mustParse(EventDueSoon,
+		`"{{.Title}}" is due on {{.ReturnDate.Format "02 Jan 2006"}}`,
+		`Hello {{.Name}},
+
+this is a reminder that "{{.Title}}" is due on {{.ReturnDate.Format "02 Jan 2006"}}.
+
+Your library`,
+		`<p>Hello {{.Name}},</p>
+<p>this is a reminder that <strong>{{.Title}}</strong> is due on <strong>{{.ReturnDate.Format "02 Jan 2006"}}</strong>.</p>
+<p>Your library</p>`,
+	),
+	EventOverdue: mustParse(EventOverdue,
+		`"{{.Title}}" is overdue`,
+		`Hello {{.Name}},
+
+"{{.Title}}" was due on {{.ReturnDate.Format "02 Jan 2006"}}. Please bring it back as soon as possible.
+
+Your library`,
+		`<p>Hello {{.Name}},</p>
+<p><strong>{{.Title}}</strong> was due on {{.ReturnDate.Format "02 Jan 2006"}}. Please bring it back as soon as possible.</p>
+<p>Your library</p>`,
+	),
+	EventHoldReady: mustParse(EventHoldReady,
+		`"{{.Title}}" is ready for pickup`,
+		`Hello {{.Name}},
+
+the book you put on hold, "{{.Title}}", is waiting for you at the desk.
+
+Your library`,
+		`<p>Hello {{.Name}},</p>
+<p>the book you put on hold, <strong>{{.Title}}</strong>, is waiting for you at the desk.</p>
+<p>Your library</p>`,
+	),
+	EventILLReceived: mustParse(EventILLReceived,
+		`"{{.Title}}" arrived from a partner library`,
+		`Hello {{.Name}},
+
+the title you asked us to borrow, "{{.Title}}", is waiting for you at the desk.
+It has to be back before {{.ReturnDate.Format "02 Jan 2006"}}.
+
+Your library`,
+		`<p>Hello {{.Name}},</p>
+<p>the title you asked us to borrow, <strong>{{.Title}}</strong>, is waiting for you at the desk.<br>
+It has to be back before <strong>{{.ReturnDate.Format "02 Jan 2006"}}</strong>.</p>
+<p>Your library</p>`,
+	),
+	EventPasswordReset: mustParse(EventPasswordReset,
+		`Reset your password`,
+		`Hello {{.Name}},
+
+somebody asked to reset the password of your account. If it was you, follow
+this link before {{.Expires.Format "02 Jan 2006 15:04 MST"}}:
+
+{{.Link}}
+
+If it was not you, you can ignore this email.
+
+Your library`,
+		`<p>Hello {{.Name}},</p>
+<p>somebody asked to reset the password of your account. If it was you, follow
+<a href="{{.Link}}">this link</a> before {{.Expires.Format "02 Jan 2006 15:04 MST"}}.</p>
+<p>If it was not you, you can ignore this email.</p>
+<p>Your library</p>`,
+	),
+	EventVerifyEmail: mustParse(EventVerifyEmail,
+		`Confirm your email address`,
+		`Hello {{.Name}},
+
+welcome to the library! Please confirm your email address by following this
+link before {{.Expires.Format "02 Jan 2006 15:04 MST"}}:
+
+{{.Link}}
+
+You will be able to sign in and borrow books once it is confirmed.
+
+Your library`,
+		`<p>Hello {{.Name}},</p>
+<p>welcome to the library! Please confirm your email address by following
+<a href="{{.Link}}">this link</a> before {{.Expires.Format "02 Jan 2006 15:04 MST"}}.</p>
+<p>You will be able to sign in and borrow books once it is confirmed.</p>
+<p>Your library</p>`,
+	),
+}
+
+// mustParse parses the templates of an event and panics when they are invalid.
+// It is only called while initializing the package.
+func mustParse(event, subject, text, html string) messageTemplate {
+	return messageTemplate{
+		subject: template.Must(template.New(event + ".subject").Option("missingkey=error").Parse(subject)),
+		text:    template.Must(template.New(event + ".txt").Option("missingkey=error").Parse(text)),
+		html:    htmltemplate.Must(htmltemplate.New(event + ".html").Option("missingkey=error").Parse(html)),
+	}
+}
+
+// Render executes the templates registered for event with the provided data.
+func Render(event string, data map[string]interface{}) (Message, error) {
+	t, ok := templates[event]
+	if !ok {
+		return Message{}, ErrUnknownEvent
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return Message{}, errors.Wrapf(err, "rendering %s subject", event)
+	}
+	if err := t.text.Execute(&text, data); err != nil {
+		return Message{}, errors.Wrapf(err, "rendering %s text body", event)
+	}
+	if err := t.html.Execute(&html, data); err != nil {
+		return Message{}, errors.Wrapf(err, "rendering %s html body", event)
+	}
+
+	m := Message{
+		Subject:  subject.String(),
+		BodyText: text.String(),
+		BodyHTML: html.String(),
+	}
+	return m, nil
+}
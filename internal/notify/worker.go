@@ -0,0 +1,206 @@
+package notify
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Retry settings of the outbox. The delay between two attempts doubles after
+// every failure, starting at baseBackoff and never exceeding maxBackoff. A
+// claimed batch has sendLease to be sent before it is claimed again.
+const (
+	baseBackoff = time.Minute
+	maxBackoff  = 6 * time.Hour
+	batchSize   = 50
+	sendLease   = 10 * time.Minute
+)
+
+// Backoff returns how long to wait before retrying a notification which
+// already failed the provided number of times.
+func Backoff(attempts int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return d
+}
+
+// WorkerConfig is the required properties to run a Worker.
+type WorkerConfig struct {
+	Interval       time.Duration
+	MaxAttempts    int
+	ReminderWindow time.Duration
+}
+
+// Worker periodically queues loan reminders and delivers the pending
+// notifications of the outbox. Because messages stay in the database until
+// they are delivered, nothing is lost when the service restarts.
+type Worker struct {
+	db     *sqlx.DB
+	sender Sender
+	log    *log.Logger
+	cfg    WorkerConfig
+
+	wg       sync.WaitGroup
+	shutdown chan struct{}
+}
+
+// NewWorker creates a *Worker for use. Call Start to run it.
+func NewWorker(log *log.Logger, db *sqlx.DB, sender Sender, cfg WorkerConfig) *Worker {
+	return &Worker{
+		db:       db,
+		sender:   sender,
+		log:      log,
+		cfg:      cfg,
+		shutdown: make(chan struct{}),
+	}
+}
+
+// Start runs the worker in its own goroutine.
+func (w *Worker) Start() {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(w.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			w.run(time.Now())
+
+			select {
+			case <-ticker.C:
+			case <-w.shutdown:
+				return
+			}
+		}
+	}()
+}
+
+// Stop asks the worker to terminate and waits for the current run to finish.
+func (w *Worker) Stop() {
+	close(w.shutdown)
+	w.wg.Wait()
+}
+
+// run executes one iteration of the worker.
+func (w *Worker) run(now time.Time) {
+	ctx := context.Background()
+
+	if n, err := ScheduleReminders(ctx, w.db, now, w.cfg.ReminderWindow); err != nil {
+		w.log.Printf("notify : scheduling reminders : %v", err)
+	} else if n > 0 {
+		w.log.Printf("notify : %d reminders queued", n)
+	}
+
+	sent, failed, err := Dispatch(ctx, w.db, w.sender, now, w.cfg.MaxAttempts)
+	if err != nil {
+		w.log.Printf("notify : dispatching : %v", err)
+	}
+	if sent+failed > 0 {
+		w.log.Printf("notify : %d sent, %d failed", sent, failed)
+	}
+}
+
+// Dispatch sends the notifications which are due. They are first claimed in
+// a short transaction, marked as sending until a lease expires, so several
+// instances of the service can run a Worker at the same time without holding
+// locks while the mail goes out. Each result is then recorded on its own, a
+// failure to record one does not send the others again. Every claim counts as
+// an attempt, so a notification whose lease expired without a result, the
+// instance stopped while sending, is claimed again until it runs out of
+// attempts too. A failed notification is retried later following Backoff
+// until maxAttempts is reached, then it is marked as failed for good.
+func Dispatch(ctx context.Context, db *sqlx.DB, sender Sender, now time.Time, maxAttempts int) (int, int, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.notify.Dispatch")
+	defer span.End()
+
+	due, failed, err := claim(ctx, db, now, maxAttempts)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var sent int
+	for _, n := range due {
+		m := Message{
+			To:       n.Recipient,
+			Subject:  n.Subject,
+			BodyText: n.BodyText,
+			BodyHTML: n.BodyHTML,
+		}
+
+		if err := sender.Send(ctx, m); err != nil {
+			failed++
+
+			attempts := n.Attempts
+			status := StatusPending
+			if attempts >= maxAttempts {
+				status = StatusFailed
+			}
+
+			const qf = `UPDATE notifications SET
+				"status" = $2,
+				"attempts" = $3,
+				"next_attempt" = $4,
+				"last_error" = $5
+				WHERE notification_id = $1 AND "status" = $6`
+			if _, err := db.ExecContext(ctx, qf, n.ID, status, attempts, now.Add(Backoff(attempts)), err.Error(), StatusSending); err != nil {
+				return sent, failed, errors.Wrapf(err, "updating notification %s", n.ID)
+			}
+			continue
+		}
+
+		sent++
+		const qs = `UPDATE notifications SET
+			"status" = $2,
+			"date_sent" = $3
+			WHERE notification_id = $1 AND "status" = $4`
+		if _, err := db.ExecContext(ctx, qs, n.ID, StatusSent, now, StatusSending); err != nil {
+			return sent, failed, errors.Wrapf(err, "updating notification %s", n.ID)
+		}
+	}
+
+	return sent, failed, nil
+}
+
+// claim marks the notifications which are due as sending until the lease
+// expires, counting the attempt, and returns them. The lease is stored in
+// next_attempt. The notifications whose lease expired after their last
+// attempt are marked as failed instead, claim returns how many.
+func claim(ctx context.Context, db *sqlx.DB, now time.Time, maxAttempts int) ([]Notification, int, error) {
+	const qf = `UPDATE notifications SET "status" = $2, "last_error" = $4
+		WHERE "status" = $1 AND next_attempt <= $3 AND "attempts" >= $5`
+	res, err := db.ExecContext(ctx, qf, StatusSending, StatusFailed, now, "lease expired without a result", maxAttempts)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failing expired notifications")
+	}
+	failed, err := res.RowsAffected()
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failing expired notifications")
+	}
+
+	var due []Notification
+	const q = `UPDATE notifications SET "status" = $3, "next_attempt" = $4, "attempts" = "attempts" + 1
+		WHERE notification_id IN (
+			SELECT notification_id FROM notifications
+			WHERE "status" IN ($1, $3) AND next_attempt <= $2
+			ORDER BY next_attempt
+			LIMIT $5
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`
+	if err := db.SelectContext(ctx, &due, q, StatusPending, now, StatusSending, now.Add(sendLease), batchSize); err != nil {
+		return nil, 0, errors.Wrap(err, "claiming due notifications")
+	}
+
+	return due, int(failed), nil
+}
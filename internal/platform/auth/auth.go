@@ -1,9 +1,11 @@
 package auth
 
 import (
+	"context"
 	"crypto/rsa"
 
 	"fmt"
+	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	errors "github.com/pkg/errors"
@@ -51,6 +53,16 @@ func NewSimpleKeyLookupFunc(activeKID string, publicKey *rsa.PublicKey) KeyLooku
 	return f
 }
 
+// RevocationFunc reports whether the token the claims were parsed from was
+// revoked before it expired, for instance because the user signed out.
+type RevocationFunc func(ctx context.Context, claims Claims, now time.Time) (bool, error)
+
+// PermissionFunc returns the permissions granted by a set of roles.
+type PermissionFunc func(ctx context.Context, roles []string) ([]string, error)
+
+// AccessTokenFunc returns the claims of a personal access token.
+type AccessTokenFunc func(ctx context.Context, token string, now time.Time) (Claims, error)
+
 // Authenticator is used to authenticate clients. It can generate a token for a
 // set of users claims and recreate the claims by parsing the token.
 type Authenticator struct {
@@ -58,6 +70,10 @@ type Authenticator struct {
 	activeID         string
 	algorithm        string
 	pubKeyLookupFunc KeyLookupFunc
+	revoked          RevocationFunc
+	accessTokens     AccessTokenFunc
+	permissions      PermissionFunc
+	jwks             JWKS
 	parser           *jwt.Parser
 }
 
@@ -88,11 +104,30 @@ func NewAuthenticator(privateKey *rsa.PrivateKey, activeKID, algorithm string, p
 		activeID:         activeKID,
 		algorithm:        algorithm,
 		pubKeyLookupFunc: publicKeyLookupFunc,
+		jwks:             JWKS{Keys: []JWK{NewJWK(activeKID, algorithm, &privateKey.PublicKey)}},
 		parser:           &parser,
 	}
 	return &a, nil
 }
 
+// NewKeyRingAuthenticator creates an *Authenticator signing with the active
+// key of the ring. The tokens signed by any key of the ring are accepted,
+// along with the ones publicKeyLookupFunc recognizes when it is not nil.
+func NewKeyRingAuthenticator(kr *KeyRing, algorithm string, publicKeyLookupFunc KeyLookupFunc) (*Authenticator, error) {
+	f := kr.KeyLookupFunc()
+	if publicKeyLookupFunc != nil {
+		f = ChainKeyLookupFuncs(f, publicKeyLookupFunc)
+	}
+
+	a, err := NewAuthenticator(kr.PrivateKey(), kr.ActiveID(), algorithm, f)
+	if err != nil {
+		return nil, err
+	}
+	a.jwks = kr.JWKS(algorithm)
+
+	return a, nil
+}
+
 //GenerateToken generates a signed jwt token string representing users claims
 func (a *Authenticator) GenerateToken(claims Claims) (string, error) {
 	method := jwt.GetSigningMethod(a.algorithm)
@@ -139,3 +174,51 @@ func (a *Authenticator) ParseClaims(tokenStr string) (Claims, error) {
 
 	return claim, nil
 }
+
+// UseRevocation makes the authenticator check every token against f. Without
+// it tokens are valid until they expire.
+func (a *Authenticator) UseRevocation(f RevocationFunc) {
+	a.revoked = f
+}
+
+// Revoked reports whether the token the claims were parsed from was revoked.
+func (a *Authenticator) Revoked(ctx context.Context, claims Claims, now time.Time) (bool, error) {
+	if a.revoked == nil {
+		return false, nil
+	}
+	return a.revoked(ctx, claims, now)
+}
+
+// UseAccessTokens makes the authenticator accept the personal access tokens
+// f knows about. Without it only signed tokens are accepted.
+func (a *Authenticator) UseAccessTokens(f AccessTokenFunc) {
+	a.accessTokens = f
+}
+
+// ParseAccessToken recreates the claims of a personal access token.
+func (a *Authenticator) ParseAccessToken(ctx context.Context, token string, now time.Time) (Claims, error) {
+	if a.accessTokens == nil || !IsAccessToken(token) {
+		return Claims{}, ErrUnknownAccessToken
+	}
+	return a.accessTokens(ctx, token, now)
+}
+
+// UsePermissions makes the authenticator resolve the permissions of roles
+// with f. Without it only the built-in roles grant their DefaultPermissions.
+func (a *Authenticator) UsePermissions(f PermissionFunc) {
+	a.permissions = f
+}
+
+// Permissions returns the permissions granted by roles, nil when they are
+// not resolved by the authenticator.
+func (a *Authenticator) Permissions(ctx context.Context, roles []string) ([]string, error) {
+	if a.permissions == nil {
+		return nil, nil
+	}
+	return a.permissions(ctx, roles)
+}
+
+// JWKS returns the public keys of the tokens signed by the authenticator.
+func (a *Authenticator) JWKS() JWKS {
+	return a.jwks
+}
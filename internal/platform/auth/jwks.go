@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	errors "github.com/pkg/errors"
+)
+
+// minRefresh is how often a remote JWKS can be fetched again because of an
+// unknown key id, so tokens with made up ids can not flood the remote.
+const minRefresh = 10 * time.Second
+
+// JWK is a public key in the JSON Web Key format, see RFC 7517.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+// JWKS is a set of JSON Web Keys as served by /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK describes an RSA public key used to sign tokens.
+func NewJWK(kid, algorithm string, key *rsa.PublicKey) JWK {
+	return JWK{
+		KeyType:   "RSA",
+		KeyID:     kid,
+		Use:       "sig",
+		Algorithm: algorithm,
+		N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// PublicKey decodes the RSA public key of a JWK.
+func (k JWK) PublicKey() (*rsa.PublicKey, error) {
+	if k.KeyType != "RSA" {
+		return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding modulus")
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding exponent")
+	}
+
+	key := rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}
+	if key.N.Sign() == 0 || key.E == 0 {
+		return nil, errors.New("invalid rsa public key")
+	}
+
+	return &key, nil
+}
+
+// NewRemoteKeyLookupFunc returns a KeyLookupFunc resolving key ids with the
+// JWKS served at url, for instance by another instance of the service. The
+// set is cached for ttl and fetched again sooner when a token uses a key id
+// it does not know yet, which happens right after the remote rotated its
+// keys. A nil client uses http.DefaultClient.
+func NewRemoteKeyLookupFunc(url string, ttl time.Duration, client *http.Client) KeyLookupFunc {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var (
+		mu      sync.Mutex
+		keys    map[string]*rsa.PublicKey
+		fetched time.Time
+	)
+
+	f := func(kid string) (*rsa.PublicKey, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		key, ok := keys[kid]
+		age := time.Since(fetched)
+		if (ok && age < ttl) || (!ok && age < minRefresh) {
+			if !ok {
+				return nil, fmt.Errorf("unrecognized key id %q", kid)
+			}
+			return key, nil
+		}
+
+		fresh, err := fetchJWKS(client, url)
+		if err != nil {
+			// Keep verifying with the keys we know while the remote is down.
+			if ok {
+				return key, nil
+			}
+			return nil, err
+		}
+		keys, fetched = fresh, time.Now()
+
+		key, ok = keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unrecognized key id %q", kid)
+		}
+		return key, nil
+	}
+	return f
+}
+
+// fetchJWKS retrieves the RSA public keys of a JWKS by key id.
+func fetchJWKS(client *http.Client, url string) (map[string]*rsa.PublicKey, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching jwks")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching jwks: unexpected status %d", resp.StatusCode)
+	}
+
+	var set JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, errors.Wrap(err, "decoding jwks")
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.KeyID] = key
+	}
+
+	return keys, nil
+}
+
+// ChainKeyLookupFuncs returns a KeyLookupFunc trying each of fs in turn, for
+// instance the local key ring before the JWKS of another instance.
+func ChainKeyLookupFuncs(fs ...KeyLookupFunc) KeyLookupFunc {
+	f := func(kid string) (*rsa.PublicKey, error) {
+		err := fmt.Errorf("unrecognized key id %q", kid)
+		for _, lookup := range fs {
+			var key *rsa.PublicKey
+			if key, err = lookup(kid); err == nil {
+				return key, nil
+			}
+		}
+		return nil, err
+	}
+	return f
+}
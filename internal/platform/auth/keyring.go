@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	errors "github.com/pkg/errors"
+)
+
+// States of the keys of a key ring. The active key signs the new tokens, the
+// retiring keys only verify the tokens they signed until those expire.
+const (
+	KeyActive   = "active"
+	KeyRetiring = "retiring"
+)
+
+// manifestFile lists the keys of a key ring directory along with their state.
+// Each key is stored next to it in <kid>.pem.
+const manifestFile = "keyring.json"
+
+var (
+	// ErrNoActiveKey is used when a key ring has no active key.
+	ErrNoActiveKey = errors.New("key ring has no active key")
+)
+
+// KeyInfo describes a key of a key ring.
+type KeyInfo struct {
+	ID          string     `json:"kid"`
+	State       string     `json:"state"`
+	DateCreated time.Time  `json:"date_created"`
+	DateRetired *time.Time `json:"date_retired,omitempty"`
+}
+
+// KeyRing holds the signing keys loaded from a directory. Rotating keys
+// promotes a new active key while the previous ones keep verifying the tokens
+// issued before, so nobody is signed out.
+type KeyRing struct {
+	activeID string
+	infos    []KeyInfo
+	keys     map[string]*rsa.PrivateKey
+}
+
+// LoadKeyRing reads the keys of a directory maintained by RotateKeys.
+func LoadKeyRing(dir string) (*KeyRing, error) {
+	infos, err := readManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	kr := KeyRing{
+		infos: infos,
+		keys:  make(map[string]*rsa.PrivateKey, len(infos)),
+	}
+	for _, info := range infos {
+		b, err := ioutil.ReadFile(filepath.Join(dir, info.ID+".pem"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading key %q", info.ID)
+		}
+
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(b)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing key %q", info.ID)
+		}
+
+		kr.keys[info.ID] = key
+		if info.State == KeyActive {
+			kr.activeID = info.ID
+		}
+	}
+
+	if kr.activeID == "" {
+		return nil, ErrNoActiveKey
+	}
+
+	return &kr, nil
+}
+
+// ActiveID returns the key id (kid) of the key signing new tokens.
+func (kr *KeyRing) ActiveID() string {
+	return kr.activeID
+}
+
+// PrivateKey returns the key signing new tokens.
+func (kr *KeyRing) PrivateKey() *rsa.PrivateKey {
+	return kr.keys[kr.activeID]
+}
+
+// Keys describes the keys of the ring.
+func (kr *KeyRing) Keys() []KeyInfo {
+	return kr.infos
+}
+
+// KeyLookupFunc returns a KeyLookupFunc accepting the tokens signed by any
+// key of the ring, active or retiring.
+func (kr *KeyRing) KeyLookupFunc() KeyLookupFunc {
+	f := func(kid string) (*rsa.PublicKey, error) {
+		key, ok := kr.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unrecognized key id %q", kid)
+		}
+		return &key.PublicKey, nil
+	}
+	return f
+}
+
+// JWKS returns the public keys of the ring for other services to verify the
+// tokens signed with them.
+func (kr *KeyRing) JWKS(algorithm string) JWKS {
+	set := JWKS{Keys: make([]JWK, 0, len(kr.infos))}
+	for _, info := range kr.infos {
+		set.Keys = append(set.Keys, NewJWK(info.ID, algorithm, &kr.keys[info.ID].PublicKey))
+	}
+	return set
+}
+
+// RotateKeys generates a new key in the directory and makes it the active
+// one, the previous active key is retiring. Keys retiring for longer than
+// retain are deleted: the tokens they signed expired by then. It returns the
+// key id of the new key. The directory is created when needed.
+func RotateKeys(dir string, now time.Time, retain time.Duration) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", errors.Wrap(err, "creating key directory")
+	}
+
+	infos, err := readManifest(dir)
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return "", err
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", errors.Wrap(err, "generating key")
+	}
+
+	now = now.UTC()
+	kid := now.Format("20060102T150405Z")
+	for _, info := range infos {
+		if info.ID == kid {
+			return "", fmt.Errorf("key %q already exists", kid)
+		}
+	}
+
+	block := pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, kid+".pem"), pem.EncodeToMemory(&block), 0600); err != nil {
+		return "", errors.Wrap(err, "writing key")
+	}
+
+	kept := []KeyInfo{{ID: kid, State: KeyActive, DateCreated: now}}
+	var removed []string
+	for _, info := range infos {
+		switch {
+		case info.State == KeyActive:
+			info.State = KeyRetiring
+			info.DateRetired = &now
+		case info.DateRetired != nil && now.Sub(*info.DateRetired) > retain:
+			removed = append(removed, info.ID)
+			continue
+		}
+		kept = append(kept, info)
+	}
+
+	if err := writeManifest(dir, kept); err != nil {
+		return "", err
+	}
+
+	// The manifest no longer references them, a failure only leaves a
+	// stale file behind.
+	for _, id := range removed {
+		os.Remove(filepath.Join(dir, id+".pem"))
+	}
+
+	return kid, nil
+}
+
+// readManifest reads the keys listed in the manifest of a directory.
+func readManifest(dir string) ([]KeyInfo, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, manifestFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "reading key ring manifest")
+	}
+
+	var infos []KeyInfo
+	if err := json.Unmarshal(b, &infos); err != nil {
+		return nil, errors.Wrap(err, "decoding key ring manifest")
+	}
+
+	return infos, nil
+}
+
+// writeManifest replaces the manifest of a directory. It is written aside
+// first so a running instance never reads half of it.
+func writeManifest(dir string, infos []KeyInfo) error {
+	b, err := json.MarshalIndent(infos, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding key ring manifest")
+	}
+
+	tmp := filepath.Join(dir, manifestFile+".tmp")
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrap(err, "writing key ring manifest")
+	}
+
+	if err := os.Rename(tmp, filepath.Join(dir, manifestFile)); err != nil {
+		return errors.Wrap(err, "replacing key ring manifest")
+	}
+
+	return nil
+}
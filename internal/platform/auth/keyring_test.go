@@ -0,0 +1,208 @@
+package auth_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/tests"
+)
+
+// TestRotateKeys validates keys are promoted, retired and pruned in turn.
+func TestRotateKeys(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	retain := 2 * time.Hour
+
+	t.Log("Given the need to rotate the signing keys.")
+	{
+		t.Log("\tWhen the key ring is rotated for the first time.")
+		{
+			first, err := auth.RotateKeys(dir, now, retain)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create a key : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to create a key.", tests.Success)
+
+			kr, err := auth.LoadKeyRing(dir)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to load the key ring : %s.", tests.Failed, err)
+			}
+			if kr.ActiveID() != first || len(kr.Keys()) != 1 {
+				t.Fatalf("\t%s\tShould sign with the new key : %s %v.", tests.Failed, kr.ActiveID(), kr.Keys())
+			}
+			t.Logf("\t%s\tShould sign with the new key.", tests.Success)
+
+			if _, err := auth.RotateKeys(dir, now, retain); err == nil {
+				t.Fatalf("\t%s\tShould not create a key with the id of another.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould not create a key with the id of another.", tests.Success)
+		}
+
+		t.Log("\tWhen the key ring is rotated again.")
+		{
+			second, err := auth.RotateKeys(dir, now.Add(time.Hour), retain)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to rotate the keys : %s.", tests.Failed, err)
+			}
+
+			kr, err := auth.LoadKeyRing(dir)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to load the key ring : %s.", tests.Failed, err)
+			}
+			if kr.ActiveID() != second {
+				t.Fatalf("\t%s\tShould sign with the new key : %s.", tests.Failed, kr.ActiveID())
+			}
+			t.Logf("\t%s\tShould sign with the new key.", tests.Success)
+
+			old := kr.Keys()[1]
+			if old.State != auth.KeyRetiring || old.DateRetired == nil || !old.DateRetired.Equal(now.Add(time.Hour)) {
+				t.Fatalf("\t%s\tShould retire the previous key : %+v.", tests.Failed, old)
+			}
+			t.Logf("\t%s\tShould retire the previous key.", tests.Success)
+
+			var want auth.JWK
+			for _, k := range kr.JWKS("RS256").Keys {
+				if k.KeyID == old.ID {
+					want = k
+				}
+			}
+
+			lookup := kr.KeyLookupFunc()
+			if key, err := lookup(old.ID); err != nil || auth.NewJWK(old.ID, "RS256", key) != want {
+				t.Fatalf("\t%s\tShould verify the tokens of the retiring key : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould verify the tokens of the retiring key.", tests.Success)
+
+			if _, err := lookup("unknown"); err == nil {
+				t.Fatalf("\t%s\tShould not verify the tokens of an unknown key.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould not verify the tokens of an unknown key.", tests.Success)
+		}
+
+		t.Log("\tWhen a key retired for longer than the retention.")
+		{
+			if _, err := auth.RotateKeys(dir, now.Add(2*time.Hour), retain); err != nil {
+				t.Fatalf("\t%s\tShould be able to rotate the keys : %s.", tests.Failed, err)
+			}
+			if _, err := auth.RotateKeys(dir, now.Add(4*time.Hour), retain); err != nil {
+				t.Fatalf("\t%s\tShould be able to rotate the keys : %s.", tests.Failed, err)
+			}
+
+			kr, err := auth.LoadKeyRing(dir)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to load the key ring : %s.", tests.Failed, err)
+			}
+
+			// The first key retired 3 hours ago, the second one 2 hours ago is
+			// still within the retention.
+			first := now.Format("20060102T150405Z")
+			for _, info := range kr.Keys() {
+				if info.ID == first {
+					t.Fatalf("\t%s\tShould prune the first key : %v.", tests.Failed, kr.Keys())
+				}
+			}
+			if len(kr.Keys()) != 3 {
+				t.Fatalf("\t%s\tShould keep the keys within the retention : %v.", tests.Failed, kr.Keys())
+			}
+			if _, err := os.Stat(filepath.Join(dir, first+".pem")); !os.IsNotExist(err) {
+				t.Fatalf("\t%s\tShould delete the file of the first key : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould prune the keys retired for longer than the retention.", tests.Success)
+		}
+	}
+}
+
+// TestLoadKeyRing validates a key ring can not be used without an active key.
+func TestLoadKeyRing(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Log("Given the need to load a key ring.")
+	{
+		t.Log("\tWhen no key of the ring is active.")
+		{
+			kid, err := auth.RotateKeys(dir, now, time.Hour)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create a key : %s.", tests.Failed, err)
+			}
+
+			infos := []auth.KeyInfo{{ID: kid, State: auth.KeyRetiring, DateCreated: now, DateRetired: &now}}
+			b, err := json.Marshal(infos)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(dir, "keyring.json"), b, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := auth.LoadKeyRing(dir); err != auth.ErrNoActiveKey {
+				t.Fatalf("\t%s\tShould refuse the key ring : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould refuse the key ring.", tests.Success)
+		}
+	}
+}
+
+// TestJWKS validates the public keys are served in the JWK format.
+func TestJWKS(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Log("Given the need to publish the public keys.")
+	{
+		t.Log("\tWhen the key ring is encoded as a JWKS.")
+		{
+			if _, err := auth.RotateKeys(dir, now, time.Hour); err != nil {
+				t.Fatalf("\t%s\tShould be able to create a key : %s.", tests.Failed, err)
+			}
+			kr, err := auth.LoadKeyRing(dir)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to load the key ring : %s.", tests.Failed, err)
+			}
+
+			b, err := json.Marshal(kr.JWKS("RS256"))
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to encode the JWKS : %s.", tests.Failed, err)
+			}
+
+			var set struct {
+				Keys []map[string]string `json:"keys"`
+			}
+			if err := json.Unmarshal(b, &set); err != nil || len(set.Keys) != 1 {
+				t.Fatalf("\t%s\tShould list every key : %s %v.", tests.Failed, b, err)
+			}
+			jwk := set.Keys[0]
+
+			for _, private := range []string{"d", "p", "q", "dp", "dq", "qi"} {
+				if _, ok := jwk[private]; ok {
+					t.Fatalf("\t%s\tShould not hand out the private key : %s.", tests.Failed, b)
+				}
+			}
+			t.Logf("\t%s\tShould not hand out the private key.", tests.Success)
+
+			pub := kr.PrivateKey().PublicKey
+			for field, want := range map[string][]byte{"n": pub.N.Bytes(), "e": {1, 0, 1}} {
+				if strings.ContainsAny(jwk[field], "+/=") {
+					t.Fatalf("\t%s\tShould encode %s in base64url : %s.", tests.Failed, field, jwk[field])
+				}
+				got, err := base64.RawURLEncoding.DecodeString(jwk[field])
+				if err != nil || string(got) != string(want) {
+					t.Fatalf("\t%s\tShould encode %s in base64url : %s %v.", tests.Failed, field, jwk[field], err)
+				}
+			}
+			t.Logf("\t%s\tShould encode n and e in base64url.", tests.Success)
+
+			if jwk["kty"] != "RSA" || jwk["kid"] != kr.ActiveID() || jwk["use"] != "sig" || jwk["alg"] != "RS256" {
+				t.Fatalf("\t%s\tShould describe the key : %s.", tests.Failed, b)
+			}
+			t.Logf("\t%s\tShould describe the key.", tests.Success)
+		}
+	}
+}
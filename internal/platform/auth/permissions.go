@@ -0,0 +1,97 @@
+package auth
+
+import "regexp"
+
+// These are the permissions roles grant. Routes and domain functions check
+// permissions rather than roles, so admins can define new roles.
+const (
+	PermissionBooksManage     = "books.manage"
+	PermissionCirculation     = "circulation.manage"
+	PermissionPolicyManage    = "policy.manage"
+	PermissionILLManage       = "ill.manage"
+	PermissionKiosksManage    = "kiosks.manage"
+	PermissionReviewsModerate = "reviews.moderate"
+	PermissionReportsRead     = "reports.read"
+	PermissionWebhooksManage  = "webhooks.manage"
+	PermissionEventsRead      = "events.read"
+	PermissionUsersRead       = "users.read"
+	PermissionUsersManage     = "users.manage"
+	PermissionRolesManage     = "roles.manage"
+
+	// Patrons use their own account with these.
+	PermissionAccount      = "account.manage"
+	PermissionLoansBorrow  = "loans.borrow"
+	PermissionListsManage  = "lists.manage"
+	PermissionReviewsWrite = "reviews.write"
+)
+
+// Permissions lists every permission a role can grant.
+var Permissions = []string{
+	PermissionBooksManage,
+	PermissionCirculation,
+	PermissionPolicyManage,
+	PermissionILLManage,
+	PermissionKiosksManage,
+	PermissionReviewsModerate,
+	PermissionReportsRead,
+	PermissionWebhooksManage,
+	PermissionEventsRead,
+	PermissionUsersRead,
+	PermissionUsersManage,
+	PermissionRolesManage,
+	PermissionAccount,
+	PermissionLoansBorrow,
+	PermissionListsManage,
+	PermissionReviewsWrite,
+}
+
+// DefaultPermissions are the permissions of the built-in roles. They are
+// used when the permissions of claims were not resolved from the database.
+var DefaultPermissions = map[string][]string{
+	RoleAdmin:     Permissions,
+	RoleLibrarian: {PermissionCirculation, PermissionReportsRead},
+	RoleUser:      {PermissionAccount, PermissionLoansBorrow, PermissionListsManage, PermissionReviewsWrite},
+}
+
+// roleName is the form of role names, like the built-in ones.
+var roleName = regexp.MustCompile(`^[A-Z][A-Z0-9_]{0,31}$`)
+
+// ValidRoleName reports whether name can name a role.
+func ValidRoleName(name string) bool {
+	return roleName.MatchString(name)
+}
+
+// ValidPermission reports whether p is one of Permissions.
+func ValidPermission(p string) bool {
+	for _, v := range Permissions {
+		if v == p {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission returns true if the roles of the claims grant at least one of
+// the provided permissions. Admins hold every permission, whatever is stored
+// for their role, so they can not lock themselves out.
+func (c Claims) HasPermission(perms ...string) bool {
+	if c.HasRole(RoleAdmin) {
+		return true
+	}
+
+	granted := c.Permissions
+	if granted == nil {
+		for _, r := range c.Roles {
+			granted = append(granted, DefaultPermissions[r]...)
+		}
+	}
+
+	for _, has := range granted {
+		for _, want := range perms {
+			if has == want {
+				return true
+			}
+		}
+	}
+	return false
+}
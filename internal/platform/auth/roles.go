@@ -3,14 +3,17 @@ package auth
 import (
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"time"
 )
 
-// These are the expected values for Claims.Roles.
+// These are the built-in values for Claims.Roles, admins define more roles
+// in the database.
 const (
-	RoleAdmin = "ADMIN"
-	RoleUser  = "USER"
+	RoleAdmin     = "ADMIN"
+	RoleUser      = "USER"
+	RoleLibrarian = "LIBRARIAN"
 )
 
 type Role struct {
@@ -28,17 +31,28 @@ const Key ctxKey = 1
 type Claims struct {
 	Roles []string `json:"roles"`
 	jwt.StandardClaims
-	Csrf string `json:"csrf"`
+	Csrf      string `json:"csrf"`
+	SessionID string `json:"sid,omitempty"` // The session the token was issued for, if any.
+
+	// Scopes restrict what a personal access token can do, the claims of a
+	// session have none and are only limited by their roles.
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Permissions are granted by the roles, they are resolved when the
+	// request is authenticated and never part of a token.
+	Permissions []string `json:"-"`
 }
 
 // NewClaims constructs a Claims value for the identified users. The Claims
-// expire within a specified duration of the provided time. Additional fields
+// expire within a specified duration of the provided time. Every Claims gets
+// its own ID (jti) so the token can be revoked on its own. Additional fields
 // of the Claims can be set after calling NewClaims is desired.
 func NewClaims(subject string, roles []string, now time.Time, expires time.Duration, csrf string) Claims {
 	c := Claims{
 		Roles: roles,
 		Csrf: csrf,
 		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
 			Subject:   subject,
 			IssuedAt:  now.Unix(),
 			ExpiresAt: now.Add(expires).Unix(),
@@ -51,9 +65,7 @@ func NewClaims(subject string, roles []string, now time.Time, expires time.Durat
 // Valid is called during the parsing of a token.
 func (c Claims) Valid() error {
 	for _, r := range c.Roles {
-		switch r {
-		case RoleAdmin, RoleUser: // Role is valid.
-		default:
+		if !ValidRoleName(r) {
 			return fmt.Errorf("invalid role %q", r)
 		}
 	}
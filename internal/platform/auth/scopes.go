@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// These are the expected values for Claims.Scopes. A scope grants reading or
+// writing a part of the API, on top of the roles of the user.
+const (
+	ScopeBooksRead     = "books:read"
+	ScopeBooksWrite    = "books:write"
+	ScopeLoansRead     = "loans:read"
+	ScopeLoansWrite    = "loans:write"
+	ScopeListsRead     = "lists:read"
+	ScopeListsWrite    = "lists:write"
+	ScopeReviewsRead   = "reviews:read"
+	ScopeReviewsWrite  = "reviews:write"
+	ScopeUsersRead     = "users:read"
+	ScopeUsersWrite    = "users:write"
+	ScopeReportsRead   = "reports:read"
+	ScopeWebhooksRead  = "webhooks:read"
+	ScopeWebhooksWrite = "webhooks:write"
+	ScopeEventsRead    = "events:read"
+)
+
+// Scopes lists every scope a personal access token can be granted.
+var Scopes = []string{
+	ScopeBooksRead, ScopeBooksWrite,
+	ScopeLoansRead, ScopeLoansWrite,
+	ScopeListsRead, ScopeListsWrite,
+	ScopeReviewsRead, ScopeReviewsWrite,
+	ScopeUsersRead, ScopeUsersWrite,
+	ScopeReportsRead,
+	ScopeWebhooksRead, ScopeWebhooksWrite,
+	ScopeEventsRead,
+}
+
+// AccessTokenPrefix starts every personal access token, so they are told
+// apart from signed tokens and found by secret scanners.
+const AccessTokenPrefix = "blpat_"
+
+// ErrUnknownAccessToken is used when a personal access token does not exist,
+// expired or was revoked.
+var ErrUnknownAccessToken = errors.New("access token is invalid or expired")
+
+// IsAccessToken reports whether a bearer token is a personal access token.
+func IsAccessToken(token string) bool {
+	return strings.HasPrefix(token, AccessTokenPrefix)
+}
+
+// ValidScope reports whether s is one of Scopes.
+func ValidScope(s string) bool {
+	for _, v := range Scopes {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope returns true if the claims has at least one of the provided
+// scopes. The claims of a session are not scoped, they have every scope, and
+// a personal access token never has an empty list of scopes.
+func (c Claims) HasScope(scopes ...string) bool {
+	if c.Scopes == nil {
+		return true
+	}
+	for _, has := range c.Scopes {
+		for _, want := range scopes {
+			if has == want {
+				return true
+			}
+		}
+	}
+	return false
+}
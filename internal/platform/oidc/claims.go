@@ -0,0 +1,100 @@
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Claims are the claims of an ID token the service relies on. Raw holds every
+// claim, for the role claim which depends on the provider.
+type Claims struct {
+	Issuer          string   `json:"iss"`
+	Subject         string   `json:"sub"`
+	Audience        audience `json:"aud"`
+	AuthorizedParty string   `json:"azp"`
+	ExpiresAt       int64    `json:"exp"`
+	IssuedAt        int64    `json:"iat"`
+	Nonce           string   `json:"nonce"`
+	Email           string   `json:"email"`
+	EmailVerified   boolean  `json:"email_verified"`
+	Name            string   `json:"name"`
+
+	Raw map[string]interface{} `json:"-"`
+}
+
+// UnmarshalJSON decodes the claims and keeps all of them in Raw.
+func (c *Claims) UnmarshalJSON(b []byte) error {
+	type claims Claims
+	if err := json.Unmarshal(b, (*claims)(c)); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, &c.Raw)
+}
+
+// Valid lets the claims be parsed by jwt-go. They are validated by Verify
+// against the time of the request instead.
+func (c *Claims) Valid() error {
+	return nil
+}
+
+// Strings returns the values of a claim holding a string or a list of them.
+func (c Claims) Strings(name string) []string {
+	switch v := c.Raw[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// audience is the aud claim, a single client or a list of them.
+type audience []string
+
+// UnmarshalJSON decodes a string or a list of strings.
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return fmt.Errorf("aud must be a string or a list of strings")
+	}
+	*a = l
+	return nil
+}
+
+// contains reports whether the token was issued for the client.
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// boolean is a claim some providers send as a string, like email_verified.
+type boolean bool
+
+// UnmarshalJSON decodes true, false, "true" and "false".
+func (v *boolean) UnmarshalJSON(b []byte) error {
+	switch string(b) {
+	case `true`, `"true"`:
+		*v = true
+	case `false`, `"false"`, `null`:
+		*v = false
+	default:
+		return fmt.Errorf("%s is not a boolean", b)
+	}
+	return nil
+}
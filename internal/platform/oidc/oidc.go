@@ -0,0 +1,285 @@
+// Package oidc signs users in with an OpenID Connect provider. It implements
+// the relying party side of the authorization code flow with PKCE: discovery
+// of the provider, the exchange of the code and the validation of the ID token
+// against the keys the provider publishes.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	jwt "github.com/dgrijalva/jwt-go"
+	errors "github.com/pkg/errors"
+)
+
+// keysCache is how long the keys of the provider are cached.
+const keysCache = 10 * time.Minute
+
+// leeway tolerates clocks of the provider and of the service drifting apart.
+const leeway = time.Minute
+
+var (
+	// ErrInvalidIDToken is used when an ID token is not signed by the
+	// provider, was issued for another client or another login, or expired.
+	ErrInvalidIDToken = errors.New("id token is invalid")
+
+	// ErrExchange is used when the provider refuses the authorization code,
+	// for instance because it was already used.
+	ErrExchange = errors.New("authorization code was refused by the provider")
+)
+
+// Config identifies the provider and this service as one of its clients.
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string // Requested along with openid.
+
+	// RoleClaim names the claim of the ID token listing the groups of the
+	// user, RoleMap maps its values to the roles of the service.
+	RoleClaim string
+	RoleMap   map[string]string
+}
+
+// Metadata is the part of the provider configuration used by the flow, as
+// served by /.well-known/openid-configuration.
+type Metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+// Provider is an OpenID Connect provider. Its configuration is discovered on
+// first use so the service starts even when the provider is unreachable.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu   sync.Mutex
+	meta *Metadata
+	keys auth.KeyLookupFunc
+}
+
+// NewProvider constructs a Provider. A nil client uses http.DefaultClient.
+func NewProvider(cfg Config, client *http.Client) *Provider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &Provider{
+		cfg:    cfg,
+		client: client,
+	}
+}
+
+// discover fetches the configuration of the provider once it succeeded.
+func (p *Provider) discover(ctx context.Context) (*Metadata, auth.KeyLookupFunc, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.meta != nil {
+		return p.meta, p.keys, nil
+	}
+
+	u := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "creating discovery request")
+	}
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "fetching provider configuration")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("fetching provider configuration: unexpected status %d", resp.StatusCode)
+	}
+
+	var meta Metadata
+	if err := json.NewDecoder(resp.Body).Decode(&meta); err != nil {
+		return nil, nil, errors.Wrap(err, "decoding provider configuration")
+	}
+
+	// The configuration must be the one of the issuer we trust, see
+	// section 4.3 of OpenID Connect Discovery.
+	if meta.Issuer != p.cfg.Issuer {
+		return nil, nil, fmt.Errorf("provider configuration is for issuer %q", meta.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, nil, errors.New("provider configuration is incomplete")
+	}
+
+	p.meta = &meta
+	p.keys = auth.NewRemoteKeyLookupFunc(meta.JWKSURI, keysCache, p.client)
+
+	return p.meta, p.keys, nil
+}
+
+// AuthCodeURL returns the address of the provider the user is sent to for
+// signing in. state and nonce tie the answer of the provider to this login,
+// challenge is the PKCE challenge of the verifier kept for the exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, challenge string) (string, error) {
+	meta, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.cfg.ClientID)
+	v.Set("redirect_uri", p.cfg.RedirectURL)
+	v.Set("scope", strings.Join(append([]string{"openid"}, p.cfg.Scopes...), " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", challenge)
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return meta.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// Exchange trades the authorization code sent back by the provider for the
+// ID token of the user, and validates it.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string, now time.Time) (Claims, error) {
+	meta, _, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", p.cfg.RedirectURL)
+	v.Set("client_id", p.cfg.ClientID)
+	v.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest(http.MethodPost, meta.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return Claims{}, errors.Wrap(err, "creating token request")
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return Claims{}, errors.Wrap(err, "requesting token")
+	}
+	defer resp.Body.Close()
+
+	// The provider answers 400 when the code is unknown, expired or was
+	// already used.
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized {
+		return Claims{}, ErrExchange
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Claims{}, fmt.Errorf("requesting token: unexpected status %d", resp.StatusCode)
+	}
+
+	var tk struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tk); err != nil {
+		return Claims{}, errors.Wrap(err, "decoding token response")
+	}
+	if tk.IDToken == "" {
+		return Claims{}, ErrInvalidIDToken
+	}
+
+	return p.Verify(ctx, tk.IDToken, nonce, now)
+}
+
+// Verify validates an ID token: it must be signed by a key of the provider,
+// issued by it for this client and this login, and not expired.
+func (p *Provider) Verify(ctx context.Context, idToken, nonce string, now time.Time) (Claims, error) {
+	_, keys, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	parser := jwt.Parser{
+		ValidMethods: []string{"RS256"},
+	}
+	keyFunc := func(tk *jwt.Token) (interface{}, error) {
+		kid, ok := tk.Header["kid"].(string)
+		if !ok {
+			return nil, auth.ErrKIDMiss
+		}
+		return keys(kid)
+	}
+
+	var c Claims
+	if _, err := parser.ParseWithClaims(idToken, &c, keyFunc); err != nil {
+		return Claims{}, ErrInvalidIDToken
+	}
+
+	switch {
+	case c.Issuer != p.cfg.Issuer,
+		!c.Audience.contains(p.cfg.ClientID),
+		len(c.Audience) > 1 && c.AuthorizedParty != p.cfg.ClientID,
+		c.Subject == "",
+		c.Nonce == "" || c.Nonce != nonce,
+		!now.Before(time.Unix(c.ExpiresAt, 0).Add(leeway)),
+		now.Add(leeway).Before(time.Unix(c.IssuedAt, 0)):
+		return Claims{}, ErrInvalidIDToken
+	}
+
+	return c, nil
+}
+
+// Roles maps the groups listed in the role claim of an ID token to the roles
+// of the service. Unknown groups are ignored.
+func (p *Provider) Roles(c Claims) []string {
+	var roles []string
+	seen := make(map[string]bool)
+	for _, group := range c.Strings(p.cfg.RoleClaim) {
+		role, ok := p.cfg.RoleMap[group]
+		if !ok || seen[role] {
+			continue
+		}
+		seen[role] = true
+		roles = append(roles, role)
+	}
+	return roles
+}
+
+// NewPKCE returns a random PKCE verifier and its S256 challenge, see RFC 7636.
+func NewPKCE() (verifier, challenge string, err error) {
+	verifier, err = RandomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// RandomString returns a URL-safe random string fit for a state or a nonce.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating random string")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
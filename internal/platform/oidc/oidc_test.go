@@ -0,0 +1,183 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/platform/oidc"
+	"github.com/book-library/internal/platform/oidc/oidctest"
+	"github.com/book-library/internal/tests"
+)
+
+// noRedirect keeps the redirections of the provider to read where they go.
+var noRedirect = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// TestProvider validates the authorization code flow against a local provider.
+func TestProvider(t *testing.T) {
+	idp := oidctest.New("library")
+	defer idp.Close()
+
+	p := oidc.NewProvider(oidc.Config{
+		Issuer:      idp.Issuer(),
+		ClientID:    "library",
+		RedirectURL: "http://localhost:3000/v1/users/sso/callback",
+		Scopes:      []string{"email"},
+		RoleClaim:   "groups",
+		RoleMap:     map[string]string{"library-staff": "LIBRARIAN"},
+	}, nil)
+
+	ctx := context.Background()
+
+	// authorize sends the user to the provider and returns the code and the
+	// state it sends back.
+	authorize := func(t *testing.T, state, nonce, challenge string) (string, string) {
+		to, err := p.AuthCodeURL(ctx, state, nonce, challenge)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to build the address of the provider : %s.", tests.Failed, err)
+		}
+
+		resp, err := noRedirect.Get(to)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to reach the provider : %s.", tests.Failed, err)
+		}
+		resp.Body.Close()
+
+		back, err := url.Parse(resp.Header.Get("Location"))
+		if err != nil || resp.StatusCode != http.StatusFound {
+			t.Fatalf("\t%s\tShould be sent back by the provider : %d %v.", tests.Failed, resp.StatusCode, err)
+		}
+		return back.Query().Get("code"), back.Query().Get("state")
+	}
+
+	t.Log("Given the need to sign users in with an identity provider.")
+	{
+		idp.SignIn(map[string]interface{}{
+			"sub":            "jane",
+			"email":          "jane@example.com",
+			"email_verified": "true",
+			"name":           "Jane Doe",
+			"groups":         []string{"students", "library-staff"},
+		})
+
+		t.Log("\tWhen the user signs in.")
+		{
+			verifier, challenge, err := oidc.NewPKCE()
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to generate a PKCE verifier : %s.", tests.Failed, err)
+			}
+
+			code, state := authorize(t, "state", "nonce", challenge)
+			if code == "" || state != "state" {
+				t.Fatalf("\t%s\tShould receive a code and the state : %q %q.", tests.Failed, code, state)
+			}
+			t.Logf("\t%s\tShould receive a code and the state.", tests.Success)
+
+			c, err := p.Exchange(ctx, code, verifier, "nonce", time.Now())
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to exchange the code : %s.", tests.Failed, err)
+			}
+			if c.Subject != "jane" || c.Email != "jane@example.com" || !c.EmailVerified || c.Name != "Jane Doe" {
+				t.Fatalf("\t%s\tShould receive the claims of the user : %+v.", tests.Failed, c)
+			}
+			t.Logf("\t%s\tShould receive the claims of the user.", tests.Success)
+
+			if roles := p.Roles(c); len(roles) != 1 || roles[0] != "LIBRARIAN" {
+				t.Fatalf("\t%s\tShould map the groups to roles : %v.", tests.Failed, roles)
+			}
+			t.Logf("\t%s\tShould map the groups to roles.", tests.Success)
+
+			if _, err := p.Exchange(ctx, code, verifier, "nonce", time.Now()); err != oidc.ErrExchange {
+				t.Fatalf("\t%s\tShould not exchange a code twice : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould not exchange a code twice.", tests.Success)
+		}
+
+		t.Log("\tWhen the code is exchanged without its verifier.")
+		{
+			_, challenge, _ := oidc.NewPKCE()
+			other, _, _ := oidc.NewPKCE()
+
+			code, _ := authorize(t, "state", "nonce", challenge)
+			if _, err := p.Exchange(ctx, code, other, "nonce", time.Now()); err != oidc.ErrExchange {
+				t.Fatalf("\t%s\tShould be refused by the provider : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be refused by the provider.", tests.Success)
+		}
+
+		t.Log("\tWhen the ID token was issued for another login.")
+		{
+			verifier, challenge, _ := oidc.NewPKCE()
+
+			code, _ := authorize(t, "state", "nonce", challenge)
+			if _, err := p.Exchange(ctx, code, verifier, "other", time.Now()); err != oidc.ErrInvalidIDToken {
+				t.Fatalf("\t%s\tShould reject a token with another nonce : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould reject a token with another nonce.", tests.Success)
+		}
+
+		t.Log("\tWhen the ID token is verified.")
+		{
+			now := time.Now()
+			valid := func() map[string]interface{} {
+				return map[string]interface{}{
+					"iss":   idp.Issuer(),
+					"aud":   []string{"library"},
+					"sub":   "jane",
+					"nonce": "nonce",
+					"iat":   now.Unix(),
+					"exp":   now.Add(time.Hour).Unix(),
+				}
+			}
+
+			if _, err := p.Verify(ctx, idp.Sign(valid()), "nonce", now); err != nil {
+				t.Fatalf("\t%s\tShould accept a valid token : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould accept a valid token.", tests.Success)
+
+			for _, tt := range []struct {
+				name  string
+				claim string
+				value interface{}
+			}{
+				{"another issuer", "iss", "https://evil.example.com"},
+				{"another client", "aud", "someone-else"},
+				{"an expired token", "exp", now.Add(-time.Hour).Unix()},
+				{"a token issued in the future", "iat", now.Add(time.Hour).Unix()},
+				{"no subject", "sub", ""},
+			} {
+				claims := valid()
+				claims[tt.claim] = tt.value
+				if _, err := p.Verify(ctx, idp.Sign(claims), "nonce", now); err != oidc.ErrInvalidIDToken {
+					t.Fatalf("\t%s\tShould reject %s : %v.", tests.Failed, tt.name, err)
+				}
+				t.Logf("\t%s\tShould reject %s.", tests.Success, tt.name)
+			}
+
+			other := oidctest.New("library")
+			defer other.Close()
+			if _, err := p.Verify(ctx, other.Sign(valid()), "nonce", now); err != oidc.ErrInvalidIDToken {
+				t.Fatalf("\t%s\tShould reject a token signed by another key : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould reject a token signed by another key.", tests.Success)
+		}
+	}
+
+	t.Log("Given the need to only trust the configured issuer.")
+	{
+		idp := oidctest.New("library")
+		defer idp.Close()
+
+		p := oidc.NewProvider(oidc.Config{Issuer: idp.Issuer() + "/", ClientID: "library"}, nil)
+		if _, err := p.AuthCodeURL(ctx, "state", "nonce", "challenge"); err == nil {
+			t.Fatalf("\t%s\tShould refuse the configuration of another issuer.", tests.Failed)
+		}
+		t.Logf("\t%s\tShould refuse the configuration of another issuer.", tests.Success)
+	}
+}
@@ -0,0 +1,112 @@
+// Package totp implements time-based one-time passwords as described by
+// RFC 6238, with the parameters authenticator apps expect: HMAC-SHA1, codes of
+// six digits and a period of thirty seconds.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	errors "github.com/pkg/errors"
+)
+
+// Digits is the length of the codes and Period how long each code is valid.
+const (
+	Digits = 6
+	Period = 30 * time.Second
+)
+
+// secretSize is the size of the secrets, the size of an HMAC-SHA1 as advised
+// by RFC 4226.
+const secretSize = 20
+
+// encoding is how secrets are shown to users and stored.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates the secret shared with the authenticator app of a user.
+func NewSecret() ([]byte, error) {
+	b := make([]byte, secretSize)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errors.Wrap(err, "generating secret")
+	}
+	return b, nil
+}
+
+// Encode returns the base32 form of a secret, the one users can type.
+func Encode(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// Decode reads a secret in its base32 form.
+func Decode(s string) ([]byte, error) {
+	b, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(s, "=")))
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding secret")
+	}
+	return b, nil
+}
+
+// Step returns the time step of t, the counter the code is computed from.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns the code of the secret at time t.
+func Code(secret []byte, t time.Time) string {
+	return code(secret, Step(t))
+}
+
+// Verify checks a code against the secret at time t, accepting the codes of
+// skew steps before and after to tolerate clocks drifting apart. It returns
+// the step of the code so the caller can refuse to see it twice.
+func Verify(secret []byte, c string, t time.Time, skew int) (int64, bool) {
+	c = strings.Replace(c, " ", "", -1)
+	if len(c) != Digits {
+		return 0, false
+	}
+
+	step := Step(t)
+	for i := -int64(skew); i <= int64(skew); i++ {
+		if subtle.ConstantTimeCompare([]byte(code(secret, step+i)), []byte(c)) == 1 {
+			return step + i, true
+		}
+	}
+	return 0, false
+}
+
+// URI returns the otpauth:// URI of the secret. Rendered as a QR code, it
+// enrolls the account in an authenticator app.
+func URI(issuer, account string, secret []byte) string {
+	v := url.Values{}
+	v.Set("secret", Encode(secret))
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period/time.Second)))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// code computes the HOTP value of a counter, see section 5.3 of RFC 4226.
+func code(secret []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, value%1000000)
+}
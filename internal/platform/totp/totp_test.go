@@ -0,0 +1,68 @@
+package totp_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/platform/totp"
+	"github.com/book-library/internal/tests"
+)
+
+// TestTOTP validates codes against the SHA1 test vectors of RFC 6238, cut to
+// six digits.
+func TestTOTP(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	t.Log("Given the need to compute the codes of authenticator apps.")
+	{
+		for i, tt := range []struct {
+			unix int64
+			code string
+		}{
+			{59, "287082"},
+			{1111111109, "081804"},
+			{1111111111, "050471"},
+			{1234567890, "005924"},
+			{2000000000, "279037"},
+			{20000000000, "353130"},
+		} {
+			t.Logf("\tTest %d:\tWhen the time is %d.", i, tt.unix)
+			{
+				now := time.Unix(tt.unix, 0)
+				if got := totp.Code(secret, now); got != tt.code {
+					t.Fatalf("\t%s\tShould compute the code %s : got %s.", tests.Failed, tt.code, got)
+				}
+				t.Logf("\t%s\tShould compute the code %s.", tests.Success, tt.code)
+
+				if step, ok := totp.Verify(secret, tt.code, now.Add(totp.Period), 1); !ok || step != totp.Step(now) {
+					t.Fatalf("\t%s\tShould accept the code of the previous step : %d %v.", tests.Failed, step, ok)
+				}
+				if _, ok := totp.Verify(secret, tt.code, now.Add(2*totp.Period), 1); ok {
+					t.Fatalf("\t%s\tShould refuse the code of older steps.", tests.Failed)
+				}
+				t.Logf("\t%s\tShould accept the code for one more step only.", tests.Success)
+			}
+		}
+	}
+
+	t.Log("Given the need to enroll authenticator apps.")
+	{
+		secret, err := totp.NewSecret()
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to generate a secret : %s.", tests.Failed, err)
+		}
+
+		decoded, err := totp.Decode(strings.ToLower(totp.Encode(secret)))
+		if err != nil || string(decoded) != string(secret) {
+			t.Fatalf("\t%s\tShould read back the secret : %v.", tests.Failed, err)
+		}
+		t.Logf("\t%s\tShould read back the secret.", tests.Success)
+
+		uri := totp.URI("Book Library", "jane@example.com", secret)
+		if !strings.HasPrefix(uri, "otpauth://totp/Book%20Library:jane@example.com?") || !strings.Contains(uri, "secret="+totp.Encode(secret)) {
+			t.Fatalf("\t%s\tShould build the provisioning URI : %s.", tests.Failed, uri)
+		}
+		t.Logf("\t%s\tShould build the provisioning URI.", tests.Success)
+	}
+}
@@ -60,6 +60,45 @@ func Respond(ctx context.Context, w http.ResponseWriter, data interface{}, statu
 	return nil
 }
 
+//RespondRaw sends an already encoded document of the provided content type to the client
+func RespondRaw(ctx context.Context, w http.ResponseWriter, data []byte, contentType string, statusCode int) error {
+
+	// Set the status code for the request logger middleware.
+	v, ok := ctx.Value(KeyValues).(*Values)
+	if !ok {
+		return NewShutdownError("web value missing form context")
+	}
+
+	v.StatusCode = statusCode
+
+	w.Header().Set("Content-Type", contentType)
+	enableCors(&w)
+
+	w.WriteHeader(statusCode)
+
+	if _, err := w.Write(data); err != nil {
+		return nil
+	}
+
+	return nil
+}
+
+//Redirect sends the client to another address, like the page of an identity provider
+func Redirect(ctx context.Context, w http.ResponseWriter, r *http.Request, url string, statusCode int) error {
+
+	// Set the status code for the request logger middleware.
+	v, ok := ctx.Value(KeyValues).(*Values)
+	if !ok {
+		return NewShutdownError("web value missing form context")
+	}
+
+	v.StatusCode = statusCode
+
+	http.Redirect(w, r, url, statusCode)
+
+	return nil
+}
+
 //ResponseError sends errorful response back to the client
 func ResponseError(ctx context.Context, w http.ResponseWriter, err error) error {
 
@@ -0,0 +1,73 @@
+package recommend
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// JobConfig is the required properties to run a Job.
+type JobConfig struct {
+	Interval time.Duration
+}
+
+// Job periodically refreshes the similarity of the books so the
+// recommendations follow the circulation.
+type Job struct {
+	db  *sqlx.DB
+	log *log.Logger
+	cfg JobConfig
+
+	wg       sync.WaitGroup
+	shutdown chan struct{}
+}
+
+// NewJob creates a *Job for use. Call Start to run it.
+func NewJob(log *log.Logger, db *sqlx.DB, cfg JobConfig) *Job {
+	return &Job{
+		db:       db,
+		log:      log,
+		cfg:      cfg,
+		shutdown: make(chan struct{}),
+	}
+}
+
+// Start runs the job in its own goroutine.
+func (j *Job) Start() {
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+
+		ticker := time.NewTicker(j.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			j.run(time.Now())
+
+			select {
+			case <-ticker.C:
+			case <-j.shutdown:
+				return
+			}
+		}
+	}()
+}
+
+// Stop asks the job to terminate and waits for the current run to finish.
+func (j *Job) Stop() {
+	close(j.shutdown)
+	j.wg.Wait()
+}
+
+// run executes one iteration of the job.
+func (j *Job) run(now time.Time) {
+	n, err := Refresh(context.Background(), j.db, now)
+	if err != nil {
+		j.log.Printf("recommend : refreshing : %v", err)
+		return
+	}
+	j.log.Printf("recommend : %d similar pairs", n)
+}
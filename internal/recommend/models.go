@@ -0,0 +1,12 @@
+package recommend
+
+// Recommendation is a book suggested to a reader along with how strongly it
+// is suggested.
+type Recommendation struct {
+	BookID    string  `db:"book_id" json:"book_id"`
+	Title     string  `db:"title" json:"title"`
+	Authors   string  `db:"authors" json:"authors"`
+	Category  string  `db:"category" json:"category"`
+	Available bool    `db:"available" json:"available"`
+	Score     float64 `db:"score" json:"score"`
+}
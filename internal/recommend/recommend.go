@@ -0,0 +1,157 @@
+package recommend
+
+import (
+	"context"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Weights of the signals making the similarity of two books. Every patron who
+// borrowed both books counts as much as CoBorrowWeight, books of the same
+// category get CategoryWeight on top so the catalog can be discovered before
+// there is enough circulation.
+const (
+	CoBorrowWeight = 1.0
+	CategoryWeight = 0.5
+)
+
+// MaxSimilar is how many similar books are kept for every book.
+const MaxSimilar = 20
+
+// DefaultLimit is how many books are returned when no limit is asked for.
+const DefaultLimit = 10
+
+// Predefined errors identify expected failure conditions.
+var (
+	// ErrInvalidID is used when an invalid UUID is provided.
+	ErrInvalidID = errors.New("ID is not in its proper form")
+
+	// ErrForbidden occurs when a users tries to do something that is forbidden to
+	// them according to our access control policies.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+)
+
+// Refresh computes the similarity of the books from the circulation history
+// and the categories, replacing the previous results. It returns the number
+// of pairs of similar books.
+func Refresh(ctx context.Context, db *sqlx.DB, now time.Time) (int, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.recommend.Refresh")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM book_similarity`); err != nil {
+		return 0, errors.Wrap(err, "clearing similarity")
+	}
+
+	// Pairs come from the patrons who borrowed both books and from the books
+	// sharing a category. Only the best MaxSimilar are kept for each book.
+	const q = `INSERT INTO book_similarity (book_id, similar_id, borrowers, same_category, score, date_computed)
+		SELECT book_id, similar_id, borrowers, same_category, score, $4 FROM (
+			SELECT book_id, similar_id, borrowers, same_category, score,
+				ROW_NUMBER() OVER (PARTITION BY book_id ORDER BY score DESC, similar_id) AS rank
+			FROM (
+				SELECT book_id, similar_id,
+					SUM(borrowers) AS borrowers,
+					BOOL_OR(same_category) AS same_category,
+					SUM(borrowers) * $1::float8 + CASE WHEN BOOL_OR(same_category) THEN $2::float8 ELSE 0 END AS score
+				FROM (
+					SELECT a.book_id, b.book_id AS similar_id, COUNT(DISTINCT a.user_id) AS borrowers, FALSE AS same_category
+					FROM loans AS a JOIN loans AS b ON b.user_id = a.user_id AND b.book_id <> a.book_id
+					GROUP BY a.book_id, b.book_id
+					UNION ALL
+					SELECT a.book_id, b.book_id, 0, TRUE
+					FROM books AS a JOIN books AS b ON b.category = a.category AND b.book_id <> a.book_id
+					WHERE a.category <> ''
+				) AS pairs
+				GROUP BY book_id, similar_id
+			) AS scored
+		) AS ranked
+		WHERE rank <= $3`
+	res, err := tx.ExecContext(ctx, q, CoBorrowWeight, CategoryWeight, MaxSimilar, now.UTC())
+	if err != nil {
+		return 0, errors.Wrap(err, "computing similarity")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "counting similarity")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, errors.Wrap(err, "committing similarity")
+	}
+
+	return int(n), nil
+}
+
+// Similar retrieves the books most similar to a book.
+func Similar(ctx context.Context, db *sqlx.DB, bookID string, limit int) ([]Recommendation, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.recommend.Similar")
+	defer span.End()
+
+	if _, err := uuid.Parse(bookID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	recs := []Recommendation{}
+	const q = `SELECT b.book_id, b.title, b.authors, b.category, b.quantity > 0 AS available, s.score
+		FROM book_similarity AS s JOIN books AS b ON b.book_id = s.similar_id
+		WHERE s.book_id = $1
+		ORDER BY s.score DESC, b.title
+		LIMIT $2`
+	if err := db.SelectContext(ctx, &recs, q, bookID, limit); err != nil {
+		return nil, errors.Wrapf(err, "selecting books similar to %q", bookID)
+	}
+
+	return recs, nil
+}
+
+// ForUser retrieves the books suggested to a patron from what they borrowed
+// so far. Books the patron already borrowed are never suggested. Patrons can
+// only see their own recommendations.
+func ForUser(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string, limit int) ([]Recommendation, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.recommend.ForUser")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionCirculation) && claims.Subject != userID {
+		return nil, ErrForbidden
+	}
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	recs := []Recommendation{}
+	const q = `WITH borrowed AS (SELECT DISTINCT book_id FROM loans WHERE user_id = $1)
+		SELECT b.book_id, b.title, b.authors, b.category, b.quantity > 0 AS available, SUM(s.score) AS score
+		FROM book_similarity AS s
+		JOIN borrowed AS h ON h.book_id = s.book_id
+		JOIN books AS b ON b.book_id = s.similar_id
+		WHERE s.similar_id NOT IN (SELECT book_id FROM borrowed)
+		GROUP BY b.book_id, b.title, b.authors, b.category, b.quantity
+		ORDER BY score DESC, b.title
+		LIMIT $2`
+	if err := db.SelectContext(ctx, &recs, q, userID, limit); err != nil {
+		return nil, errors.Wrapf(err, "selecting user %q recommendations", userID)
+	}
+
+	return recs, nil
+}
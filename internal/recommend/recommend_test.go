@@ -0,0 +1,101 @@
+package recommend_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/books"
+	loans "github.com/book-library/internal/loan"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/recommend"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+)
+
+// TestRecommend validates books are suggested from what patrons borrow.
+func TestRecommend(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to suggest books to read next.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		admin := auth.NewClaims(
+			auth.RoleAdmin,
+			[]string{auth.RoleAdmin, auth.RoleUser},
+			now, time.Hour,
+			"718ffbea-f4a1-4667-8ae3-b349da52675e", // This is just some random UUID.
+		)
+
+		var ids []string
+		for i, nb := range []books.NewBook{
+			{Title: "Go programming", ISBN: "bcn1", Category: "computer-science", Quantity: 5},
+			{Title: "Concurrency in Go", ISBN: "bcn2", Category: "computer-science", Quantity: 5},
+			{Title: "Dune", ISBN: "bcn3", Category: "fiction", Quantity: 5},
+			{Title: "Foundation", ISBN: "bcn4", Category: "fiction", Quantity: 5},
+		} {
+			b, err := books.Create(ctx, now, nb, admin, db)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create book %d : %s.", tests.Failed, i, err)
+			}
+			ids = append(ids, b.ID)
+		}
+		goBook, concurrency, dune := ids[0], ids[1], ids[2]
+
+		var patrons []auth.Claims
+		for _, email := range []string{"jane@example.com", "john@example.com"} {
+			u, err := users.Create(ctx, db, users.NewUser{Name: "Patron", Email: email, Roles: []string{auth.RoleUser}, Password: "gophers"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create patron : %s.", tests.Failed, err)
+			}
+			patrons = append(patrons, auth.NewClaims(u.ID, []string{auth.RoleUser}, now, time.Hour, ""))
+		}
+		jane, john := patrons[0], patrons[1]
+
+		// Jane reads Go and science fiction, John only started with Go.
+		if _, err := loans.CheckoutBatch(ctx, jane, db, jane.Subject, loans.NewBatch{BookIDs: []string{goBook, dune}}, now); err != nil {
+			t.Fatalf("\t%s\tShould be able to borrow books : %s.", tests.Failed, err)
+		}
+		if _, err := loans.CheckoutBatch(ctx, john, db, john.Subject, loans.NewBatch{BookIDs: []string{goBook}}, now); err != nil {
+			t.Fatalf("\t%s\tShould be able to borrow books : %s.", tests.Failed, err)
+		}
+
+		if _, err := recommend.Refresh(ctx, db, now); err != nil {
+			t.Fatalf("\t%s\tShould be able to refresh the similarity : %s.", tests.Failed, err)
+		}
+		t.Logf("\t%s\tShould be able to refresh the similarity.", tests.Success)
+
+		t.Log("\tWhen looking for books similar to a book.")
+		{
+			similar, err := recommend.Similar(ctx, db, goBook, 0)
+			if err != nil || len(similar) != 2 {
+				t.Fatalf("\t%s\tShould find the similar books : %+v, %v.", tests.Failed, similar, err)
+			}
+			if similar[0].BookID != dune || similar[1].BookID != concurrency {
+				t.Fatalf("\t%s\tShould rank co-borrowed books first : %+v.", tests.Failed, similar)
+			}
+			t.Logf("\t%s\tShould rank co-borrowed books before the category.", tests.Success)
+		}
+
+		t.Log("\tWhen suggesting books to a patron.")
+		{
+			recs, err := recommend.ForUser(ctx, john, db, john.Subject, 0)
+			if err != nil || len(recs) == 0 || recs[0].BookID != dune {
+				t.Fatalf("\t%s\tShould suggest what similar readers borrowed : %+v, %v.", tests.Failed, recs, err)
+			}
+			for _, r := range recs {
+				if r.BookID == goBook {
+					t.Fatalf("\t%s\tShould not suggest a borrowed book : %+v.", tests.Failed, recs)
+				}
+			}
+			t.Logf("\t%s\tShould suggest what similar readers borrowed.", tests.Success)
+
+			if _, err := recommend.ForUser(ctx, john, db, jane.Subject, 0); err != recommend.ErrForbidden {
+				t.Fatalf("\t%s\tShould not see the suggestions of others : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould not see the suggestions of others.", tests.Success)
+		}
+	}
+}
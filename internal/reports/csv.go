@@ -0,0 +1,135 @@
+package reports
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Table is a report which can be exported as CSV.
+type Table interface {
+	Header() []string
+	Records() [][]string
+}
+
+// CSV renders a report as a CSV document, the first line holds the names of
+// the columns.
+func CSV(t Table) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	if err := w.Write(t.Header()); err != nil {
+		return nil, errors.Wrap(err, "writing header")
+	}
+	if err := w.WriteAll(t.Records()); err != nil {
+		return nil, errors.Wrap(err, "writing records")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Periods is the circulation report.
+type Periods []Period
+
+// Header implements the Table interface.
+func (ps Periods) Header() []string {
+	return []string{"period", "loans", "returns", "patrons"}
+}
+
+// Records implements the Table interface.
+func (ps Periods) Records() [][]string {
+	records := make([][]string, 0, len(ps))
+	for _, p := range ps {
+		records = append(records, []string{p.Start.Format(dateLayout), itoa(p.Loans), itoa(p.Returns), itoa(p.Patrons)})
+	}
+	return records
+}
+
+// Titles is the most borrowed titles report.
+type Titles []Title
+
+// Header implements the Table interface.
+func (ts Titles) Header() []string {
+	return []string{"book_id", "title", "isbn", "loans"}
+}
+
+// Records implements the Table interface.
+func (ts Titles) Records() [][]string {
+	records := make([][]string, 0, len(ts))
+	for _, t := range ts {
+		records = append(records, []string{t.BookID, t.Title, t.ISBN, itoa(t.Loans)})
+	}
+	return records
+}
+
+// Categories is the most borrowed categories report.
+type Categories []Category
+
+// Header implements the Table interface.
+func (cs Categories) Header() []string {
+	return []string{"category", "loans", "books"}
+}
+
+// Records implements the Table interface.
+func (cs Categories) Records() [][]string {
+	records := make([][]string, 0, len(cs))
+	for _, c := range cs {
+		records = append(records, []string{c.Name, itoa(c.Loans), itoa(c.Books)})
+	}
+	return records
+}
+
+// Patrons is the active patrons report.
+type Patrons []Patron
+
+// Header implements the Table interface.
+func (ps Patrons) Header() []string {
+	return []string{"user_id", "name", "email", "loans", "last_loan"}
+}
+
+// Records implements the Table interface.
+func (ps Patrons) Records() [][]string {
+	records := make([][]string, 0, len(ps))
+	for _, p := range ps {
+		records = append(records, []string{p.UserID, p.Name, p.Email, itoa(p.Loans), p.LastLoan.Format(time.RFC3339)})
+	}
+	return records
+}
+
+// Header implements the Table interface.
+func (d *Duration) Header() []string {
+	return []string{"returned", "average_days", "minimum_days", "maximum_days"}
+}
+
+// Records implements the Table interface.
+func (d *Duration) Records() [][]string {
+	return [][]string{{itoa(d.Returned), ftoa(d.AverageDays), ftoa(d.MinimumDays), ftoa(d.MaximumDays)}}
+}
+
+// Turnovers is the turnover rate report.
+type Turnovers []Turnover
+
+// Header implements the Table interface.
+func (ts Turnovers) Header() []string {
+	return []string{"book_id", "title", "copies", "loans", "rate"}
+}
+
+// Records implements the Table interface.
+func (ts Turnovers) Records() [][]string {
+	records := make([][]string, 0, len(ts))
+	for _, t := range ts {
+		records = append(records, []string{t.BookID, t.Title, itoa(t.Copies), itoa(t.Loans), ftoa(t.Rate)})
+	}
+	return records
+}
+
+func itoa(n int) string {
+	return strconv.Itoa(n)
+}
+
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'f', 2, 64)
+}
@@ -0,0 +1,63 @@
+package reports
+
+import (
+	"time"
+)
+
+// Range limits a report to the loans started between From and To. Both ends
+// are optional, From is inclusive and To is exclusive.
+type Range struct {
+	From *time.Time `json:"from"`
+	To   *time.Time `json:"to"`
+}
+
+// Period is the circulation of the library during a day, a week or a month.
+type Period struct {
+	Start   time.Time `db:"period" json:"period"`
+	Loans   int       `db:"loans" json:"loans"`
+	Returns int       `db:"returns" json:"returns"`
+	Patrons int       `db:"patrons" json:"patrons"`
+}
+
+// Title is a book along with how many times it was borrowed.
+type Title struct {
+	BookID string `db:"book_id" json:"book_id"`
+	Title  string `db:"title" json:"title"`
+	ISBN   string `db:"isbn" json:"isbn"`
+	Loans  int    `db:"loans" json:"loans"`
+}
+
+// Category is a category of books along with how many times its books were
+// borrowed.
+type Category struct {
+	Name  string `db:"category" json:"category"`
+	Loans int    `db:"loans" json:"loans"`
+	Books int    `db:"books" json:"books"`
+}
+
+// Patron is a patron who borrowed books during the range.
+type Patron struct {
+	UserID   string    `db:"user_id" json:"user_id"`
+	Name     string    `db:"name" json:"name"`
+	Email    string    `db:"email" json:"email"`
+	Loans    int       `db:"loans" json:"loans"`
+	LastLoan time.Time `db:"last_loan" json:"last_loan"`
+}
+
+// Duration is how long the books are kept, computed from the loans which
+// were given back.
+type Duration struct {
+	Returned    int     `db:"returned" json:"returned"`
+	AverageDays float64 `db:"average_days" json:"average_days"`
+	MinimumDays float64 `db:"minimum_days" json:"minimum_days"`
+	MaximumDays float64 `db:"maximum_days" json:"maximum_days"`
+}
+
+// Turnover is how many times every copy of a book was borrowed.
+type Turnover struct {
+	BookID string  `db:"book_id" json:"book_id"`
+	Title  string  `db:"title" json:"title"`
+	Copies int     `db:"copies" json:"copies"`
+	Loans  int     `db:"loans" json:"loans"`
+	Rate   float64 `db:"rate" json:"rate"`
+}
@@ -0,0 +1,271 @@
+package reports
+
+import (
+	"context"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// These are the intervals the circulation can be grouped by.
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+)
+
+// DefaultLimit is how many rows the ranked reports return when no limit is
+// asked for.
+const DefaultLimit = 10
+
+// dateLayout is how the periods are written in the CSV exports.
+const dateLayout = "2006-01-02"
+
+// Predefined errors identify expected failure conditions.
+var (
+	// ErrForbidden occurs when a users tries to do something that is forbidden to
+	// them according to our access control policies.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrInvalidInterval is used when the circulation is grouped by something
+	// else than a day, a week or a month.
+	ErrInvalidInterval = errors.New("Interval must be day, week or month")
+
+	// ErrInvalidRange is used when the range ends before it starts.
+	ErrInvalidRange = errors.New("Range must end after it starts")
+)
+
+// isStaff reports whether the claims belong to someone allowed to read the
+// statistics of the library.
+func isStaff(user auth.Claims) bool {
+	return user.HasPermission(auth.PermissionReportsRead)
+}
+
+// Circulation counts the loans, the returns and the patrons borrowing books
+// for every day, week or month of the range. Periods without any activity
+// are left out.
+func Circulation(ctx context.Context, user auth.Claims, db *sqlx.DB, interval string, rng Range) (Periods, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.reports.Circulation")
+	defer span.End()
+
+	switch interval {
+	case IntervalDay, IntervalWeek, IntervalMonth:
+	default:
+		return nil, ErrInvalidInterval
+	}
+
+	from, to, err := check(user, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	// Loans are counted when they start and returns when the book is given
+	// back, so each of them is filtered by its own date.
+	periods := Periods{}
+	const q = `SELECT period, SUM(loan) AS loans, SUM(returned) AS returns, COUNT(DISTINCT user_id) AS patrons
+		FROM (
+			SELECT date_trunc($1, loan_date) AS period, 1 AS loan, 0 AS returned, user_id
+			FROM loans
+			WHERE ($2::timestamp IS NULL OR loan_date >= $2) AND ($3::timestamp IS NULL OR loan_date < $3)
+			UNION ALL
+			SELECT date_trunc($1, date_returned), 0, 1, NULL
+			FROM loans
+			WHERE date_returned IS NOT NULL
+				AND ($2::timestamp IS NULL OR date_returned >= $2) AND ($3::timestamp IS NULL OR date_returned < $3)
+		) AS activity
+		GROUP BY period
+		ORDER BY period`
+	if err := db.SelectContext(ctx, &periods, q, interval, from, to); err != nil {
+		return nil, errors.Wrap(err, "selecting circulation")
+	}
+
+	return periods, nil
+}
+
+// TopTitles retrieves the books borrowed the most during the range.
+func TopTitles(ctx context.Context, user auth.Claims, db *sqlx.DB, rng Range, limit int) (Titles, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.reports.TopTitles")
+	defer span.End()
+
+	from, to, err := check(user, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	titles := Titles{}
+	const q = `SELECT b.book_id, b.title, b.isbn, COUNT(*) AS loans
+		FROM loans AS l JOIN books AS b ON b.book_id = l.book_id
+		WHERE ($1::timestamp IS NULL OR l.loan_date >= $1) AND ($2::timestamp IS NULL OR l.loan_date < $2)
+		GROUP BY b.book_id, b.title, b.isbn
+		ORDER BY loans DESC, b.title
+		LIMIT $3`
+	if err := db.SelectContext(ctx, &titles, q, from, to, limit); err != nil {
+		return nil, errors.Wrap(err, "selecting top titles")
+	}
+
+	return titles, nil
+}
+
+// TopCategories retrieves the categories whose books were borrowed the most
+// during the range.
+func TopCategories(ctx context.Context, user auth.Claims, db *sqlx.DB, rng Range, limit int) (Categories, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.reports.TopCategories")
+	defer span.End()
+
+	from, to, err := check(user, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	categories := Categories{}
+	const q = `SELECT b.category, COUNT(*) AS loans, COUNT(DISTINCT b.book_id) AS books
+		FROM loans AS l JOIN books AS b ON b.book_id = l.book_id
+		WHERE ($1::timestamp IS NULL OR l.loan_date >= $1) AND ($2::timestamp IS NULL OR l.loan_date < $2)
+		GROUP BY b.category
+		ORDER BY loans DESC, b.category
+		LIMIT $3`
+	if err := db.SelectContext(ctx, &categories, q, from, to, limit); err != nil {
+		return nil, errors.Wrap(err, "selecting top categories")
+	}
+
+	return categories, nil
+}
+
+// ActivePatrons retrieves the patrons who borrowed books during the range,
+// the ones who borrowed the most first.
+func ActivePatrons(ctx context.Context, user auth.Claims, db *sqlx.DB, rng Range, limit int) (Patrons, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.reports.ActivePatrons")
+	defer span.End()
+
+	from, to, err := check(user, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	patrons := Patrons{}
+	const q = `SELECT u.user_id, u.name, u.email, COUNT(*) AS loans, MAX(l.loan_date) AS last_loan
+		FROM loans AS l JOIN users AS u ON u.user_id = l.user_id
+		WHERE ($1::timestamp IS NULL OR l.loan_date >= $1) AND ($2::timestamp IS NULL OR l.loan_date < $2)
+		GROUP BY u.user_id, u.name, u.email
+		ORDER BY loans DESC, u.name
+		LIMIT $3`
+	if err := db.SelectContext(ctx, &patrons, q, from, to, limit); err != nil {
+		return nil, errors.Wrap(err, "selecting active patrons")
+	}
+
+	return patrons, nil
+}
+
+// LoanDuration computes how many days the books given back during the range
+// were kept.
+func LoanDuration(ctx context.Context, user auth.Claims, db *sqlx.DB, rng Range) (*Duration, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.reports.LoanDuration")
+	defer span.End()
+
+	from, to, err := check(user, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	var d Duration
+	const q = `SELECT COUNT(*) AS returned,
+			COALESCE(AVG(days), 0) AS average_days,
+			COALESCE(MIN(days), 0) AS minimum_days,
+			COALESCE(MAX(days), 0) AS maximum_days
+		FROM (
+			SELECT EXTRACT(EPOCH FROM date_returned - loan_date) / 86400 AS days
+			FROM loans
+			WHERE date_returned IS NOT NULL
+				AND ($1::timestamp IS NULL OR date_returned >= $1) AND ($2::timestamp IS NULL OR date_returned < $2)
+		) AS returned`
+	if err := db.GetContext(ctx, &d, q, from, to); err != nil {
+		return nil, errors.Wrap(err, "selecting loan duration")
+	}
+
+	return &d, nil
+}
+
+// TurnoverRate computes how many times every copy of the books was borrowed
+// during the range, the busiest books first. Books which were not borrowed
+// at all are reported too so the least used books can be found at the end
+// of an unlimited report.
+func TurnoverRate(ctx context.Context, user auth.Claims, db *sqlx.DB, rng Range, limit int) (Turnovers, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.reports.TurnoverRate")
+	defer span.End()
+
+	from, to, err := check(user, rng)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	// The quantity of a book only counts the copies on the shelf, the copies
+	// out on loan are added back to get every copy the library owns.
+	turnovers := Turnovers{}
+	const q = `SELECT book_id, title, copies, loans,
+			CASE WHEN copies > 0 THEN loans::float8 / copies ELSE 0 END AS rate
+		FROM (
+			SELECT b.book_id, b.title,
+				b.quantity + COALESCE(o.out, 0) AS copies,
+				COALESCE(l.loans, 0) AS loans
+			FROM books AS b
+			LEFT JOIN (
+				SELECT book_id, COUNT(*) AS loans
+				FROM loans
+				WHERE ($1::timestamp IS NULL OR loan_date >= $1) AND ($2::timestamp IS NULL OR loan_date < $2)
+				GROUP BY book_id
+			) AS l ON l.book_id = b.book_id
+			LEFT JOIN (
+				SELECT book_id, COUNT(*) AS out
+				FROM loans
+				WHERE date_returned IS NULL
+				GROUP BY book_id
+			) AS o ON o.book_id = b.book_id
+		) AS owned
+		ORDER BY rate DESC, title
+		LIMIT $3`
+	if err := db.SelectContext(ctx, &turnovers, q, from, to, limit); err != nil {
+		return nil, errors.Wrap(err, "selecting turnover rate")
+	}
+
+	return turnovers, nil
+}
+
+// check makes sure the user may read the reports and returns the ends of the
+// range as query arguments, a missing end is passed as NULL.
+func check(user auth.Claims, rng Range) (interface{}, interface{}, error) {
+	if !isStaff(user) {
+		return nil, nil, ErrForbidden
+	}
+
+	if rng.From != nil && rng.To != nil && !rng.From.Before(*rng.To) {
+		return nil, nil, ErrInvalidRange
+	}
+
+	var from, to interface{}
+	if rng.From != nil {
+		from = rng.From.UTC()
+	}
+	if rng.To != nil {
+		to = rng.To.UTC()
+	}
+
+	return from, to, nil
+}
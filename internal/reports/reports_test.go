@@ -0,0 +1,144 @@
+package reports_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/books"
+	loans "github.com/book-library/internal/loan"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/reports"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+)
+
+// TestCSV validates the reports can be opened in a spreadsheet.
+func TestCSV(t *testing.T) {
+	t.Log("Given the need to export the reports as CSV.")
+	{
+		titles := reports.Titles{
+			{BookID: "a", Title: "Go, the language", ISBN: "123", Loans: 4},
+		}
+
+		data, err := reports.CSV(titles)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to export the report : %s.", tests.Failed, err)
+		}
+
+		want := "book_id,title,isbn,loans\na,\"Go, the language\",123,4\n"
+		if string(data) != want {
+			t.Fatalf("\t%s\tShould quote the fields : %q.", tests.Failed, data)
+		}
+		t.Logf("\t%s\tShould write a header and quote the fields.", tests.Success)
+
+		data, err = reports.CSV(&reports.Duration{Returned: 2, AverageDays: 10.5, MinimumDays: 7, MaximumDays: 14})
+		if err != nil || !strings.HasSuffix(string(data), "2,10.50,7.00,14.00\n") {
+			t.Fatalf("\t%s\tShould round the days : %q, %v.", tests.Failed, data, err)
+		}
+		t.Logf("\t%s\tShould round the days.", tests.Success)
+	}
+}
+
+// TestReports validates the circulation statistics.
+func TestReports(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to report on the circulation of the library.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		admin := auth.NewClaims(
+			auth.RoleAdmin,
+			[]string{auth.RoleAdmin, auth.RoleUser},
+			now, time.Hour,
+			"718ffbea-f4a1-4667-8ae3-b349da52675e", // This is just some random UUID.
+		)
+
+		var ids []string
+		for _, nb := range []books.NewBook{
+			{Title: "Go programming", ISBN: "rpt1", Category: "programming", Quantity: 2},
+			{Title: "Gardening", ISBN: "rpt2", Category: "home", Quantity: 1},
+		} {
+			b, err := books.Create(ctx, now, nb, admin, db)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create book : %s.", tests.Failed, err)
+			}
+			ids = append(ids, b.ID)
+		}
+
+		var patrons []auth.Claims
+		for _, email := range []string{"jane@example.com", "john@example.com"} {
+			u, err := users.Create(ctx, db, users.NewUser{Name: email, Email: email, Roles: []string{auth.RoleUser}, Password: "gophers"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create patron : %s.", tests.Failed, err)
+			}
+			patrons = append(patrons, auth.NewClaims(u.ID, []string{auth.RoleUser}, now, time.Hour, ""))
+		}
+
+		if _, err := loans.CheckoutBatch(ctx, patrons[0], db, patrons[0].Subject, loans.NewBatch{BookIDs: ids}, now); err != nil {
+			t.Fatalf("\t%s\tShould be able to borrow books : %s.", tests.Failed, err)
+		}
+		receipt, err := loans.CheckoutBatch(ctx, patrons[1], db, patrons[1].Subject, loans.NewBatch{BookIDs: ids[:1]}, now.AddDate(0, 0, 1))
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to borrow books : %s.", tests.Failed, err)
+		}
+		if err := loans.Checkin(ctx, admin, db, receipt.Loans[0].ID, now.AddDate(0, 0, 11)); err != nil {
+			t.Fatalf("\t%s\tShould be able to check the book in : %s.", tests.Failed, err)
+		}
+
+		t.Log("\tWhen management asks for the statistics of the month.")
+		{
+			from := now
+			to := now.AddDate(0, 1, 0)
+			rng := reports.Range{From: &from, To: &to}
+
+			periods, err := reports.Circulation(ctx, admin, db, reports.IntervalMonth, rng)
+			if err != nil || len(periods) != 1 || periods[0].Loans != 3 || periods[0].Returns != 1 || periods[0].Patrons != 2 {
+				t.Fatalf("\t%s\tShould count the loans of the month : %+v, %v.", tests.Failed, periods, err)
+			}
+			t.Logf("\t%s\tShould count the loans of the month.", tests.Success)
+
+			titles, err := reports.TopTitles(ctx, admin, db, rng, 1)
+			if err != nil || len(titles) != 1 || titles[0].BookID != ids[0] || titles[0].Loans != 2 {
+				t.Fatalf("\t%s\tShould rank the most borrowed title first : %+v, %v.", tests.Failed, titles, err)
+			}
+			categories, err := reports.TopCategories(ctx, admin, db, rng, 0)
+			if err != nil || len(categories) != 2 || categories[0].Name != "programming" {
+				t.Fatalf("\t%s\tShould rank the most borrowed category first : %+v, %v.", tests.Failed, categories, err)
+			}
+			active, err := reports.ActivePatrons(ctx, admin, db, rng, 0)
+			if err != nil || len(active) != 2 || active[0].UserID != patrons[0].Subject || active[0].Loans != 2 {
+				t.Fatalf("\t%s\tShould rank the busiest patron first : %+v, %v.", tests.Failed, active, err)
+			}
+			t.Logf("\t%s\tShould rank the titles, categories and patrons.", tests.Success)
+
+			d, err := reports.LoanDuration(ctx, admin, db, rng)
+			if err != nil || d.Returned != 1 || d.AverageDays != 10 {
+				t.Fatalf("\t%s\tShould average the duration of the returned loans : %+v, %v.", tests.Failed, d, err)
+			}
+			turnovers, err := reports.TurnoverRate(ctx, admin, db, rng, 0)
+			if err != nil || len(turnovers) != 2 || turnovers[0].BookID != ids[1] || turnovers[0].Copies != 1 || turnovers[1].Copies != 2 || turnovers[1].Rate != 1 {
+				t.Fatalf("\t%s\tShould compute the turnover of every copy : %+v, %v.", tests.Failed, turnovers, err)
+			}
+			t.Logf("\t%s\tShould compute the duration and the turnover.", tests.Success)
+		}
+
+		t.Log("\tWhen the report is asked for with a bad range or by a patron.")
+		{
+			from := now
+			if _, err := reports.TopTitles(ctx, admin, db, reports.Range{From: &from, To: &from}, 0); err != reports.ErrInvalidRange {
+				t.Fatalf("\t%s\tShould reject an empty range : %v.", tests.Failed, err)
+			}
+			if _, err := reports.Circulation(ctx, admin, db, "year", reports.Range{}); err != reports.ErrInvalidInterval {
+				t.Fatalf("\t%s\tShould reject an unknown interval : %v.", tests.Failed, err)
+			}
+			if _, err := reports.TopTitles(ctx, patrons[0], db, reports.Range{}, 0); err != reports.ErrForbidden {
+				t.Fatalf("\t%s\tShould not let patrons read the reports : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould reject the request.", tests.Success)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package reviews
+
+import (
+	"time"
+)
+
+// Review is the feedback of a patron on a book they borrowed.
+type Review struct {
+	ID          string    `db:"review_id" json:"id"`
+	BookID      string    `db:"book_id" json:"book_id"`
+	UserID      string    `db:"user_id" json:"user_id"`
+	Rating      int       `db:"rating" json:"rating"`
+	Body        string    `db:"body" json:"body"`
+	Status      string    `db:"status" json:"status"`
+	Reports     int       `db:"reports" json:"reports"` // How many patrons reported the review as abusive.
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// NewReview contains information needed to review a book. A review without
+// a body is only a rating.
+type NewReview struct {
+	Rating int    `json:"rating" validate:"gte=1,lte=5"`
+	Body   string `json:"body" validate:"max=5000"`
+}
+
+// Moderation contains the decision of an admin on a review.
+type Moderation struct {
+	Action string `json:"action" validate:"required,oneof=approve hide"`
+}
+
+// Report is a patron telling the moderators a review is abusive.
+type Report struct {
+	ReviewID    string    `db:"review_id" json:"review_id"`
+	UserID      string    `db:"user_id" json:"user_id"`
+	Reason      string    `db:"reason" json:"reason"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+}
+
+// NewReport contains why a patron reports a review.
+type NewReport struct {
+	Reason string `json:"reason" validate:"required"`
+}
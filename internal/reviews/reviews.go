@@ -0,0 +1,320 @@
+package reviews
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Review status values. Only approved reviews are shown and counted in the
+// rating of the book.
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusHidden   = "hidden"
+)
+
+// Moderation actions.
+const (
+	ActionApprove = "approve"
+	ActionHide    = "hide"
+)
+
+// ReportThreshold is the number of reports sending an approved review back
+// to the moderation queue. It stays visible until an admin decides.
+const ReportThreshold = 3
+
+// Predefined errors identify expected failure conditions.
+var (
+	// ErrNotFound is used when a specific Review or book is requested but does
+	// not exist.
+	ErrNotFound = errors.New("Not found")
+
+	// ErrInvalidID is used when an invalid UUID is provided.
+	ErrInvalidID = errors.New("ID is not in its proper form")
+
+	// ErrForbidden occurs when a users tries to do something that is forbidden to
+	// them according to our access control policies.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrNotBorrowed is used when a patron reviews a book they never borrowed.
+	ErrNotBorrowed = errors.New("Only borrowed books can be reviewed")
+
+	// ErrAlreadyReported is used when a patron reports the same review twice.
+	ErrAlreadyReported = errors.New("Review is already reported")
+)
+
+// Submit records the review of a patron on a book they borrowed. A patron
+// has a single review per book, submitting again replaces it. Ratings
+// without text are published at once, the others wait for moderation.
+func Submit(ctx context.Context, claims auth.Claims, db *sqlx.DB, bookID string, nr NewReview, now time.Time) (*Review, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.reviews.Submit")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionReviewsWrite) {
+		return nil, ErrForbidden
+	}
+
+	if _, err := uuid.Parse(bookID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var borrowed bool
+	const qb = `SELECT EXISTS (SELECT 1 FROM loans WHERE user_id = $1 AND book_id = $2)`
+	if err := tx.GetContext(ctx, &borrowed, qb, claims.Subject, bookID); err != nil {
+		return nil, errors.Wrapf(err, "selecting book %q loans", bookID)
+	}
+	if !borrowed {
+		return nil, ErrNotBorrowed
+	}
+
+	r := Review{
+		ID:          uuid.New().String(),
+		BookID:      bookID,
+		UserID:      claims.Subject,
+		Rating:      nr.Rating,
+		Body:        strings.TrimSpace(nr.Body),
+		Status:      StatusPending,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+	if r.Body == "" {
+		r.Status = StatusApproved
+	}
+
+	// A new version of a review is moderated again and its reports are
+	// cleared as they were about the previous text.
+	const q = `INSERT INTO reviews
+		(review_id, book_id, user_id, rating, body, status, reports, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, 0, $7, $8)
+		ON CONFLICT (book_id, user_id) DO UPDATE SET
+			"rating" = EXCLUDED.rating, "body" = EXCLUDED.body, "status" = EXCLUDED.status,
+			"reports" = 0, "date_updated" = EXCLUDED.date_updated
+		RETURNING review_id, date_created`
+	row := tx.QueryRowxContext(ctx, q, r.ID, r.BookID, r.UserID, r.Rating, r.Body, r.Status, r.DateCreated, r.DateUpdated)
+	if err := row.Scan(&r.ID, &r.DateCreated); err != nil {
+		return nil, errors.Wrap(err, "inserting review")
+	}
+
+	const qd = `DELETE FROM review_reports WHERE review_id = $1`
+	if _, err := tx.ExecContext(ctx, qd, r.ID); err != nil {
+		return nil, errors.Wrapf(err, "clearing review %s reports", r.ID)
+	}
+
+	if err := rate(ctx, tx, bookID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing review")
+	}
+
+	return &r, nil
+}
+
+// ForBook retrieves the published reviews of a book, the latest first.
+func ForBook(ctx context.Context, db *sqlx.DB, bookID string) ([]Review, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.reviews.ForBook")
+	defer span.End()
+
+	if _, err := uuid.Parse(bookID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	reviews := []Review{}
+	const q = `SELECT * FROM reviews WHERE book_id = $1 AND status = $2 ORDER BY date_updated DESC`
+	if err := db.SelectContext(ctx, &reviews, q, bookID, StatusApproved); err != nil {
+		return nil, errors.Wrapf(err, "selecting book %q reviews", bookID)
+	}
+
+	return reviews, nil
+}
+
+// Queue retrieves the reviews waiting for a moderator: new reviews and
+// reviews reported by other patrons, the oldest first.
+func Queue(ctx context.Context, claims auth.Claims, db *sqlx.DB) ([]Review, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.reviews.Queue")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionReviewsModerate) {
+		return nil, ErrForbidden
+	}
+
+	reviews := []Review{}
+	const q = `SELECT * FROM reviews WHERE status = $1 OR (status = $2 AND reports > 0) ORDER BY date_updated`
+	if err := db.SelectContext(ctx, &reviews, q, StatusPending, StatusApproved); err != nil {
+		return nil, errors.Wrap(err, "selecting moderation queue")
+	}
+
+	return reviews, nil
+}
+
+// Moderate approves or hides a review. Handled reports are cleared so the
+// review leaves the queue.
+func Moderate(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, m Moderation, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.reviews.Moderate")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionReviewsModerate) {
+		return ErrForbidden
+	}
+
+	status := StatusApproved
+	if m.Action == ActionHide {
+		status = StatusHidden
+	}
+
+	return change(ctx, db, id, func(tx *sqlx.Tx, r *Review) error {
+		const q = `UPDATE reviews SET "status" = $2, "reports" = 0, "date_updated" = $3 WHERE review_id = $1`
+		if _, err := tx.ExecContext(ctx, q, id, status, now.UTC()); err != nil {
+			return errors.Wrapf(err, "moderating review %s", id)
+		}
+
+		const qd = `DELETE FROM review_reports WHERE review_id = $1`
+		if _, err := tx.ExecContext(ctx, qd, id); err != nil {
+			return errors.Wrapf(err, "clearing review %s reports", id)
+		}
+		return nil
+	})
+}
+
+// Delete removes a review. Admins can delete any review, patrons their own.
+func Delete(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string) error {
+	ctx, span := trace.StartSpan(ctx, "internal.reviews.Delete")
+	defer span.End()
+
+	return change(ctx, db, id, func(tx *sqlx.Tx, r *Review) error {
+		if !claims.HasPermission(auth.PermissionReviewsModerate) && claims.Subject != r.UserID {
+			return ErrForbidden
+		}
+
+		const q = `DELETE FROM reviews WHERE review_id = $1`
+		if _, err := tx.ExecContext(ctx, q, id); err != nil {
+			return errors.Wrapf(err, "deleting review %s", id)
+		}
+		return nil
+	})
+}
+
+// Flag reports a review as abusive. Patrons can not report their own reviews
+// nor report a review twice.
+func Flag(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, nr NewReport, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.reviews.Flag")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionReviewsWrite) {
+		return ErrForbidden
+	}
+
+	return change(ctx, db, id, func(tx *sqlx.Tx, r *Review) error {
+		if r.Status != StatusApproved {
+			return ErrNotFound
+		}
+		if r.UserID == claims.Subject {
+			return ErrForbidden
+		}
+
+		const q = `INSERT INTO review_reports (review_id, user_id, reason, date_created)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (review_id, user_id) DO NOTHING`
+		res, err := tx.ExecContext(ctx, q, id, claims.Subject, nr.Reason, now.UTC())
+		if err != nil {
+			return errors.Wrapf(err, "reporting review %s", id)
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return ErrAlreadyReported
+		}
+
+		// Enough reports take the review down until a moderator looks at it.
+		status := r.Status
+		if r.Reports+1 >= ReportThreshold {
+			status = StatusPending
+		}
+
+		const qr = `UPDATE reviews SET "reports" = "reports" + 1, "status" = $2 WHERE review_id = $1`
+		if _, err := tx.ExecContext(ctx, qr, id, status); err != nil {
+			return errors.Wrapf(err, "counting review %s reports", id)
+		}
+		return nil
+	})
+}
+
+// Reports retrieves the reports made on a review.
+func Reports(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string) ([]Report, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.reviews.Reports")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionReviewsModerate) {
+		return nil, ErrForbidden
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	reports := []Report{}
+	const q = `SELECT * FROM review_reports WHERE review_id = $1 ORDER BY date_created`
+	if err := db.SelectContext(ctx, &reports, q, id); err != nil {
+		return nil, errors.Wrapf(err, "selecting review %q reports", id)
+	}
+
+	return reports, nil
+}
+
+// change locks a review, applies fn and refreshes the rating of the book in
+// a single transaction.
+func change(ctx context.Context, db *sqlx.DB, id string, fn func(tx *sqlx.Tx, r *Review) error) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var r Review
+	const q = `SELECT * FROM reviews WHERE review_id = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &r, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return errors.Wrapf(err, "selecting review %q", id)
+	}
+
+	if err := fn(tx, &r); err != nil {
+		return err
+	}
+
+	if err := rate(ctx, tx, r.BookID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// rate refreshes the rating kept on the book from its approved reviews.
+func rate(ctx context.Context, tx sqlx.ExecerContext, bookID string) error {
+	const q = `UPDATE books SET
+		"rating_average" = COALESCE((SELECT AVG(rating) FROM reviews WHERE book_id = $1 AND status = $2), 0),
+		"rating_count" = (SELECT COUNT(*) FROM reviews WHERE book_id = $1 AND status = $2)
+		WHERE book_id = $1`
+	if _, err := tx.ExecContext(ctx, q, bookID, StatusApproved); err != nil {
+		return errors.Wrapf(err, "rating book %s", bookID)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package reviews_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/books"
+	loans "github.com/book-library/internal/loan"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/reviews"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+)
+
+// TestReviews validates patrons can review books and admins moderate them.
+func TestReviews(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to share feedback on books.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		admin := auth.NewClaims(
+			auth.RoleAdmin,
+			[]string{auth.RoleAdmin, auth.RoleUser},
+			now, time.Hour,
+			"718ffbea-f4a1-4667-8ae3-b349da52675e", // This is just some random UUID.
+		)
+
+		bk, err := books.Create(ctx, now, books.NewBook{Title: "Go programming", ISBN: "bcn22", Quantity: 5}, admin, db)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create book : %s.", tests.Failed, err)
+		}
+
+		var patrons []auth.Claims
+		for _, email := range []string{"jane@example.com", "john@example.com", "joe@example.com"} {
+			u, err := users.Create(ctx, db, users.NewUser{Name: "Patron", Email: email, Roles: []string{auth.RoleUser}, Password: "gophers"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create patron : %s.", tests.Failed, err)
+			}
+			patrons = append(patrons, auth.NewClaims(u.ID, []string{auth.RoleUser}, now, time.Hour, ""))
+		}
+		jane, john, joe := patrons[0], patrons[1], patrons[2]
+
+		t.Log("\tWhen patrons review a book.")
+		{
+			if _, err := reviews.Submit(ctx, jane, db, bk.ID, reviews.NewReview{Rating: 5}, now); err != reviews.ErrNotBorrowed {
+				t.Fatalf("\t%s\tShould only review borrowed books : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould only review borrowed books.", tests.Success)
+
+			for _, p := range []auth.Claims{jane, john} {
+				if _, err := loans.CheckoutBatch(ctx, p, db, p.Subject, loans.NewBatch{BookIDs: []string{bk.ID}}, now); err != nil {
+					t.Fatalf("\t%s\tShould be able to borrow the book : %s.", tests.Failed, err)
+				}
+			}
+
+			if _, err := reviews.Submit(ctx, john, db, bk.ID, reviews.NewReview{Rating: 2}, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to rate the book : %s.", tests.Failed, err)
+			}
+			rv, err := reviews.Submit(ctx, jane, db, bk.ID, reviews.NewReview{Rating: 4, Body: "A classic."}, now)
+			if err != nil || rv.Status != reviews.StatusPending {
+				t.Fatalf("\t%s\tShould queue the review for moderation : %+v, %v.", tests.Failed, rv, err)
+			}
+
+			saved, err := books.Retrieve(ctx, bk.ID, db)
+			if err != nil || saved.RatingCount != 1 || saved.RatingAverage != 2 {
+				t.Fatalf("\t%s\tShould only count published reviews : %+v, %v.", tests.Failed, saved, err)
+			}
+			t.Logf("\t%s\tShould publish ratings and queue reviews.", tests.Success)
+
+			if err := reviews.Moderate(ctx, admin, db, rv.ID, reviews.Moderation{Action: reviews.ActionApprove}, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to approve the review : %s.", tests.Failed, err)
+			}
+			saved, err = books.Retrieve(ctx, bk.ID, db)
+			if err != nil || saved.RatingCount != 2 || saved.RatingAverage != 3 {
+				t.Fatalf("\t%s\tShould update the rating of the book : %+v, %v.", tests.Failed, saved, err)
+			}
+			t.Logf("\t%s\tShould update the rating of the book.", tests.Success)
+		}
+
+		t.Log("\tWhen patrons report a review.")
+		{
+			published, err := reviews.ForBook(ctx, db, bk.ID)
+			if err != nil || len(published) != 2 {
+				t.Fatalf("\t%s\tShould list the published reviews : %+v, %v.", tests.Failed, published, err)
+			}
+			var id string
+			for _, r := range published {
+				if r.UserID == jane.Subject {
+					id = r.ID
+				}
+			}
+
+			if err := reviews.Flag(ctx, jane, db, id, reviews.NewReport{Reason: "spam"}, now); err != reviews.ErrForbidden {
+				t.Fatalf("\t%s\tShould not report an own review : %v.", tests.Failed, err)
+			}
+			if err := reviews.Flag(ctx, joe, db, id, reviews.NewReport{Reason: "spoilers"}, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to report the review : %s.", tests.Failed, err)
+			}
+			if err := reviews.Flag(ctx, joe, db, id, reviews.NewReport{Reason: "spoilers"}, now); err != reviews.ErrAlreadyReported {
+				t.Fatalf("\t%s\tShould not report a review twice : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to report the review once.", tests.Success)
+
+			queue, err := reviews.Queue(ctx, admin, db)
+			if err != nil || len(queue) != 1 || queue[0].ID != id {
+				t.Fatalf("\t%s\tShould queue the reported review : %+v, %v.", tests.Failed, queue, err)
+			}
+			t.Logf("\t%s\tShould queue the reported review.", tests.Success)
+
+			if err := reviews.Moderate(ctx, admin, db, id, reviews.Moderation{Action: reviews.ActionHide}, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to hide the review : %s.", tests.Failed, err)
+			}
+			saved, err := books.Retrieve(ctx, bk.ID, db)
+			if err != nil || saved.RatingCount != 1 {
+				t.Fatalf("\t%s\tShould not count hidden reviews : %+v, %v.", tests.Failed, saved, err)
+			}
+			t.Logf("\t%s\tShould not count hidden reviews.", tests.Success)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package roles
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/users"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// Predefined errors identify expected failure conditions.
+var (
+	// ErrNotFound is used when a specific Role is requested but does not exist.
+	ErrNotFound = errors.New("Role not found")
+
+	// ErrInvalidID is used when an invalid UUID is provided.
+	ErrInvalidID = errors.New("ID is not in its proper form")
+
+	// ErrInvalidName is used when a role name is not upper case letters,
+	// digits and underscores.
+	ErrInvalidName = errors.New("Role name must be upper case letters, digits and underscores")
+
+	// ErrNameTaken is used when creating a role which already exists.
+	ErrNameTaken = errors.New("Role already exists")
+
+	// ErrUnknownPermission is used when granting a permission which does not
+	// exist.
+	ErrUnknownPermission = errors.New("unknown permission")
+
+	// ErrBuiltIn is used when deleting a built-in role, or changing ADMIN.
+	ErrBuiltIn = errors.New("Built-in roles can not be changed this way")
+
+	// ErrInUse is used when deleting a role which is still assigned.
+	ErrInUse = errors.New("Role is assigned to users")
+
+	// ErrForbidden occurs when a users tries to do something that is forbidden to
+	// them according to our access control policies.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+)
+
+// List retrieves every role from the database.
+func List(ctx context.Context, claims auth.Claims, db *sqlx.DB) ([]Role, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.roles.List")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionRolesManage) {
+		return nil, ErrForbidden
+	}
+
+	roles := []Role{}
+	const q = `SELECT * FROM roles ORDER BY builtin DESC, name`
+	if err := db.SelectContext(ctx, &roles, q); err != nil {
+		return nil, errors.Wrap(err, "selecting roles")
+	}
+
+	return roles, nil
+}
+
+// Retrieve gets the specified role from the database.
+func Retrieve(ctx context.Context, claims auth.Claims, db *sqlx.DB, name string) (*Role, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.roles.Retrieve")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionRolesManage) {
+		return nil, ErrForbidden
+	}
+
+	var r Role
+	const q = `SELECT * FROM roles WHERE name = $1`
+	if err := db.GetContext(ctx, &r, q, name); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting role %q", name)
+	}
+
+	return &r, nil
+}
+
+// Create inserts a new role into the database.
+func Create(ctx context.Context, claims auth.Claims, db *sqlx.DB, n NewRole, now time.Time) (*Role, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.roles.Create")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionRolesManage) {
+		return nil, ErrForbidden
+	}
+
+	if !auth.ValidRoleName(n.Name) {
+		return nil, ErrInvalidName
+	}
+	if err := validPermissions(n.Permissions); err != nil {
+		return nil, err
+	}
+
+	r := Role{
+		Name:        n.Name,
+		Description: n.Description,
+		Permissions: n.Permissions,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `INSERT INTO roles
+		(name, description, permissions, builtin, date_created, date_updated)
+		VALUES ($1, $2, $3, FALSE, $4, $5)
+		ON CONFLICT (name) DO NOTHING`
+	res, err := db.ExecContext(ctx, q, r.Name, r.Description, r.Permissions, r.DateCreated, r.DateUpdated)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting role")
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return nil, ErrNameTaken
+	}
+
+	return &r, nil
+}
+
+// Update replaces the description or the permissions of a role. The
+// permissions of ADMIN can not be changed, it always has all of them.
+func Update(ctx context.Context, claims auth.Claims, db *sqlx.DB, name string, upd UpdateRole, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.roles.Update")
+	defer span.End()
+
+	r, err := Retrieve(ctx, claims, db, name)
+	if err != nil {
+		return err
+	}
+
+	if upd.Description != nil {
+		r.Description = *upd.Description
+	}
+	if upd.Permissions != nil {
+		if r.Name == auth.RoleAdmin {
+			return ErrBuiltIn
+		}
+		if err := validPermissions(upd.Permissions); err != nil {
+			return err
+		}
+		r.Permissions = upd.Permissions
+	}
+
+	const q = `UPDATE roles SET description = $2, permissions = $3, date_updated = $4 WHERE name = $1`
+	if _, err := db.ExecContext(ctx, q, r.Name, r.Description, r.Permissions, now.UTC()); err != nil {
+		return errors.Wrap(err, "updating role")
+	}
+
+	return nil
+}
+
+// Delete removes a role which is not built-in nor assigned to anyone.
+func Delete(ctx context.Context, claims auth.Claims, db *sqlx.DB, name string) error {
+	ctx, span := trace.StartSpan(ctx, "internal.roles.Delete")
+	defer span.End()
+
+	r, err := Retrieve(ctx, claims, db, name)
+	if err != nil {
+		return err
+	}
+	if r.BuiltIn {
+		return ErrBuiltIn
+	}
+
+	// Deleting and checking the role is not assigned happen in one statement
+	// so it can not be assigned in between.
+	const q = `DELETE FROM roles
+		WHERE name = $1 AND NOT EXISTS (SELECT 1 FROM users WHERE $1 = ANY(roles))`
+	res, err := db.ExecContext(ctx, q, name)
+	if err != nil {
+		return errors.Wrapf(err, "deleting role %s", name)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrInUse
+	}
+
+	return nil
+}
+
+// Assign replaces the roles of a user. Only admins can grant or take away
+// ADMIN, and they can not take it away from themselves, someone has to keep
+// managing the library. The roles are part of the tokens of the user so they
+// are revoked, the user signs in again with the new roles.
+func Assign(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string, a Assignment, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.roles.Assign")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionRolesManage) {
+		return ErrForbidden
+	}
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrInvalidID
+	}
+
+	if claims.Subject == userID && claims.HasRole(auth.RoleAdmin) && !contains(a.Roles, auth.RoleAdmin) {
+		return ErrForbidden
+	}
+
+	var known int
+	const qk = `SELECT COUNT(*) FROM roles WHERE name = ANY($1)`
+	if err := db.GetContext(ctx, &known, qk, pq.StringArray(a.Roles)); err != nil {
+		return errors.Wrap(err, "selecting roles")
+	}
+	if known != len(unique(a.Roles)) {
+		return ErrNotFound
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var current pq.StringArray
+	const qc = `SELECT roles FROM users WHERE user_id = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &current, qc, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		return errors.Wrap(err, "selecting user roles")
+	}
+
+	// Holding roles.manage is not enough to make someone an admin, ADMIN has
+	// every permission.
+	if contains(current, auth.RoleAdmin) != contains(a.Roles, auth.RoleAdmin) && !claims.HasRole(auth.RoleAdmin) {
+		return ErrForbidden
+	}
+
+	const q = `UPDATE users SET roles = $2, date_updated = $3 WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, q, userID, pq.StringArray(unique(a.Roles)), now.UTC()); err != nil {
+		return errors.Wrap(err, "assigning roles")
+	}
+
+	if err := users.RevokeSessions(ctx, tx, userID, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing roles")
+	}
+
+	return nil
+}
+
+// Cache resolves the permissions of roles for every request. The roles are
+// read again once they are older than its ttl, changes to roles apply after
+// that long.
+type Cache struct {
+	db  *sqlx.DB
+	ttl time.Duration
+
+	mu      sync.Mutex
+	loaded  time.Time
+	granted map[string][]string
+}
+
+// NewCache returns a Cache reading the roles from db.
+func NewCache(db *sqlx.DB, ttl time.Duration) *Cache {
+	return &Cache{db: db, ttl: ttl}
+}
+
+// Permissions returns the permissions granted by roles. Roles which do not
+// exist grant nothing.
+func (c *Cache) Permissions(ctx context.Context, roles []string) ([]string, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.roles.Permissions")
+	defer span.End()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.granted == nil || time.Since(c.loaded) > c.ttl {
+		var rs []Role
+		if err := c.db.SelectContext(ctx, &rs, `SELECT * FROM roles`); err != nil {
+			return nil, errors.Wrap(err, "selecting roles")
+		}
+
+		c.granted = make(map[string][]string, len(rs))
+		for _, r := range rs {
+			c.granted[r.Name] = r.Permissions
+		}
+		c.loaded = time.Now()
+	}
+
+	// Never nil, nil would grant the permissions of the built-in roles.
+	perms := []string{}
+	for _, r := range roles {
+		perms = append(perms, c.granted[r]...)
+	}
+
+	return perms, nil
+}
+
+// validPermissions checks every permission exists.
+func validPermissions(perms []string) error {
+	for _, p := range perms {
+		if !auth.ValidPermission(p) {
+			return ErrUnknownPermission
+		}
+	}
+	return nil
+}
+
+// contains reports whether s holds v.
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// unique returns s without duplicates, in order.
+func unique(s []string) []string {
+	var u []string
+	for _, e := range s {
+		if !contains(u, e) {
+			u = append(u, e)
+		}
+	}
+	return u
+}
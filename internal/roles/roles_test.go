@@ -0,0 +1,108 @@
+package roles_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/roles"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+)
+
+// TestRoles validates roles are defined, granted to users and resolved to
+// their permissions.
+func TestRoles(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to define roles with their permissions.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		admin := auth.NewClaims("5cf37266-3473-4006-984f-9325122678b7", []string{auth.RoleAdmin}, now, time.Hour, "")
+		librarian := auth.NewClaims("45b5fbd3-755f-4379-8f07-a58d4a30fa2f", []string{auth.RoleLibrarian}, now, time.Hour, "")
+
+		t.Log("\tWhen an admin defines a role.")
+		{
+			if _, err := roles.Create(ctx, librarian, db, roles.NewRole{Name: "SHELVER", Permissions: []string{auth.PermissionCirculation}}, now); err != roles.ErrForbidden {
+				t.Fatalf("\t%s\tShould refuse other users : %v.", tests.Failed, err)
+			}
+			if _, err := roles.Create(ctx, admin, db, roles.NewRole{Name: "shelver", Permissions: []string{auth.PermissionCirculation}}, now); err != roles.ErrInvalidName {
+				t.Fatalf("\t%s\tShould refuse lower case names : %v.", tests.Failed, err)
+			}
+			if _, err := roles.Create(ctx, admin, db, roles.NewRole{Name: "SHELVER", Permissions: []string{"shelves.dust"}}, now); err != roles.ErrUnknownPermission {
+				t.Fatalf("\t%s\tShould refuse unknown permissions : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould refuse invalid roles.", tests.Success)
+
+			if _, err := roles.Create(ctx, admin, db, roles.NewRole{Name: "SHELVER", Permissions: []string{auth.PermissionCirculation}}, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to create a role : %s.", tests.Failed, err)
+			}
+			if _, err := roles.Create(ctx, admin, db, roles.NewRole{Name: "SHELVER", Permissions: []string{}}, now); err != roles.ErrNameTaken {
+				t.Fatalf("\t%s\tShould refuse a name taken : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to create a role.", tests.Success)
+
+			if err := roles.Update(ctx, admin, db, auth.RoleAdmin, roles.UpdateRole{Permissions: []string{}}, now); err != roles.ErrBuiltIn {
+				t.Fatalf("\t%s\tShould not change the permissions of ADMIN : %v.", tests.Failed, err)
+			}
+			if err := roles.Delete(ctx, admin, db, auth.RoleLibrarian); err != roles.ErrBuiltIn {
+				t.Fatalf("\t%s\tShould not delete a built-in role : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould protect the built-in roles.", tests.Success)
+		}
+
+		t.Log("\tWhen a role is assigned.")
+		{
+			u, err := users.Create(ctx, db, users.NewUser{Name: "Jane", Email: "jane@example.com", Roles: []string{auth.RoleUser}, Password: "gophers"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+			}
+
+			if err := roles.Assign(ctx, admin, db, u.ID, roles.Assignment{Roles: []string{"NOBODY"}}, now); err != roles.ErrNotFound {
+				t.Fatalf("\t%s\tShould refuse unknown roles : %v.", tests.Failed, err)
+			}
+			if err := roles.Assign(ctx, admin, db, u.ID, roles.Assignment{Roles: []string{auth.RoleUser, "SHELVER"}}, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to assign roles : %s.", tests.Failed, err)
+			}
+			if err := roles.Assign(ctx, admin, db, admin.Subject, roles.Assignment{Roles: []string{auth.RoleUser}}, now); err != roles.ErrForbidden {
+				t.Fatalf("\t%s\tShould not let admins demote themselves : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to assign roles.", tests.Success)
+
+			var revoked bool
+			const q = `SELECT EXISTS (SELECT 1 FROM token_revocations WHERE user_id = $1)`
+			if err := db.GetContext(ctx, &revoked, q, u.ID); err != nil || !revoked {
+				t.Fatalf("\t%s\tShould revoke the tokens of the user : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould revoke the tokens of the user.", tests.Success)
+
+			manager := auth.NewClaims(u.ID, []string{"SHELVER"}, now, time.Hour, "")
+			manager.Permissions = []string{auth.PermissionRolesManage}
+			if err := roles.Assign(ctx, manager, db, u.ID, roles.Assignment{Roles: []string{auth.RoleAdmin}}, now); err != roles.ErrForbidden {
+				t.Fatalf("\t%s\tShould not let other managers grant ADMIN : %v.", tests.Failed, err)
+			}
+			if err := roles.Assign(ctx, manager, db, admin.Subject, roles.Assignment{Roles: []string{auth.RoleUser}}, now); err != roles.ErrForbidden {
+				t.Fatalf("\t%s\tShould not let other managers take ADMIN away : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould keep ADMIN to admins.", tests.Success)
+
+			if err := roles.Delete(ctx, admin, db, "SHELVER"); err != roles.ErrInUse {
+				t.Fatalf("\t%s\tShould not delete an assigned role : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould not delete an assigned role.", tests.Success)
+
+			perms, err := roles.NewCache(db, time.Minute).Permissions(ctx, []string{auth.RoleUser, "SHELVER"})
+			if err != nil {
+				t.Fatalf("\t%s\tShould resolve the permissions : %s.", tests.Failed, err)
+			}
+			claims := auth.Claims{Roles: []string{auth.RoleUser, "SHELVER"}, Permissions: perms}
+			if !claims.HasPermission(auth.PermissionCirculation) || !claims.HasPermission(auth.PermissionLoansBorrow) || claims.HasPermission(auth.PermissionBooksManage) {
+				t.Fatalf("\t%s\tShould grant the permissions of every role : %v.", tests.Failed, perms)
+			}
+			t.Logf("\t%s\tShould grant the permissions of every role.", tests.Success)
+		}
+	}
+}
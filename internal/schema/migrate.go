@@ -103,5 +103,610 @@ CREATE TABLE sessions (
 
 	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
 );`,
+	}, {
+		Version:     6,
+		Description: "Add notifications outbox and preferences",
+		Script: `
+CREATE TABLE notifications (
+	notification_id UUID,
+	user_id         UUID,
+	event           TEXT,
+	recipient       TEXT,
+	subject         TEXT,
+	body_text       TEXT,
+	body_html       TEXT,
+	dedupe_key      TEXT UNIQUE,
+	status          TEXT,
+	attempts        INT,
+	next_attempt    TIMESTAMP,
+	last_error      TEXT,
+	date_created    TIMESTAMP,
+	date_sent       TIMESTAMP,
+
+	PRIMARY KEY (notification_id),
+
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX notifications_due_idx ON notifications (status, next_attempt);
+
+CREATE TABLE notification_preferences (
+	user_id UUID,
+	event   TEXT,
+	email   BOOLEAN NOT NULL,
+
+	PRIMARY KEY (user_id, event),
+
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);`,
+	}, {
+		Version:     7,
+		Description: "Add webhooks",
+		Script: `
+CREATE TABLE webhooks (
+	webhook_id   UUID,
+	url          TEXT,
+	events       TEXT[],
+	secret       TEXT,
+	active       BOOLEAN,
+	date_created TIMESTAMP,
+	date_updated TIMESTAMP,
+
+	PRIMARY KEY (webhook_id)
+);
+
+CREATE TABLE webhook_deliveries (
+	delivery_id    UUID,
+	webhook_id     UUID,
+	event          TEXT,
+	payload        JSONB,
+	status         TEXT,
+	attempts       INT,
+	next_attempt   TIMESTAMP,
+	date_created   TIMESTAMP,
+	date_delivered TIMESTAMP,
+
+	PRIMARY KEY (delivery_id),
+
+	FOREIGN KEY (webhook_id) REFERENCES webhooks(webhook_id) ON DELETE CASCADE
+);
+
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (status, next_attempt);
+
+CREATE TABLE webhook_attempts (
+	delivery_id    UUID,
+	number         INT,
+	status_code    INT,
+	error          TEXT,
+	duration_ms    BIGINT,
+	date_attempted TIMESTAMP,
+
+	PRIMARY KEY (delivery_id, number),
+
+	FOREIGN KEY (delivery_id) REFERENCES webhook_deliveries(delivery_id) ON DELETE CASCADE
+);`,
+	}, {
+		Version:     8,
+		Description: "Add events outbox",
+		Script: `
+CREATE TABLE events (
+	event_id     BIGSERIAL,
+	type         TEXT,
+	aggregate_id TEXT,
+	payload      JSONB,
+	occurred_at  TIMESTAMP,
+
+	PRIMARY KEY (event_id)
+);`,
+	}, {
+		Version:     9,
+		Description: "Add loan returns and audit",
+		Script: `
+ALTER TABLE loans ADD COLUMN date_returned TIMESTAMP;
+
+CREATE TABLE loan_audit (
+	audit_id     UUID,
+	loan_id      UUID,
+	staff_id     TEXT,
+	action       TEXT,
+	reason       TEXT,
+	old_due      TIMESTAMP,
+	new_due      TIMESTAMP,
+	date_created TIMESTAMP,
+
+	PRIMARY KEY (audit_id),
+
+	FOREIGN KEY (loan_id) REFERENCES loans(loan_id) ON DELETE CASCADE
+);`,
+	}, {
+		Version:     10,
+		Description: "Add lost and damaged items",
+		Script: `
+ALTER TABLE books ADD COLUMN replacement_price INT NOT NULL DEFAULT 0;
+
+ALTER TABLE loans ADD COLUMN status TEXT NOT NULL DEFAULT 'active';
+UPDATE loans SET status = 'returned' WHERE date_returned IS NOT NULL;
+
+CREATE TABLE fines (
+	fine_id      UUID,
+	user_id      UUID,
+	loan_id      UUID,
+	kind         TEXT,
+	amount       INT,
+	status       TEXT,
+	date_created TIMESTAMP,
+	date_updated TIMESTAMP,
+
+	PRIMARY KEY (fine_id)
+);
+
+CREATE INDEX fines_user_idx ON fines (user_id);`,
+	}, {
+		Version:     11,
+		Description: "Add calendar tokens",
+		Script: `
+CREATE TABLE calendar_tokens (
+	user_id      UUID,
+	token_hash   TEXT,
+	date_created TIMESTAMP,
+
+	PRIMARY KEY (user_id)
+);`,
+	}, {
+		Version:     12,
+		Description: "Add self-service kiosks",
+		Script: `
+CREATE TABLE kiosks (
+	kiosk_id     UUID,
+	name         TEXT,
+	secret_hash  TEXT,
+	active       BOOLEAN,
+	date_created TIMESTAMP,
+
+	PRIMARY KEY (kiosk_id)
+);
+
+CREATE TABLE library_cards (
+	barcode      TEXT,
+	user_id      UUID UNIQUE,
+	pin_hash     TEXT,
+	date_created TIMESTAMP,
+
+	PRIMARY KEY (barcode),
+
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE kiosk_sessions (
+	session_id   UUID,
+	kiosk_id     UUID,
+	user_id      UUID,
+	barcode      TEXT,
+	token_hash   TEXT UNIQUE,
+	last_seen    TIMESTAMP,
+	date_created TIMESTAMP,
+	date_ended   TIMESTAMP,
+
+	PRIMARY KEY (session_id),
+
+	FOREIGN KEY (kiosk_id) REFERENCES kiosks(kiosk_id) ON DELETE CASCADE
+);
+
+CREATE TABLE kiosk_activity (
+	session_id   UUID,
+	loan_id      UUID,
+	action       TEXT,
+	title        TEXT,
+	isbn         TEXT,
+	date_return  TIMESTAMP,
+	date_created TIMESTAMP,
+
+	FOREIGN KEY (session_id) REFERENCES kiosk_sessions(session_id) ON DELETE CASCADE
+);`,
+	}, {
+		Version:     13,
+		Description: "Add inter-library loans",
+		Script: `
+CREATE TABLE ill_partners (
+	partner_id   UUID,
+	name         TEXT,
+	email        TEXT,
+	loan_days    INT,
+	active       BOOLEAN,
+	date_created TIMESTAMP,
+	date_updated TIMESTAMP,
+
+	PRIMARY KEY (partner_id)
+);
+
+CREATE TABLE ill_requests (
+	request_id   UUID,
+	user_id      UUID,
+	partner_id   UUID,
+	isbn         TEXT,
+	citation     TEXT,
+	title        TEXT,
+	state        TEXT,
+	note         TEXT,
+	date_loaned  TIMESTAMP,
+	date_due     TIMESTAMP,
+	date_created TIMESTAMP,
+	date_updated TIMESTAMP,
+
+	PRIMARY KEY (request_id),
+
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+	FOREIGN KEY (partner_id) REFERENCES ill_partners(partner_id)
+);
+
+CREATE INDEX ill_requests_user_idx ON ill_requests (user_id);`,
+	}, {
+		Version:     14,
+		Description: "Add holds and reading lists",
+		Script: `
+CREATE TABLE holds (
+	hold_id      UUID,
+	user_id      UUID,
+	book_id      UUID,
+	title        TEXT,
+	status       TEXT,
+	date_ready   TIMESTAMP,
+	date_expires TIMESTAMP,
+	date_created TIMESTAMP,
+	date_updated TIMESTAMP,
+
+	PRIMARY KEY (hold_id),
+
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+	FOREIGN KEY (book_id) REFERENCES books(book_id) ON DELETE CASCADE
+);
+
+CREATE INDEX holds_book_idx ON holds (book_id, status);
+
+CREATE TABLE lists (
+	list_id      UUID,
+	user_id      UUID,
+	name         TEXT,
+	description  TEXT,
+	public       BOOLEAN,
+	share_token  TEXT UNIQUE,
+	date_created TIMESTAMP,
+	date_updated TIMESTAMP,
+
+	PRIMARY KEY (list_id),
+
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE list_entries (
+	list_id    UUID,
+	book_id    UUID,
+	position   INT,
+	note       TEXT,
+	date_added TIMESTAMP,
+
+	PRIMARY KEY (list_id, book_id),
+
+	FOREIGN KEY (list_id) REFERENCES lists(list_id) ON DELETE CASCADE,
+	FOREIGN KEY (book_id) REFERENCES books(book_id) ON DELETE CASCADE
+);`,
+	}, {
+		Version:     15,
+		Description: "Add book similarity",
+		Script: `
+CREATE TABLE book_similarity (
+	book_id       UUID,
+	similar_id    UUID,
+	borrowers     INT,
+	same_category BOOLEAN,
+	score         DOUBLE PRECISION,
+	date_computed TIMESTAMP,
+
+	PRIMARY KEY (book_id, similar_id),
+
+	FOREIGN KEY (book_id) REFERENCES books(book_id) ON DELETE CASCADE,
+	FOREIGN KEY (similar_id) REFERENCES books(book_id) ON DELETE CASCADE
+);`,
+	}, {
+		Version:     16,
+		Description: "Add reviews",
+		Script: `
+ALTER TABLE books ADD COLUMN rating_average DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE books ADD COLUMN rating_count INT NOT NULL DEFAULT 0;
+
+CREATE TABLE reviews (
+	review_id    UUID,
+	book_id      UUID,
+	user_id      UUID,
+	rating       INT,
+	body         TEXT,
+	status       TEXT,
+	reports      INT,
+	date_created TIMESTAMP,
+	date_updated TIMESTAMP,
+
+	PRIMARY KEY (review_id),
+	UNIQUE (book_id, user_id),
+
+	FOREIGN KEY (book_id) REFERENCES books(book_id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE review_reports (
+	review_id    UUID,
+	user_id      UUID,
+	reason       TEXT,
+	date_created TIMESTAMP,
+
+	PRIMARY KEY (review_id, user_id),
+
+	FOREIGN KEY (review_id) REFERENCES reviews(review_id) ON DELETE CASCADE
+);`,
+	}, {
+		Version:     17,
+		Description: "Add patron standing",
+		Script: `
+ALTER TABLE loans ADD COLUMN renewals INT NOT NULL DEFAULT 0;
+
+CREATE TABLE standing_policy (
+	max_overdue  INT,
+	max_fines    INT,
+	date_updated TIMESTAMP
+);
+
+INSERT INTO standing_policy (max_overdue, max_fines, date_updated) VALUES (3, 1000, NOW());
+
+CREATE TABLE blocks (
+	block_id     UUID,
+	user_id      UUID,
+	staff_id     TEXT,
+	reason       TEXT,
+	date_created TIMESTAMP,
+	date_lifted  TIMESTAMP,
+	lifted_by    TEXT,
+
+	PRIMARY KEY (block_id),
+
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE memberships (
+	user_id      UUID,
+	date_expires TIMESTAMP,
+	date_updated TIMESTAMP,
+
+	PRIMARY KEY (user_id),
+
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);`,
+	}, {
+		Version:     18,
+		Description: "Add email verification",
+		Script: `
+ALTER TABLE users ADD COLUMN date_verified TIMESTAMP;
+UPDATE users SET date_verified = date_created;
+
+CREATE TABLE email_verifications (
+	token_hash   TEXT,
+	user_id      UUID,
+	date_created TIMESTAMP,
+	date_expires TIMESTAMP,
+	date_used    TIMESTAMP,
+
+	PRIMARY KEY (token_hash),
+
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX email_verifications_user_idx ON email_verifications (user_id, date_created);`,
+	}, {
+		Version:     19,
+		Description: "Add password resets",
+		Script: `
+CREATE TABLE password_resets (
+	token_hash   TEXT,
+	user_id      UUID,
+	date_created TIMESTAMP,
+	date_expires TIMESTAMP,
+	date_used    TIMESTAMP,
+
+	PRIMARY KEY (token_hash),
+
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX password_resets_user_idx ON password_resets (user_id, date_created);`,
+	}, {
+		Version:     20,
+		Description: "Replace sessions with rotating refresh tokens",
+		Script: `
+DROP TABLE sessions;
+
+-- token, data and expiry are the columns session.PostgresManager works with,
+-- its cleanup deletes the expired sessions.
+CREATE TABLE sessions (
+	token        TEXT,
+	data         BYTEA NOT NULL,
+	expiry       TIMESTAMP NOT NULL,
+	session_id   UUID NOT NULL UNIQUE,
+	family_id    UUID NOT NULL,
+	user_id      UUID NOT NULL,
+	user_agent   TEXT,
+	ip           TEXT,
+	date_created TIMESTAMP,
+	date_rotated TIMESTAMP,
+	date_revoked TIMESTAMP,
+
+	PRIMARY KEY (token),
+
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX sessions_family_idx ON sessions (family_id);
+CREATE INDEX sessions_user_idx ON sessions (user_id);`,
+	}, {
+		Version:     21,
+		Description: "Add access token revocation",
+		Script: `
+-- Access tokens revoked one by one, kept until they expire anyway.
+CREATE TABLE revoked_tokens (
+	jti          TEXT,
+	user_id      TEXT,
+	date_expires TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (jti)
+);
+
+CREATE INDEX revoked_tokens_expires_idx ON revoked_tokens (date_expires);
+
+-- Every access token of a user issued before date_revoked is revoked.
+CREATE TABLE token_revocations (
+	user_id      UUID,
+	date_revoked TIMESTAMP NOT NULL,
+
+	PRIMARY KEY (user_id),
+
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);`,
+	}, {
+		Version:     22,
+		Description: "Add single sign-on with an identity provider",
+		Script: `
+CREATE TABLE sso_logins (
+	state_hash    TEXT,
+	nonce         TEXT NOT NULL,
+	code_verifier TEXT NOT NULL,
+	date_created  TIMESTAMP,
+	date_expires  TIMESTAMP,
+
+	PRIMARY KEY (state_hash)
+);
+
+CREATE TABLE user_identities (
+	issuer          TEXT,
+	subject         TEXT,
+	user_id         UUID NOT NULL,
+	email           TEXT,
+	provisioned     BOOLEAN NOT NULL DEFAULT FALSE,
+	date_created    TIMESTAMP,
+	date_last_login TIMESTAMP,
+
+	PRIMARY KEY (issuer, subject),
+
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX user_identities_user_idx ON user_identities (user_id);`,
+	}, {
+		Version:     23,
+		Description: "Add two-factor authentication",
+		Script: `
+-- last_step is the time step of the last code used, a code is only accepted once.
+CREATE TABLE user_totp (
+	user_id        UUID,
+	secret         TEXT NOT NULL,
+	last_step      BIGINT NOT NULL DEFAULT 0,
+	date_created   TIMESTAMP,
+	date_confirmed TIMESTAMP,
+
+	PRIMARY KEY (user_id),
+
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE recovery_codes (
+	code_hash    TEXT,
+	user_id      UUID NOT NULL,
+	date_created TIMESTAMP,
+	date_used    TIMESTAMP,
+
+	PRIMARY KEY (code_hash),
+
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX recovery_codes_user_idx ON recovery_codes (user_id);
+
+CREATE TABLE login_challenges (
+	token_hash   TEXT,
+	user_id      UUID NOT NULL,
+	type         TEXT NOT NULL,
+	attempts     INT NOT NULL DEFAULT 0,
+	date_created TIMESTAMP,
+	date_expires TIMESTAMP,
+	date_used    TIMESTAMP,
+
+	PRIMARY KEY (token_hash),
+
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);`,
+	}, {
+		Version:     24,
+		Description: "Add personal access tokens",
+		Script: `
+-- Only the hash of a token is kept, prefix is the start of the token shown to
+-- tell the tokens of a user apart.
+CREATE TABLE access_tokens (
+	token_id       UUID,
+	user_id        UUID NOT NULL,
+	name           TEXT NOT NULL,
+	token_hash     TEXT NOT NULL UNIQUE,
+	prefix         TEXT NOT NULL,
+	scopes         TEXT[] NOT NULL,
+	date_created   TIMESTAMP,
+	date_expires   TIMESTAMP,
+	date_last_used TIMESTAMP,
+	date_revoked   TIMESTAMP,
+
+	PRIMARY KEY (token_id),
+
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX access_tokens_user_idx ON access_tokens (user_id);`,
+	}, {
+		Version:     25,
+		Description: "Add roles and their permissions",
+		Script: `
+-- The names in users.roles refer to this table. Built-in roles can not be
+-- deleted, ADMIN always has every permission.
+CREATE TABLE roles (
+	name         TEXT,
+	description  TEXT NOT NULL DEFAULT '',
+	permissions  TEXT[] NOT NULL,
+	builtin      BOOLEAN NOT NULL DEFAULT FALSE,
+	date_created TIMESTAMP,
+	date_updated TIMESTAMP,
+
+	PRIMARY KEY (name)
+);
+
+INSERT INTO roles (name, description, permissions, builtin, date_created, date_updated) VALUES
+	('ADMIN', 'Manages the library and every account',
+		'{books.manage,circulation.manage,policy.manage,ill.manage,kiosks.manage,reviews.moderate,reports.read,webhooks.manage,events.read,users.read,users.manage,roles.manage,account.manage,loans.borrow,lists.manage,reviews.write}',
+		TRUE, now(), now()),
+	('LIBRARIAN', 'Runs the circulation desk', '{circulation.manage,reports.read}', TRUE, now(), now()),
+	('USER', 'Patron of the library', '{account.manage,loans.borrow,lists.manage,reviews.write}', TRUE, now(), now()),
+	('CATALOGER', 'Maintains the catalog', '{books.manage}', FALSE, now(), now());`,
+	}, {
+		Version:     26,
+		Description: "Add login throttles",
+		Script: `
+-- Failed sign in attempts by account or by address. A row with
+-- date_locked_until in the future refuses every attempt until then.
+CREATE TABLE login_throttles (
+	throttle_key      TEXT,
+	failures          INT NOT NULL DEFAULT 0,
+	date_last_failure TIMESTAMP,
+	date_locked_until TIMESTAMP,
+
+	PRIMARY KEY (throttle_key)
+);`,
+	}, {
+		Version:     27,
+		Description: "Track when loans change",
+		Script: `
+-- Calendar feeds tell subscribers when the due date of a loan moved.
+ALTER TABLE loans ADD COLUMN date_updated TIMESTAMP;`,
 	},
 }
@@ -1,6 +1,7 @@
 package session
 
 import (
+	"database/sql"
 	"github.com/alexedwards/scs/postgresstore"
 	"time"
 )
@@ -9,6 +10,11 @@ type PostgresManager struct {
 	 *postgresstore.PostgresStore
 }
 
+//NewPostgresManager creates a *PostgresManager deleting the expired sessions from the db every cleanupInterval
+func NewPostgresManager(db *sql.DB, cleanupInterval time.Duration) *PostgresManager {
+	return &PostgresManager{PostgresStore: postgresstore.NewWithCleanupInterval(db, cleanupInterval)}
+}
+
 //GetTokenData get and return a specific token from the database
 func (m *PostgresManager) GetTokenData(token string) (b []byte, exists bool, err error) {
 	return m.PostgresStore.Find(token)
@@ -0,0 +1,58 @@
+package standing
+
+import (
+	"time"
+)
+
+// Policy holds the thresholds blocking patrons automatically.
+type Policy struct {
+	MaxOverdue  int       `db:"max_overdue" json:"max_overdue"`   // Patrons are blocked from this many overdue loans.
+	MaxFines    int       `db:"max_fines" json:"max_fines"`       // Patrons are blocked from this unpaid balance, in cents.
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"` // When the policy was last modified.
+}
+
+// UpdatePolicy defines what thresholds may be modified. All fields are
+// optional so clients can send just the fields they want changed.
+type UpdatePolicy struct {
+	MaxOverdue *int `json:"max_overdue" validate:"omitempty,gte=1"`
+	MaxFines   *int `json:"max_fines" validate:"omitempty,gte=1"`
+}
+
+// Block is a patron prevented from borrowing by staff.
+type Block struct {
+	ID          string     `db:"block_id" json:"id"`
+	UserID      string     `db:"user_id" json:"user_id"`
+	StaffID     string     `db:"staff_id" json:"staff_id"`
+	Reason      string     `db:"reason" json:"reason"`
+	DateCreated time.Time  `db:"date_created" json:"date_created"`
+	DateLifted  *time.Time `db:"date_lifted" json:"date_lifted,omitempty"`
+	LiftedBy    *string    `db:"lifted_by" json:"lifted_by,omitempty"`
+}
+
+// NewBlock contains why staff block a patron.
+type NewBlock struct {
+	Reason string `json:"reason" validate:"required"`
+}
+
+// Membership contains until when a patron is a member of the library.
+type Membership struct {
+	Expires time.Time `json:"expires" validate:"required"`
+}
+
+// Reason explains one of the causes of a patron being blocked.
+type Reason struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Standing is the state of the account of a patron and whether they are
+// allowed to borrow.
+type Standing struct {
+	UserID            string     `json:"user_id"`
+	Blocked           bool       `json:"blocked"`
+	Reasons           []Reason   `json:"reasons"`
+	OverdueLoans      int        `json:"overdue_loans"`
+	FinesBalance      int        `json:"fines_balance"` // Unpaid fines, in cents.
+	MembershipExpires *time.Time `json:"membership_expires,omitempty"`
+	Blocks            []Block    `json:"blocks"`
+}
@@ -0,0 +1,293 @@
+package standing
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// These are the codes of the reasons a patron is blocked.
+const (
+	ReasonOverdue           = "overdue"
+	ReasonFines             = "fines"
+	ReasonMembershipExpired = "membership_expired"
+	ReasonManual            = "manual"
+)
+
+// Predefined errors identify expected failure conditions.
+var (
+	// ErrNotFound is used when a specific patron or Block is requested but
+	// does not exist.
+	ErrNotFound = errors.New("Not found")
+
+	// ErrInvalidID is used when an invalid UUID is provided.
+	ErrInvalidID = errors.New("ID is not in its proper form")
+
+	// ErrForbidden occurs when a users tries to do something that is forbidden to
+	// them according to our access control policies.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+
+	// ErrBlocked is used when a blocked patron tries to borrow. The standing
+	// of the patron explains why.
+	ErrBlocked = errors.New("Account is blocked, see its standing")
+)
+
+// isStaff reports whether the claims belong to someone working at the desk.
+func isStaff(claims auth.Claims) bool {
+	return claims.HasPermission(auth.PermissionCirculation)
+}
+
+// Verify returns ErrBlocked when the patron is not allowed to borrow. It is
+// meant to be called within the transaction creating or extending a loan.
+func Verify(ctx context.Context, db sqlx.QueryerContext, userID string, now time.Time) error {
+	s, err := evaluate(ctx, db, userID, now)
+	if err != nil {
+		return err
+	}
+	if s.Blocked {
+		return ErrBlocked
+	}
+	return nil
+}
+
+// Retrieve explains the standing of a patron. Patrons can only see their own.
+func Retrieve(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string, now time.Time) (*Standing, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.standing.Retrieve")
+	defer span.End()
+
+	if !isStaff(claims) && claims.Subject != userID {
+		return nil, ErrForbidden
+	}
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var exists bool
+	const q = `SELECT EXISTS (SELECT 1 FROM users WHERE user_id = $1)`
+	if err := db.GetContext(ctx, &exists, q, userID); err != nil {
+		return nil, errors.Wrapf(err, "selecting user %q", userID)
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	return evaluate(ctx, db, userID, now)
+}
+
+// Add blocks a patron until staff lift the block.
+func Add(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string, nb NewBlock, now time.Time) (*Block, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.standing.Add")
+	defer span.End()
+
+	if !isStaff(claims) {
+		return nil, ErrForbidden
+	}
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	b := Block{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		StaffID:     claims.Subject,
+		Reason:      strings.TrimSpace(nb.Reason),
+		DateCreated: now.UTC(),
+	}
+
+	const q = `INSERT INTO blocks (block_id, user_id, staff_id, reason, date_created)
+		SELECT $1, user_id, $3, $4, $5 FROM users WHERE user_id = $2`
+	res, err := db.ExecContext(ctx, q, b.ID, b.UserID, b.StaffID, b.Reason, b.DateCreated)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting block")
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return nil, ErrNotFound
+	}
+
+	return &b, nil
+}
+
+// Lift ends a block set by staff.
+func Lift(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.standing.Lift")
+	defer span.End()
+
+	if !isStaff(claims) {
+		return ErrForbidden
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `UPDATE blocks SET "date_lifted" = $2, "lifted_by" = $3 WHERE block_id = $1 AND date_lifted IS NULL`
+	res, err := db.ExecContext(ctx, q, id, now.UTC(), claims.Subject)
+	if err != nil {
+		return errors.Wrapf(err, "lifting block %s", id)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// SetMembership records until when a patron is a member. Patrons without a
+// membership recorded are never blocked for it.
+func SetMembership(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string, m Membership, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.standing.SetMembership")
+	defer span.End()
+
+	if !isStaff(claims) {
+		return ErrForbidden
+	}
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `INSERT INTO memberships (user_id, date_expires, date_updated)
+		SELECT user_id, $2, $3 FROM users WHERE user_id = $1
+		ON CONFLICT (user_id) DO UPDATE SET "date_expires" = EXCLUDED.date_expires, "date_updated" = EXCLUDED.date_updated`
+	res, err := db.ExecContext(ctx, q, userID, m.Expires.UTC(), now.UTC())
+	if err != nil {
+		return errors.Wrapf(err, "updating user %s membership", userID)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// RetrievePolicy gets the thresholds blocking patrons automatically.
+func RetrievePolicy(ctx context.Context, claims auth.Claims, db *sqlx.DB) (*Policy, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.standing.RetrievePolicy")
+	defer span.End()
+
+	if !isStaff(claims) {
+		return nil, ErrForbidden
+	}
+
+	return policy(ctx, db)
+}
+
+// ChangePolicy modifies the thresholds blocking patrons automatically.
+func ChangePolicy(ctx context.Context, claims auth.Claims, db *sqlx.DB, up UpdatePolicy, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.standing.ChangePolicy")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionPolicyManage) {
+		return ErrForbidden
+	}
+
+	p, err := policy(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	if up.MaxOverdue != nil {
+		p.MaxOverdue = *up.MaxOverdue
+	}
+	if up.MaxFines != nil {
+		p.MaxFines = *up.MaxFines
+	}
+
+	const q = `UPDATE standing_policy SET "max_overdue" = $1, "max_fines" = $2, "date_updated" = $3`
+	if _, err := db.ExecContext(ctx, q, p.MaxOverdue, p.MaxFines, now.UTC()); err != nil {
+		return errors.Wrap(err, "updating policy")
+	}
+
+	return nil
+}
+
+// policy gets the thresholds of the library.
+func policy(ctx context.Context, db sqlx.QueryerContext) (*Policy, error) {
+	var p Policy
+	const q = `SELECT max_overdue, max_fines, date_updated FROM standing_policy`
+	if err := sqlx.GetContext(ctx, db, &p, q); err != nil {
+		return nil, errors.Wrap(err, "selecting policy")
+	}
+	return &p, nil
+}
+
+// evaluate computes the standing of a patron against the policy.
+func evaluate(ctx context.Context, db sqlx.QueryerContext, userID string, now time.Time) (*Standing, error) {
+	p, err := policy(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	s := Standing{
+		UserID:  userID,
+		Reasons: []Reason{},
+		Blocks:  []Block{},
+	}
+
+	const qo = `SELECT COUNT(*) FROM loans WHERE user_id = $1 AND status = 'active' AND date_return < $2`
+	if err := sqlx.GetContext(ctx, db, &s.OverdueLoans, qo, userID, now.UTC()); err != nil {
+		return nil, errors.Wrapf(err, "counting user %q overdue loans", userID)
+	}
+	if s.OverdueLoans >= p.MaxOverdue {
+		s.Reasons = append(s.Reasons, Reason{
+			Code:    ReasonOverdue,
+			Message: fmt.Sprintf("%d overdue loans, the limit is %d", s.OverdueLoans, p.MaxOverdue),
+		})
+	}
+
+	const qf = `SELECT COALESCE(SUM(amount), 0) FROM fines WHERE user_id = $1 AND status = 'open'`
+	if err := sqlx.GetContext(ctx, db, &s.FinesBalance, qf, userID); err != nil {
+		return nil, errors.Wrapf(err, "summing user %q fines", userID)
+	}
+	if s.FinesBalance >= p.MaxFines {
+		s.Reasons = append(s.Reasons, Reason{
+			Code:    ReasonFines,
+			Message: fmt.Sprintf("%s of unpaid fines, the limit is %s", cents(s.FinesBalance), cents(p.MaxFines)),
+		})
+	}
+
+	var expires time.Time
+	const qm = `SELECT date_expires FROM memberships WHERE user_id = $1`
+	switch err := sqlx.GetContext(ctx, db, &expires, qm, userID); err {
+	case nil:
+		s.MembershipExpires = &expires
+		if !now.Before(expires) {
+			s.Reasons = append(s.Reasons, Reason{
+				Code:    ReasonMembershipExpired,
+				Message: "Membership expired on " + expires.Format("02 Jan 2006"),
+			})
+		}
+	case sql.ErrNoRows:
+	default:
+		return nil, errors.Wrapf(err, "selecting user %q membership", userID)
+	}
+
+	const qb = `SELECT * FROM blocks WHERE user_id = $1 AND date_lifted IS NULL ORDER BY date_created`
+	if err := sqlx.SelectContext(ctx, db, &s.Blocks, qb, userID); err != nil {
+		return nil, errors.Wrapf(err, "selecting user %q blocks", userID)
+	}
+	for _, b := range s.Blocks {
+		s.Reasons = append(s.Reasons, Reason{
+			Code:    ReasonManual,
+			Message: "Blocked by staff: " + b.Reason,
+		})
+	}
+
+	s.Blocked = len(s.Reasons) > 0
+	return &s, nil
+}
+
+// cents formats an amount of money.
+func cents(amount int) string {
+	return fmt.Sprintf("%d.%02d", amount/100, amount%100)
+}
@@ -0,0 +1,115 @@
+package standing_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/books"
+	loans "github.com/book-library/internal/loan"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/standing"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+)
+
+// TestStanding validates patrons in bad standing can not borrow.
+func TestStanding(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to stop patrons in bad standing from borrowing.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		admin := auth.NewClaims(
+			auth.RoleAdmin,
+			[]string{auth.RoleAdmin, auth.RoleUser},
+			now, time.Hour,
+			"718ffbea-f4a1-4667-8ae3-b349da52675e", // This is just some random UUID.
+		)
+
+		var ids []string
+		for _, isbn := range []string{"bcn1", "bcn2", "bcn3"} {
+			b, err := books.Create(ctx, now, books.NewBook{Title: "Book " + isbn, ISBN: isbn, Quantity: 5}, admin, db)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create book : %s.", tests.Failed, err)
+			}
+			ids = append(ids, b.ID)
+		}
+
+		u, err := users.Create(ctx, db, users.NewUser{Name: "Jane Doe", Email: "jane@example.com", Roles: []string{auth.RoleUser}, Password: "gophers"}, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create patron : %s.", tests.Failed, err)
+		}
+		patron := auth.NewClaims(u.ID, []string{auth.RoleUser}, now, time.Hour, "")
+
+		max := 2
+		if err := standing.ChangePolicy(ctx, admin, db, standing.UpdatePolicy{MaxOverdue: &max}, now); err != nil {
+			t.Fatalf("\t%s\tShould be able to change the policy : %s.", tests.Failed, err)
+		}
+
+		receipt, err := loans.CheckoutBatch(ctx, patron, db, u.ID, loans.NewBatch{BookIDs: ids[:2]}, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to borrow books : %s.", tests.Failed, err)
+		}
+
+		t.Log("\tWhen the patron keeps the books too long.")
+		{
+			late := now.Add(loans.LoanPeriod + 24*time.Hour)
+
+			st, err := standing.Retrieve(ctx, patron, db, u.ID, late)
+			if err != nil || !st.Blocked || st.OverdueLoans != 2 || st.Reasons[0].Code != standing.ReasonOverdue {
+				t.Fatalf("\t%s\tShould explain the patron is blocked : %+v, %v.", tests.Failed, st, err)
+			}
+			t.Logf("\t%s\tShould explain the patron is blocked.", tests.Success)
+
+			if _, err := loans.CheckoutBatch(ctx, patron, db, u.ID, loans.NewBatch{BookIDs: ids[2:]}, late); err != standing.ErrBlocked {
+				t.Fatalf("\t%s\tShould not let the patron borrow : %v.", tests.Failed, err)
+			}
+			if _, err := loans.Renew(ctx, patron, db, receipt.Loans[0].ID, late); err != standing.ErrBlocked {
+				t.Fatalf("\t%s\tShould not let the patron renew : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould not let the patron borrow or renew.", tests.Success)
+		}
+
+		t.Log("\tWhen staff block the patron.")
+		{
+			if _, err := loans.Renew(ctx, patron, db, receipt.Loans[0].ID, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to renew in good standing : %s.", tests.Failed, err)
+			}
+
+			b, err := standing.Add(ctx, admin, db, u.ID, standing.NewBlock{Reason: "damaged a reading room chair"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to block the patron : %s.", tests.Failed, err)
+			}
+
+			st, err := standing.Retrieve(ctx, patron, db, u.ID, now)
+			if err != nil || !st.Blocked || len(st.Blocks) != 1 || st.Reasons[0].Code != standing.ReasonManual {
+				t.Fatalf("\t%s\tShould explain the manual block : %+v, %v.", tests.Failed, st, err)
+			}
+			t.Logf("\t%s\tShould explain the manual block.", tests.Success)
+
+			if err := standing.Lift(ctx, admin, db, b.ID, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to lift the block : %s.", tests.Failed, err)
+			}
+			if err := standing.Verify(ctx, db, u.ID, now); err != nil {
+				t.Fatalf("\t%s\tShould be back in good standing : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be back in good standing once lifted.", tests.Success)
+		}
+
+		t.Log("\tWhen the membership of the patron expires.")
+		{
+			if err := standing.SetMembership(ctx, admin, db, u.ID, standing.Membership{Expires: now.Add(time.Hour)}, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to set the membership : %s.", tests.Failed, err)
+			}
+
+			st, err := standing.Retrieve(ctx, admin, db, u.ID, now.Add(2*time.Hour))
+			if err != nil || !st.Blocked || st.Reasons[0].Code != standing.ReasonMembershipExpired {
+				t.Fatalf("\t%s\tShould block the expired membership : %+v, %v.", tests.Failed, st, err)
+			}
+			t.Logf("\t%s\tShould block the expired membership.", tests.Success)
+		}
+	}
+}
@@ -150,12 +150,18 @@ func (test *Test) Token(email, pass string) string {
 
 	claims, err := users.Authenticate(
 		context.Background(), test.DB, time.Now(),
-		email, pass,
+		email, pass, "",
 	)
 	if err != nil {
 		test.t.Fatal(err)
 	}
 
+	_, s, err := users.StartSession(context.Background(), test.DB, claims.Subject, users.Device{}, time.Now())
+	if err != nil {
+		test.t.Fatal(err)
+	}
+	claims.SessionID = s.FamilyID
+
 	tkn, err := test.Authenticator.GenerateToken(claims)
 	if err != nil {
 		test.t.Fatal(err)
@@ -0,0 +1,194 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// MaxAccessTokenPeriod is how long a personal access token can be valid.
+const MaxAccessTokenPeriod = 365 * 24 * time.Hour
+
+// accessTokenPrefixLen is how much of a token is kept to tell tokens apart.
+const accessTokenPrefixLen = len(auth.AccessTokenPrefix) + 6
+
+var (
+	// ErrInvalidScope occurs when a personal access token is created with a
+	// scope which does not exist.
+	ErrInvalidScope = errors.New("Scope is unknown")
+
+	// ErrInvalidExpiry occurs when a personal access token would never expire
+	// or is already expired.
+	ErrInvalidExpiry = errors.New("Expiry must be in the future and within a year")
+)
+
+// CreateAccessToken creates a personal access token for the user. The token is
+// only returned here, the database keeps its hash.
+func CreateAccessToken(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string, nat NewAccessToken, now time.Time) (*CreatedAccessToken, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.users.CreateAccessToken")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	// Tokens act on behalf of the user, nobody else creates them.
+	if claims.Subject != userID {
+		return nil, ErrForbidden
+	}
+
+	for _, s := range nat.Scopes {
+		if !auth.ValidScope(s) {
+			return nil, ErrInvalidScope
+		}
+	}
+
+	if !nat.ExpiresAt.After(now) || nat.ExpiresAt.After(now.Add(MaxAccessTokenPeriod)) {
+		return nil, ErrInvalidExpiry
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errors.Wrap(err, "generating access token")
+	}
+	token := auth.AccessTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	at := CreatedAccessToken{
+		AccessToken: AccessToken{
+			ID:          uuid.New().String(),
+			UserID:      userID,
+			Name:        nat.Name,
+			Prefix:      token[:accessTokenPrefixLen],
+			Scopes:      nat.Scopes,
+			DateCreated: now.UTC(),
+			DateExpires: nat.ExpiresAt.UTC(),
+		},
+		Token: token,
+	}
+
+	const q = `INSERT INTO access_tokens
+		(token_id, user_id, name, token_hash, prefix, scopes, date_created, date_expires)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err := db.ExecContext(ctx, q, at.ID, at.UserID, at.Name, tokenHash(token), at.Prefix, at.Scopes, at.DateCreated, at.DateExpires)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting access token")
+	}
+
+	return &at, nil
+}
+
+// AccessTokens retrieves the personal access tokens of a user which can still
+// be used.
+func AccessTokens(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string, now time.Time) ([]AccessToken, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.users.AccessTokens")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	// If you are not an admin and looking to retrieve someone else then you are rejected.
+	if !claims.HasPermission(auth.PermissionUsersManage) && claims.Subject != userID {
+		return nil, ErrForbidden
+	}
+
+	tokens := []AccessToken{}
+	const q = `SELECT token_id, user_id, name, prefix, scopes, date_created, date_expires, date_last_used, date_revoked
+		FROM access_tokens
+		WHERE user_id = $1 AND date_revoked IS NULL AND date_expires > $2
+		ORDER BY date_created DESC`
+	if err := db.SelectContext(ctx, &tokens, q, userID, now.UTC()); err != nil {
+		return nil, errors.Wrap(err, "selecting access tokens")
+	}
+
+	return tokens, nil
+}
+
+// RevokeAccessToken revokes a personal access token of a user. Admins revoke
+// the tokens of anyone.
+func RevokeAccessToken(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID, tokenID string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.users.RevokeAccessToken")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrInvalidID
+	}
+	if _, err := uuid.Parse(tokenID); err != nil {
+		return ErrInvalidID
+	}
+
+	if !claims.HasPermission(auth.PermissionUsersManage) && claims.Subject != userID {
+		return ErrForbidden
+	}
+
+	const q = `UPDATE access_tokens SET date_revoked = $3
+		WHERE token_id = $1 AND user_id = $2 AND date_revoked IS NULL`
+	res, err := db.ExecContext(ctx, q, tokenID, userID, now.UTC())
+	if err != nil {
+		return errors.Wrap(err, "revoking access token")
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// AccessTokenClaims returns the claims of a personal access token. They carry
+// the current roles of the user and the scopes of the token.
+func AccessTokenClaims(ctx context.Context, db *sqlx.DB, token string, now time.Time) (auth.Claims, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.users.AccessTokenClaims")
+	defer span.End()
+
+	var t struct {
+		AccessToken
+		Roles pq.StringArray `db:"roles"`
+	}
+	const q = `SELECT t.token_id, t.user_id, t.name, t.prefix, t.scopes, t.date_created, t.date_expires,
+		t.date_last_used, t.date_revoked, u.roles
+		FROM access_tokens AS t
+		JOIN users AS u ON u.user_id = t.user_id
+		WHERE t.token_hash = $1`
+	if err := db.GetContext(ctx, &t, q, tokenHash(token)); err != nil {
+		if err == sql.ErrNoRows {
+			return auth.Claims{}, auth.ErrUnknownAccessToken
+		}
+		return auth.Claims{}, errors.Wrap(err, "selecting access token")
+	}
+
+	if t.DateRevoked != nil || !t.DateExpires.After(now.UTC()) {
+		return auth.Claims{}, auth.ErrUnknownAccessToken
+	}
+
+	// Recording every use would write on each request, a minute is precise
+	// enough to tell whether a token is still used.
+	if t.DateLastUsed == nil || now.Sub(*t.DateLastUsed) > time.Minute {
+		const qu = `UPDATE access_tokens SET date_last_used = $2 WHERE token_id = $1`
+		if _, err := db.ExecContext(ctx, qu, t.ID, now.UTC()); err != nil {
+			return auth.Claims{}, errors.Wrap(err, "recording access token use")
+		}
+	}
+
+	c := auth.Claims{
+		Roles:  t.Roles,
+		Scopes: t.Scopes,
+		StandardClaims: jwt.StandardClaims{
+			Id:        t.ID,
+			Subject:   t.UserID,
+			IssuedAt:  t.DateCreated.Unix(),
+			ExpiresAt: t.DateExpires.Unix(),
+		},
+	}
+
+	return c, nil
+}
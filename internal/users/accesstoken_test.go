@@ -0,0 +1,83 @@
+package users_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+)
+
+// TestAccessToken validates personal access tokens are created, resolved to
+// scoped claims and revoked.
+func TestAccessToken(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to let scripts call the API.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		u, err := users.Create(ctx, db, users.NewUser{Name: "Jane", Email: "jane@example.com", Roles: []string{auth.RoleUser}, Password: "gophers"}, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+		}
+		claims := auth.NewClaims(u.ID, u.Roles, now, time.Hour, "csrf")
+
+		t.Log("\tWhen a user creates a token.")
+		{
+			nat := users.NewAccessToken{Name: "backup", Scopes: []string{"books:delete"}, ExpiresAt: now.Add(24 * time.Hour)}
+			if _, err := users.CreateAccessToken(ctx, claims, db, u.ID, nat, now); err != users.ErrInvalidScope {
+				t.Fatalf("\t%s\tShould refuse unknown scopes : %v.", tests.Failed, err)
+			}
+
+			nat.Scopes = []string{auth.ScopeBooksRead}
+			nat.ExpiresAt = now.Add(2 * users.MaxAccessTokenPeriod)
+			if _, err := users.CreateAccessToken(ctx, claims, db, u.ID, nat, now); err != users.ErrInvalidExpiry {
+				t.Fatalf("\t%s\tShould refuse tokens valid too long : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould refuse unknown scopes and long expiries.", tests.Success)
+
+			nat.ExpiresAt = now.Add(24 * time.Hour)
+			at, err := users.CreateAccessToken(ctx, claims, db, u.ID, nat, now)
+			if err != nil || !auth.IsAccessToken(at.Token) {
+				t.Fatalf("\t%s\tShould be able to create a token : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to create a token.", tests.Success)
+
+			got, err := users.AccessTokenClaims(ctx, db, at.Token, now.Add(time.Hour))
+			if err != nil || got.Subject != u.ID {
+				t.Fatalf("\t%s\tShould resolve the token to the user : %v.", tests.Failed, err)
+			}
+			if !got.HasScope(auth.ScopeBooksRead) || got.HasScope(auth.ScopeLoansWrite) || !got.HasRole(auth.RoleUser) {
+				t.Fatalf("\t%s\tShould be limited to the scopes of the token : %v.", tests.Failed, got.Scopes)
+			}
+			t.Logf("\t%s\tShould resolve the token to scoped claims.", tests.Success)
+
+			if _, err := users.AccessTokenClaims(ctx, db, at.Token, now.Add(25*time.Hour)); err != auth.ErrUnknownAccessToken {
+				t.Fatalf("\t%s\tShould refuse an expired token : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould refuse an expired token.", tests.Success)
+
+			tokens, err := users.AccessTokens(ctx, claims, db, u.ID, now)
+			if err != nil || len(tokens) != 1 || tokens[0].Prefix != at.Token[:len(tokens[0].Prefix)] {
+				t.Fatalf("\t%s\tShould list the token : %+v, %v.", tests.Failed, tokens, err)
+			}
+			t.Logf("\t%s\tShould list the token.", tests.Success)
+
+			other := auth.NewClaims("45b5fbd3-755f-4379-8f07-a58d4a30fa2f", []string{auth.RoleUser}, now, time.Hour, "csrf")
+			if err := users.RevokeAccessToken(ctx, other, db, u.ID, at.ID, now); err != users.ErrForbidden {
+				t.Fatalf("\t%s\tShould not revoke the token of another user : %v.", tests.Failed, err)
+			}
+			if err := users.RevokeAccessToken(ctx, claims, db, u.ID, at.ID, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to revoke the token : %v.", tests.Failed, err)
+			}
+			if _, err := users.AccessTokenClaims(ctx, db, at.Token, now); err != auth.ErrUnknownAccessToken {
+				t.Fatalf("\t%s\tShould refuse a revoked token : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould refuse a revoked token.", tests.Success)
+		}
+	}
+}
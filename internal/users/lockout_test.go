@@ -0,0 +1,101 @@
+package users_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/lockout"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+	"github.com/pkg/errors"
+)
+
+// TestLockout validates failed sign in attempts are delayed, then locked out
+// until an admin lifts the lockout.
+func TestLockout(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to stop passwords being guessed.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		nu := users.NewUser{Name: "Jane", Email: "jane@example.com", Roles: []string{auth.RoleUser}, Password: "gophers"}
+		u, err := users.Create(ctx, db, nu, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+		}
+
+		t.Log("\tWhen a wrong password is tried again and again.")
+		{
+			for i := 0; i < lockout.Account.FreeAttempts; i++ {
+				if _, err := users.Authenticate(ctx, db, now, nu.Email, "cats", "10.0.0.1"); err != users.ErrAuthenticationFailure {
+					t.Fatalf("\t%s\tShould refuse the wrong password : %v.", tests.Failed, err)
+				}
+			}
+			t.Logf("\t%s\tShould not delay the first failures.", tests.Success)
+
+			if _, err := users.Authenticate(ctx, db, now, nu.Email, "cats", "10.0.0.1"); err != users.ErrAuthenticationFailure {
+				t.Fatalf("\t%s\tShould refuse the wrong password : %v.", tests.Failed, err)
+			}
+			_, err := users.Authenticate(ctx, db, now, nu.Email, nu.Password, "10.0.0.2")
+			if errors.Cause(err) != users.ErrTooManyAttempts {
+				t.Fatalf("\t%s\tShould delay the next attempt, even from elsewhere : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould delay the next attempts.", tests.Success)
+
+			if d := lockout.RetryAfter(err); d != lockout.Account.BaseDelay {
+				t.Fatalf("\t%s\tShould tell how long to wait : got %v want %v.", tests.Failed, d, lockout.Account.BaseDelay)
+			}
+			t.Logf("\t%s\tShould tell how long to wait.", tests.Success)
+
+			for i := lockout.Account.FreeAttempts + 1; i < lockout.Account.Threshold; i++ {
+				now = now.Add(lockout.Account.MaxDelay)
+				if _, err := users.Authenticate(ctx, db, now, nu.Email, "cats", "10.0.0.1"); err != users.ErrAuthenticationFailure {
+					t.Fatalf("\t%s\tShould refuse the wrong password : %v.", tests.Failed, err)
+				}
+			}
+			now = now.Add(lockout.Account.MaxDelay)
+			_, err = users.Authenticate(ctx, db, now, nu.Email, nu.Password, "10.0.0.2")
+			if errors.Cause(err) != users.ErrAccountLocked {
+				t.Fatalf("\t%s\tShould lock the account out : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould lock the account out.", tests.Success)
+
+			if d := lockout.RetryAfter(err); d != lockout.Account.Period-lockout.Account.MaxDelay {
+				t.Fatalf("\t%s\tShould tell how long the lockout lasts : got %v want %v.", tests.Failed, d, lockout.Account.Period-lockout.Account.MaxDelay)
+			}
+			t.Logf("\t%s\tShould tell how long the lockout lasts.", tests.Success)
+
+			var n int
+			if err := db.Get(&n, `SELECT COUNT(*) FROM events WHERE type = 'LoginLocked' AND aggregate_id = 'account:jane@example.com'`); err != nil || n != 1 {
+				t.Fatalf("\t%s\tShould record the lockout : %d %v.", tests.Failed, n, err)
+			}
+			t.Logf("\t%s\tShould record the lockout.", tests.Success)
+		}
+
+		t.Log("\tWhen an admin unlocks the account.")
+		{
+			admin := auth.NewClaims("5cf37266-3473-4006-984f-9325122678b7", []string{auth.RoleAdmin}, now, time.Hour, "")
+			patron := auth.NewClaims(u.ID, []string{auth.RoleUser}, now, time.Hour, "")
+
+			if err := users.Unlock(ctx, patron, db, u.ID, now); err != users.ErrForbidden {
+				t.Fatalf("\t%s\tShould refuse other users : %v.", tests.Failed, err)
+			}
+			if err := users.Unlock(ctx, admin, db, u.ID, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to unlock the account : %s.", tests.Failed, err)
+			}
+			if err := users.Unlock(ctx, admin, db, u.ID, now); err != users.ErrNotLocked {
+				t.Fatalf("\t%s\tShould tell the account is not locked : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to unlock the account.", tests.Success)
+
+			if _, err := users.Authenticate(ctx, db, now, nu.Email, nu.Password, "10.0.0.2"); err != nil {
+				t.Fatalf("\t%s\tShould be able to sign in : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be able to sign in.", tests.Success)
+		}
+	}
+}
@@ -15,6 +15,7 @@ type User struct {
 	PasswordHash []byte         `db:"password_hash" json:"-"`
 	DateCreated  time.Time      `db:"date_created" json:"date_created"`
 	DateUpdated  time.Time      `db:"date_updated" json:"date_updated"`
+	DateVerified *time.Time     `db:"date_verified" json:"date_verified,omitempty"` // When the email address was confirmed.
 }
 
 // NewUser contains information needed to create a new User.
@@ -26,31 +27,194 @@ type NewUser struct {
 	PasswordConfirm string   `json:"password_confirm" validate:"eqfield=Password"`
 }
 
+// NewRegistration contains information needed by patrons to open their own
+// account. Unlike NewUser the roles can not be chosen, every registered
+// account is a patron.
+type NewRegistration struct {
+	Name            string `json:"name" validate:"required"`
+	Email           string `json:"email" validate:"required,email"`
+	Password        string `json:"password" validate:"required,min=8"`
+	PasswordConfirm string `json:"password_confirm" validate:"eqfield=Password"`
+}
+
+// Verification contains the token sent to patrons to confirm their email
+// address.
+type Verification struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// ResendVerification contains the email address of an account waiting to be
+// verified.
+type ResendVerification struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// ForgotPassword contains the email address of an account whose password was
+// forgotten.
+type ForgotPassword struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// PasswordReset contains the token sent to a user who forgot their password
+// along with the new password.
+type PasswordReset struct {
+	Token           string `json:"token" validate:"required"`
+	Password        string `json:"password" validate:"required,min=8"`
+	PasswordConfirm string `json:"password_confirm" validate:"eqfield=Password"`
+}
+
 // UpdateUser defines what information may be provided to modify an existing
 // User. All fields are optional so clients can send just the fields they want
 // changed. It uses pointer fields so we can differentiate between a field that
 // was not provided and a field that was provided as explicitly blank. Normally
 // we do not want to use pointers to basic types but we make exceptions around
-// marshalling/unmarshalling.
+// marshalling/unmarshalling. Roles are not part of it, they are only changed
+// through roles.Assign by someone allowed to manage roles.
 type UpdateUser struct {
-	Name            *string  `json:"name"`
-	Email           *string  `json:"email"`
-	Roles           []string `json:"roles"`
-	Password        *string  `json:"password"`
-	PasswordConfirm *string  `json:"password_confirm" validate:"omitempty,eqfield=Password"`
+	Name            *string `json:"name"`
+	Email           *string `json:"email"`
+	Password        *string `json:"password"`
+	PasswordConfirm *string `json:"password_confirm" validate:"omitempty,eqfield=Password"`
 }
 
-// Session contains information needed to manage session-cookie/token.
+// Session is a device signed in to an account. The device keeps an opaque
+// refresh token, only its hash is stored. Every refresh rotates the token: the
+// session is marked as rotated and a new one of the same family replaces it.
 type Session struct {
-	Id     string    `db:"user_id" json:"user_id"`
-	Token  string    `db:"token" json:"token"`
-	Data   []byte    `db:"data" json:"data"`
-	Expiry time.Time `db:"expiry" json:"expiry"`
+	ID          string     `db:"session_id" json:"id"`
+	FamilyID    string     `db:"family_id" json:"family_id"`
+	UserID      string     `db:"user_id" json:"user_id"`
+	TokenHash   string     `db:"token" json:"-"`
+	UserAgent   string     `db:"user_agent" json:"user_agent"`
+	IP          string     `db:"ip" json:"ip"`
+	DateCreated time.Time  `db:"date_created" json:"date_created"`
+	Expiry      time.Time  `db:"expiry" json:"expiry"`
+	DateRotated *time.Time `db:"date_rotated" json:"-"`
+	DateRevoked *time.Time `db:"date_revoked" json:"-"`
+}
+
+// Device describes the client a session is opened from.
+type Device struct {
+	UserAgent string
+	IP        string
+}
+
+// Tokens is what a client receives when it signs in or refreshes its
+// session. The access token is a short lived JWT, the refresh token is used
+// once to get the next Tokens.
+type Tokens struct {
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+
+	// RecoveryCodes are only sent when a sign in enrolled an authenticator
+	// app.
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// Refresh contains the refresh token of a session, for clients which do not
+// keep it in a cookie.
+type Refresh struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// SSOLogin is a sign in with the identity provider waiting for its answer.
+// The state sent to the provider identifies it, only its hash is stored.
+type SSOLogin struct {
+	Nonce       string    `db:"nonce"`
+	Verifier    string    `db:"code_verifier"`
+	DateExpires time.Time `db:"date_expires"`
+}
+
+// ExternalIdentity is a user as described by the identity provider after
+// they signed in with it.
+type ExternalIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Roles         []string // Mapped from the claims of the provider.
+}
+
+// Identity links an account to the account of a user at an identity
+// provider. Provisioned identities created the account they are linked to,
+// its roles follow the ones of the provider.
+type Identity struct {
+	Issuer        string     `db:"issuer" json:"issuer"`
+	Subject       string     `db:"subject" json:"subject"`
+	UserID        string     `db:"user_id" json:"user_id"`
+	Email         string     `db:"email" json:"email"`
+	Provisioned   bool       `db:"provisioned" json:"provisioned"`
+	DateCreated   time.Time  `db:"date_created" json:"date_created"`
+	DateLastLogin *time.Time `db:"date_last_login" json:"date_last_login"`
+}
+
+// TwoFactor tells whether an account signs in with a code of an authenticator
+// app on top of its password.
+type TwoFactor struct {
+	Enabled       bool       `json:"enabled"`
+	Required      bool       `json:"required"`
+	DateConfirmed *time.Time `json:"date_confirmed,omitempty"`
+	RecoveryCodes int        `json:"recovery_codes"` // How many are left.
+}
+
+// Enrollment is the secret to add to an authenticator app, as text and as
+// the URI to render as a QR code.
+type Enrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// TwoFactorCode is a code of the authenticator app or a recovery code.
+type TwoFactorCode struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// RecoveryCodes can each be used once instead of a code of the authenticator
+// app. They are only shown when generated.
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// Challenge is what a client receives when a password is not enough to sign
+// in. A totp challenge is answered with a code, an enroll challenge requires
+// to enroll an authenticator app first.
+type Challenge struct {
+	Token     string    `json:"challenge"`
+	Type      string    `json:"type"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ChallengeAnswer answers a Challenge to finish signing in.
+type ChallengeAnswer struct {
+	Challenge string `json:"challenge" validate:"required"`
+	Code      string `json:"code"`
+}
+
+// AccessToken is a personal access token scripts use to call the API on
+// behalf of a user. The token itself is only shown when created.
+type AccessToken struct {
+	ID           string         `db:"token_id" json:"id"`
+	UserID       string         `db:"user_id" json:"user_id"`
+	Name         string         `db:"name" json:"name"`
+	Prefix       string         `db:"prefix" json:"prefix"`
+	Scopes       pq.StringArray `db:"scopes" json:"scopes"`
+	DateCreated  time.Time      `db:"date_created" json:"date_created"`
+	DateExpires  time.Time      `db:"date_expires" json:"date_expires"`
+	DateLastUsed *time.Time     `db:"date_last_used" json:"date_last_used"`
+	DateRevoked  *time.Time     `db:"date_revoked" json:"date_revoked,omitempty"`
+}
+
+// NewAccessToken contains what is needed to create a personal access token.
+type NewAccessToken struct {
+	Name      string    `json:"name" validate:"required"`
+	Scopes    []string  `json:"scopes" validate:"required,min=1"`
+	ExpiresAt time.Time `json:"expires_at" validate:"required"`
 }
 
-// UpdateSession update the session-cookie/token.
-type UpdateSession struct {
-	Token   *string   `db:"token" json:"token"`
-	NewData []byte    `db:"data" json:"data"`
-	Expiry  time.Time `db:"expiry" json:"expiry"`
+// CreatedAccessToken is a new personal access token along with its secret.
+type CreatedAccessToken struct {
+	AccessToken
+	Token string `json:"token"`
 }
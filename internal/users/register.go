@@ -0,0 +1,249 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/book-library/internal/events"
+	"github.com/book-library/internal/notify"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// VerificationPeriod is how long the link sent to confirm an email address
+// can be used.
+const VerificationPeriod = 24 * time.Hour
+
+// At most MaxVerifications links are sent to an account during
+// VerificationWindow, so the resend endpoint can not be used to flood a
+// mailbox.
+const (
+	MaxVerifications   = 3
+	VerificationWindow = time.Hour
+)
+
+var (
+	// ErrEmailTaken occurs when somebody registers with the email address of
+	// an existing account.
+	ErrEmailTaken = errors.New("Email address is already registered")
+
+	// ErrNotVerified occurs when a patron signs in before confirming their
+	// email address.
+	ErrNotVerified = errors.New("Email address is not verified")
+
+	// ErrInvalidToken occurs when a verification or a password reset link is
+	// unknown, was already used or expired.
+	ErrInvalidToken = errors.New("Link is invalid or expired")
+
+	// ErrTooManyRequests occurs when too many verification links were asked
+	// for in a short time.
+	ErrTooManyRequests = errors.New("Too many verification links were sent, try again later")
+)
+
+// Register creates the account of a patron who signed up by themselves. The
+// account can not be used until the email address is confirmed with the link
+// sent to it, link is the address of the page the token is appended to.
+func Register(ctx context.Context, db *sqlx.DB, nr NewRegistration, link string, now time.Time) (*User, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.users.Register")
+	defer span.End()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(nr.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, errors.Wrap(err, "generating password hash")
+	}
+
+	u := User{
+		ID:           uuid.New().String(),
+		Name:         nr.Name,
+		Email:        nr.Email,
+		PasswordHash: hash,
+		Roles:        []string{auth.RoleUser},
+		DateCreated:  now.UTC(),
+		DateUpdated:  now.UTC(),
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var taken bool
+	const qe = `SELECT EXISTS (SELECT 1 FROM users WHERE email = $1)`
+	if err := tx.GetContext(ctx, &taken, qe, u.Email); err != nil {
+		return nil, errors.Wrap(err, "checking email")
+	}
+	if taken {
+		return nil, ErrEmailTaken
+	}
+
+	if err := insert(ctx, tx, u, now); err != nil {
+		return nil, err
+	}
+
+	if err := issue(ctx, tx, u.ID, link, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing registration")
+	}
+
+	return &u, nil
+}
+
+// Verify confirms the email address of the account the token was sent to.
+// Every link sent to the account stops working once one of them is used.
+func Verify(ctx context.Context, db *sqlx.DB, token string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.users.Verify")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var userID string
+	const qv = `UPDATE email_verifications SET date_used = $2
+		WHERE token_hash = $1 AND date_used IS NULL AND date_expires > $2
+		RETURNING user_id`
+	if err := tx.GetContext(ctx, &userID, qv, tokenHash(token), now.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInvalidToken
+		}
+		return errors.Wrap(err, "using verification")
+	}
+
+	const qu = `UPDATE users SET date_verified = $2, date_updated = $2
+		WHERE user_id = $1 AND date_verified IS NULL`
+	if _, err := tx.ExecContext(ctx, qu, userID, now.UTC()); err != nil {
+		return errors.Wrap(err, "verifying user")
+	}
+
+	const qo = `UPDATE email_verifications SET date_used = $2
+		WHERE user_id = $1 AND date_used IS NULL`
+	if _, err := tx.ExecContext(ctx, qo, userID, now.UTC()); err != nil {
+		return errors.Wrap(err, "closing other verifications")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing verification")
+	}
+
+	return nil
+}
+
+// Resend sends a new verification link to an account which is not verified
+// yet. Nothing is sent, and no error returned, for unknown or already
+// verified addresses so the endpoint does not tell which accounts exist.
+func Resend(ctx context.Context, db *sqlx.DB, email, link string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.users.Resend")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var u struct {
+		ID           string     `db:"user_id"`
+		DateVerified *time.Time `db:"date_verified"`
+	}
+	const qu = `SELECT user_id, date_verified FROM users WHERE email = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &u, qu, email); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return errors.Wrap(err, "selecting user")
+	}
+	if u.DateVerified != nil {
+		return nil
+	}
+
+	var sent int
+	const qc = `SELECT COUNT(*) FROM email_verifications WHERE user_id = $1 AND date_created > $2`
+	if err := tx.GetContext(ctx, &sent, qc, u.ID, now.Add(-VerificationWindow).UTC()); err != nil {
+		return errors.Wrap(err, "counting verifications")
+	}
+	if sent >= MaxVerifications {
+		return ErrTooManyRequests
+	}
+
+	if err := issue(ctx, tx, u.ID, link, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing verification")
+	}
+
+	return nil
+}
+
+// insert stores a new user and records that it was registered.
+func insert(ctx context.Context, tx sqlx.ExtContext, u User, now time.Time) error {
+	const q = `INSERT INTO users
+		(user_id, name, email, password_hash, roles, date_created, date_updated, date_verified)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err := tx.ExecContext(
+		ctx, q,
+		u.ID, u.Name, u.Email,
+		u.PasswordHash, u.Roles,
+		u.DateCreated, u.DateUpdated, u.DateVerified,
+	)
+	if err != nil {
+		return errors.Wrap(err, "inserting users")
+	}
+
+	return events.Record(ctx, tx, events.UserRegistered, u.ID, u, now)
+}
+
+// issue stores a new verification token for the user and emails the link
+// made of it. Only the hash of the token is kept.
+func issue(ctx context.Context, tx sqlx.ExtContext, userID, link string, now time.Time) error {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return errors.Wrap(err, "generating verification token")
+	}
+	token := hex.EncodeToString(b)
+	hash := tokenHash(token)
+	expires := now.Add(VerificationPeriod).UTC()
+
+	const q = `INSERT INTO email_verifications
+		(token_hash, user_id, date_created, date_expires)
+		VALUES ($1, $2, $3, $4)`
+	if _, err := tx.ExecContext(ctx, q, hash, userID, now.UTC(), expires); err != nil {
+		return errors.Wrap(err, "inserting verification")
+	}
+
+	nn := notify.NewNotification{
+		UserID:    userID,
+		Event:     notify.EventVerifyEmail,
+		DedupeKey: "verify:" + hash,
+		Data: map[string]interface{}{
+			"Link":    link + "?token=" + token,
+			"Expires": expires,
+		},
+	}
+	if _, err := notify.Enqueue(ctx, tx, nn, now); err != nil {
+		return errors.Wrap(err, "queueing verification")
+	}
+
+	return nil
+}
+
+// tokenHash returns the value of a verification token stored in the database.
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,103 @@
+package users_test
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+)
+
+// TestRegister validates patrons can open their own account once they
+// confirmed their email address.
+func TestRegister(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to let patrons register by themselves.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+		link := "http://localhost:3000/v1/users/verify"
+
+		nr := users.NewRegistration{
+			Name:            "Jane Doe",
+			Email:           "jane@example.com",
+			Password:        "gophers1",
+			PasswordConfirm: "gophers1",
+		}
+
+		// token extracts the token of the latest verification email.
+		token := func() string {
+			var body string
+			const q = `SELECT body_text FROM notifications WHERE event = 'verify_email' ORDER BY date_created DESC LIMIT 1`
+			if err := db.GetContext(ctx, &body, q); err != nil {
+				t.Fatalf("\t%s\tShould have queued the verification email : %s.", tests.Failed, err)
+			}
+			m := regexp.MustCompile(`\?token=([0-9a-f]+)`).FindStringSubmatch(body)
+			if m == nil {
+				t.Fatalf("\t%s\tShould put the link in the email : %s.", tests.Failed, body)
+			}
+			return m[1]
+		}
+
+		t.Log("\tWhen a patron registers.")
+		{
+			u, err := users.Register(ctx, db, nr, link, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to register : %s.", tests.Failed, err)
+			}
+			if len(u.Roles) != 1 || u.Roles[0] != auth.RoleUser || u.DateVerified != nil {
+				t.Fatalf("\t%s\tShould create an unverified patron : %+v.", tests.Failed, u)
+			}
+			t.Logf("\t%s\tShould create an unverified patron.", tests.Success)
+
+			if _, err := users.Register(ctx, db, nr, link, now); err != users.ErrEmailTaken {
+				t.Fatalf("\t%s\tShould not register the same email twice : %v.", tests.Failed, err)
+			}
+			if _, err := users.Authenticate(ctx, db, now, nr.Email, nr.Password, ""); err != users.ErrNotVerified {
+				t.Fatalf("\t%s\tShould not sign in before verifying : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould not sign in before verifying.", tests.Success)
+		}
+
+		t.Log("\tWhen the patron asks for more links.")
+		{
+			for i := 1; i < users.MaxVerifications; i++ {
+				if err := users.Resend(ctx, db, nr.Email, link, now.Add(time.Duration(i)*time.Minute)); err != nil {
+					t.Fatalf("\t%s\tShould be able to resend the link : %s.", tests.Failed, err)
+				}
+			}
+			if err := users.Resend(ctx, db, nr.Email, link, now.Add(time.Hour-time.Second)); err != users.ErrTooManyRequests {
+				t.Fatalf("\t%s\tShould limit the links sent : %v.", tests.Failed, err)
+			}
+			if err := users.Resend(ctx, db, "nobody@example.com", link, now); err != nil {
+				t.Fatalf("\t%s\tShould not tell the account does not exist : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould limit the links sent.", tests.Success)
+		}
+
+		t.Log("\tWhen the patron follows the link.")
+		{
+			tk := token()
+
+			if err := users.Verify(ctx, db, tk, now.Add(users.VerificationPeriod+time.Hour)); err != users.ErrInvalidToken {
+				t.Fatalf("\t%s\tShould reject an expired link : %v.", tests.Failed, err)
+			}
+			if err := users.Verify(ctx, db, tk, now.Add(time.Hour)); err != nil {
+				t.Fatalf("\t%s\tShould be able to verify : %s.", tests.Failed, err)
+			}
+			if err := users.Verify(ctx, db, tk, now.Add(time.Hour)); err != users.ErrInvalidToken {
+				t.Fatalf("\t%s\tShould not use a link twice : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould use the link only once.", tests.Success)
+
+			if _, err := users.Authenticate(ctx, db, now, nr.Email, nr.Password, ""); err != nil {
+				t.Fatalf("\t%s\tShould sign in once verified : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould sign in once verified.", tests.Success)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/book-library/internal/notify"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ResetPeriod is how long the link sent to reset a password can be used.
+const ResetPeriod = time.Hour
+
+// At most MaxResets links are sent to an account during ResetWindow. Further
+// requests are ignored without telling the caller, like requests for unknown
+// email addresses.
+const (
+	MaxResets   = 3
+	ResetWindow = time.Hour
+)
+
+// Forgot emails a link to reset the password of the account. Nothing is sent,
+// and no error returned, for unknown addresses so the endpoint does not tell
+// which accounts exist. link is the address of the page the token is appended
+// to.
+func Forgot(ctx context.Context, db *sqlx.DB, email, link string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.users.Forgot")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var userID string
+	const qu = `SELECT user_id FROM users WHERE email = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &userID, qu, email); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return errors.Wrap(err, "selecting user")
+	}
+
+	var sent int
+	const qc = `SELECT COUNT(*) FROM password_resets WHERE user_id = $1 AND date_created > $2`
+	if err := tx.GetContext(ctx, &sent, qc, userID, now.Add(-ResetWindow).UTC()); err != nil {
+		return errors.Wrap(err, "counting password resets")
+	}
+	if sent >= MaxResets {
+		return nil
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return errors.Wrap(err, "generating reset token")
+	}
+	token := hex.EncodeToString(b)
+	hash := tokenHash(token)
+	expires := now.Add(ResetPeriod).UTC()
+
+	const q = `INSERT INTO password_resets
+		(token_hash, user_id, date_created, date_expires)
+		VALUES ($1, $2, $3, $4)`
+	if _, err := tx.ExecContext(ctx, q, hash, userID, now.UTC(), expires); err != nil {
+		return errors.Wrap(err, "inserting password reset")
+	}
+
+	nn := notify.NewNotification{
+		UserID:    userID,
+		Event:     notify.EventPasswordReset,
+		DedupeKey: "reset:" + hash,
+		Data: map[string]interface{}{
+			"Link":    link + "?token=" + token,
+			"Expires": expires,
+		},
+	}
+	if _, err := notify.Enqueue(ctx, tx, nn, now); err != nil {
+		return errors.Wrap(err, "queueing password reset")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing password reset")
+	}
+
+	return nil
+}
+
+// Reset replaces the password of the account the token was sent to. Every
+// reset link of the account stops working and every session is revoked, so
+// whoever knew the previous password is signed out. Since the link was
+// received by email, the email address of the account is verified too.
+func Reset(ctx context.Context, db *sqlx.DB, pr PasswordReset, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.users.Reset")
+	defer span.End()
+
+	pw, err := bcrypt.GenerateFromPassword([]byte(pr.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.Wrap(err, "generating password hash")
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var userID string
+	const qr = `UPDATE password_resets SET date_used = $2
+		WHERE token_hash = $1 AND date_used IS NULL AND date_expires > $2
+		RETURNING user_id`
+	if err := tx.GetContext(ctx, &userID, qr, tokenHash(pr.Token), now.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInvalidToken
+		}
+		return errors.Wrap(err, "using password reset")
+	}
+
+	const qu = `UPDATE users SET
+		"password_hash" = $2,
+		"date_updated" = $3,
+		"date_verified" = COALESCE(date_verified, $3)
+		WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, qu, userID, pw, now.UTC()); err != nil {
+		return errors.Wrap(err, "updating password")
+	}
+
+	const qo = `UPDATE password_resets SET date_used = $2
+		WHERE user_id = $1 AND date_used IS NULL`
+	if _, err := tx.ExecContext(ctx, qo, userID, now.UTC()); err != nil {
+		return errors.Wrap(err, "closing other password resets")
+	}
+
+	if err := RevokeSessions(ctx, tx, userID, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing password reset")
+	}
+
+	return nil
+}
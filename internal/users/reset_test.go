@@ -0,0 +1,96 @@
+package users_test
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+)
+
+// TestReset validates users can choose a new password with the link emailed
+// to them.
+func TestReset(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to reset forgotten passwords.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+		link := "http://localhost:4200/users/reset-password"
+
+		nu := users.NewUser{
+			Name:            "Jane Doe",
+			Email:           "jane@example.com",
+			Roles:           []string{auth.RoleUser},
+			Password:        "gophers",
+			PasswordConfirm: "gophers",
+		}
+		u, err := users.Create(ctx, db, nu, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+		}
+		if _, _, err := users.StartSession(ctx, db, u.ID, users.Device{}, now); err != nil {
+			t.Fatalf("\t%s\tShould be able to sign in : %s.", tests.Failed, err)
+		}
+
+		t.Log("\tWhen a user forgets their password.")
+		{
+			if err := users.Forgot(ctx, db, "nobody@example.com", link, now); err != nil {
+				t.Fatalf("\t%s\tShould not tell the account does not exist : %v.", tests.Failed, err)
+			}
+			for i := 0; i < users.MaxResets+1; i++ {
+				if err := users.Forgot(ctx, db, nu.Email, link, now); err != nil {
+					t.Fatalf("\t%s\tShould be able to ask for a link : %s.", tests.Failed, err)
+				}
+			}
+
+			var sent int
+			const q = `SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND event = 'password_reset'`
+			if err := db.GetContext(ctx, &sent, q, u.ID); err != nil || sent != users.MaxResets {
+				t.Fatalf("\t%s\tShould limit the links sent : %d, %v.", tests.Failed, sent, err)
+			}
+			t.Logf("\t%s\tShould limit the links sent.", tests.Success)
+		}
+
+		t.Log("\tWhen the user follows the link.")
+		{
+			var body string
+			const q = `SELECT body_text FROM notifications WHERE user_id = $1 AND event = 'password_reset' LIMIT 1`
+			if err := db.GetContext(ctx, &body, q, u.ID); err != nil {
+				t.Fatalf("\t%s\tShould have queued the reset email : %s.", tests.Failed, err)
+			}
+			m := regexp.MustCompile(`\?token=([0-9a-f]+)`).FindStringSubmatch(body)
+			if m == nil {
+				t.Fatalf("\t%s\tShould put the link in the email : %s.", tests.Failed, body)
+			}
+
+			pr := users.PasswordReset{Token: m[1], Password: "new gophers", PasswordConfirm: "new gophers"}
+			if err := users.Reset(ctx, db, pr, now.Add(users.ResetPeriod+time.Minute)); err != users.ErrInvalidToken {
+				t.Fatalf("\t%s\tShould reject an expired link : %v.", tests.Failed, err)
+			}
+			if err := users.Reset(ctx, db, pr, now.Add(time.Minute)); err != nil {
+				t.Fatalf("\t%s\tShould be able to reset the password : %s.", tests.Failed, err)
+			}
+			if err := users.Reset(ctx, db, pr, now.Add(time.Minute)); err != users.ErrInvalidToken {
+				t.Fatalf("\t%s\tShould not use a link twice : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould use the link only once.", tests.Success)
+
+			var sessions int
+			if err := db.GetContext(ctx, &sessions, `SELECT COUNT(*) FROM sessions WHERE user_id = $1 AND date_revoked IS NULL`, u.ID); err != nil || sessions != 0 {
+				t.Fatalf("\t%s\tShould revoke the sessions : %d, %v.", tests.Failed, sessions, err)
+			}
+			if _, err := users.Authenticate(ctx, db, now, nu.Email, nu.Password, ""); err != users.ErrAuthenticationFailure {
+				t.Fatalf("\t%s\tShould not accept the previous password : %v.", tests.Failed, err)
+			}
+			if _, err := users.Authenticate(ctx, db, now, nu.Email, pr.Password, ""); err != nil {
+				t.Fatalf("\t%s\tShould accept the new password : %s.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould sign the user out and accept the new password only.", tests.Success)
+		}
+	}
+}
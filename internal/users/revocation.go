@@ -0,0 +1,173 @@
+package users
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// maxCached bounds how many tokens Revocations remembers before it forgets
+// the ones that expired.
+const maxCached = 10000
+
+// Revocations checks access tokens against the revocations stored in the
+// database. A token is revoked when:
+//
+//   - it was revoked on its own with RevokeToken
+//   - its session was revoked by signing out or by a reused refresh token
+//   - it was issued before every token of its user was revoked
+//
+// Answers are cached so every request does not hit the database: a revoked
+// token stays revoked until it expires, a token found valid is checked again
+// after the cache TTL. A revocation can take up to the TTL to be enforced.
+type Revocations struct {
+	db  *sqlx.DB
+	ttl time.Duration
+
+	mu    sync.Mutex
+	cache map[string]revocation
+}
+
+// revocation is the cached answer for a token.
+type revocation struct {
+	revoked bool
+	until   time.Time
+}
+
+// NewRevocations constructs a Revocations caching its answers for ttl.
+func NewRevocations(db *sqlx.DB, ttl time.Duration) *Revocations {
+	return &Revocations{
+		db:    db,
+		ttl:   ttl,
+		cache: make(map[string]revocation),
+	}
+}
+
+// Revoked reports whether the token of the claims was revoked. Its signature
+// matches auth.RevocationFunc.
+func (r *Revocations) Revoked(ctx context.Context, claims auth.Claims, now time.Time) (bool, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.users.Revoked")
+	defer span.End()
+
+	key := claims.Id
+	if key == "" {
+		key = claims.Subject + "/" + time.Unix(claims.IssuedAt, 0).String()
+	}
+
+	r.mu.Lock()
+	c, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && now.Before(c.until) {
+		return c.revoked, nil
+	}
+
+	// Identifiers which are not uuids are passed as NULL, they cannot match.
+	var family, user *string
+	if _, err := uuid.Parse(claims.SessionID); err == nil {
+		family = &claims.SessionID
+	}
+	if _, err := uuid.Parse(claims.Subject); err == nil {
+		user = &claims.Subject
+	}
+
+	// Revocations of every token are stored to the second, a token issued
+	// in that same second is revoked too.
+	var revoked bool
+	const q = `SELECT
+		EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+		OR EXISTS (SELECT 1 FROM sessions WHERE family_id = $2 AND date_revoked IS NOT NULL)
+		OR EXISTS (SELECT 1 FROM token_revocations WHERE user_id = $3 AND date_revoked >= $4)`
+	err := r.db.GetContext(ctx, &revoked, q, claims.Id, family, user, time.Unix(claims.IssuedAt, 0).UTC())
+	if err != nil {
+		return false, errors.Wrap(err, "selecting revocations")
+	}
+
+	c = revocation{revoked: revoked, until: now.Add(r.ttl)}
+	if expires := time.Unix(claims.ExpiresAt, 0); revoked || expires.Before(c.until) {
+		c.until = expires
+	}
+
+	r.mu.Lock()
+	if len(r.cache) >= maxCached {
+		for k, v := range r.cache {
+			if !now.Before(v.until) {
+				delete(r.cache, k)
+			}
+		}
+	}
+	r.cache[key] = c
+	r.mu.Unlock()
+
+	return revoked, nil
+}
+
+// RevokeToken revokes the access token of the claims until it expires. The
+// tokens which already expired are forgotten on the way.
+func RevokeToken(ctx context.Context, db *sqlx.DB, claims auth.Claims, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.users.RevokeToken")
+	defer span.End()
+
+	if claims.Id == "" {
+		return ErrInvalidID
+	}
+
+	const qd = `DELETE FROM revoked_tokens WHERE date_expires < $1`
+	if _, err := db.ExecContext(ctx, qd, now.UTC()); err != nil {
+		return errors.Wrap(err, "deleting expired revocations")
+	}
+
+	const q = `INSERT INTO revoked_tokens (jti, user_id, date_expires) VALUES ($1, $2, $3)
+		ON CONFLICT (jti) DO NOTHING`
+	_, err := db.ExecContext(ctx, q, claims.Id, claims.Subject, time.Unix(claims.ExpiresAt, 0).UTC())
+	if err != nil {
+		return errors.Wrap(err, "revoking token")
+	}
+
+	return nil
+}
+
+// RevokeAll signs a user out of every device and revokes every access token
+// issued to them so far. Only admins can do it.
+func RevokeAll(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.users.RevokeAll")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrInvalidID
+	}
+
+	if !claims.HasPermission(auth.PermissionUsersManage) {
+		return ErrForbidden
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	const q = `SELECT EXISTS (SELECT 1 FROM users WHERE user_id = $1)`
+	if err := tx.GetContext(ctx, &exists, q, userID); err != nil {
+		return errors.Wrap(err, "selecting user")
+	}
+	if !exists {
+		return ErrNotFound
+	}
+
+	if err := RevokeSessions(ctx, tx, userID, now); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing revocation")
+	}
+
+	return nil
+}
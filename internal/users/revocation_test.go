@@ -0,0 +1,94 @@
+package users_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+)
+
+// TestRevocations validates access tokens can be revoked before they expire.
+func TestRevocations(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to revoke access tokens.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+		admin := auth.NewClaims(
+			"718ffbea-f4a1-4667-8ae3-b349da52675e",
+			[]string{auth.RoleAdmin, auth.RoleUser},
+			now, time.Hour, "",
+		)
+
+		u, err := users.Create(ctx, db, users.NewUser{Name: "Jane Doe", Email: "jane@example.com", Roles: []string{auth.RoleUser}, Password: "gophers"}, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+		}
+
+		// A cache of zero checks the database every time.
+		revocations := users.NewRevocations(db, 0)
+
+		t.Log("\tWhen a token is revoked on its own.")
+		{
+			claims := auth.NewClaims(u.ID, []string{auth.RoleUser}, now, time.Hour, "")
+			other := auth.NewClaims(u.ID, []string{auth.RoleUser}, now, time.Hour, "")
+
+			if revoked, err := revocations.Revoked(ctx, claims, now); err != nil || revoked {
+				t.Fatalf("\t%s\tShould accept a fresh token : %v, %v.", tests.Failed, revoked, err)
+			}
+			if err := users.RevokeToken(ctx, db, claims, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to revoke the token : %s.", tests.Failed, err)
+			}
+			if revoked, err := revocations.Revoked(ctx, claims, now); err != nil || !revoked {
+				t.Fatalf("\t%s\tShould reject the revoked token : %v, %v.", tests.Failed, revoked, err)
+			}
+			if revoked, err := revocations.Revoked(ctx, other, now); err != nil || revoked {
+				t.Fatalf("\t%s\tShould accept the other tokens : %v, %v.", tests.Failed, revoked, err)
+			}
+			t.Logf("\t%s\tShould only reject the revoked token.", tests.Success)
+		}
+
+		t.Log("\tWhen the session of a token is signed out.")
+		{
+			refresh, s, err := users.StartSession(ctx, db, u.ID, users.Device{}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to start a session : %s.", tests.Failed, err)
+			}
+			claims := auth.NewClaims(u.ID, []string{auth.RoleUser}, now, time.Hour, "")
+			claims.SessionID = s.FamilyID
+
+			if err := users.Logout(ctx, db, u.ID, refresh, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to logout : %s.", tests.Failed, err)
+			}
+			if revoked, err := revocations.Revoked(ctx, claims, now); err != nil || !revoked {
+				t.Fatalf("\t%s\tShould reject the token of the session : %v, %v.", tests.Failed, revoked, err)
+			}
+			t.Logf("\t%s\tShould reject the token of the session.", tests.Success)
+		}
+
+		t.Log("\tWhen every token of a user is revoked.")
+		{
+			claims := auth.NewClaims(u.ID, []string{auth.RoleUser}, now, time.Hour, "")
+
+			if err := users.RevokeAll(ctx, claims, db, u.ID, now.Add(time.Minute)); err != users.ErrForbidden {
+				t.Fatalf("\t%s\tShould only let admins revoke every token : %v.", tests.Failed, err)
+			}
+			if err := users.RevokeAll(ctx, admin, db, u.ID, now.Add(time.Minute)); err != nil {
+				t.Fatalf("\t%s\tShould be able to revoke every token : %s.", tests.Failed, err)
+			}
+			if revoked, err := revocations.Revoked(ctx, claims, now.Add(time.Minute)); err != nil || !revoked {
+				t.Fatalf("\t%s\tShould reject the tokens issued before : %v, %v.", tests.Failed, revoked, err)
+			}
+
+			later := auth.NewClaims(u.ID, []string{auth.RoleUser}, now.Add(2*time.Minute), time.Hour, "")
+			if revoked, err := revocations.Revoked(ctx, later, now.Add(2*time.Minute)); err != nil || revoked {
+				t.Fatalf("\t%s\tShould accept the tokens issued after : %v, %v.", tests.Failed, revoked, err)
+			}
+			t.Logf("\t%s\tShould reject the tokens issued before the revocation.", tests.Success)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/utils"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// AccessPeriod is how long the JWT handed to clients can be used. RefreshPeriod
+// is how long a session stays open without being refreshed.
+const (
+	AccessPeriod  = 15 * time.Minute
+	RefreshPeriod = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrInvalidRefresh occurs when a refresh token is unknown, revoked or
+	// expired.
+	ErrInvalidRefresh = errors.New("Refresh token is invalid or expired")
+
+	// ErrRefreshReused occurs when a refresh token is used a second time. The
+	// token was probably stolen so every session of its family is revoked.
+	ErrRefreshReused = errors.New("Refresh token was already used, the session is revoked")
+)
+
+// StartSession opens a new session for a user who just signed in. It returns
+// the refresh token of the session, which is only ever known by the client.
+func StartSession(ctx context.Context, db sqlx.ExtContext, userID string, d Device, now time.Time) (string, *Session, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.users.StartSession")
+	defer span.End()
+
+	return openSession(ctx, db, uuid.New().String(), userID, d, now)
+}
+
+// RefreshSession rotates the session of a refresh token: it returns the claims of a
+// new access token and the refresh token replacing the one given. Using a
+// refresh token twice revokes every session of its family, the legitimate
+// client and whoever copied the token both have to sign in again.
+func RefreshSession(ctx context.Context, db *sqlx.DB, token string, d Device, now time.Time) (auth.Claims, string, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.users.RefreshSession")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return auth.Claims{}, "", errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var s Session
+	const qs = `SELECT session_id, family_id, user_id, token, user_agent, ip, date_created, expiry, date_rotated, date_revoked
+		FROM sessions WHERE token = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &s, qs, tokenHash(token)); err != nil {
+		if err == sql.ErrNoRows {
+			return auth.Claims{}, "", ErrInvalidRefresh
+		}
+		return auth.Claims{}, "", errors.Wrap(err, "selecting session")
+	}
+
+	if s.DateRevoked != nil || !s.Expiry.After(now.UTC()) {
+		return auth.Claims{}, "", ErrInvalidRefresh
+	}
+
+	if s.DateRotated != nil {
+		const qr = `UPDATE sessions SET date_revoked = $2 WHERE family_id = $1 AND date_revoked IS NULL`
+		if _, err := tx.ExecContext(ctx, qr, s.FamilyID, now.UTC()); err != nil {
+			return auth.Claims{}, "", errors.Wrap(err, "revoking session family")
+		}
+		if err := tx.Commit(); err != nil {
+			return auth.Claims{}, "", errors.Wrap(err, "committing revocation")
+		}
+		return auth.Claims{}, "", ErrRefreshReused
+	}
+
+	const qu = `UPDATE sessions SET date_rotated = $2 WHERE session_id = $1`
+	if _, err := tx.ExecContext(ctx, qu, s.ID, now.UTC()); err != nil {
+		return auth.Claims{}, "", errors.Wrap(err, "rotating session")
+	}
+
+	next, _, err := openSession(ctx, tx, s.FamilyID, s.UserID, d, now)
+	if err != nil {
+		return auth.Claims{}, "", err
+	}
+
+	var roles pq.StringArray
+	const qr = `SELECT roles FROM users WHERE user_id = $1`
+	if err := tx.GetContext(ctx, &roles, qr, s.UserID); err != nil {
+		if err == sql.ErrNoRows {
+			return auth.Claims{}, "", ErrInvalidRefresh
+		}
+		return auth.Claims{}, "", errors.Wrap(err, "selecting roles")
+	}
+
+	csrf, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return auth.Claims{}, "", ErrGenerationFailure
+	}
+
+	if err := tx.Commit(); err != nil {
+		return auth.Claims{}, "", errors.Wrap(err, "committing session")
+	}
+
+	claims := auth.NewClaims(s.UserID, roles, now, AccessPeriod, csrf)
+	claims.SessionID = s.FamilyID
+
+	return claims, next, nil
+}
+
+// Logout revokes the session of a refresh token along with the sessions it
+// was rotated from, so the device is signed out.
+func Logout(ctx context.Context, db *sqlx.DB, userID, token string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.users.Logout")
+	defer span.End()
+
+	const q = `UPDATE sessions SET date_revoked = $3
+		WHERE date_revoked IS NULL AND family_id = (
+			SELECT family_id FROM sessions WHERE token = $1 AND user_id = $2
+		)`
+	res, err := db.ExecContext(ctx, q, tokenHash(token), userID, now.UTC())
+	if err != nil {
+		return errors.Wrap(err, "revoking session")
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "counting revoked sessions")
+	}
+	if n == 0 {
+		return ErrInvalidRefresh
+	}
+
+	return nil
+}
+
+// RevokeSessions signs the user out of every device, the access tokens issued
+// so far and the personal access tokens are revoked too.
+//
+// db can be a *sqlx.DB or a *sqlx.Tx so the sessions are only revoked when
+// the surrounding transaction commits.
+func RevokeSessions(ctx context.Context, db sqlx.ExecerContext, userID string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.users.RevokeSessions")
+	defer span.End()
+
+	const q = `UPDATE sessions SET date_revoked = $2 WHERE user_id = $1 AND date_revoked IS NULL`
+	if _, err := db.ExecContext(ctx, q, userID, now.UTC()); err != nil {
+		return errors.Wrap(err, "revoking sessions")
+	}
+
+	// The access tokens already handed out are revoked along with the sessions.
+	const qt = `INSERT INTO token_revocations (user_id, date_revoked) VALUES ($1, date_trunc('second', $2::timestamp))
+		ON CONFLICT (user_id) DO UPDATE SET date_revoked = EXCLUDED.date_revoked`
+	if _, err := db.ExecContext(ctx, qt, userID, now.UTC()); err != nil {
+		return errors.Wrap(err, "revoking tokens")
+	}
+
+	// So are the personal access tokens of the user.
+	const qa = `UPDATE access_tokens SET date_revoked = $2 WHERE user_id = $1 AND date_revoked IS NULL`
+	if _, err := db.ExecContext(ctx, qa, userID, now.UTC()); err != nil {
+		return errors.Wrap(err, "revoking access tokens")
+	}
+
+	return nil
+}
+
+// Sessions retrieves the devices signed in to an account.
+func Sessions(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string, now time.Time) ([]Session, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.users.Sessions")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	// If you are not an admin and looking to retrieve someone else then you are rejected.
+	if !claims.HasPermission(auth.PermissionUsersManage) && claims.Subject != userID {
+		return nil, ErrForbidden
+	}
+
+	sessions := []Session{}
+	const q = `SELECT session_id, family_id, user_id, token, user_agent, ip, date_created, expiry, date_rotated, date_revoked
+		FROM sessions
+		WHERE user_id = $1 AND date_rotated IS NULL AND date_revoked IS NULL AND expiry > $2
+		ORDER BY date_created DESC`
+	if err := db.SelectContext(ctx, &sessions, q, userID, now.UTC()); err != nil {
+		return nil, errors.Wrap(err, "selecting sessions")
+	}
+
+	return sessions, nil
+}
+
+// openSession stores a session of the family and returns its refresh token.
+func openSession(ctx context.Context, db sqlx.ExtContext, familyID, userID string, d Device, now time.Time) (string, *Session, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, errors.Wrap(err, "generating refresh token")
+	}
+	token := hex.EncodeToString(b)
+
+	s := Session{
+		ID:          uuid.New().String(),
+		FamilyID:    familyID,
+		UserID:      userID,
+		TokenHash:   tokenHash(token),
+		UserAgent:   d.UserAgent,
+		IP:          d.IP,
+		DateCreated: now.UTC(),
+		Expiry:      now.Add(RefreshPeriod).UTC(),
+	}
+
+	const q = `INSERT INTO sessions
+		(token, data, expiry, session_id, family_id, user_id, user_agent, ip, date_created)
+		VALUES ($1, '', $2, $3, $4, $5, $6, $7, $8)`
+	_, err := db.ExecContext(ctx, q,
+		s.TokenHash, s.Expiry, s.ID, s.FamilyID, s.UserID,
+		s.UserAgent, s.IP, s.DateCreated,
+	)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "inserting session")
+	}
+
+	return token, &s, nil
+}
@@ -0,0 +1,84 @@
+package users_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+)
+
+// TestSession validates refresh tokens are rotated and can not be reused.
+func TestSession(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to keep users signed in with refresh tokens.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+		d := users.Device{UserAgent: "test", IP: "127.0.0.1"}
+
+		u, err := users.Create(ctx, db, users.NewUser{Name: "Jane Doe", Email: "jane@example.com", Roles: []string{auth.RoleUser}, Password: "gophers"}, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+		}
+		claims := auth.NewClaims(u.ID, []string{auth.RoleUser}, now, time.Hour, "")
+
+		first, _, err := users.StartSession(ctx, db, u.ID, d, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to start a session : %s.", tests.Failed, err)
+		}
+
+		t.Log("\tWhen the client refreshes its session.")
+		{
+			c, second, err := users.RefreshSession(ctx, db, first, d, now.Add(time.Hour))
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to refresh : %s.", tests.Failed, err)
+			}
+			if c.Subject != u.ID || second == first || c.ExpiresAt != now.Add(time.Hour+users.AccessPeriod).Unix() {
+				t.Fatalf("\t%s\tShould issue short lived claims and a new refresh token : %+v.", tests.Failed, c)
+			}
+			t.Logf("\t%s\tShould rotate the refresh token.", tests.Success)
+
+			sessions, err := users.Sessions(ctx, claims, db, u.ID, now.Add(time.Hour))
+			if err != nil || len(sessions) != 1 || sessions[0].UserAgent != "test" {
+				t.Fatalf("\t%s\tShould list the device once : %+v, %v.", tests.Failed, sessions, err)
+			}
+			t.Logf("\t%s\tShould list the device once.", tests.Success)
+
+			if _, _, err := users.RefreshSession(ctx, db, first, d, now.Add(2*time.Hour)); err != users.ErrRefreshReused {
+				t.Fatalf("\t%s\tShould detect the reuse of a refresh token : %v.", tests.Failed, err)
+			}
+			if _, _, err := users.RefreshSession(ctx, db, second, d, now.Add(2*time.Hour)); err != users.ErrInvalidRefresh {
+				t.Fatalf("\t%s\tShould revoke the whole family : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould revoke the whole family once a token is reused.", tests.Success)
+		}
+
+		t.Log("\tWhen the client signs out.")
+		{
+			token, _, err := users.StartSession(ctx, db, u.ID, d, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to start a session : %s.", tests.Failed, err)
+			}
+			if err := users.Logout(ctx, db, u.ID, token, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to sign out : %s.", tests.Failed, err)
+			}
+			if _, _, err := users.RefreshSession(ctx, db, token, d, now); err != users.ErrInvalidRefresh {
+				t.Fatalf("\t%s\tShould not refresh a revoked session : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould not refresh a revoked session.", tests.Success)
+
+			token, _, err = users.StartSession(ctx, db, u.ID, d, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to start a session : %s.", tests.Failed, err)
+			}
+			if _, _, err := users.RefreshSession(ctx, db, token, d, now.Add(users.RefreshPeriod+time.Minute)); err != users.ErrInvalidRefresh {
+				t.Fatalf("\t%s\tShould not refresh an expired session : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould not refresh an expired session.", tests.Success)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/utils"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SSOPeriod is how long a user has to sign in with the identity provider once
+// they were sent to it.
+const SSOPeriod = 10 * time.Minute
+
+var (
+	// ErrUnverifiedIdentity occurs when the identity provider does not vouch
+	// for the email address of a user signing in for the first time, so the
+	// account can not be found or created.
+	ErrUnverifiedIdentity = errors.New("Email address is not verified by the identity provider")
+)
+
+// StartSSO keeps what is needed to finish a sign in with the identity
+// provider: the state identifying the sign in, the nonce expected in the ID
+// token and the PKCE verifier of the code. The sign ins which were never
+// finished are deleted on the way.
+func StartSSO(ctx context.Context, db *sqlx.DB, state, nonce, verifier string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.users.StartSSO")
+	defer span.End()
+
+	const qd = `DELETE FROM sso_logins WHERE date_expires < $1`
+	if _, err := db.ExecContext(ctx, qd, now.UTC()); err != nil {
+		return errors.Wrap(err, "deleting expired sign ins")
+	}
+
+	const q = `INSERT INTO sso_logins
+		(state_hash, nonce, code_verifier, date_created, date_expires)
+		VALUES ($1, $2, $3, $4, $5)`
+	_, err := db.ExecContext(ctx, q, tokenHash(state), nonce, verifier, now.UTC(), now.Add(SSOPeriod).UTC())
+	if err != nil {
+		return errors.Wrap(err, "inserting sign in")
+	}
+
+	return nil
+}
+
+// FinishSSO retrieves the sign in of a state sent back by the identity
+// provider. A state can only be used once.
+func FinishSSO(ctx context.Context, db *sqlx.DB, state string, now time.Time) (*SSOLogin, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.users.FinishSSO")
+	defer span.End()
+
+	var l SSOLogin
+	const q = `DELETE FROM sso_logins WHERE state_hash = $1
+		RETURNING nonce, code_verifier, date_expires`
+	if err := db.GetContext(ctx, &l, q, tokenHash(state)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidToken
+		}
+		return nil, errors.Wrap(err, "using sign in")
+	}
+
+	if !l.DateExpires.After(now.UTC()) {
+		return nil, ErrInvalidToken
+	}
+
+	return &l, nil
+}
+
+// SignInExternal signs in a user authenticated by the identity provider. The
+// first time, the identity is linked to the account with the same email
+// address, or a new account is created for it. Either way the provider must
+// have verified the email address.
+//
+// The roles of accounts created this way follow the roles mapped from the
+// provider at every sign in. Accounts which existed before keep the roles
+// they were given here.
+func SignInExternal(ctx context.Context, db *sqlx.DB, ei ExternalIdentity, now time.Time) (auth.Claims, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.users.SignInExternal")
+	defer span.End()
+
+	roles := pq.StringArray{auth.RoleUser}
+	for _, r := range ei.Roles {
+		if r != auth.RoleUser {
+			roles = append(roles, r)
+		}
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return auth.Claims{}, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	var id Identity
+	const qi = `SELECT * FROM user_identities WHERE issuer = $1 AND subject = $2 FOR UPDATE`
+	err = tx.GetContext(ctx, &id, qi, ei.Issuer, ei.Subject)
+	switch err {
+	case nil:
+		if id.Provisioned {
+			const qr = `UPDATE users SET roles = $2, date_updated = $3 WHERE user_id = $1 AND roles <> $2`
+			if _, err := tx.ExecContext(ctx, qr, id.UserID, roles, now.UTC()); err != nil {
+				return auth.Claims{}, errors.Wrap(err, "updating roles")
+			}
+		}
+
+		const qu = `UPDATE user_identities SET email = $3, date_last_login = $4
+			WHERE issuer = $1 AND subject = $2`
+		if _, err := tx.ExecContext(ctx, qu, ei.Issuer, ei.Subject, ei.Email, now.UTC()); err != nil {
+			return auth.Claims{}, errors.Wrap(err, "updating identity")
+		}
+
+	case sql.ErrNoRows:
+		id, err = link(ctx, tx, ei, roles, now)
+		if err != nil {
+			return auth.Claims{}, err
+		}
+
+	default:
+		return auth.Claims{}, errors.Wrap(err, "selecting identity")
+	}
+
+	var u User
+	const q = `SELECT * FROM users WHERE user_id = $1`
+	if err := tx.GetContext(ctx, &u, q, id.UserID); err != nil {
+		return auth.Claims{}, errors.Wrap(err, "selecting user")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return auth.Claims{}, errors.Wrap(err, "committing sign in")
+	}
+
+	csrf, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return auth.Claims{}, ErrGenerationFailure
+	}
+
+	return auth.NewClaims(u.ID, u.Roles, now, AccessPeriod, csrf), nil
+}
+
+// Identities retrieves the identity provider accounts linked to an account.
+func Identities(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string) ([]Identity, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.users.Identities")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	// If you are not an admin and looking to retrieve someone else then you are rejected.
+	if !claims.HasPermission(auth.PermissionUsersManage) && claims.Subject != userID {
+		return nil, ErrForbidden
+	}
+
+	identities := []Identity{}
+	const q = `SELECT * FROM user_identities WHERE user_id = $1 ORDER BY date_created`
+	if err := db.SelectContext(ctx, &identities, q, userID); err != nil {
+		return nil, errors.Wrap(err, "selecting identities")
+	}
+
+	return identities, nil
+}
+
+// link links an identity seen for the first time to the account of its email
+// address, creating the account when there is none.
+func link(ctx context.Context, tx *sqlx.Tx, ei ExternalIdentity, roles []string, now time.Time) (Identity, error) {
+	if !ei.EmailVerified || ei.Email == "" {
+		return Identity{}, ErrUnverifiedIdentity
+	}
+
+	t := now.UTC()
+	id := Identity{
+		Issuer:        ei.Issuer,
+		Subject:       ei.Subject,
+		Email:         ei.Email,
+		DateCreated:   t,
+		DateLastLogin: &t,
+	}
+
+	const qe = `SELECT user_id FROM users WHERE email = $1`
+	err := tx.GetContext(ctx, &id.UserID, qe, ei.Email)
+	switch err {
+	case nil:
+		// The provider vouches for the email address, a patron who never
+		// confirmed it does not need to anymore.
+		const qv = `UPDATE users SET date_verified = COALESCE(date_verified, $2) WHERE user_id = $1`
+		if _, err := tx.ExecContext(ctx, qv, id.UserID, t); err != nil {
+			return Identity{}, errors.Wrap(err, "verifying user")
+		}
+
+	case sql.ErrNoRows:
+		// Accounts created here are only signed in through the provider, the
+		// password nobody knows can still be reset by email.
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return Identity{}, errors.Wrap(err, "generating password")
+		}
+		hash, err := bcrypt.GenerateFromPassword(b, bcrypt.DefaultCost)
+		if err != nil {
+			return Identity{}, errors.Wrap(err, "generating password hash")
+		}
+
+		name := ei.Name
+		if name == "" {
+			name = ei.Email
+		}
+
+		u := User{
+			ID:           uuid.New().String(),
+			Name:         name,
+			Email:        ei.Email,
+			PasswordHash: hash,
+			Roles:        roles,
+			DateCreated:  t,
+			DateUpdated:  t,
+			DateVerified: &t,
+		}
+		if err := insert(ctx, tx, u, now); err != nil {
+			return Identity{}, err
+		}
+
+		id.UserID = u.ID
+		id.Provisioned = true
+
+	default:
+		return Identity{}, errors.Wrap(err, "selecting user")
+	}
+
+	const q = `INSERT INTO user_identities
+		(issuer, subject, user_id, email, provisioned, date_created, date_last_login)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err = tx.ExecContext(ctx, q,
+		id.Issuer, id.Subject, id.UserID, id.Email,
+		id.Provisioned, id.DateCreated, id.DateLastLogin,
+	)
+	if err != nil {
+		return Identity{}, errors.Wrap(err, "inserting identity")
+	}
+
+	return id, nil
+}
@@ -0,0 +1,108 @@
+package users_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+)
+
+// TestSSO validates accounts are linked or created for users signing in with
+// an identity provider.
+func TestSSO(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to sign users in with an identity provider.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+		const issuer = "https://idp.example.com"
+
+		t.Log("\tWhen a sign in is finished.")
+		{
+			if err := users.StartSSO(ctx, db, "state", "nonce", "verifier", now); err != nil {
+				t.Fatalf("\t%s\tShould be able to start a sign in : %s.", tests.Failed, err)
+			}
+
+			l, err := users.FinishSSO(ctx, db, "state", now.Add(time.Minute))
+			if err != nil || l.Nonce != "nonce" || l.Verifier != "verifier" {
+				t.Fatalf("\t%s\tShould retrieve the sign in : %+v, %v.", tests.Failed, l, err)
+			}
+			if _, err := users.FinishSSO(ctx, db, "state", now.Add(time.Minute)); err != users.ErrInvalidToken {
+				t.Fatalf("\t%s\tShould only finish a sign in once : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould only finish a sign in once.", tests.Success)
+
+			if err := users.StartSSO(ctx, db, "late", "nonce", "verifier", now); err != nil {
+				t.Fatalf("\t%s\tShould be able to start a sign in : %s.", tests.Failed, err)
+			}
+			if _, err := users.FinishSSO(ctx, db, "late", now.Add(users.SSOPeriod)); err != users.ErrInvalidToken {
+				t.Fatalf("\t%s\tShould not finish an expired sign in : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould not finish an expired sign in.", tests.Success)
+		}
+
+		t.Log("\tWhen the provider did not verify the email address.")
+		{
+			ei := users.ExternalIdentity{Issuer: issuer, Subject: "mallory", Email: "mallory@example.com"}
+			if _, err := users.SignInExternal(ctx, db, ei, now); err != users.ErrUnverifiedIdentity {
+				t.Fatalf("\t%s\tShould refuse the identity : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould refuse the identity.", tests.Success)
+		}
+
+		t.Log("\tWhen a new user signs in.")
+		{
+			ei := users.ExternalIdentity{
+				Issuer: issuer, Subject: "jane", Email: "jane@example.com", EmailVerified: true,
+				Name: "Jane Doe", Roles: []string{auth.RoleLibrarian},
+			}
+
+			claims, err := users.SignInExternal(ctx, db, ei, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to sign in : %s.", tests.Failed, err)
+			}
+			if !claims.HasRole(auth.RoleUser) || !claims.HasRole(auth.RoleLibrarian) {
+				t.Fatalf("\t%s\tShould map the roles of the provider : %v.", tests.Failed, claims.Roles)
+			}
+			t.Logf("\t%s\tShould create an account with the roles of the provider.", tests.Success)
+
+			ei.Roles = nil
+			again, err := users.SignInExternal(ctx, db, ei, now.Add(time.Hour))
+			if err != nil || again.Subject != claims.Subject {
+				t.Fatalf("\t%s\tShould sign in to the same account : %v.", tests.Failed, err)
+			}
+			if again.HasRole(auth.RoleLibrarian) {
+				t.Fatalf("\t%s\tShould follow the roles of the provider : %v.", tests.Failed, again.Roles)
+			}
+			t.Logf("\t%s\tShould sign in to the same account and follow the roles of the provider.", tests.Success)
+		}
+
+		t.Log("\tWhen a patron with an account signs in.")
+		{
+			u, err := users.Create(ctx, db, users.NewUser{Name: "Bill", Email: "bill@example.com", Roles: []string{auth.RoleAdmin, auth.RoleUser}, Password: "gophers"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+			}
+
+			ei := users.ExternalIdentity{Issuer: issuer, Subject: "bill", Email: "bill@example.com", EmailVerified: true}
+			claims, err := users.SignInExternal(ctx, db, ei, now)
+			if err != nil || claims.Subject != u.ID {
+				t.Fatalf("\t%s\tShould link the account of the email address : %v.", tests.Failed, err)
+			}
+			if !claims.HasRole(auth.RoleAdmin) {
+				t.Fatalf("\t%s\tShould keep the roles of the account : %v.", tests.Failed, claims.Roles)
+			}
+			t.Logf("\t%s\tShould link the account and keep its roles.", tests.Success)
+
+			identities, err := users.Identities(ctx, claims, db, u.ID)
+			if err != nil || len(identities) != 1 || identities[0].Subject != "bill" || identities[0].Provisioned {
+				t.Fatalf("\t%s\tShould list the linked identity : %+v, %v.", tests.Failed, identities, err)
+			}
+			t.Logf("\t%s\tShould list the linked identity.", tests.Success)
+		}
+	}
+}
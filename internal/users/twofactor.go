@@ -0,0 +1,672 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/book-library/internal/lockout"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/totp"
+	"github.com/book-library/internal/utils"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// TOTPIssuer names the service in authenticator apps.
+const TOTPIssuer = "Book Library"
+
+// ChallengePeriod is how long a user has to answer the challenge of a sign in,
+// with at most MaxChallengeAttempts codes.
+const (
+	ChallengePeriod      = 5 * time.Minute
+	MaxChallengeAttempts = 5
+)
+
+// Types of Challenge.
+const (
+	ChallengeTOTP   = "totp"
+	ChallengeEnroll = "enroll"
+)
+
+// recoveryCodeCount is how many recovery codes are generated at once.
+const recoveryCodeCount = 10
+
+// totpSkew is how many steps of 30 seconds a code is accepted before and after
+// its time, for the clocks of phones running late.
+const totpSkew = 1
+
+var (
+	// ErrTwoFactorEnabled occurs when enrolling an account which already
+	// signs in with an authenticator app.
+	ErrTwoFactorEnabled = errors.New("Two-factor authentication is already enabled")
+
+	// ErrTwoFactorDisabled occurs when a code is checked for an account
+	// without an authenticator app.
+	ErrTwoFactorDisabled = errors.New("Two-factor authentication is not enabled")
+
+	// ErrTwoFactorRequired occurs when a user tries to turn off two-factor
+	// authentication while the policy requires it for their roles.
+	ErrTwoFactorRequired = errors.New("Two-factor authentication is required for this account")
+
+	// ErrInvalidCode occurs when a code is wrong, was already used or
+	// expired.
+	ErrInvalidCode = errors.New("Code is invalid")
+
+	// ErrInvalidChallenge occurs when a challenge is unknown, answered, expired
+	// or failed too many times.
+	ErrInvalidChallenge = errors.New("Challenge is invalid or expired")
+)
+
+// TwoFactorRequired is the policy of two-factor authentication: users whose
+// roles grant managing accounts or roles can take over every account, they
+// can not sign in with only a password. The permissions of the claims have to
+// be resolved.
+func TwoFactorRequired(claims auth.Claims) bool {
+	return claims.HasPermission(auth.PermissionUsersManage, auth.PermissionRolesManage)
+}
+
+// twoFactorRequired applies the policy to roles with the permissions they
+// grant in the roles table, admins edit roles at any time.
+func twoFactorRequired(ctx context.Context, db sqlx.QueryerContext, roles []string) (bool, error) {
+	perms := []string{}
+	const q = `SELECT DISTINCT unnest(permissions) FROM roles WHERE name = ANY($1)`
+	if err := sqlx.SelectContext(ctx, db, &perms, q, pq.StringArray(roles)); err != nil {
+		return false, errors.Wrap(err, "selecting permissions")
+	}
+
+	return TwoFactorRequired(auth.Claims{Roles: roles, Permissions: perms}), nil
+}
+
+// secret is the authenticator app enrolled by an account. It is only used
+// once confirmed with a code.
+type secret struct {
+	Secret        string     `db:"secret"`
+	LastStep      int64      `db:"last_step"`
+	DateConfirmed *time.Time `db:"date_confirmed"`
+}
+
+// challenge is the stored state of a Challenge.
+type challenge struct {
+	UserID      string     `db:"user_id"`
+	Type        string     `db:"type"`
+	Attempts    int        `db:"attempts"`
+	DateExpires time.Time  `db:"date_expires"`
+	DateUsed    *time.Time `db:"date_used"`
+}
+
+// TwoFactorStatus tells whether an account uses two-factor authentication.
+func TwoFactorStatus(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string) (*TwoFactor, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.users.TwoFactorStatus")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	// If you are not an admin and looking to retrieve someone else then you are rejected.
+	if !claims.HasPermission(auth.PermissionUsersManage) && claims.Subject != userID {
+		return nil, ErrForbidden
+	}
+
+	var u User
+	if err := db.GetContext(ctx, &u, `SELECT * FROM users WHERE user_id = $1`, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting user")
+	}
+
+	required, err := twoFactorRequired(ctx, db, u.Roles)
+	if err != nil {
+		return nil, err
+	}
+	tf := TwoFactor{Required: required}
+
+	var s secret
+	const qs = `SELECT secret, last_step, date_confirmed FROM user_totp WHERE user_id = $1`
+	switch err := db.GetContext(ctx, &s, qs, userID); err {
+	case nil:
+		tf.Enabled = s.DateConfirmed != nil
+		tf.DateConfirmed = s.DateConfirmed
+	case sql.ErrNoRows:
+	default:
+		return nil, errors.Wrap(err, "selecting secret")
+	}
+
+	const qc = `SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND date_used IS NULL`
+	if err := db.GetContext(ctx, &tf.RecoveryCodes, qc, userID); err != nil {
+		return nil, errors.Wrap(err, "counting recovery codes")
+	}
+
+	return &tf, nil
+}
+
+// Enroll generates the secret of a new authenticator app for the account of
+// the user. It is not used until ConfirmTwoFactor is called with a code of the
+// app, so enrolling again replaces an app which was never confirmed.
+func Enroll(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID string, now time.Time) (*Enrollment, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.users.Enroll")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	// Only the user holds their authenticator app.
+	if claims.Subject != userID {
+		return nil, ErrForbidden
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	e, err := enroll(ctx, tx, userID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing enrollment")
+	}
+
+	return e, nil
+}
+
+// ConfirmTwoFactor turns two-factor authentication on with the first code of
+// the enrolled app. It returns the recovery codes of the account.
+func ConfirmTwoFactor(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID, code string, now time.Time) ([]string, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.users.ConfirmTwoFactor")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	if claims.Subject != userID {
+		return nil, ErrForbidden
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	codes, err := confirm(ctx, tx, userID, code, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing confirmation")
+	}
+
+	return codes, nil
+}
+
+// DisableTwoFactor turns two-factor authentication off. Users prove they hold
+// the app or a recovery code, unless the policy requires it for their roles.
+// Admins turn it off for users who lost their app without any code, the
+// users whose roles require it enroll again at their next sign in.
+func DisableTwoFactor(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID, code string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.users.DisableTwoFactor")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrInvalidID
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	switch {
+	case claims.Subject == userID:
+		required, err := twoFactorRequired(ctx, tx, claims.Roles)
+		if err != nil {
+			return err
+		}
+		if required {
+			return ErrTwoFactorRequired
+		}
+		if err := verifyCodeLimited(ctx, db, tx, userID, code, now); err != nil {
+			return err
+		}
+
+	case claims.HasPermission(auth.PermissionUsersManage):
+
+	default:
+		return ErrForbidden
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID)
+	if err != nil {
+		return errors.Wrap(err, "deleting secret")
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrTwoFactorDisabled
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return errors.Wrap(err, "deleting recovery codes")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing deletion")
+	}
+
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the account, the
+// user proves they hold the app or one of the previous codes.
+func RegenerateRecoveryCodes(ctx context.Context, claims auth.Claims, db *sqlx.DB, userID, code string, now time.Time) ([]string, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.users.RegenerateRecoveryCodes")
+	defer span.End()
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	if claims.Subject != userID {
+		return nil, ErrForbidden
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	if err := verifyCodeLimited(ctx, db, tx, userID, code, now); err != nil {
+		return nil, err
+	}
+
+	codes, err := recoveryCodes(ctx, tx, userID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing recovery codes")
+	}
+
+	return codes, nil
+}
+
+// BeginLogin decides whether the password of a user is enough to sign in. It
+// returns nil when it is, otherwise the challenge to answer with a code of
+// their app, or with the first code of an app to enroll when the policy
+// requires one and they have none yet.
+func BeginLogin(ctx context.Context, db *sqlx.DB, claims auth.Claims, now time.Time) (*Challenge, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.users.BeginLogin")
+	defer span.End()
+
+	var confirmed *time.Time
+	const qs = `SELECT date_confirmed FROM user_totp WHERE user_id = $1`
+	if err := db.GetContext(ctx, &confirmed, qs, claims.Subject); err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "selecting secret")
+	}
+
+	required, err := twoFactorRequired(ctx, db, claims.Roles)
+	if err != nil {
+		return nil, err
+	}
+
+	c := Challenge{
+		ExpiresAt: now.Add(ChallengePeriod).UTC(),
+	}
+	switch {
+	case confirmed != nil:
+		c.Type = ChallengeTOTP
+	case required:
+		c.Type = ChallengeEnroll
+	default:
+		return nil, nil
+	}
+
+	// Wrong codes are counted against the account and not the challenge, a
+	// new challenge does not give more attempts. Only the answer waits for
+	// the delay after a wrong code.
+	err = checkCodeLockout(ctx, db, lockout.Code.Key(claims.Subject), now)
+	if err != nil && errors.Cause(err) != ErrTooManyAttempts {
+		return nil, err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, errors.Wrap(err, "generating challenge")
+	}
+	c.Token = hex.EncodeToString(b)
+
+	const q = `INSERT INTO login_challenges
+		(token_hash, user_id, type, date_created, date_expires)
+		VALUES ($1, $2, $3, $4, $5)`
+	if _, err := db.ExecContext(ctx, q, tokenHash(c.Token), claims.Subject, c.Type, now.UTC(), c.ExpiresAt); err != nil {
+		return nil, errors.Wrap(err, "inserting challenge")
+	}
+
+	return &c, nil
+}
+
+// EnrollChallenge generates the secret of the app a user enrolls to answer an
+// enroll challenge.
+func EnrollChallenge(ctx context.Context, db *sqlx.DB, token string, now time.Time) (*Enrollment, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.users.EnrollChallenge")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	c, err := selectChallenge(ctx, tx, token, now)
+	if err != nil {
+		return nil, err
+	}
+	if c.Type != ChallengeEnroll {
+		return nil, ErrInvalidChallenge
+	}
+
+	e, err := enroll(ctx, tx, c.UserID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing enrollment")
+	}
+
+	return e, nil
+}
+
+// CompleteLogin answers the challenge of a sign in. It returns the claims of
+// the user, and their recovery codes when the answer confirmed a new app.
+func CompleteLogin(ctx context.Context, db *sqlx.DB, ans ChallengeAnswer, now time.Time) (auth.Claims, []string, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.users.CompleteLogin")
+	defer span.End()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return auth.Claims{}, nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	c, err := selectChallenge(ctx, tx, ans.Challenge, now)
+	if err != nil {
+		return auth.Claims{}, nil, err
+	}
+
+	key := lockout.Code.Key(c.UserID)
+	if err := checkCodeLockout(ctx, tx, key, now); err != nil {
+		return auth.Claims{}, nil, err
+	}
+
+	var codes []string
+	switch c.Type {
+	case ChallengeTOTP:
+		err = verifyCode(ctx, tx, c.UserID, ans.Code, now)
+	case ChallengeEnroll:
+		codes, err = confirm(ctx, tx, c.UserID, ans.Code, now)
+	}
+
+	// Wrong codes count against the challenge and the account, the attempt
+	// is kept even though the sign in fails.
+	if err == ErrInvalidCode {
+		const qa = `UPDATE login_challenges SET attempts = attempts + 1 WHERE token_hash = $1`
+		if _, err := tx.ExecContext(ctx, qa, tokenHash(ans.Challenge)); err != nil {
+			return auth.Claims{}, nil, errors.Wrap(err, "counting attempt")
+		}
+		if err := tx.Commit(); err != nil {
+			return auth.Claims{}, nil, errors.Wrap(err, "committing attempt")
+		}
+		if err := lockout.Fail(ctx, db, now, key); err != nil {
+			return auth.Claims{}, nil, err
+		}
+		return auth.Claims{}, nil, ErrInvalidCode
+	}
+	if err != nil {
+		return auth.Claims{}, nil, err
+	}
+
+	if err := lockout.Reset(ctx, tx, key); err != nil {
+		return auth.Claims{}, nil, err
+	}
+
+	const qu = `UPDATE login_challenges SET date_used = $2 WHERE token_hash = $1`
+	if _, err := tx.ExecContext(ctx, qu, tokenHash(ans.Challenge), now.UTC()); err != nil {
+		return auth.Claims{}, nil, errors.Wrap(err, "using challenge")
+	}
+
+	var u User
+	if err := tx.GetContext(ctx, &u, `SELECT * FROM users WHERE user_id = $1`, c.UserID); err != nil {
+		return auth.Claims{}, nil, errors.Wrap(err, "selecting user")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return auth.Claims{}, nil, errors.Wrap(err, "committing sign in")
+	}
+
+	csrf, err := utils.GenerateRandomString(32)
+	if err != nil {
+		return auth.Claims{}, nil, ErrGenerationFailure
+	}
+
+	return auth.NewClaims(u.ID, u.Roles, now, AccessPeriod, csrf), codes, nil
+}
+
+// selectChallenge locks a challenge which can still be answered.
+func selectChallenge(ctx context.Context, tx *sqlx.Tx, token string, now time.Time) (*challenge, error) {
+	var c challenge
+	const q = `SELECT user_id, type, attempts, date_expires, date_used
+		FROM login_challenges WHERE token_hash = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &c, q, tokenHash(token)); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidChallenge
+		}
+		return nil, errors.Wrap(err, "selecting challenge")
+	}
+
+	if c.DateUsed != nil || !c.DateExpires.After(now.UTC()) || c.Attempts >= MaxChallengeAttempts {
+		return nil, ErrInvalidChallenge
+	}
+
+	return &c, nil
+}
+
+// enroll stores a new secret for the user, replacing one never confirmed.
+func enroll(ctx context.Context, tx *sqlx.Tx, userID string, now time.Time) (*Enrollment, error) {
+	var confirmed *time.Time
+	const qs = `SELECT date_confirmed FROM user_totp WHERE user_id = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &confirmed, qs, userID); err != nil && err != sql.ErrNoRows {
+		return nil, errors.Wrap(err, "selecting secret")
+	}
+	if confirmed != nil {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	var email string
+	if err := tx.GetContext(ctx, &email, `SELECT email FROM users WHERE user_id = $1`, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "selecting user")
+	}
+
+	key, err := totp.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	const q = `INSERT INTO user_totp (user_id, secret, date_created) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_step = 0, date_created = EXCLUDED.date_created`
+	if _, err := tx.ExecContext(ctx, q, userID, totp.Encode(key), now.UTC()); err != nil {
+		return nil, errors.Wrap(err, "inserting secret")
+	}
+
+	e := Enrollment{
+		Secret: totp.Encode(key),
+		URI:    totp.URI(TOTPIssuer, email, key),
+	}
+
+	return &e, nil
+}
+
+// confirm turns on the enrolled app of the user with one of its codes.
+func confirm(ctx context.Context, tx *sqlx.Tx, userID, code string, now time.Time) ([]string, error) {
+	var s secret
+	const qs = `SELECT secret, last_step, date_confirmed FROM user_totp WHERE user_id = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &s, qs, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTwoFactorDisabled
+		}
+		return nil, errors.Wrap(err, "selecting secret")
+	}
+	if s.DateConfirmed != nil {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	key, err := totp.Decode(s.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := totp.Verify(key, code, now, totpSkew)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	const q = `UPDATE user_totp SET date_confirmed = $2, last_step = $3 WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, q, userID, now.UTC(), step); err != nil {
+		return nil, errors.Wrap(err, "confirming secret")
+	}
+
+	return recoveryCodes(ctx, tx, userID, now)
+}
+
+// checkCodeLockout refuses a code while the codes of the account are locked
+// or delayed after too many failures.
+func checkCodeLockout(ctx context.Context, db sqlx.QueryerContext, key lockout.Key, now time.Time) error {
+	switch wait, err := lockout.Check(ctx, db, now, key); err {
+	case nil:
+		return nil
+	case lockout.ErrLocked:
+		return lockout.Retry(ErrAccountLocked, wait)
+	case lockout.ErrThrottled:
+		return lockout.Retry(ErrTooManyAttempts, wait)
+	default:
+		return err
+	}
+}
+
+// verifyCodeLimited checks a code like verifyCode, wrong codes count against
+// the account like the codes of a sign in.
+func verifyCodeLimited(ctx context.Context, db *sqlx.DB, tx *sqlx.Tx, userID, code string, now time.Time) error {
+	key := lockout.Code.Key(userID)
+	if err := checkCodeLockout(ctx, tx, key, now); err != nil {
+		return err
+	}
+
+	if err := verifyCode(ctx, tx, userID, code, now); err != nil {
+		if err == ErrInvalidCode {
+			if err := lockout.Fail(ctx, db, now, key); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+
+	return lockout.Reset(ctx, tx, key)
+}
+
+// verifyCode checks a code of the app of the user, or one of their recovery
+// codes. Each code is only accepted once.
+func verifyCode(ctx context.Context, tx *sqlx.Tx, userID, code string, now time.Time) error {
+	var s secret
+	const qs = `SELECT secret, last_step, date_confirmed FROM user_totp WHERE user_id = $1 FOR UPDATE`
+	if err := tx.GetContext(ctx, &s, qs, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrTwoFactorDisabled
+		}
+		return errors.Wrap(err, "selecting secret")
+	}
+	if s.DateConfirmed == nil {
+		return ErrTwoFactorDisabled
+	}
+
+	key, err := totp.Decode(s.Secret)
+	if err != nil {
+		return err
+	}
+
+	if step, ok := totp.Verify(key, code, now, totpSkew); ok {
+		if step <= s.LastStep {
+			return ErrInvalidCode
+		}
+
+		const q = `UPDATE user_totp SET last_step = $2 WHERE user_id = $1`
+		if _, err := tx.ExecContext(ctx, q, userID, step); err != nil {
+			return errors.Wrap(err, "using code")
+		}
+		return nil
+	}
+
+	const qr = `UPDATE recovery_codes SET date_used = $3
+		WHERE code_hash = $1 AND user_id = $2 AND date_used IS NULL`
+	res, err := tx.ExecContext(ctx, qr, tokenHash(normalizeRecoveryCode(code)), userID, now.UTC())
+	if err != nil {
+		return errors.Wrap(err, "using recovery code")
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+// recoveryCodes replaces the recovery codes of the user. Only their hashes
+// are kept.
+func recoveryCodes(ctx context.Context, tx *sqlx.Tx, userID string, now time.Time) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, errors.Wrap(err, "deleting recovery codes")
+	}
+
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, errors.Wrap(err, "generating recovery code")
+		}
+		c := strings.ToLower(enc.EncodeToString(b))
+		codes[i] = c[:8] + "-" + c[8:16]
+
+		const q = `INSERT INTO recovery_codes (code_hash, user_id, date_created) VALUES ($1, $2, $3)`
+		if _, err := tx.ExecContext(ctx, q, tokenHash(normalizeRecoveryCode(codes[i])), userID, now.UTC()); err != nil {
+			return nil, errors.Wrap(err, "inserting recovery code")
+		}
+	}
+
+	return codes, nil
+}
+
+// normalizeRecoveryCode ignores the case and the separators users type.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.Replace(code, "-", "", -1)
+	return strings.Replace(code, " ", "", -1)
+}
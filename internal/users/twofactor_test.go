@@ -0,0 +1,244 @@
+package users_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/lockout"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/platform/totp"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/users"
+	"github.com/pkg/errors"
+)
+
+// TestTwoFactor validates users enroll an authenticator app and answer the
+// challenge of their sign in with its codes.
+func TestTwoFactor(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	t.Log("Given the need to sign users in with a second factor.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		u, err := users.Create(ctx, db, users.NewUser{Name: "Jane", Email: "jane@example.com", Roles: []string{auth.RoleUser}, Password: "gophers"}, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+		}
+		claims := auth.NewClaims(u.ID, u.Roles, now, time.Hour, "csrf")
+
+		var secret []byte
+		var codes []string
+
+		t.Log("\tWhen a user enrolls an authenticator app.")
+		{
+			ch, err := users.BeginLogin(ctx, db, claims, now)
+			if err != nil || ch != nil {
+				t.Fatalf("\t%s\tShould sign in with the password only : %+v, %v.", tests.Failed, ch, err)
+			}
+			t.Logf("\t%s\tShould sign in with the password only.", tests.Success)
+
+			e, err := users.Enroll(ctx, claims, db, u.ID, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to enroll : %s.", tests.Failed, err)
+			}
+			if secret, err = totp.Decode(e.Secret); err != nil {
+				t.Fatalf("\t%s\tShould receive the secret : %s.", tests.Failed, err)
+			}
+
+			if _, err := users.ConfirmTwoFactor(ctx, claims, db, u.ID, "000000", now); err != users.ErrInvalidCode {
+				t.Fatalf("\t%s\tShould refuse a wrong code : %v.", tests.Failed, err)
+			}
+			codes, err = users.ConfirmTwoFactor(ctx, claims, db, u.ID, totp.Code(secret, now), now)
+			if err != nil || len(codes) != 10 {
+				t.Fatalf("\t%s\tShould confirm the app with its code : %v, %v.", tests.Failed, codes, err)
+			}
+			t.Logf("\t%s\tShould confirm the app and receive recovery codes.", tests.Success)
+
+			tf, err := users.TwoFactorStatus(ctx, claims, db, u.ID)
+			if err != nil || !tf.Enabled || tf.Required || tf.RecoveryCodes != 10 {
+				t.Fatalf("\t%s\tShould report the app : %+v, %v.", tests.Failed, tf, err)
+			}
+			t.Logf("\t%s\tShould report the app.", tests.Success)
+		}
+
+		t.Log("\tWhen the user signs in.")
+		{
+			later := now.Add(time.Minute)
+
+			ch, err := users.BeginLogin(ctx, db, claims, later)
+			if err != nil || ch == nil || ch.Type != users.ChallengeTOTP {
+				t.Fatalf("\t%s\tShould be challenged : %+v, %v.", tests.Failed, ch, err)
+			}
+
+			ans := users.ChallengeAnswer{Challenge: ch.Token, Code: totp.Code(secret, now)}
+			if _, _, err := users.CompleteLogin(ctx, db, ans, later); err != users.ErrInvalidCode {
+				t.Fatalf("\t%s\tShould refuse the code used to confirm : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould refuse a code used before.", tests.Success)
+
+			ans.Code = totp.Code(secret, later)
+			got, _, err := users.CompleteLogin(ctx, db, ans, later)
+			if err != nil || got.Subject != u.ID {
+				t.Fatalf("\t%s\tShould sign in with the code : %v.", tests.Failed, err)
+			}
+			if _, _, err := users.CompleteLogin(ctx, db, ans, later); err != users.ErrInvalidChallenge {
+				t.Fatalf("\t%s\tShould only answer a challenge once : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould sign in with the code once.", tests.Success)
+
+			ch, err = users.BeginLogin(ctx, db, claims, later)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be challenged : %v.", tests.Failed, err)
+			}
+			ans = users.ChallengeAnswer{Challenge: ch.Token, Code: codes[0]}
+			if _, _, err := users.CompleteLogin(ctx, db, ans, later); err != nil {
+				t.Fatalf("\t%s\tShould sign in with a recovery code : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould sign in with a recovery code.", tests.Success)
+
+			ch, err = users.BeginLogin(ctx, db, claims, later)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be challenged : %v.", tests.Failed, err)
+			}
+			ans = users.ChallengeAnswer{Challenge: ch.Token, Code: codes[0]}
+			for i := 0; i < users.MaxChallengeAttempts; i++ {
+				at := later.Add(time.Duration(i) * lockout.Code.MaxDelay)
+				if _, _, err := users.CompleteLogin(ctx, db, ans, at); err != users.ErrInvalidCode {
+					t.Fatalf("\t%s\tShould refuse a used recovery code : %v.", tests.Failed, err)
+				}
+			}
+			ans.Code = codes[1]
+			if _, _, err := users.CompleteLogin(ctx, db, ans, later.Add(time.Hour/2)); err != users.ErrInvalidChallenge {
+				t.Fatalf("\t%s\tShould give up the challenge after %d attempts : %v.", tests.Failed, users.MaxChallengeAttempts, err)
+			}
+			t.Logf("\t%s\tShould give up the challenge after %d attempts.", tests.Success, users.MaxChallengeAttempts)
+		}
+
+		t.Log("\tWhen codes keep being guessed with new challenges.")
+		{
+			at := now.Add(10 * time.Minute)
+			for i := users.MaxChallengeAttempts; i < lockout.Code.Threshold; i++ {
+				ch, err := users.BeginLogin(ctx, db, claims, at)
+				if err != nil {
+					t.Fatalf("\t%s\tShould be challenged : %v.", tests.Failed, err)
+				}
+				ans := users.ChallengeAnswer{Challenge: ch.Token, Code: codes[0]}
+				if _, _, err := users.CompleteLogin(ctx, db, ans, at); err != users.ErrInvalidCode {
+					t.Fatalf("\t%s\tShould refuse a used recovery code : %v.", tests.Failed, err)
+				}
+				at = at.Add(lockout.Code.MaxDelay)
+			}
+
+			if _, err := users.BeginLogin(ctx, db, claims, at); errors.Cause(err) != users.ErrAccountLocked {
+				t.Fatalf("\t%s\tShould lock the account after %d wrong codes : %v.", tests.Failed, lockout.Code.Threshold, err)
+			}
+			t.Logf("\t%s\tShould lock the account after %d wrong codes.", tests.Success, lockout.Code.Threshold)
+
+			admin := auth.NewClaims(tests.AdminID, []string{auth.RoleAdmin}, now, time.Hour, "csrf")
+			if err := users.Unlock(ctx, admin, db, u.ID, at); err != nil {
+				t.Fatalf("\t%s\tShould be able to unlock the account : %v.", tests.Failed, err)
+			}
+			if ch, err := users.BeginLogin(ctx, db, claims, at); err != nil || ch == nil {
+				t.Fatalf("\t%s\tShould be challenged once unlocked : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould be challenged once unlocked.", tests.Success)
+		}
+
+		t.Log("\tWhen codes are guessed to change the app.")
+		{
+			at := now.Add(2 * time.Hour)
+			for i := 0; i <= lockout.Code.FreeAttempts; i++ {
+				if _, err := users.RegenerateRecoveryCodes(ctx, claims, db, u.ID, codes[0], at); err != users.ErrInvalidCode {
+					t.Fatalf("\t%s\tShould refuse a used recovery code : %v.", tests.Failed, err)
+				}
+			}
+			_, err := users.RegenerateRecoveryCodes(ctx, claims, db, u.ID, codes[1], at)
+			if errors.Cause(err) != users.ErrTooManyAttempts || lockout.RetryAfter(err) != lockout.Code.BaseDelay {
+				t.Fatalf("\t%s\tShould delay the next code : %v, %v.", tests.Failed, err, lockout.RetryAfter(err))
+			}
+			t.Logf("\t%s\tShould delay the next code.", tests.Success)
+
+			for i := lockout.Code.FreeAttempts + 1; i < lockout.Code.Threshold; i++ {
+				at = at.Add(lockout.Code.MaxDelay)
+				if err := users.DisableTwoFactor(ctx, claims, db, u.ID, codes[0], at); err != users.ErrInvalidCode {
+					t.Fatalf("\t%s\tShould refuse a used recovery code : %v.", tests.Failed, err)
+				}
+			}
+			err = users.DisableTwoFactor(ctx, claims, db, u.ID, codes[1], at)
+			if errors.Cause(err) != users.ErrAccountLocked || lockout.RetryAfter(err) != lockout.Code.Period {
+				t.Fatalf("\t%s\tShould lock the account out : %v, %v.", tests.Failed, err, lockout.RetryAfter(err))
+			}
+			t.Logf("\t%s\tShould lock the account out, even for a right code.", tests.Success)
+		}
+
+		t.Log("\tWhen an admin without an app signs in.")
+		{
+			a, err := users.Create(ctx, db, users.NewUser{Name: "Bill", Email: "bill@example.com", Roles: []string{auth.RoleAdmin, auth.RoleUser}, Password: "gophers"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+			}
+			admin := auth.NewClaims(a.ID, a.Roles, now, time.Hour, "csrf")
+
+			ch, err := users.BeginLogin(ctx, db, admin, now)
+			if err != nil || ch == nil || ch.Type != users.ChallengeEnroll {
+				t.Fatalf("\t%s\tShould be required to enroll : %+v, %v.", tests.Failed, ch, err)
+			}
+
+			e, err := users.EnrollChallenge(ctx, db, ch.Token, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to enroll : %s.", tests.Failed, err)
+			}
+			key, _ := totp.Decode(e.Secret)
+
+			ans := users.ChallengeAnswer{Challenge: ch.Token, Code: totp.Code(key, now)}
+			got, codes, err := users.CompleteLogin(ctx, db, ans, now)
+			if err != nil || got.Subject != a.ID || len(codes) != 10 {
+				t.Fatalf("\t%s\tShould sign in and receive recovery codes : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould enroll and sign in.", tests.Success)
+
+			if err := users.DisableTwoFactor(ctx, admin, db, a.ID, totp.Code(key, now.Add(time.Minute)), now); err != users.ErrTwoFactorRequired {
+				t.Fatalf("\t%s\tShould not turn off a required app : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould not turn off a required app.", tests.Success)
+
+			if err := users.DisableTwoFactor(ctx, claims, db, a.ID, "", now); err != users.ErrForbidden {
+				t.Fatalf("\t%s\tShould not turn off the app of another user : %v.", tests.Failed, err)
+			}
+			if err := users.DisableTwoFactor(ctx, admin, db, u.ID, "", now); err != nil {
+				t.Fatalf("\t%s\tShould turn off the app of a user who lost it : %v.", tests.Failed, err)
+			}
+			t.Logf("\t%s\tShould let admins turn off the app of a user.", tests.Success)
+		}
+
+		t.Log("\tWhen the role of a user grants managing accounts.")
+		{
+			const q = `INSERT INTO roles (name, description, permissions, date_created, date_updated)
+				VALUES ('SUPPORT', 'Helps patrons with their account', '{users.read,users.manage}', $1, $1)`
+			if _, err := db.ExecContext(ctx, q, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to create the role : %s.", tests.Failed, err)
+			}
+
+			s, err := users.Create(ctx, db, users.NewUser{Name: "Sam", Email: "sam@example.com", Roles: []string{"SUPPORT"}, Password: "gophers"}, now)
+			if err != nil {
+				t.Fatalf("\t%s\tShould be able to create user : %s.", tests.Failed, err)
+			}
+			support := auth.NewClaims(s.ID, s.Roles, now, time.Hour, "csrf")
+
+			ch, err := users.BeginLogin(ctx, db, support, now)
+			if err != nil || ch == nil || ch.Type != users.ChallengeEnroll {
+				t.Fatalf("\t%s\tShould be required to enroll : %+v, %v.", tests.Failed, ch, err)
+			}
+			t.Logf("\t%s\tShould be required to enroll.", tests.Success)
+
+			tf, err := users.TwoFactorStatus(ctx, support, db, s.ID)
+			if err != nil || !tf.Required {
+				t.Fatalf("\t%s\tShould report the app as required : %+v, %v.", tests.Failed, tf, err)
+			}
+			t.Logf("\t%s\tShould report the app as required.", tests.Success)
+		}
+	}
+}
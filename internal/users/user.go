@@ -3,12 +3,11 @@ package users
 import (
 	"context"
 	"database/sql"
-	"encoding/base64"
-	"encoding/json"
-	"fmt"
 	"github.com/book-library/internal/utils"
+	"strings"
 	"time"
 
+	"github.com/book-library/internal/lockout"
 	"github.com/book-library/internal/platform/auth"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
@@ -32,11 +31,18 @@ var (
 
 	// ErrForbidden occurs when a users tries to do something that is forbidden to them according to our access control policies.
 	ErrForbidden = errors.New("Attempted action is not allowed")
-)
 
-const (
-	defaultJWTCookieName = "Session-Cookie"
-	defaultXSRFCookieName       = "x-xsrf-token"
+	// ErrTooManyAttempts occurs when a password or a code is tried again too
+	// soon after failing several times.
+	ErrTooManyAttempts = errors.New("Too many failed attempts, slow down")
+
+	// ErrAccountLocked occurs when a password or a code is tried while the
+	// account, or the address the attempt comes from, is locked after too many
+	// failures.
+	ErrAccountLocked = errors.New("Too many failed attempts, try again later")
+
+	// ErrNotLocked occurs when unlocking an account which is not locked.
+	ErrNotLocked = errors.New("Account is not locked")
 )
 
 // List retrieves a list of existing users from the database.
@@ -45,7 +51,7 @@ func List(ctx context.Context, claims auth.Claims, db *sqlx.DB) ([]User, error)
 	defer span.End()
 
 	// If you are not an admin and looking to retrieve someone else then you are rejected.
-	if !claims.HasRole(auth.RoleAdmin) {
+	if !claims.HasPermission(auth.PermissionUsersRead) {
 		return nil, ErrForbidden
 	}
 
@@ -69,7 +75,7 @@ func Retrieve(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string) (
 	}
 
 	// If you are not an admin and looking to retrieve someone else then you are rejected.
-	if !claims.HasRole(auth.RoleAdmin) && claims.Subject != id {
+	if !claims.HasPermission(auth.PermissionUsersRead) && claims.Subject != id {
 		return nil, ErrForbidden
 	}
 
@@ -103,7 +109,7 @@ func RetrieveMe(ctx context.Context, claims auth.Claims, db *sqlx.DB)(*User, err
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
 		}
-		return nil, errors.Wrapf(err, "selecting users %q")
+		return nil, errors.Wrap(err, "selecting current users")
 	}
 
 	return &u, nil
@@ -119,6 +125,9 @@ func Create(ctx context.Context, db *sqlx.DB, n NewUser, now time.Time) (*User,
 		return nil, errors.Wrap(err, "generating password hash")
 	}
 
+	// Accounts made by staff are trusted, only patrons registering by
+	// themselves have to confirm their email address.
+	verified := now.UTC()
 	u := User{
 		ID:           uuid.New().String(),
 		Name:         n.Name,
@@ -127,31 +136,35 @@ func Create(ctx context.Context, db *sqlx.DB, n NewUser, now time.Time) (*User,
 		Roles:        n.Roles,
 		DateCreated:  now.UTC(),
 		DateUpdated:  now.UTC(),
+		DateVerified: &verified,
 	}
 
-	const q = `INSERT INTO users
-		(user_id, name, email, password_hash, roles, date_created, date_updated)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`
-	_, err = db.ExecContext(
-		ctx, q,
-		u.ID, u.Name, u.Email,
-		u.PasswordHash, u.Roles,
-		u.DateCreated, u.DateUpdated,
-	)
+	tx, err := db.BeginTxx(ctx, nil)
 	if err != nil {
-		return nil, errors.Wrap(err, "inserting users")
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	if err := insert(ctx, tx, u, now); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "committing users")
 	}
 
 	return &u, nil
 }
 
-// Update replaces a users document in the database.
-func Update(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, upd UpdateUser, now time.Time) error {
+// Update replaces a users document in the database. A new email address has
+// to be confirmed again, the verification link is sent to it, link is the
+// address of the page the token is appended to.
+func Update(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, upd UpdateUser, link string, now time.Time) error {
 	ctx, span := trace.StartSpan(ctx, "internal.users.Update")
 	defer span.End()
 
 	// If you are not an admin and looking to retrieve someone else then you are rejected.
-	if !claims.HasRole(auth.RoleUser) && claims.Subject != id {
+	if !claims.HasPermission(auth.PermissionUsersManage) && claims.Subject != id {
 		return ErrForbidden
 	}
 
@@ -163,11 +176,11 @@ func Update(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, upd
 	if upd.Name != nil {
 		u.Name = *upd.Name
 	}
-	if upd.Email != nil {
+	changed := false
+	if upd.Email != nil && *upd.Email != u.Email {
 		u.Email = *upd.Email
-	}
-	if upd.Roles != nil {
-		u.Roles = upd.Roles
+		u.DateVerified = nil
+		changed = true
 	}
 	if upd.Password != nil {
 		pw, err := bcrypt.GenerateFromPassword([]byte(*upd.Password), bcrypt.DefaultCost)
@@ -179,21 +192,37 @@ func Update(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, upd
 
 	u.DateUpdated = now
 
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
 	const q = `UPDATE users SET
 		"name" = $2,
 		"email" = $3,
-		"roles" = $4,
-		"password_hash" = $5,
-		"date_updated" = $6
+		"password_hash" = $4,
+		"date_updated" = $5,
+		"date_verified" = $6
 		WHERE user_id = $1`
-	_, err = db.ExecContext(ctx, q, id,
-		u.Name, u.Email, u.Roles,
-		u.PasswordHash, u.DateUpdated,
+	_, err = tx.ExecContext(ctx, q, id,
+		u.Name, u.Email,
+		u.PasswordHash, u.DateUpdated, u.DateVerified,
 	)
 	if err != nil {
 		return errors.Wrap(err, "updating users")
 	}
 
+	if changed {
+		if err := issue(ctx, tx, id, link, now); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "committing users")
+	}
+
 	return nil
 }
 
@@ -217,27 +246,22 @@ func Delete(ctx context.Context, db *sqlx.DB, id string) error {
 
 // Authenticate finds a users by email and verifies their password. On
 // success it returns a Claims value representing this users. The claims can be
-// used to generate a token for future authentication.
-func Authenticate(ctx context.Context, db *sqlx.DB, now time.Time, email, password string) (auth.Claims, error) {
+// used to generate a token for future authentication. Failed attempts are
+// counted against the email and the address ip they come from, see
+// CheckPassword.
+func Authenticate(ctx context.Context, db *sqlx.DB, now time.Time, email, password, ip string) (auth.Claims, error) {
 	ctx, span := trace.StartSpan(ctx, "internal.users.Authenticate")
 	defer span.End()
 
-	const q = `SELECT * FROM users WHERE email = $1`
-	var u User
-	if err := db.GetContext(ctx, &u, q, email); err != nil {
-		// Normally we would return ErrNotFound in this scenario but we do not want
-		// to leak to an unauthenticated users which emails are in the system.
-		if err == sql.ErrNoRows {
-			return auth.Claims{}, ErrAuthenticationFailure
-		}
-
-		return auth.Claims{}, errors.Wrap(err, "selecting single users")
+	u, err := CheckPassword(ctx, db, now, email, password, ip)
+	if err != nil {
+		return auth.Claims{}, err
 	}
 
-	// Compare the provided password with the saved hash. Use the bcrypt
-	// comparison function so it is cryptographically secure.
-	if err := bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(password)); err != nil {
-		return auth.Claims{}, ErrAuthenticationFailure
+	// Patrons who registered by themselves can not sign in until their email
+	// address is confirmed.
+	if u.DateVerified == nil {
+		return auth.Claims{}, ErrNotVerified
 	}
 
 	csrf, err := utils.GenerateRandomString(32)
@@ -246,92 +270,103 @@ func Authenticate(ctx context.Context, db *sqlx.DB, now time.Time, email, passwo
 	}
 
 	// If we are this far the request is valid. Create some claims for the users
-	// and generate their token.
-	claims := auth.NewClaims(u.ID, u.Roles, now, time.Hour, csrf)
-
-	//save the claim as session-token or drop if claim is nil
-	const t = `INSERT INTO sessions (user_id, token, data, expiry) VALUES ($1, $2, $3, $4)`
-	//convert claims in string
-	str := fmt.Sprint(claims)
-	timer := time.Now().Add(10)
-	//encode converted string in base64
-	encoded := base64.StdEncoding.EncodeToString([]byte(str))
-	_, err = db.ExecContext(
-		ctx, t, u.ID, defaultJWTCookieName, []byte(encoded), timer)
-	if err != nil {
-		return auth.Claims{}, errors.Wrap(err, "Session expired or not existed")
-	}
+	// and generate their token. They are short lived, clients keep using the
+	// account by refreshing their session.
+	claims := auth.NewClaims(u.ID, u.Roles, now, AccessPeriod, csrf)
 
 	return claims, nil
 }
 
-func RefreshesToken(ctx context.Context, db *sqlx.DB, user_id string) (auth.Claims, error) {
-	ctx, span := trace.StartSpan(ctx, "internal.users.RefreshToken")
+// CheckPassword finds a users by email and verifies their password, it is
+// meant for every flow asking for a password. Once the failures for the email
+// or the address ip pile up the attempts are delayed, then refused for a
+// while, before the password is even compared.
+func CheckPassword(ctx context.Context, db *sqlx.DB, now time.Time, email, password, ip string) (*User, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.users.CheckPassword")
 	defer span.End()
 
-	const q = `SELECT * FROM sessions WHERE user_id = $1`
-	var tk Session
-	if err := db.GetContext(ctx, &tk, q, user_id); err != nil {
+	// The failures are counted by email and not by user so an unknown email
+	// is throttled like any other, it does not tell which emails exist.
+	account := lockout.Account.Key(strings.ToLower(email))
+	address := lockout.Address.Key(ip)
+
+	switch wait, err := lockout.Check(ctx, db, now, account, address); err {
+	case nil:
+	case lockout.ErrLocked:
+		return nil, lockout.Retry(ErrAccountLocked, wait)
+	case lockout.ErrThrottled:
+		return nil, lockout.Retry(ErrTooManyAttempts, wait)
+	default:
+		return nil, err
+	}
+
+	const q = `SELECT * FROM users WHERE email = $1`
+	var u User
+	if err := db.GetContext(ctx, &u, q, email); err != nil {
 		// Normally we would return ErrNotFound in this scenario but we do not want
 		// to leak to an unauthenticated users which emails are in the system.
 		if err == sql.ErrNoRows {
-			return auth.Claims{}, ErrNotFound
+			if err := lockout.Fail(ctx, db, now, account, address); err != nil {
+				return nil, err
+			}
+			return nil, ErrAuthenticationFailure
 		}
 
-		return auth.Claims{}, errors.Wrap(err, "selecting single token")
+		return nil, errors.Wrap(err, "selecting single users")
 	}
 
-	var claim auth.Claims
-	err := json.Unmarshal(tk.Data, &claim)
-	if err != nil {
-		return auth.Claims{}, errors.Wrap(err, "unable to convert byte data back")
-	}
-	if IsExpired(claim) {
-		t := `DELETE FROM sessions WHERE expiry < current_timestamp`
-		_, err := db.ExecContext(
-			ctx, t, user_id)
-		if err != nil {
-			return auth.Claims{}, errors.Wrap(err, "Deleting session-token")
+	// Compare the provided password with the saved hash. Use the bcrypt
+	// comparison function so it is cryptographically secure.
+	if err := bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(password)); err != nil {
+		if err := lockout.Fail(ctx, db, now, account, address); err != nil {
+			return nil, err
 		}
+		return nil, ErrAuthenticationFailure
 	}
 
-	tk.Expiry = time.Now().Add(3600)
-	//save the claim as session-token or drop if claim is nil
-	const t = `INSERT INTO sessions (token, data, expiry) VALUES ($1, $2, $3)`
-	_, err = db.ExecContext(
-		ctx, t, tk.Token, tk.Data, tk.Expiry)
-	if err != nil {
-		return auth.Claims{}, errors.Wrap(err, "Session expired or not existed")
+	// Only the failures of the account are forgotten, an address trying many
+	// accounts keeps being throttled when one of them works.
+	if err := lockout.Reset(ctx, db, account); err != nil {
+		return nil, err
 	}
 
-	return claim, nil
+	return &u, nil
 }
 
-//Logout deletes user's session-token from the database which invalidates all existing cookies
-//in browsers
-func Logout(ctx context.Context, db *sqlx.DB, user_id string) error {
-	ctx, span := trace.StartSpan(ctx, "internal.users.Logout")
+// Unlock lifts the lockout of the account of a user before it ends by itself,
+// for its password and for the codes of its app. It returns ErrNotLocked when
+// the account is not locked.
+func Unlock(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.users.Unlock")
 	defer span.End()
 
-	//TODO make sure user is not logged in many times
-	const q = `SELECT * FROM sessions WHERE user_id = $1`
-	var tk Session
-	if err := db.GetContext(ctx, &tk, q, user_id); err != nil {
-		// Normally we would return ErrNotFound in this scenario but we do not want
-		// to leak to an unauthenticated users which emails are in the system.
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	if !claims.HasPermission(auth.PermissionUsersManage) {
+		return ErrForbidden
+	}
+
+	var email string
+	const q = `SELECT email FROM users WHERE user_id = $1`
+	if err := db.GetContext(ctx, &email, q, id); err != nil {
 		if err == sql.ErrNoRows {
 			return ErrNotFound
 		}
-
-		return errors.Wrap(err, "selecting single token")
+		return errors.Wrapf(err, "selecting users %q", id)
 	}
 
-	//save the claim as session-token or drop if claim is nil
-	const t = `DELETE FROM sessions WHERE user_id = $1`
-	_, err := db.ExecContext(
-		ctx, t, user_id)
+	locked, err := lockout.Unlock(ctx, db, lockout.Account.Key(strings.ToLower(email)), claims.Subject, now)
 	if err != nil {
-		return errors.Wrap(err, "Deleting session-token")
+		return err
+	}
+	codes, err := lockout.Unlock(ctx, db, lockout.Code.Key(id), claims.Subject, now)
+	if err != nil {
+		return err
+	}
+	if !locked && !codes {
+		return ErrNotLocked
 	}
 
 	return nil
@@ -341,31 +376,3 @@ func Logout(ctx context.Context, db *sqlx.DB, user_id string) error {
 func IsExpired(claims auth.Claims) bool {
 	return !claims.VerifyExpiresAt(time.Now().Unix(), true)
 }
-
-func IsLoggedOut(ctx context.Context, db *sqlx.DB, user_id string, token string) (bool, error){
-	ctx, span := trace.StartSpan(ctx, "internal.users.IsLoggedOut")
-	defer span.End()
-
-	const q = `SELECT * FROM sessions WHERE user_id = $1`
-	var tk Session
-	if err := db.GetContext(ctx, &tk, q, user_id); err != nil {
-		// Normally we would return ErrNotFound in this scenario but we do not want
-		// to leak to an unauthenticated users which emails are in the system.
-		if err == sql.ErrNoRows {
-			return false, ErrNotFound
-		}
-		return false, errors.Wrap(err, "selecting single token")
-	}
-
-	//convert token in base64
-	encodedToken := base64.StdEncoding.EncodeToString([]byte(token))
-
-	//verify if token value
-	if &tk != nil {
-		if len(tk.Data) == len([]byte(encodedToken)) {
-			return false, nil
-		}
-	}
-
-	return true, nil
-}
\ No newline at end of file
@@ -62,7 +62,7 @@ func TestUser(t *testing.T) {
 				Email: tests.StringPointer("jacob@ardanlabs.com"),
 			}
 
-			if err := users.Update(ctx, claims, db, u.ID, upd, now); err != nil {
+			if err := users.Update(ctx, claims, db, u.ID, upd, "http://localhost:3000/v1/users/verify", now); err != nil {
 				t.Fatalf("\t%s\tShould be able to update user : %s.", tests.Failed, err)
 			}
 			t.Logf("\t%s\tShould be able to update user.", tests.Success)
@@ -89,6 +89,19 @@ func TestUser(t *testing.T) {
 				t.Logf("\t%s\tShould be able to see updates to Email.", tests.Success)
 			}
 
+			if savedU.DateVerified != nil {
+				t.Errorf("\t%s\tShould have to confirm the new Email : %v.", tests.Failed, savedU.DateVerified)
+			} else {
+				t.Logf("\t%s\tShould have to confirm the new Email.", tests.Success)
+			}
+
+			var recipient string
+			const q = `SELECT recipient FROM notifications WHERE user_id = $1 AND event = 'verify_email'`
+			if err := db.GetContext(ctx, &recipient, q, u.ID); err != nil || recipient != *upd.Email {
+				t.Fatalf("\t%s\tShould send a verification link to the new Email : %q %v.", tests.Failed, recipient, err)
+			}
+			t.Logf("\t%s\tShould send a verification link to the new Email.", tests.Success)
+
 			if err := users.Delete(ctx, db, u.ID); err != nil {
 				t.Fatalf("\t%s\tShould be able to delete user : %s.", tests.Failed, err)
 			}
@@ -130,7 +143,7 @@ func TestAuthenticate(t *testing.T) {
 			}
 			t.Logf("\t%s\tShould be able to create user.", tests.Success)
 
-			claims, err := users.Authenticate(ctx, db, now, "anna@ardanlabs.com", "goroutines")
+			claims, err := users.Authenticate(ctx, db, now, "anna@ardanlabs.com", "goroutines", "")
 			if err != nil {
 				t.Fatalf("\t%s\tShould be able to generate claims : %s.", tests.Failed, err)
 			}
@@ -2,10 +2,7 @@ package utils
 
 import (
 	"crypto/rand"
-	"crypto/sha256"
-	"fmt"
-	"io"
-	"strconv"
+	"encoding/base64"
 )
 
 // GenerateRandomBytes returns securely generated random bytes.
@@ -24,14 +21,15 @@ func GenerateRandomBytes(n int) ([]byte, error) {
 }
 
 // GenerateRandomString returns a URL-safe, base64 encoded
-// securely generated random string.
+// securely generated random string of s random bytes.
 // It will return an error if the system's secure random
 // number generator fails to function correctly, in which
 // case the caller should not continue.
 func GenerateRandomString(s int64) (string, error) {
-	h := sha256.New()
-	io.WriteString(h, strconv.FormatInt(s, 10))
-	io.WriteString(h, "ganraomaxxxxxxxxx")
-	token := fmt.Sprintf("%x", h.Sum(nil))
-	return token, nil
+	b, err := GenerateRandomBytes(int(s))
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
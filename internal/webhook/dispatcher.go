@@ -0,0 +1,277 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/plugin/ochttp"
+	"go.opencensus.io/trace"
+)
+
+// Headers set on every posted delivery.
+const (
+	SignatureHeader = "X-Library-Signature"
+	EventHeader     = "X-Library-Event"
+	DeliveryHeader  = "X-Library-Delivery"
+)
+
+// Retry settings of the delivery queue. The delay between two attempts
+// doubles after every failure, starting at baseBackoff and never exceeding
+// maxBackoff. A claimed batch has sendLease to be posted before it is claimed
+// again.
+const (
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 12 * time.Hour
+	batchSize   = 20
+	sendLease   = 10 * time.Minute
+)
+
+// Backoff returns how long to wait before retrying a delivery which already
+// failed the provided number of times.
+func Backoff(attempts int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return d
+}
+
+// DispatcherConfig is the required properties to run a Dispatcher.
+type DispatcherConfig struct {
+	Interval    time.Duration
+	Timeout     time.Duration
+	MaxAttempts int
+}
+
+// Dispatcher periodically posts the pending deliveries to their webhooks.
+type Dispatcher struct {
+	db     *sqlx.DB
+	client *http.Client
+	log    *log.Logger
+	cfg    DispatcherConfig
+
+	wg       sync.WaitGroup
+	shutdown chan struct{}
+}
+
+// NewDispatcher creates a *Dispatcher for use. Call Start to run it.
+func NewDispatcher(log *log.Logger, db *sqlx.DB, cfg DispatcherConfig) *Dispatcher {
+	client := http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: &ochttp.Transport{},
+	}
+
+	return &Dispatcher{
+		db:       db,
+		client:   &client,
+		log:      log,
+		cfg:      cfg,
+		shutdown: make(chan struct{}),
+	}
+}
+
+// Start runs the dispatcher in its own goroutine.
+func (d *Dispatcher) Start() {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		ticker := time.NewTicker(d.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			delivered, failed, err := Deliver(context.Background(), d.db, d.client, time.Now(), d.cfg.MaxAttempts)
+			if err != nil {
+				d.log.Printf("webhook : delivering : %v", err)
+			}
+			if delivered+failed > 0 {
+				d.log.Printf("webhook : %d delivered, %d failed", delivered, failed)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-d.shutdown:
+				return
+			}
+		}
+	}()
+}
+
+// Stop asks the dispatcher to terminate and waits for the current run to finish.
+func (d *Dispatcher) Stop() {
+	close(d.shutdown)
+	d.wg.Wait()
+}
+
+// Deliver posts the deliveries which are due and records every attempt. They
+// are first claimed in a short transaction, marked as sending until a lease
+// expires, so several instances of the service can run a Dispatcher at the
+// same time without holding locks during the requests. Each attempt is then
+// recorded in its own transaction, a failure to record one does not post the
+// others again. A delivery whose lease expired without a result is claimed
+// again. A delivery is considered successful when the subscriber answers with
+// a 2xx status code, otherwise it is retried following Backoff until
+// maxAttempts is reached and it is dead-lettered.
+func Deliver(ctx context.Context, db *sqlx.DB, client *http.Client, now time.Time, maxAttempts int) (int, int, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.webhook.Deliver")
+	defer span.End()
+
+	ds, err := claim(ctx, db, now)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var delivered, failed int
+	for _, d := range ds {
+		start := time.Now()
+		code, postErr := post(ctx, client, d.URL, d.Secret, d.Delivery)
+		duration := time.Since(start).Milliseconds()
+
+		a := attempt{Delivery: d.Delivery, Duration: duration, Now: now}
+		if code != 0 {
+			a.StatusCode = &code
+		}
+		if postErr != nil {
+			s := postErr.Error()
+			a.Error = &s
+		}
+
+		a.Attempts = d.Attempts + 1
+		a.Status = StatusDelivered
+		a.Next = d.NextAttempt
+		switch {
+		case postErr == nil:
+			delivered++
+			a.DateDelivered = &now
+		case a.Attempts >= maxAttempts:
+			failed++
+			a.Status = StatusDead
+		default:
+			failed++
+			a.Status = StatusPending
+			a.Next = now.Add(Backoff(a.Attempts))
+		}
+
+		if err := record(ctx, db, a); err != nil {
+			return delivered, failed, err
+		}
+	}
+
+	return delivered, failed, nil
+}
+
+// due is a claimed delivery with the webhook it is posted to.
+type due struct {
+	Delivery
+	URL    string `db:"url"`
+	Secret string `db:"secret"`
+}
+
+// claim marks the deliveries which are due as sending until the lease expires
+// and returns them. The lease is stored in next_attempt.
+func claim(ctx context.Context, db *sqlx.DB, now time.Time) ([]due, error) {
+	var ds []due
+	const q = `WITH claimed AS (
+			UPDATE webhook_deliveries SET "status" = $3, "next_attempt" = $4
+			WHERE delivery_id IN (
+				SELECT d.delivery_id
+				FROM webhook_deliveries AS d
+				JOIN webhooks AS w ON w.webhook_id = d.webhook_id
+				WHERE d.status IN ($1, $3) AND d.next_attempt <= $2 AND w.active
+				ORDER BY d.next_attempt
+				LIMIT $5
+				FOR UPDATE OF d SKIP LOCKED
+			)
+			RETURNING *
+		)
+		SELECT c.*, w.url, w.secret
+		FROM claimed AS c
+		JOIN webhooks AS w ON w.webhook_id = c.webhook_id`
+	if err := db.SelectContext(ctx, &ds, q, StatusPending, now, StatusSending, now.Add(sendLease), batchSize); err != nil {
+		return nil, errors.Wrap(err, "claiming due deliveries")
+	}
+
+	return ds, nil
+}
+
+// attempt is the outcome of posting a claimed delivery.
+type attempt struct {
+	Delivery      Delivery
+	StatusCode    *int
+	Error         *string
+	Duration      int64
+	Attempts      int
+	Status        string
+	Next          time.Time
+	DateDelivered *time.Time
+	Now           time.Time
+}
+
+// record logs an attempt and updates its delivery in one transaction.
+func record(ctx context.Context, db *sqlx.DB, a attempt) error {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	const qa = `INSERT INTO webhook_attempts
+		(delivery_id, number, status_code, error, duration_ms, date_attempted)
+		VALUES ($1, (SELECT COALESCE(MAX(number), 0) + 1 FROM webhook_attempts WHERE delivery_id = $1), $2, $3, $4, $5)`
+	if _, err := tx.ExecContext(ctx, qa, a.Delivery.ID, a.StatusCode, a.Error, a.Duration, a.Now); err != nil {
+		return errors.Wrapf(err, "recording attempt of delivery %s", a.Delivery.ID)
+	}
+
+	const qu = `UPDATE webhook_deliveries SET
+		"status" = $2,
+		"attempts" = $3,
+		"next_attempt" = $4,
+		"date_delivered" = $5
+		WHERE delivery_id = $1 AND "status" = $6`
+	if _, err := tx.ExecContext(ctx, qu, a.Delivery.ID, a.Status, a.Attempts, a.Next, a.DateDelivered, StatusSending); err != nil {
+		return errors.Wrapf(err, "updating delivery %s", a.Delivery.ID)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrapf(err, "committing delivery %s", a.Delivery.ID)
+	}
+
+	return nil
+}
+
+// post sends the signed payload of a delivery to the subscriber. It returns
+// the status code of the response if one was received.
+func post(ctx context.Context, client *http.Client, url, secret string, d Delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, errors.Wrap(err, "creating request")
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, d.Event)
+	req.Header.Set(DeliveryHeader, d.ID)
+	req.Header.Set(SignatureHeader, Sign(secret, d.Payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("subscriber answered %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,74 @@
+package webhook
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Webhook is a subscription of an external endpoint to library events.
+type Webhook struct {
+	ID          string         `db:"webhook_id" json:"id"`
+	URL         string         `db:"url" json:"url"`
+	Events      pq.StringArray `db:"events" json:"events"`
+	Secret      string         `db:"secret" json:"secret,omitempty"`
+	Active      bool           `db:"active" json:"active"`
+	DateCreated time.Time      `db:"date_created" json:"date_created"`
+	DateUpdated time.Time      `db:"date_updated" json:"date_updated"`
+}
+
+// NewWebhook contains information needed to create a new Webhook. A secret is
+// generated when none is provided.
+type NewWebhook struct {
+	URL    string   `json:"url" validate:"required,url"`
+	Events []string `json:"events" validate:"required,min=1"`
+	Secret string   `json:"secret"`
+}
+
+// UpdateWebhook defines what information may be provided to modify an
+// existing Webhook. All fields are optional so clients can send just the
+// fields they want changed.
+type UpdateWebhook struct {
+	URL    *string  `json:"url" validate:"omitempty,url"`
+	Events []string `json:"events"`
+	Secret *string  `json:"secret"`
+	Active *bool    `json:"active"`
+}
+
+// Delivery is one event which has to be, or was, posted to a Webhook.
+type Delivery struct {
+	ID            string          `db:"delivery_id" json:"id"`
+	WebhookID     string          `db:"webhook_id" json:"webhook_id"`
+	Event         string          `db:"event" json:"event"`
+	Payload       json.RawMessage `db:"payload" json:"payload"`
+	Status        string          `db:"status" json:"status"`
+	Attempts      int             `db:"attempts" json:"attempts"`
+	NextAttempt   time.Time       `db:"next_attempt" json:"next_attempt"`
+	DateCreated   time.Time       `db:"date_created" json:"date_created"`
+	DateDelivered *time.Time      `db:"date_delivered" json:"date_delivered,omitempty"`
+}
+
+// Attempt records the outcome of posting a Delivery once.
+type Attempt struct {
+	DeliveryID    string    `db:"delivery_id" json:"delivery_id"`
+	Number        int       `db:"number" json:"number"`
+	StatusCode    *int      `db:"status_code" json:"status_code,omitempty"`
+	Error         *string   `db:"error" json:"error,omitempty"`
+	Duration      int64     `db:"duration_ms" json:"duration_ms"`
+	DateAttempted time.Time `db:"date_attempted" json:"date_attempted"`
+}
+
+// DeliveryLog is a Delivery along with every attempt made to post it.
+type DeliveryLog struct {
+	Delivery
+	History []Attempt `json:"attempts_history"`
+}
+
+// envelope is the JSON document posted to subscribers.
+type envelope struct {
+	ID      string      `json:"id"`
+	Event   string      `json:"event"`
+	Created time.Time   `json:"created_at"`
+	Data    interface{} `json:"data"`
+}
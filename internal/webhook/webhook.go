@@ -0,0 +1,368 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/utils"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+)
+
+// These are the events a Webhook can subscribe to.
+const (
+	EventBookCreated = "book.created"
+	EventBookUpdated = "book.updated"
+	EventBookDeleted = "book.deleted"
+	EventLoanStarted = "loan.started"
+	EventLoanEnded   = "loan.ended"
+	EventUserCreated = "user.created"
+)
+
+// Events lists every event a Webhook can subscribe to.
+var Events = []string{
+	EventBookCreated,
+	EventBookUpdated,
+	EventBookDeleted,
+	EventLoanStarted,
+	EventLoanEnded,
+	EventUserCreated,
+}
+
+// Delivery status values. A delivery which keeps failing ends up dead and is
+// only posted again when an admin replays it.
+const (
+	StatusPending   = "pending"
+	StatusSending   = "sending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+// Predefined errors identify expected failure conditions.
+var (
+	// ErrNotFound is used when a specific Webhook or Delivery is requested but
+	// does not exist.
+	ErrNotFound = errors.New("Webhook not found")
+
+	// ErrInvalidID is used when an invalid UUID is provided.
+	ErrInvalidID = errors.New("ID is not in its proper form")
+
+	// ErrUnknownEvent is used when subscribing to an event which does not exist.
+	ErrUnknownEvent = errors.New("unknown webhook event")
+
+	// ErrForbidden occurs when a users tries to do something that is forbidden to
+	// them according to our access control policies.
+	ErrForbidden = errors.New("Attempted action is not allowed")
+)
+
+// Sign returns the value of the signature header for a payload: the hex
+// encoded HMAC-SHA256 of the body keyed with the webhook secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// List retrieves the existing webhooks from the database.
+func List(ctx context.Context, claims auth.Claims, db *sqlx.DB) ([]Webhook, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.webhook.List")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionWebhooksManage) {
+		return nil, ErrForbidden
+	}
+
+	hooks := []Webhook{}
+	const q = `SELECT * FROM webhooks ORDER BY date_created`
+	if err := db.SelectContext(ctx, &hooks, q); err != nil {
+		return nil, errors.Wrap(err, "selecting webhooks")
+	}
+
+	// Secrets are only handed out when the webhook is created.
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+
+	return hooks, nil
+}
+
+// Retrieve gets the specified webhook from the database.
+func Retrieve(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string) (*Webhook, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.webhook.Retrieve")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionWebhooksManage) {
+		return nil, ErrForbidden
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var h Webhook
+	const q = `SELECT * FROM webhooks WHERE webhook_id = $1`
+	if err := db.GetContext(ctx, &h, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting webhook %q", id)
+	}
+	h.Secret = ""
+
+	return &h, nil
+}
+
+// Create inserts a new webhook into the database. The returned value is the
+// only one holding the secret.
+func Create(ctx context.Context, claims auth.Claims, db *sqlx.DB, n NewWebhook, now time.Time) (*Webhook, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.webhook.Create")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionWebhooksManage) {
+		return nil, ErrForbidden
+	}
+
+	if err := validEvents(n.Events); err != nil {
+		return nil, err
+	}
+
+	secret := n.Secret
+	if secret == "" {
+		b, err := utils.GenerateRandomBytes(32)
+		if err != nil {
+			return nil, errors.Wrap(err, "generating webhook secret")
+		}
+		secret = hex.EncodeToString(b)
+	}
+
+	h := Webhook{
+		ID:          uuid.New().String(),
+		URL:         n.URL,
+		Events:      n.Events,
+		Secret:      secret,
+		Active:      true,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `INSERT INTO webhooks
+		(webhook_id, url, events, secret, active, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := db.ExecContext(ctx, q,
+		h.ID, h.URL, h.Events, h.Secret, h.Active, h.DateCreated, h.DateUpdated,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting webhook")
+	}
+
+	return &h, nil
+}
+
+// Update modifies a webhook in the database.
+func Update(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, upd UpdateWebhook, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.webhook.Update")
+	defer span.End()
+
+	h, err := Retrieve(ctx, claims, db, id)
+	if err != nil {
+		return err
+	}
+
+	const qs = `SELECT secret FROM webhooks WHERE webhook_id = $1`
+	if err := db.GetContext(ctx, &h.Secret, qs, id); err != nil {
+		return errors.Wrapf(err, "selecting webhook %q secret", id)
+	}
+
+	if upd.URL != nil {
+		h.URL = *upd.URL
+	}
+	if upd.Events != nil {
+		if err := validEvents(upd.Events); err != nil {
+			return err
+		}
+		h.Events = upd.Events
+	}
+	if upd.Secret != nil {
+		h.Secret = *upd.Secret
+	}
+	if upd.Active != nil {
+		h.Active = *upd.Active
+	}
+	h.DateUpdated = now.UTC()
+
+	const q = `UPDATE webhooks SET
+		"url" = $2,
+		"events" = $3,
+		"secret" = $4,
+		"active" = $5,
+		"date_updated" = $6
+		WHERE webhook_id = $1`
+	if _, err := db.ExecContext(ctx, q, id, h.URL, h.Events, h.Secret, h.Active, h.DateUpdated); err != nil {
+		return errors.Wrap(err, "updating webhook")
+	}
+
+	return nil
+}
+
+// Delete removes a webhook and its deliveries from the database.
+func Delete(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string) error {
+	ctx, span := trace.StartSpan(ctx, "internal.webhook.Delete")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionWebhooksManage) {
+		return ErrForbidden
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `DELETE FROM webhooks WHERE webhook_id = $1`
+	if _, err := db.ExecContext(ctx, q, id); err != nil {
+		return errors.Wrapf(err, "deleting webhook %s", id)
+	}
+
+	return nil
+}
+
+// Emit queues a delivery of the event for every active webhook subscribed to
+// it. db can be a *sqlx.DB or a *sqlx.Tx so deliveries are only queued when
+// the surrounding transaction commits.
+func Emit(ctx context.Context, db sqlx.ExtContext, event string, data interface{}, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.webhook.Emit")
+	defer span.End()
+
+	var ids []string
+	const qs = `SELECT webhook_id FROM webhooks WHERE active AND $1 = ANY(events)`
+	if err := sqlx.SelectContext(ctx, db, &ids, qs, event); err != nil {
+		return errors.Wrap(err, "selecting subscribed webhooks")
+	}
+
+	const q = `INSERT INTO webhook_deliveries
+		(delivery_id, webhook_id, event, payload, status, attempts, next_attempt, date_created)
+		VALUES ($1, $2, $3, $4, $5, 0, $6, $6)`
+	for _, id := range ids {
+		env := envelope{
+			ID:      uuid.New().String(),
+			Event:   event,
+			Created: now.UTC(),
+			Data:    data,
+		}
+
+		payload, err := json.Marshal(env)
+		if err != nil {
+			return errors.Wrap(err, "encoding webhook payload")
+		}
+
+		if _, err := db.ExecContext(ctx, q, env.ID, id, event, string(payload), StatusPending, env.Created); err != nil {
+			return errors.Wrap(err, "inserting webhook delivery")
+		}
+	}
+
+	return nil
+}
+
+// Deliveries retrieves the most recent deliveries of a webhook.
+func Deliveries(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string) ([]Delivery, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.webhook.Deliveries")
+	defer span.End()
+
+	if _, err := Retrieve(ctx, claims, db, id); err != nil {
+		return nil, err
+	}
+
+	deliveries := []Delivery{}
+	const q = `SELECT * FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY date_created DESC LIMIT 100`
+	if err := db.SelectContext(ctx, &deliveries, q, id); err != nil {
+		return nil, errors.Wrap(err, "selecting webhook deliveries")
+	}
+
+	return deliveries, nil
+}
+
+// RetrieveDelivery gets a delivery along with all of its attempts.
+func RetrieveDelivery(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string) (*DeliveryLog, error) {
+	ctx, span := trace.StartSpan(ctx, "internal.webhook.RetrieveDelivery")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionWebhooksManage) {
+		return nil, ErrForbidden
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	var d DeliveryLog
+	const q = `SELECT * FROM webhook_deliveries WHERE delivery_id = $1`
+	if err := db.GetContext(ctx, &d.Delivery, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrapf(err, "selecting delivery %q", id)
+	}
+
+	d.History = []Attempt{}
+	const qa = `SELECT * FROM webhook_attempts WHERE delivery_id = $1 ORDER BY number`
+	if err := db.SelectContext(ctx, &d.History, qa, id); err != nil {
+		return nil, errors.Wrapf(err, "selecting delivery %q attempts", id)
+	}
+
+	return &d, nil
+}
+
+// Replay schedules a delivery to be posted again right away, whatever its
+// current status is. Dead deliveries get a fresh set of attempts.
+func Replay(ctx context.Context, claims auth.Claims, db *sqlx.DB, id string, now time.Time) error {
+	ctx, span := trace.StartSpan(ctx, "internal.webhook.Replay")
+	defer span.End()
+
+	if !claims.HasPermission(auth.PermissionWebhooksManage) {
+		return ErrForbidden
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `UPDATE webhook_deliveries SET
+		"status" = $2,
+		"attempts" = 0,
+		"next_attempt" = $3
+		WHERE delivery_id = $1`
+	res, err := db.ExecContext(ctx, q, id, StatusPending, now.UTC())
+	if err != nil {
+		return errors.Wrapf(err, "replaying delivery %s", id)
+	}
+
+	if rows, err := res.RowsAffected(); err == nil && rows == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// validEvents makes sure every event can be subscribed to.
+func validEvents(events []string) error {
+	for _, e := range events {
+		known := false
+		for _, k := range Events {
+			if e == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return ErrUnknownEvent
+		}
+	}
+	return nil
+}
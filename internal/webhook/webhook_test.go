@@ -0,0 +1,119 @@
+package webhook_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/book-library/internal/books"
+	"github.com/book-library/internal/platform/auth"
+	"github.com/book-library/internal/tests"
+	"github.com/book-library/internal/webhook"
+)
+
+// TestWebhook validates events are posted, signed, to their subscribers and
+// dead-lettered when the subscriber keeps failing.
+func TestWebhook(t *testing.T) {
+	db, teardown := tests.NewUnit(t)
+	defer teardown()
+
+	// fail is read by the handler goroutine of the server.
+	fail := int32(1)
+	signatures := make(chan bool, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		signatures <- r.Header.Get(webhook.SignatureHeader) == webhook.Sign("s3cr3t", body)
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	t.Log("Given the need to notify subscribers of library events.")
+	{
+		ctx := tests.Context()
+		now := time.Date(2018, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+		// claims is information about the person making the request.
+		claims := auth.NewClaims(
+			auth.RoleAdmin,
+			[]string{auth.RoleAdmin, auth.RoleUser},
+			now, time.Hour,
+			"718ffbea-f4a1-4667-8ae3-b349da52675e", // This is just some random UUID.
+		)
+
+		nw := webhook.NewWebhook{
+			URL:    srv.URL,
+			Events: []string{webhook.EventBookCreated},
+			Secret: "s3cr3t",
+		}
+		hook, err := webhook.Create(ctx, claims, db, nw, now)
+		if err != nil {
+			t.Fatalf("\t%s\tShould be able to create webhook : %s.", tests.Failed, err)
+		}
+		t.Logf("\t%s\tShould be able to create webhook.", tests.Success)
+
+		nb := books.NewBook{
+			Title:    "Go programming",
+			ISBN:     "bcn22",
+			Category: "computer-science",
+			Authors:  "Bill Kenedy",
+			Quantity: 2,
+		}
+		if _, err := books.Create(ctx, now, nb, claims, db); err != nil {
+			t.Fatalf("\t%s\tShould be able to create book : %s.", tests.Failed, err)
+		}
+
+		t.Log("\tWhen the subscriber fails.")
+		{
+			delivered, failed, err := webhook.Deliver(ctx, db, srv.Client(), now, 2)
+			if err != nil || delivered != 0 || failed != 1 {
+				t.Fatalf("\t%s\tShould record the failure : %d delivered, %d failed, %v.", tests.Failed, delivered, failed, err)
+			}
+			t.Logf("\t%s\tShould record the failure.", tests.Success)
+
+			if !<-signatures {
+				t.Fatalf("\t%s\tShould sign the payload.", tests.Failed)
+			}
+			t.Logf("\t%s\tShould sign the payload.", tests.Success)
+
+			if _, _, err := webhook.Deliver(ctx, db, srv.Client(), now.Add(webhook.Backoff(1)), 2); err != nil {
+				t.Fatalf("\t%s\tShould retry the delivery : %s.", tests.Failed, err)
+			}
+			<-signatures
+
+			deliveries, err := webhook.Deliveries(ctx, claims, db, hook.ID)
+			if err != nil || len(deliveries) != 1 || deliveries[0].Status != webhook.StatusDead {
+				t.Fatalf("\t%s\tShould dead-letter the delivery : %+v, %v.", tests.Failed, deliveries, err)
+			}
+			t.Logf("\t%s\tShould dead-letter the delivery.", tests.Success)
+
+			log, err := webhook.RetrieveDelivery(ctx, claims, db, deliveries[0].ID)
+			if err != nil || len(log.History) != 2 {
+				t.Fatalf("\t%s\tShould log every attempt : %+v, %v.", tests.Failed, log, err)
+			}
+			t.Logf("\t%s\tShould log every attempt.", tests.Success)
+		}
+
+		t.Log("\tWhen replaying a dead delivery.")
+		{
+			atomic.StoreInt32(&fail, 0)
+
+			deliveries, _ := webhook.Deliveries(ctx, claims, db, hook.ID)
+			if err := webhook.Replay(ctx, claims, db, deliveries[0].ID, now); err != nil {
+				t.Fatalf("\t%s\tShould be able to replay the delivery : %s.", tests.Failed, err)
+			}
+
+			delivered, _, err := webhook.Deliver(ctx, db, srv.Client(), now, 2)
+			if err != nil || delivered != 1 {
+				t.Fatalf("\t%s\tShould deliver the event : %d delivered, %v.", tests.Failed, delivered, err)
+			}
+			t.Logf("\t%s\tShould deliver the event.", tests.Success)
+		}
+	}
+}